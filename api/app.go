@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ajg/form"
@@ -311,6 +312,9 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 				Message: "In order to create an app, you should be member of at least one team",
 			}
 		}
+		if e, ok := err.(*app.TeamQuotaExceededError); ok {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: e.Error()}
+		}
 		if e, ok := err.(*app.AppCreationError); ok {
 			if e.Err == app.ErrAppAlreadyExists {
 				return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
@@ -380,8 +384,18 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	if updateData.TeamOwner != "" {
 		wantedPerms = append(wantedPerms, permission.PermAppUpdateTeamowner)
 	}
+	var structuredLogs *bool
+	if v := r.FormValue("structuredLogs"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			msg := `Parameter "structuredLogs" must be a boolean.`
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+		}
+		structuredLogs = &enabled
+		wantedPerms = append(wantedPerms, permission.PermAppUpdateStructuredLogs)
+	}
 	if len(wantedPerms) == 0 {
-		msg := "Neither the description, plan, pool or team owner were set. You must define at least one."
+		msg := "Neither the description, plan, pool, team owner or structured logs were set. You must define at least one."
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
 	}
 	for _, perm := range wantedPerms {
@@ -409,10 +423,19 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	defer keepAliveWriter.Stop()
 	w.Header().Set("Content-Type", "application/x-json-stream")
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	if structuredLogs != nil {
+		err = a.SetStructuredLogs(*structuredLogs)
+		if err != nil {
+			return err
+		}
+	}
 	err = a.Update(updateData, writer)
 	if err == app.ErrPlanNotFound {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
 	}
+	if e, ok := err.(*app.TeamQuotaExceededError); ok {
+		return &errors.HTTP{Code: http.StatusForbidden, Message: e.Error()}
+	}
 	return err
 }
 
@@ -735,6 +758,7 @@ func runCommand(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	}
 	appName := r.URL.Query().Get(":app")
 	once := r.FormValue("once")
+	isolated, _ := strconv.ParseBool(r.FormValue("isolated"))
 	a, err := getAppFromContext(appName, r)
 	if err != nil {
 		return err
@@ -753,6 +777,7 @@ func runCommand(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 		Kind:       permission.PermAppRun,
 		Owner:      t,
 		CustomData: formToEvents(r.Form),
+		Cancelable: isolated,
 	})
 	if err != nil {
 		return err
@@ -762,6 +787,9 @@ func runCommand(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	if isolated {
+		return a.RunIsolated(command, writer, evt)
+	}
 	onceBool, _ := strconv.ParseBool(once)
 	return a.Run(command, writer, onceBool)
 }
@@ -1072,11 +1100,40 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: `Parameter "lines" is mandatory.`}
 	}
 	w.Header().Set("Content-Type", "application/x-json-stream")
-	source := r.URL.Query().Get("source")
-	unit := r.URL.Query().Get("unit")
+	query := app.LogQuery{
+		Lines:   lines,
+		Source:  r.URL.Query().Get("source"),
+		Unit:    r.URL.Query().Get("unit"),
+		Message: r.URL.Query().Get("message"),
+		Regex:   r.URL.Query().Get("regex"),
+		Cursor:  r.URL.Query().Get("cursor"),
+	}
+	for _, field := range r.URL.Query()["field"] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			msg := `Parameter "field" must be in the form key=value.`
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+		}
+		if query.Fields == nil {
+			query.Fields = make(map[string]string)
+		}
+		query.Fields[parts[0]] = parts[1]
+	}
+	query.Since, err = timeParam(r, "since")
+	if err != nil {
+		return err
+	}
+	query.Until, err = timeParam(r, "until")
+	if err != nil {
+		return err
+	}
+	err = query.Validate()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	follow := r.URL.Query().Get("follow")
 	appName := r.URL.Query().Get(":app")
-	filterLog := app.Applog{Source: source, Unit: unit}
+	filterLog := app.Applog{Source: query.Source, Unit: query.Unit, Fields: query.Fields}
 	a, err := getAppFromContext(appName, r)
 	if err != nil {
 		return err
@@ -1090,10 +1147,16 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if !allowed {
 		return permission.ErrUnauthorized
 	}
-	logs, err := a.LastLogs(lines, filterLog)
+	logs, cursor, err := a.QueryLogs(query)
+	if err == app.ErrInvalidLogCursor || err == app.ErrLogQueryTimeout {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	if err != nil {
 		return err
 	}
+	if cursor != "" {
+		w.Header().Set("X-Tsuru-Log-Cursor", cursor)
+	}
 	encoder := json.NewEncoder(w)
 	err = encoder.Encode(logs)
 	if err != nil {
@@ -1120,14 +1183,18 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	logChan := l.ListenChan()
 	for {
 		var logMsg app.Applog
+		var ok bool
 		select {
 		case <-closeChan:
 			return nil
-		case logMsg = <-logChan:
+		case logMsg, ok = <-logChan:
 		}
-		if logMsg == (app.Applog{}) {
+		if !ok {
 			break
 		}
+		if !query.Match(&logMsg) {
+			continue
+		}
 		err := encoder.Encode([]app.Applog{logMsg})
 		if err != nil {
 			break
@@ -1136,6 +1203,19 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return nil
 }
 
+func timeParam(r *http.Request, param string) (time.Time, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		msg := fmt.Sprintf("Parameter %q must be a RFC 3339 timestamp.", param)
+		return time.Time{}, &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+	}
+	return t, nil
+}
+
 func getServiceInstance(serviceName, instanceName, appName string) (*service.ServiceInstance, *app.App, error) {
 	var app app.App
 	conn, err := db.Conn()
@@ -1758,6 +1838,35 @@ func appRebuildRoutes(w http.ResponseWriter, r *http.Request, t auth.Token) (err
 	return json.NewEncoder(w).Encode(&result)
 }
 
+// title: routes drift
+// path: /routes/drift
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func routesDrift(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	contexts := permission.ContextsForPermission(t, permission.PermAppReadRoutes)
+	if len(contexts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	filter := appFilterByContext(contexts, nil)
+	filter.Name = r.URL.Query().Get("app")
+	filter.Pool = r.URL.Query().Get("pool")
+	drifts, err := app.FindRoutesDrift(filter)
+	if err != nil {
+		return err
+	}
+	if len(drifts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(drifts)
+}
+
 func formToEvents(form url.Values) []map[string]interface{} {
 	ret := make([]map[string]interface{}, 0, len(form))
 	for k, v := range form {
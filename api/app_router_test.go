@@ -0,0 +1,221 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestListAppRouters(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/routers", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var routers []app.AppRouter
+	err = json.Unmarshal(recorder.Body.Bytes(), &routers)
+	c.Assert(err, check.IsNil)
+	hcAddr, err := routertest.HCRouter.Addr(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []app.AppRouter{
+		{Name: "fake", Address: a.Ip},
+		{Name: "fake-hc", Address: hcAddr},
+	})
+}
+
+func (s *S) TestAddAppRouter(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	v := url.Values{"name": {"fake-hc"}}
+	request, err := http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, true)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 1)
+	c.Assert(dbApp.Routers[0].Name, check.Equals, "fake-hc")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.router.add",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "fake-hc"},
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+	request, err = http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrRouterAlreadyAttached.Error()+"\n")
+}
+
+func (s *S) TestAddAppRouterInvalid(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	tests := []url.Values{
+		{},
+		{"name": {"unknown"}},
+	}
+	m := RunServer(true)
+	for _, v := range tests {
+		request, err := http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *S) TestAddAppRouterWithoutPermission(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadRouter,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	v := url.Values{"name": {"fake-hc"}}
+	request, err := http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(routertest.HCRouter.HasBackend("myapp"), check.Equals, false)
+}
+
+func (s *S) TestRemoveAppRouter(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/routers/fake-hc", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, true)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.router.remove",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": ":router", "value": "fake-hc"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveAppRouterPlanRouter(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/routers/fake", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrCannotRemovePlanRouter.Error()+"\n")
+}
+
+func (s *S) TestAddAppRouterTeamPermission(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppUpdate,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	v := url.Values{"name": {"fake-hc"}}
+	request, err := http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(routertest.HCRouter.HasBackend("myapp"), check.Equals, false)
+}
+
+func (s *S) TestAddAppRouterPoolPermission(c *check.C) {
+	config.Set("routers:fake-hc:type", "fake-hc")
+	defer config.Unset("routers:fake-hc:type")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppUpdateRouterAdd,
+		Context: permission.Context(permission.CtxPool, a.Pool),
+	})
+	v := url.Values{"name": {"fake-hc"}}
+	request, err := http.NewRequest("POST", "/apps/myapp/routers", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, true)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
@@ -33,6 +34,7 @@ import (
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/repository/repositorytest"
+	"github.com/tsuru/tsuru/router/routertest"
 	"github.com/tsuru/tsuru/service"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
@@ -1117,6 +1119,56 @@ func (s *S) TestUpdateAppWithDescriptionOnly(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestUpdateAppWithStructuredLogsOnly(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppUpdateStructuredLogs,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	b := strings.NewReader("structuredLogs=true")
+	request, err := http.NewRequest("PUT", "/apps/myapp", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var gotApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": "myapp"}).One(&gotApp)
+	c.Assert(err, check.IsNil)
+	c.Assert(gotApp.StructuredLogs, check.Equals, true)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  token.GetUserName(),
+		Kind:   "app.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":appname", "value": a.Name},
+			{"name": "structuredLogs", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestUpdateAppWithInvalidStructuredLogs(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	b := strings.NewReader("structuredLogs=maybe")
+	request, err := http.NewRequest("PUT", "/apps/myapp", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "Parameter \"structuredLogs\" must be a boolean.\n")
+}
+
 func (s *S) TestUpdateAppWithPoolOnly(c *check.C) {
 	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
 	err := app.CreateApp(&a, s.user)
@@ -1244,7 +1296,7 @@ func (s *S) TestUpdateAppWithoutFlag(c *check.C) {
 	recorder := httptest.NewRecorder()
 	m := RunServer(true)
 	m.ServeHTTP(recorder, request)
-	errorMessage := "Neither the description, plan, pool or team owner were set. You must define at least one.\n"
+	errorMessage := "Neither the description, plan, pool, team owner or structured logs were set. You must define at least one.\n"
 	c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
 	c.Check(recorder.Body.String(), check.Equals, errorMessage)
 }
@@ -2162,6 +2214,43 @@ func (s *S) TestRun(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestRunIsolated(c *check.C) {
+	s.provisioner.PrepareOutput([]byte("migrated"))
+	a := app.App{Name: "secrets", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/run", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("command=migrate&isolated=true"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"Message":"migrated"}`+"\n")
+	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	expected += " [ -d /home/application/current ] && cd /home/application/current;"
+	expected += " migrate"
+	cmds := s.provisioner.GetCmds(expected, &a)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Isolated, check.Equals, true)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.run",
+		StartCustomData: []map[string]interface{}{
+			{"name": "command", "value": "migrate"},
+			{"name": "isolated", "value": "true"},
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+	evts, err := event.List(&event.Filter{Target: appTarget(a.Name), KindName: "app.run"})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Cancelable, check.Equals, true)
+}
+
 func (s *S) TestRunReturnsTheOutputOfTheCommandEvenIfItFails(c *check.C) {
 	s.provisioner.PrepareFailure("ExecuteCommand", &errors.HTTP{Code: 500, Message: "something went wrong"})
 	s.provisioner.PrepareOutput([]byte("failure output"))
@@ -3527,6 +3616,171 @@ func (s *S) TestAppLogSelectByUnit(c *check.C) {
 	c.Assert(logs[0].Unit, check.Equals, "caliban")
 }
 
+func (s *S) TestAppLogSelectByMessage(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	a.Log("GET /users 500", "app", "")
+	a.Log("GET /healthcheck 200", "app", "")
+	a.Log("POST /users 201", "app", "")
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&message=/users&lines=10", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "GET /users 500")
+	c.Assert(logs[1].Message, check.Equals, "POST /users 201")
+	url = fmt.Sprintf("/apps/%s/log/?:app=%s&regex=^GET&lines=10", a.Name, a.Name)
+	request, err = http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs = []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[1].Message, check.Equals, "GET /healthcheck 200")
+}
+
+func (s *S) TestAppLogSelectByField(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name, StructuredLogs: true}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	a.Log(`{"level": "info", "msg": "started"}`, "app", "")
+	a.Log(`{"level": "error", "msg": "failed"}`, "app", "")
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&field=level=error&lines=10", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Fields, check.DeepEquals, map[string]string{"level": "error", "msg": "failed"})
+}
+
+func (s *S) TestAppLogSelectByTimeRange(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	a.Log("recent log", "app", "")
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	since := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	until := time.Now().Add(-30 * time.Minute).UTC().Format(time.RFC3339)
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&since=%s&until=%s&lines=10", a.Name, a.Name, since, until)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 0)
+	url = fmt.Sprintf("/apps/%s/log/?:app=%s&since=%s&lines=10", a.Name, a.Name, since)
+	request, err = http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs = []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "recent log")
+}
+
+func (s *S) TestAppLogPagination(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 3; i++ {
+		a.Log(strconv.Itoa(i), "app", "")
+	}
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=2", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs := []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	cursor := recorder.Header().Get("X-Tsuru-Log-Cursor")
+	c.Assert(cursor, check.Not(check.Equals), "")
+	url = fmt.Sprintf("/apps/%s/log/?:app=%s&lines=2&cursor=%s", a.Name, a.Name, cursor)
+	request, err = http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	logs = []app.Applog{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "0")
+	c.Assert(recorder.Header().Get("X-Tsuru-Log-Cursor"), check.Equals, "")
+}
+
+func (s *S) TestAppLogInvalidQuery(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	var tests = []struct {
+		params string
+		msg    string
+	}{
+		{"since=yesterday", `Parameter "since" must be a RFC 3339 timestamp.`},
+		{"until=2016-13-01", `Parameter "until" must be a RFC 3339 timestamp.`},
+		{"regex=(a", "invalid regex: .*"},
+		{"message=a&regex=a", "message and regex filters are mutually exclusive"},
+		{"cursor=invalid", "invalid log cursor"},
+		{"field=level", `Parameter "field" must be in the form key=value.`},
+		{"field=http.status=500", `invalid field name: "http.status"`},
+	}
+	for _, t := range tests {
+		url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=10&%s", a.Name, a.Name, t.params)
+		request, err := http.NewRequest("GET", url, nil)
+		c.Assert(err, check.IsNil)
+		recorder := httptest.NewRecorder()
+		err = appLog(recorder, request, token)
+		c.Assert(err, check.NotNil)
+		e, ok := err.(*errors.HTTP)
+		c.Assert(ok, check.Equals, true)
+		c.Assert(e.Code, check.Equals, http.StatusBadRequest)
+		c.Assert(e.Message, check.Matches, t.msg)
+	}
+}
+
 func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *check.C) {
 	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
 	err := app.CreateApp(&a, s.user)
@@ -5092,3 +5346,66 @@ func (s *S) TestRebuildRoutes(c *check.C) {
 	json.Unmarshal(recorder.Body.Bytes(), &parsed)
 	c.Assert(parsed, check.DeepEquals, app.RebuildRoutesResult{})
 }
+
+func (s *S) TestRoutesDrift(c *check.C) {
+	config.Set("docker:router", "fake")
+	defer config.Unset("docker:router")
+	for _, name := range []string{"myappx", "myappy"} {
+		a := app.App{Name: name, Platform: "zend", TeamOwner: s.team.Name}
+		err := app.CreateApp(&a, s.user)
+		c.Assert(err, check.IsNil)
+		s.provisioner.Provision(&a)
+		defer s.provisioner.Destroy(&a)
+	}
+	routertest.FakeRouter.AddRoute("myappx", &url.URL{Scheme: "http", Host: "invalid:1234"})
+	request, err := http.NewRequest("GET", "/routes/drift", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var drifts []app.RoutesDrift
+	err = json.Unmarshal(recorder.Body.Bytes(), &drifts)
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.DeepEquals, []app.RoutesDrift{
+		{App: "myappx", Router: "fake", StaleRoutes: []string{"invalid:1234"}},
+	})
+	c.Assert(routertest.FakeRouter.HasRoute("myappx", "http://invalid:1234"), check.Equals, true)
+}
+
+func (s *S) TestRoutesDriftFilteredByPermission(c *check.C) {
+	config.Set("docker:router", "fake")
+	defer config.Unset("docker:router")
+	for _, name := range []string{"myappx", "myappy"} {
+		a := app.App{Name: name, Platform: "zend", TeamOwner: s.team.Name}
+		err := app.CreateApp(&a, s.user)
+		c.Assert(err, check.IsNil)
+		s.provisioner.Provision(&a)
+		defer s.provisioner.Destroy(&a)
+	}
+	routertest.FakeRouter.AddRoute("myappx", &url.URL{Scheme: "http", Host: "invalid:1234"})
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadRoutes,
+		Context: permission.Context(permission.CtxApp, "myappy"),
+	})
+	request, err := http.NewRequest("GET", "/routes/drift", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestRoutesDriftNoPermission(c *check.C) {
+	token := userWithPermission(c)
+	request, err := http.NewRequest("GET", "/routes/drift", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
@@ -22,6 +22,11 @@ import (
 const (
 	nonManagedSchemeMsg = "Authentication scheme does not allow this operation."
 	createDisabledMsg   = "User registration is disabled for non-admin users."
+	noTwoFactorMsg      = "Authentication scheme does not support two-factor authentication."
+
+	// twoFactorHeader is set in login responses when the user must provide a
+	// two-factor authentication code.
+	twoFactorHeader = "X-Tsuru-Two-Factor"
 )
 
 var createDisabledErr = &errors.HTTP{Code: http.StatusUnauthorized, Message: createDisabledMsg}
@@ -123,6 +128,9 @@ func login(w http.ResponseWriter, r *http.Request) (err error) {
 	}
 	token, err := app.AuthScheme.Login(params)
 	if err != nil {
+		if err == auth.ErrTwoFactorRequired {
+			w.Header().Set(twoFactorHeader, "required")
+		}
 		return handleAuthError(err)
 	}
 	return json.NewEncoder(w).Encode(map[string]string{"token": token.GetValue()})
@@ -137,6 +145,97 @@ func logout(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	return app.AuthScheme.Logout(t.GetValue())
 }
 
+// title: enroll two-factor authentication
+// path: /users/{email}/two-factor
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Not found
+//   409: Already enabled
+func enrollTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	scheme, ok := app.AuthScheme.(auth.TwoFactorScheme)
+	if !ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: noTwoFactorMsg}
+	}
+	email := r.URL.Query().Get(":email")
+	enrollment, err := scheme.EnrollTwoFactor(email, r.FormValue("password"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(enrollment)
+}
+
+// title: confirm two-factor authentication
+// path: /users/{email}/two-factor/confirm
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Not found
+//   409: Already enabled
+func confirmTwoFactor(w http.ResponseWriter, r *http.Request) (err error) {
+	scheme, ok := app.AuthScheme.(auth.TwoFactorScheme)
+	if !ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: noTwoFactorMsg}
+	}
+	email := r.URL.Query().Get(":email")
+	evt, err := event.New(&event.Opts{
+		Target:   userTarget(email),
+		Kind:     permission.PermUserUpdateTwoFactor,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: email},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	recoveryCodes, err := scheme.ConfirmTwoFactor(email, r.FormValue("password"), r.FormValue("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": recoveryCodes})
+}
+
+// title: disable two-factor authentication
+// path: /users/two-factor
+// method: DELETE
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+func disableTwoFactor(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
+	scheme, ok := app.AuthScheme.(auth.TwoFactorScheme)
+	if !ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: noTwoFactorMsg}
+	}
+	evt, err := event.New(&event.Opts{
+		Target: userTarget(t.GetUserName()),
+		Kind:   permission.PermUserUpdateTwoFactor,
+		Owner:  t,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = scheme.DisableTwoFactor(t, r.URL.Query().Get("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	return nil
+}
+
 // title: change password
 // path: /users/password
 // method: PUT
@@ -148,6 +247,9 @@ func logout(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 //   403: Forbidden
 //   404: Not found
 func changePassword(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	managed, ok := app.AuthScheme.(auth.ManagedScheme)
 	if !ok {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: nonManagedSchemeMsg}
@@ -366,8 +468,12 @@ func teamList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 //   200: Ok
 //   400: Invalid data
 //   401: Unauthorized
+//   403: Forbidden
 //   409: Key already exists
 func addKeyToUser(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	key := repository.Key{
 		Body: r.FormValue("key"),
 		Name: r.FormValue("name"),
@@ -410,9 +516,13 @@ func addKeyToUser(w http.ResponseWriter, r *http.Request, t auth.Token) (err err
 //   200: Ok
 //   400: Invalid data
 //   401: Unauthorized
+//   403: Forbidden
 //   404: Not found
 func removeKeyFromUser(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	r.ParseForm()
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	key := repository.Key{
 		Name: r.URL.Query().Get(":key"),
 	}
@@ -473,9 +583,13 @@ func listKeys(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 // responses:
 //   200: User removed
 //   401: Unauthorized
+//   403: Forbidden
 //   404: Not found
 func removeUser(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	r.ParseForm()
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	u, err := t.User()
 	if err != nil {
 		return err
@@ -542,9 +656,13 @@ func authScheme(w http.ResponseWriter, r *http.Request) error {
 // responses:
 //   200: OK
 //   401: Unauthorized
+//   403: Forbidden
 //   404: User not found
 func regenerateAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	r.ParseForm()
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	u, err := t.User()
 	if err != nil {
 		return err
@@ -584,8 +702,12 @@ func regenerateAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) (e
 // responses:
 //   200: OK
 //   401: Unauthorized
+//   403: Forbidden
 //   404: User not found
 func showAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
 	u, err := t.User()
 	if err != nil {
 		return err
@@ -616,6 +738,8 @@ type rolePermissionData struct {
 
 type apiUser struct {
 	Email       string
+	Type        string `json:",omitempty"`
+	Team        string `json:",omitempty"`
 	Roles       []rolePermissionData
 	Permissions []rolePermissionData
 }
@@ -672,6 +796,8 @@ func createAPIUser(perms []permission.Permission, user *auth.User, roleMap map[s
 	}
 	return &apiUser{
 		Email:       user.Email,
+		Type:        user.Type,
+		Team:        user.Team,
 		Roles:       roleData,
 		Permissions: permData,
 	}, nil
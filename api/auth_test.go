@@ -22,6 +22,7 @@ import (
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
@@ -339,6 +340,119 @@ func (s *AuthSuite) TestLoginPasswordDoesNotMatch(c *check.C) {
 	c.Assert(recorder.Body.String(), check.Matches, "^Authentication failed, wrong password.\n$")
 }
 
+func (s *AuthSuite) TestLoginTwoFactorCodeRequired(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	u.TwoFactor = &auth.TwoFactor{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", Enabled: true}
+	err = u.Update()
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("password=123456")
+	request, err := http.NewRequest("POST", "/users/nobody@globo.com/tokens", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("X-Tsuru-Two-Factor"), check.Equals, "required")
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrTwoFactorRequired.Error()+"\n")
+	b = strings.NewReader("password=123456&otp=000000")
+	request, err = http.NewRequest("POST", "/users/nobody@globo.com/tokens", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("X-Tsuru-Two-Factor"), check.Equals, "")
+}
+
+func (s *AuthSuite) TestEnrollTwoFactor(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("password=123456")
+	request, err := http.NewRequest("POST", "/users/nobody@globo.com/two-factor", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var enrollment auth.TwoFactorEnrollment
+	err = json.Unmarshal(recorder.Body.Bytes(), &enrollment)
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.Secret, check.Not(check.Equals), "")
+	c.Assert(enrollment.URI, check.Matches, "^otpauth://totp/tsuru:nobody@globo.com\\?.*secret="+enrollment.Secret+".*")
+	b = strings.NewReader("password=123456&otp=abcdef")
+	request, err = http.NewRequest("POST", "/users/nobody@globo.com/two-factor/confirm", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, native.ErrTwoFactorCodeMismatch.Error()+"\n")
+}
+
+func (s *AuthSuite) TestConfirmTwoFactorLocked(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("password=123456")
+	request, err := http.NewRequest("POST", "/users/nobody@globo.com/two-factor", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	evt, err := event.New(&event.Opts{
+		Target: userTarget(u.Email),
+		Kind:   permission.PermUserUpdateTwoFactor,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	defer evt.Done(nil)
+	b = strings.NewReader("password=123456&otp=abcdef")
+	request, err = http.NewRequest("POST", "/users/nobody@globo.com/two-factor/confirm", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Not(check.Equals), http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, "(?s)event locked.*")
+	dbUser, err := auth.GetUserByEmail(u.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbUser.TwoFactor, check.NotNil)
+	c.Assert(dbUser.TwoFactor.Enabled, check.Equals, false)
+}
+
+func (s *AuthSuite) TestEnrollTwoFactorWrongPassword(c *check.C) {
+	u := auth.User{Email: "nobody@globo.com", Password: "123456"}
+	_, err := nativeScheme.Create(&u)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("password=1234567")
+	request, err := http.NewRequest("POST", "/users/nobody@globo.com/two-factor", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *AuthSuite) TestDisableTwoFactorNotEnabled(c *check.C) {
+	request, err := http.NewRequest("DELETE", "/users/two-factor?otp=123456", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, native.ErrTwoFactorNotEnabled.Error()+"\n")
+}
+
 func (s *AuthSuite) TestLoginEmailIsNotValid(c *check.C) {
 	b := strings.NewReader("password=123456")
 	request, err := http.NewRequest("POST", "/users/nobody/tokens", b)
@@ -0,0 +1,176 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// title: autoscale rule list
+// path: /apps/{app}/autoscale
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: App not found
+func autoScaleRuleList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppReadAutoscale,
+		append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	rules, err := app.ListAutoScaleRules(a.Name)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(rules)
+}
+
+// title: autoscale rule set
+// path: /apps/{app}/autoscale
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Rule set
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+func autoScaleRuleSet(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateAutoscaleSet,
+		append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	rule := app.AutoScaleRule{
+		App:     a.Name,
+		Process: r.FormValue("process"),
+		Metric:  r.FormValue("metric"),
+	}
+	floatParams := map[string]*float64{
+		"threshold":          &rule.Threshold,
+		"scaleDownThreshold": &rule.ScaleDownThreshold,
+	}
+	for name, dst := range floatParams {
+		if value := r.FormValue(name); value != "" {
+			*dst, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return &errors.HTTP{Code: http.StatusBadRequest, Message: "Parameter \"" + name + "\" must be a number."}
+			}
+		}
+	}
+	uintParams := map[string]*uint{
+		"minUnits": &rule.MinUnits,
+		"maxUnits": &rule.MaxUnits,
+	}
+	for name, dst := range uintParams {
+		if value := r.FormValue(name); value != "" {
+			var n uint64
+			n, err = strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return &errors.HTTP{Code: http.StatusBadRequest, Message: "Parameter \"" + name + "\" must be a positive integer."}
+			}
+			*dst = uint(n)
+		}
+	}
+	if cooldown := r.FormValue("cooldown"); cooldown != "" {
+		rule.Cooldown, err = strconv.Atoi(cooldown)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "Parameter \"cooldown\" must be an integer."}
+		}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateAutoscaleSet,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = app.SetAutoScaleRule(&rule)
+	if _, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+// title: autoscale rule unset
+// path: /apps/{app}/autoscale
+// method: DELETE
+// responses:
+//   200: Rule removed
+//   401: Unauthorized
+//   404: Not found
+func autoScaleRuleUnset(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	appName := r.URL.Query().Get(":app")
+	process := r.URL.Query().Get("process")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateAutoscaleUnset,
+		append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateAutoscaleUnset,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = app.RemoveAutoScaleRule(a.Name, process)
+	if err == app.ErrAutoScaleRuleNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAutoScaleRuleSet(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("process=web&metric=cpu&threshold=70.5&minUnits=1&maxUnits=5&cooldown=60")
+	request, err := http.NewRequest("POST", "/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	rules, err := app.ListAutoScaleRules("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].Process, check.Equals, "web")
+	c.Assert(rules[0].Threshold, check.Equals, 70.5)
+	c.Assert(rules[0].MaxUnits, check.Equals, uint(5))
+	c.Assert(rules[0].Cooldown, check.Equals, 60)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.autoscale.set",
+		StartCustomData: []map[string]interface{}{
+			{"name": "process", "value": "web"},
+			{"name": "metric", "value": "cpu"},
+			{"name": "threshold", "value": "70.5"},
+			{"name": "minUnits", "value": "1"},
+			{"name": "maxUnits", "value": "5"},
+			{"name": "cooldown", "value": "60"},
+			{"name": ":app", "value": "myapp"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleRuleSetInvalid(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	var tests = []struct {
+		body    string
+		message string
+	}{
+		{"process=web&metric=cpu&threshold=abc&minUnits=1&maxUnits=5", "Parameter \"threshold\" must be a number.\n"},
+		{"process=web&metric=cpu&threshold=70&minUnits=-1&maxUnits=5", "Parameter \"minUnits\" must be a positive integer.\n"},
+		{"process=web&metric=cpu&threshold=70&minUnits=1&maxUnits=5&cooldown=x", "Parameter \"cooldown\" must be an integer.\n"},
+		{"process=web&metric=disk&threshold=70&minUnits=1&maxUnits=5", "invalid autoscale metric .*\n"},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("POST", "/apps/myapp/autoscale", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Matches, t.message)
+	}
+}
+
+func (s *S) TestAutoScaleRuleSetForbidden(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadAutoscale,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	body := strings.NewReader("process=web&metric=cpu&threshold=70&minUnits=1&maxUnits=5")
+	request, err := http.NewRequest("POST", "/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAutoScaleRuleList(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/autoscale", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	err = app.SetAutoScaleRule(&app.AutoScaleRule{App: "myapp", Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 5})
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var rules []app.AutoScaleRule
+	err = json.Unmarshal(recorder.Body.Bytes(), &rules)
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].Metric, check.Equals, "cpu")
+	c.Assert(rules[0].ScaleDownThreshold, check.Equals, 35.0)
+}
+
+func (s *S) TestAutoScaleRuleUnset(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = app.SetAutoScaleRule(&app.AutoScaleRule{App: "myapp", Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 5})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/autoscale?process=web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	rules, err := app.ListAutoScaleRules("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 0)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.autoscale.unset",
+		StartCustomData: []map[string]interface{}{
+			{"name": "process", "value": "web"},
+			{"name": ":app", "value": "myapp"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
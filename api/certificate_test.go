@@ -0,0 +1,199 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func generateCertificate(c *check.C, hosts ...string) (string, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"tsuru"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     hosts,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	c.Assert(err, check.IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	c.Assert(err, check.IsNil)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(cert), string(key)
+}
+
+func (s *S) TestSetCertificate(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "app.example.com")
+	v := url.Values{"cname": {"app.example.com"}, "certificate": {cert}, "key": {key}}
+	request, err := http.NewRequest("PUT", "/apps/myapp/certificate", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	certificate, err := routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(certificate, check.Equals, cert)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.certificate.set",
+		StartCustomData: []map[string]interface{}{
+			{"name": "cname", "value": "app.example.com"},
+			{"name": "certificate", "value": cert},
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetCertificateInvalid(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "other.example.com")
+	tests := []struct {
+		values   url.Values
+		expected string
+	}{
+		{url.Values{"cname": {"app.example.com"}}, "You must provide the cname, certificate and key."},
+		{url.Values{"cname": {"www.example.com"}, "certificate": {cert}, "key": {key}}, app.ErrCertificateCNameNotFound.Error()},
+		{url.Values{"cname": {"app.example.com"}, "certificate": {cert}, "key": {key}}, "invalid certificate: .*"},
+	}
+	m := RunServer(true)
+	for _, tt := range tests {
+		request, err := http.NewRequest("PUT", "/apps/myapp/certificate", strings.NewReader(tt.values.Encode()))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Matches, tt.expected+"\n")
+	}
+}
+
+func (s *S) TestSetCertificateWithoutPermission(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadCertificate,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	cert, key := generateCertificate(c, "app.example.com")
+	v := url.Values{"cname": {"app.example.com"}, "certificate": {cert}, "key": {key}}
+	request, err := http.NewRequest("PUT", "/apps/myapp/certificate", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestUnsetCertificate(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "app.example.com")
+	err = a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/certificate?cname=app.example.com", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.certificate.unset",
+		StartCustomData: []map[string]interface{}{
+			{"name": "cname", "value": "app.example.com"},
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListCertificates(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("www.example.com")
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "app.example.com")
+	err = a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/certificate", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var certificates map[string]string
+	err = json.Unmarshal(recorder.Body.Bytes(), &certificates)
+	c.Assert(err, check.IsNil)
+	c.Assert(certificates, check.DeepEquals, map[string]string{
+		"app.example.com": cert,
+		"www.example.com": "",
+	})
+}
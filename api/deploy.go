@@ -29,6 +29,7 @@ import (
 // consume: application/x-www-form-urlencoded
 // responses:
 //   200: OK
+//   202: Waiting for approval
 //   400: Invalid data
 //   403: Forbidden
 //   404: Not found
@@ -97,6 +98,40 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 			}
 		}
 	}
+	var canary *app.CanaryOptions
+	if canaryString := r.FormValue("canary"); canaryString != "" {
+		var fraction float64
+		fraction, err = strconv.ParseFloat(canaryString, 64)
+		if err != nil {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+		canary = &app.CanaryOptions{Fraction: fraction}
+		if err = canary.Validate(); err != nil {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+	}
+	var blueGreen bool
+	if blueGreenString := r.FormValue("blue-green"); blueGreenString != "" {
+		blueGreen, err = strconv.ParseBool(blueGreenString)
+		if err != nil {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+	}
+	if canary != nil && blueGreen {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: app.ErrCanaryWithBlueGreen.Error(),
+		}
+	}
 	message := r.FormValue("message")
 	if commit != "" && message == "" {
 		var messages []string
@@ -119,19 +154,35 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		Origin:     origin,
 		Build:      build,
 		Message:    message,
+		Canary:     canary,
+		BlueGreen:  blueGreen,
 	}
 	opts.GetKind()
 	if t.GetAppName() != app.InternalAppName {
-		canDeploy := permission.Check(t, permSchemeForDeploy(opts),
-			append(permission.Contexts(permission.CtxTeam, instance.Teams),
-				permission.Context(permission.CtxApp, appName),
-				permission.Context(permission.CtxPool, instance.Pool),
-			)...,
+		contexts := append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, appName),
+			permission.Context(permission.CtxPool, instance.Pool),
 		)
+		canDeploy := permission.Check(t, permSchemeForDeploy(opts), contexts...)
+		if canDeploy && canary != nil {
+			canDeploy = permission.Check(t, permission.PermAppDeployCanary, contexts...)
+		}
 		if !canDeploy {
 			return &errors.HTTP{Code: http.StatusForbidden, Message: "User does not have permission to do this action in this app"}
 		}
 	}
+	approval, err := app.RequestDeployApproval(opts)
+	if err == app.ErrDeployApprovalUpload {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if approval != nil {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, deployApprovalMessage(approval))
+		return nil
+	}
 	var imageID string
 	evt, err := event.New(&event.Opts{
 		Target:     appTarget(appName),
@@ -155,6 +206,108 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	return err
 }
 
+// title: promote canary deploy
+// path: /apps/{appname}/deploy/canary/promote
+// method: POST
+// produce: application/x-json-stream
+// responses:
+//   200: OK
+//   403: Forbidden
+//   404: Not found
+func deployCanaryPromote(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	instance, err := app.GetByName(appName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+	}
+	allowed := permission.Check(t, permission.PermAppDeployCanaryPromote,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, instance.Name),
+			permission.Context(permission.CtxPool, instance.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	return runDeployAction(w, r, t, instance, permission.PermAppDeployCanaryPromote, app.PromoteCanary)
+}
+
+// title: abort canary deploy
+// path: /apps/{appname}/deploy/canary/abort
+// method: POST
+// produce: application/x-json-stream
+// responses:
+//   200: OK
+//   403: Forbidden
+//   404: Not found
+func deployCanaryAbort(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	instance, err := app.GetByName(appName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+	}
+	allowed := permission.Check(t, permission.PermAppDeployCanaryAbort,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, instance.Name),
+			permission.Context(permission.CtxPool, instance.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	return runDeployAction(w, r, t, instance, permission.PermAppDeployCanaryAbort, app.AbortCanary)
+}
+
+// title: revert blue/green deploy
+// path: /apps/{appname}/deploy/blue-green/revert
+// method: POST
+// produce: application/x-json-stream
+// responses:
+//   200: OK
+//   403: Forbidden
+//   404: Not found
+func deployBlueGreenRevert(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	instance, err := app.GetByName(appName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+	}
+	allowed := permission.Check(t, permission.PermAppDeployBlueGreenRevert,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, instance.Name),
+			permission.Context(permission.CtxPool, instance.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	return runDeployAction(w, r, t, instance, permission.PermAppDeployBlueGreenRevert, app.RevertBlueGreen)
+}
+
+func runDeployAction(w http.ResponseWriter, r *http.Request, t auth.Token, instance *app.App, perm *permission.PermissionScheme, fn func(*app.App, *event.Event) error) error {
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(instance.Name),
+		Kind:       perm,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+		Cancelable: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &io.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	err = fn(instance, evt)
+	if err != nil {
+		writer.Encode(io.SimpleJsonMessage{Error: err.Error()})
+	}
+	return nil
+}
+
 func permSchemeForDeploy(opts app.DeployOptions) *permission.PermissionScheme {
 	switch opts.GetKind() {
 	case app.DeployGit:
@@ -220,6 +373,7 @@ func diffDeploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 // produce: application/x-json-stream
 // responses:
 //   200: OK
+//   202: Waiting for approval
 //   400: Invalid data
 //   403: Forbidden
 //   404: Not found
@@ -267,6 +421,14 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 	if !canRollback {
 		return &errors.HTTP{Code: http.StatusForbidden, Message: permission.ErrUnauthorized.Error()}
 	}
+	approval, err := app.RequestDeployApproval(opts)
+	if err != nil {
+		return err
+	}
+	if approval != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return writer.Encode(io.SimpleJsonMessage{Message: deployApprovalMessage(approval)})
+	}
 	var imageID string
 	evt, err := event.New(&event.Opts{
 		Target:     appTarget(appName),
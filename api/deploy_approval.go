@@ -0,0 +1,137 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func deployApprovalMessage(approval *app.DeployApproval) string {
+	return fmt.Sprintf("Pool %q requires deploy approval, the deploy will start once %s is approved, until %s.",
+		approval.Pool, approval.ID, approval.ExpiresAt.Format(time.RFC3339))
+}
+
+// title: deploy approval list
+// path: /apps/{appname}/deploy/approvals
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   403: Forbidden
+//   404: Not found
+func deployApprovalList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	instance, err := app.GetByName(appName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+	}
+	allowed := permission.Check(t, permission.PermAppReadDeploy,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, instance.Name),
+			permission.Context(permission.CtxPool, instance.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	approvals, err := app.ListDeployApprovals(instance.Name)
+	if err != nil {
+		return err
+	}
+	if len(approvals) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(approvals)
+}
+
+// title: deploy approve
+// path: /apps/{appname}/deploy/approvals/{id}/approve
+// method: POST
+// produce: text/plain
+// responses:
+//   200: OK
+//   403: Forbidden
+//   404: Not found
+//   409: Deploy not waiting for approval
+func deployApprove(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return decideDeployApproval(r, t, func(appName, id string) error {
+		w.Header().Set("Content-Type", "text")
+		writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+		defer writer.Stop()
+		_, err := app.ApproveDeploy(appName, id, t.GetUserName(), writer)
+		if err == nil {
+			fmt.Fprintln(w, "\nOK")
+		}
+		return err
+	})
+}
+
+// title: deploy reject
+// path: /apps/{appname}/deploy/approvals/{id}/reject
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: OK
+//   403: Forbidden
+//   404: Not found
+//   409: Deploy not waiting for approval
+func deployReject(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return decideDeployApproval(r, t, func(appName, id string) error {
+		return app.RejectDeploy(appName, id, t.GetUserName(), r.FormValue("reason"))
+	})
+}
+
+func decideDeployApproval(r *http.Request, t auth.Token, decide func(appName, id string) error) (err error) {
+	r.ParseForm()
+	appName := r.URL.Query().Get(":appname")
+	id := r.URL.Query().Get(":id")
+	instance, err := app.GetByName(appName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+	}
+	allowed := permission.Check(t, permission.PermAppApproveDeploy,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxApp, instance.Name),
+			permission.Context(permission.CtxPool, instance.Pool),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:      appTarget(appName),
+		Kind:        permission.PermAppApproveDeploy,
+		Owner:       t,
+		CustomData:  formToEvents(r.Form),
+		DisableLock: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = decide(instance.Name, id)
+	switch err {
+	case app.ErrDeployApprovalNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case app.ErrDeployApprovalByRequester:
+		return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+	case app.ErrDeployApprovalNotPending:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
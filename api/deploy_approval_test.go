@@ -0,0 +1,168 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) insertDeployApproval(c *check.C, appName, requester string) app.DeployApproval {
+	approval := app.DeployApproval{
+		ID:        "58a1b2c3d4e5f6a7b8c9d0e1",
+		App:       appName,
+		Pool:      "protected",
+		Requester: requester,
+		Status:    app.DeployApprovalPending,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+		Options:   app.DeployOptions{Image: "myimage", User: requester},
+	}
+	err := s.conn.DeployApprovals().Insert(approval)
+	c.Assert(err, check.IsNil)
+	return approval
+}
+
+func (s *S) TestDeployApprovalList(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/deploy/approvals", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	s.insertDeployApproval(c, "myapp", "someone@example.com")
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var approvals []app.DeployApproval
+	err = json.Unmarshal(recorder.Body.Bytes(), &approvals)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 1)
+	c.Assert(approvals[0].Requester, check.Equals, "someone@example.com")
+}
+
+func (s *S) TestDeployApprove(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	approval := s.insertDeployApproval(c, "myapp", "someone@example.com")
+	request, err := http.NewRequest("POST", "/apps/myapp/deploy/approvals/"+approval.ID+"/approve", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text")
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*approved by `+s.token.GetUserName()+`.*Image deploy called.*OK\n`)
+	var dbApproval app.DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, app.DeployApprovalApproved)
+	c.Assert(dbApproval.Approver, check.Equals, s.token.GetUserName())
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.approve-deploy",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":appname", "value": "myapp"},
+			{"name": ":id", "value": approval.ID},
+		},
+	}, eventtest.HasEvent)
+	c.Assert(eventtest.EventDesc{
+		Target:        appTarget("myapp"),
+		Owner:         "someone@example.com",
+		Kind:          "app.deploy",
+		EndCustomData: map[string]interface{}{"image": "myimage"},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestDeployReject(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	approval := s.insertDeployApproval(c, "myapp", "someone@example.com")
+	body := strings.NewReader("reason=not+today")
+	request, err := http.NewRequest("POST", "/apps/myapp/deploy/approvals/"+approval.ID+"/reject", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var dbApproval app.DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, app.DeployApprovalRejected)
+	c.Assert(dbApproval.Reason, check.Equals, "not today")
+}
+
+func (s *S) TestDeployApproveByRequester(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	approval := s.insertDeployApproval(c, "myapp", s.token.GetUserName())
+	request, err := http.NewRequest("POST", "/apps/myapp/deploy/approvals/"+approval.ID+"/approve", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrDeployApprovalByRequester.Error()+"\n")
+}
+
+func (s *S) TestDeployApproveWithoutPermission(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	approval := s.insertDeployApproval(c, "myapp", "someone@example.com")
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppDeploy,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	m := RunServer(true)
+	for _, action := range []string{"approve", "reject"} {
+		request, err := http.NewRequest("POST", "/apps/myapp/deploy/approvals/"+approval.ID+"/"+action, nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "b "+token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	}
+	var dbApproval app.DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, app.DeployApprovalPending)
+}
+
+func (s *S) TestDeployApproveNotFound(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/myapp/deploy/approvals/unknown/approve", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
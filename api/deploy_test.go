@@ -23,6 +23,7 @@ import (
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/provisiontest"
@@ -284,6 +285,35 @@ func (s *DeploySuite) TestDeployArchiveURL(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *DeploySuite) TestDeployRequiresApproval(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", Public: true, RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name, Pool: "protected"}
+	err = app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/repository/clone", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("archive-url=http://something.tar.gz"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	approvals, err := app.ListDeployApprovals(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 1)
+	c.Assert(approvals[0].Requester, check.Equals, s.token.GetUserName())
+	c.Assert(approvals[0].Options.ArchiveURL, check.Equals, "http://something.tar.gz")
+	c.Assert(recorder.Body.String(), check.Matches, `Pool "protected" requires deploy approval, the deploy will start once `+approvals[0].ID+` is approved.*\n`)
+	c.Assert(eventtest.EventDesc{
+		Target:  appTarget(a.Name),
+		Kind:    "app.deploy",
+		IsEmpty: true,
+	}, eventtest.HasEvent)
+}
+
 func (s *DeploySuite) TestDeployUploadFile(c *check.C) {
 	user, _ := s.token.User()
 	a := app.App{
@@ -1232,3 +1262,145 @@ func (s *DeploySuite) TestDiffDeployWhenUserDoesNotHaveAccessToApp(c *check.C) {
 `
 	c.Assert(recorder.Body.String(), check.Equals, expected+permission.ErrUnauthorized.Error()+"\n")
 }
+
+func (s *DeploySuite) TestDeployWithInvalidCanaryFraction(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=127.0.0.1:5000/tsuru/otherapp&canary=1.5"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrInvalidCanaryFraction.Error()+"\n")
+}
+
+func (s *DeploySuite) TestDeployCanaryNotSupported(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=127.0.0.1:5000/tsuru/otherapp&canary=0.5"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusInternalServerError)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrCanaryNotSupported.Error()+"\n")
+}
+
+func (s *DeploySuite) TestDeployCanaryWithoutPermission(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppDeployImage,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=127.0.0.1:5000/tsuru/otherapp&canary=0.5"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, "User does not have permission to do this action in this app\n")
+}
+
+func (s *DeploySuite) TestDeployCanaryPromote(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary/promote", a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	var msg io.SimpleJsonMessage
+	err = json.Unmarshal(recorder.Body.Bytes(), &msg)
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Error, check.Equals, app.ErrCanaryNotSupported.Error())
+	c.Assert(eventtest.EventDesc{
+		Target:       appTarget(a.Name),
+		Owner:        s.token.GetUserName(),
+		Kind:         "app.deploy.canary.promote",
+		ErrorMatches: app.ErrCanaryNotSupported.Error(),
+	}, eventtest.HasEvent)
+}
+
+func (s *DeploySuite) TestDeployCanaryAbortForbidden(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppDeployCanaryPromote,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/deploy/canary/abort", a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *DeploySuite) TestDeployWithCanaryAndBlueGreen(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=127.0.0.1:5000/tsuru/otherapp&canary=0.5&blue-green=true"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrCanaryWithBlueGreen.Error()+"\n")
+}
+
+func (s *DeploySuite) TestDeployBlueGreenRevert(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy/blue-green/revert", a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var msg io.SimpleJsonMessage
+	err = json.Unmarshal(recorder.Body.Bytes(), &msg)
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Error, check.Equals, app.ErrBlueGreenNotSupported.Error())
+	c.Assert(eventtest.EventDesc{
+		Target:       appTarget(a.Name),
+		Owner:        s.token.GetUserName(),
+		Kind:         "app.deploy.blue-green.revert",
+		ErrorMatches: app.ErrBlueGreenNotSupported.Error(),
+	}, eventtest.HasEvent)
+}
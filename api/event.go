@@ -31,6 +31,7 @@ var evtPermMap = map[event.TargetType]evtPermChecker{
 	event.TargetTypeNode:            &nodePermChecker{},
 	event.TargetTypeIaas:            &iaasPermChecker{},
 	event.TargetTypeRole:            &rolePermChecker{},
+	event.TargetTypeJob:             &jobPermChecker{},
 }
 
 type checkKind string
@@ -84,6 +85,60 @@ func (c *appPermChecker) check(t auth.Token, r *http.Request, e *event.Event, ki
 	return hasPermission, nil
 }
 
+type jobPermChecker struct{}
+
+func (c *jobPermChecker) filter(t auth.Token) (*event.TargetFilter, error) {
+	contexts := permission.ContextsForPermission(t, permission.PermAppReadEvents)
+	if len(contexts) == 0 {
+		return nil, nil
+	}
+	apps, err := app.List(appFilterByContext(contexts, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) == 0 {
+		return nil, nil
+	}
+	appNames := make([]string, len(apps))
+	for i, a := range apps {
+		appNames[i] = a.Name
+	}
+	jobs, err := app.ListJobsByApps(appNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	allowed := event.TargetFilter{Type: event.TargetTypeJob}
+	for _, j := range jobs {
+		allowed.Values = append(allowed.Values, app.JobTarget(j.App, j.Name).Value)
+	}
+	return &allowed, nil
+}
+
+func (c *jobPermChecker) check(t auth.Token, r *http.Request, e *event.Event, kind checkKind) (bool, error) {
+	v := strings.SplitN(e.Target.Value, "/", 2)
+	if len(v) != 2 {
+		return false, nil
+	}
+	a, err := getAppFromContext(v[0], r)
+	if err != nil {
+		return false, err
+	}
+	perms := map[checkKind]*permission.PermissionScheme{
+		readCheckKind:   permission.PermAppReadEvents,
+		updateCheckKind: permission.PermAppUpdateEvents,
+	}
+	hasPermission := permission.Check(t, perms[kind],
+		append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...,
+	)
+	return hasPermission, nil
+}
+
 type teamPermChecker struct{}
 
 func (c *teamPermChecker) filter(t auth.Token) (*event.TargetFilter, error) {
@@ -458,6 +513,9 @@ func eventList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if err != nil {
 		return err
 	}
+	if r.Form.Get("follow") == "1" {
+		return followEvents(w, filter)
+	}
 	events, err := event.List(filter)
 	if err != nil {
 		return err
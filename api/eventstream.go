@@ -0,0 +1,160 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	eventStreamInterval = time.Second
+	// eventStreamWindow is how far back each check for changed events
+	// looks, as the end time of events is set slightly before they're
+	// stored.
+	eventStreamWindow = 5 * time.Second
+)
+
+const (
+	eventStreamStart  = "start"
+	eventStreamLog    = "log"
+	eventStreamFinish = "finish"
+)
+
+// eventStreamMessage is a change in an event sent to clients following
+// events. Start and finish messages hold the whole event, log messages hold
+// only the log written since the previous message of the event.
+type eventStreamMessage struct {
+	Type    string
+	Event   *event.Event `json:",omitempty"`
+	EventID string       `json:",omitempty"`
+	Log     string       `json:",omitempty"`
+}
+
+type streamedEvent struct {
+	finished bool
+	logSize  int
+}
+
+type eventStream struct {
+	filter  *event.Filter
+	encoder *json.Encoder
+	sent    map[bson.ObjectId]streamedEvent
+}
+
+// check sends the changes in the events running or changed after since.
+func (s *eventStream) check(since time.Time) error {
+	evts, err := event.ListChanged(s.filter, since)
+	if err != nil {
+		return err
+	}
+	sent := make(map[bson.ObjectId]streamedEvent, len(evts))
+	for i := range evts {
+		evt := &evts[i]
+		state, started := s.sent[evt.UniqueID]
+		if !started {
+			err = s.encoder.Encode(eventStreamMessage{Type: eventStreamStart, Event: evt})
+			if err != nil {
+				return err
+			}
+			state.logSize = len(evt.Log)
+		}
+		if len(evt.Log) > state.logSize {
+			err = s.encoder.Encode(eventStreamMessage{
+				Type:    eventStreamLog,
+				EventID: evt.UniqueID.Hex(),
+				Log:     evt.Log[state.logSize:],
+			})
+			if err != nil {
+				return err
+			}
+			state.logSize = len(evt.Log)
+		}
+		if !evt.Running && !state.finished {
+			err = s.encoder.Encode(eventStreamMessage{Type: eventStreamFinish, Event: evt})
+			if err != nil {
+				return err
+			}
+			state.finished = true
+		}
+		sent[evt.UniqueID] = state
+	}
+	s.sent = sent
+	return nil
+}
+
+// followEvents streams, one JSON message per line, the events matching the
+// filter as they start, log and finish, starting with the events currently
+// running. The stream ends when the client disconnects or tsurud shuts down.
+func followEvents(w http.ResponseWriter, filter *event.Filter) error {
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	var closeChan <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeChan = notifier.CloseNotify()
+	} else {
+		closeChan = make(chan bool)
+	}
+	stop := eventStreams.add()
+	defer eventStreams.remove(stop)
+	stream := eventStream{filter: filter, encoder: json.NewEncoder(w)}
+	since := time.Now().UTC()
+	for {
+		now := time.Now().UTC()
+		err := stream.check(since)
+		if err != nil {
+			return err
+		}
+		since = now.Add(-eventStreamWindow)
+		select {
+		case <-closeChan:
+			return nil
+		case <-stop:
+			return nil
+		case <-time.After(eventStreamInterval):
+		}
+	}
+}
+
+type eventStreamTracker struct {
+	sync.Mutex
+	streams map[chan struct{}]struct{}
+}
+
+func (t *eventStreamTracker) add() chan struct{} {
+	t.Lock()
+	defer t.Unlock()
+	if t.streams == nil {
+		t.streams = make(map[chan struct{}]struct{})
+	}
+	stop := make(chan struct{})
+	t.streams[stop] = struct{}{}
+	return stop
+}
+
+func (t *eventStreamTracker) remove(stop chan struct{}) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.streams, stop)
+}
+
+func (t *eventStreamTracker) String() string {
+	return "event streams"
+}
+
+func (t *eventStreamTracker) Shutdown() {
+	t.Lock()
+	defer t.Unlock()
+	for stop := range t.streams {
+		close(stop)
+	}
+	t.streams = nil
+}
+
+var eventStreams eventStreamTracker
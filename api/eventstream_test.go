@@ -0,0 +1,140 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func decodeEventStream(c *check.C, data []byte) []eventStreamMessage {
+	var messages []eventStreamMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var msg eventStreamMessage
+		err := decoder.Decode(&msg)
+		c.Assert(err, check.IsNil)
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func (s *EventSuite) TestEventStreamCheck(c *check.C) {
+	var buf bytes.Buffer
+	stream := eventStream{filter: &event.Filter{}, encoder: json.NewEncoder(&buf)}
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   permission.PermAppDeploy,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	evt.Logf("building")
+	since := time.Now().UTC()
+	err = stream.check(since)
+	c.Assert(err, check.IsNil)
+	messages := decodeEventStream(c, buf.Bytes())
+	c.Assert(messages, check.HasLen, 1)
+	c.Assert(messages[0].Type, check.Equals, eventStreamStart)
+	c.Assert(messages[0].Event.UniqueID, check.Equals, evt.UniqueID)
+	c.Assert(messages[0].Event.Log, check.Equals, "building\n")
+	buf.Reset()
+	time.Sleep(time.Second)
+	evt.Logf("deploying")
+	err = stream.check(since)
+	c.Assert(err, check.IsNil)
+	messages = decodeEventStream(c, buf.Bytes())
+	c.Assert(messages, check.DeepEquals, []eventStreamMessage{
+		{Type: eventStreamLog, EventID: evt.UniqueID.Hex(), Log: "deploying\n"},
+	})
+	buf.Reset()
+	evt.Logf("done")
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	err = stream.check(since)
+	c.Assert(err, check.IsNil)
+	messages = decodeEventStream(c, buf.Bytes())
+	c.Assert(messages, check.HasLen, 2)
+	c.Assert(messages[0].Type, check.Equals, eventStreamLog)
+	c.Assert(messages[0].Log, check.Equals, "done\n")
+	c.Assert(messages[1].Type, check.Equals, eventStreamFinish)
+	c.Assert(messages[1].Event.UniqueID, check.Equals, evt.UniqueID)
+	c.Assert(messages[1].Event.Running, check.Equals, false)
+	buf.Reset()
+	err = stream.check(since)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "")
+	err = stream.check(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "")
+	c.Assert(stream.sent, check.HasLen, 0)
+}
+
+func (s *EventSuite) TestEventStreamCheckStartedAndFinished(c *check.C) {
+	var buf bytes.Buffer
+	stream := eventStream{filter: &event.Filter{}, encoder: json.NewEncoder(&buf)}
+	since := time.Now().UTC()
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   permission.PermAppDeploy,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	err = stream.check(since)
+	c.Assert(err, check.IsNil)
+	messages := decodeEventStream(c, buf.Bytes())
+	c.Assert(messages, check.HasLen, 2)
+	c.Assert(messages[0].Type, check.Equals, eventStreamStart)
+	c.Assert(messages[1].Type, check.Equals, eventStreamFinish)
+}
+
+func (s *EventSuite) TestEventListFollow(c *check.C) {
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   permission.PermAppDeploy,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	defer evt.Done(nil)
+	request, err := http.NewRequest("GET", "/events?follow=1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.ServeHTTP(recorder, request)
+	}()
+	for i := 0; i < 100; i++ {
+		eventStreams.Lock()
+		n := len(eventStreams.streams)
+		eventStreams.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	eventStreams.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for event stream to finish")
+	}
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	messages := decodeEventStream(c, recorder.Body.Bytes())
+	c.Assert(messages, check.HasLen, 1)
+	c.Assert(messages[0].Type, check.Equals, eventStreamStart)
+	c.Assert(messages[0].Event.UniqueID, check.Equals, evt.UniqueID)
+}
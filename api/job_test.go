@@ -0,0 +1,174 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestJobCreate(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=cleanup&schedule=@daily&command=./cleanup.sh&process=worker&timeout=60")
+	request, err := http.NewRequest("POST", "/apps/myapp/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var job app.Job
+	err = json.Unmarshal(recorder.Body.Bytes(), &job)
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Name, check.Equals, "cleanup")
+	c.Assert(job.NextRun.IsZero(), check.Equals, false)
+	dbJob, err := app.GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.Command, check.Equals, "./cleanup.sh")
+	c.Assert(dbJob.Process, check.Equals, "worker")
+	c.Assert(dbJob.Timeout, check.Equals, 60)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.job.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "cleanup"},
+			{"name": "schedule", "value": "@daily"},
+			{"name": "command", "value": "./cleanup.sh"},
+			{"name": "process", "value": "worker"},
+			{"name": "timeout", "value": "60"},
+			{"name": ":app", "value": "myapp"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestJobCreateInvalid(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	var tests = []struct {
+		body    string
+		message string
+	}{
+		{"name=cleanup&schedule=@daily&command=ls&timeout=abc", "Parameter \"timeout\" must be an integer.\n"},
+		{"name=cleanup&schedule=@daily", "job command is required\n"},
+		{"name=cleanup&schedule=* *&command=ls", "invalid cron expression .*\n"},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest("POST", "/apps/myapp/jobs", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Matches, t.message)
+	}
+}
+
+func (s *S) TestJobCreateAlreadyExists(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = app.CreateJob(&app.Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=cleanup&schedule=@hourly&command=ls")
+	request, err := http.NewRequest("POST", "/apps/myapp/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrJobAlreadyExists.Error()+"\n")
+}
+
+func (s *S) TestJobCreateForbidden(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadJob,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	body := strings.NewReader("name=cleanup&schedule=@daily&command=ls")
+	request, err := http.NewRequest("POST", "/apps/myapp/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestJobList(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/jobs", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	err = app.CreateJob(&app.Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	request, err = http.NewRequest("GET", "/apps/myapp/jobs", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var jobs []app.Job
+	err = json.Unmarshal(recorder.Body.Bytes(), &jobs)
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].Schedule, check.Equals, "@daily")
+}
+
+func (s *S) TestJobDelete(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = app.CreateJob(&app.Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/jobs/cleanup", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = app.GetJob("myapp", "cleanup")
+	c.Assert(err, check.Equals, app.ErrJobNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.job.delete",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": "myapp"},
+			{"name": ":job", "value": "cleanup"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
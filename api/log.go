@@ -8,12 +8,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"runtime"
 
+	"github.com/ajg/form"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/api/context"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
 	"golang.org/x/net/websocket"
 )
 
@@ -70,3 +75,108 @@ func scanLogs(stream io.Reader) error {
 type errMsg struct {
 	Error string `json:"error"`
 }
+
+// title: log forward config
+// path: /logs/forward
+// method: GET
+// produce: application/json
+// responses:
+//   200: Ok
+//   401: Unauthorized
+func logForwardConfigGet(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	contexts := permission.ContextsForPermission(t, permission.PermPoolUpdateLogs)
+	if len(contexts) == 0 {
+		return permission.ErrUnauthorized
+	}
+	configs, err := app.LogForwardLoadAll()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	filtered := map[string]app.LogForwardConfig{}
+	for _, ctx := range contexts {
+		if ctx.CtxType == permission.CtxGlobal {
+			return json.NewEncoder(w).Encode(configs)
+		}
+		if conf, ok := configs[ctx.Value]; ok {
+			filtered[ctx.Value] = conf
+		}
+	}
+	return json.NewEncoder(w).Encode(filtered)
+}
+
+// title: log forward config set
+// path: /logs/forward
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+func logForwardConfigSet(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	err := r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("unable to parse form values: %s", err),
+		}
+	}
+	pool := r.FormValue("pool")
+	delete(r.Form, "pool")
+	var conf app.LogForwardConfig
+	dec := form.NewDecoder(nil)
+	dec.IgnoreUnknownKeys(true)
+	err = dec.DecodeValues(&conf, r.Form)
+	if err != nil {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("unable to parse fields in log forward config: %s", err),
+		}
+	}
+	var allowed bool
+	if pool == "" {
+		allowed = permission.Check(t, permission.PermPoolUpdateLogs)
+	} else {
+		allowed = permission.Check(t, permission.PermPoolUpdateLogs,
+			permission.Context(permission.CtxPool, pool))
+	}
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	err = conf.Save(pool)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+// title: log forward failures
+// path: /logs/forward/failures
+// method: GET
+// produce: application/json
+// responses:
+//   200: Ok
+//   204: No content
+//   401: Unauthorized
+func logForwardFailuresList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	contexts := permission.ContextsForPermission(t, permission.PermPoolUpdateLogs)
+	if len(contexts) == 0 {
+		return permission.ErrUnauthorized
+	}
+	failures, err := app.LogForwardFailures()
+	if err != nil {
+		return err
+	}
+	var result []app.LogForwardFailure
+	for _, f := range failures {
+		if permission.Check(t, permission.PermPoolUpdateLogs, permission.Context(permission.CtxPool, f.Pool)) {
+			result = append(result, f)
+		}
+	}
+	if len(result) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
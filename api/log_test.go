@@ -5,8 +5,10 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"sort"
@@ -18,6 +20,7 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/repository/repositorytest"
 	"golang.org/x/net/websocket"
 	"gopkg.in/check.v1"
@@ -199,3 +202,85 @@ func (s *S) BenchmarkScanLogs(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.StopTimer()
 }
+
+func (s *S) TestLogForwardConfigSet(c *check.C) {
+	body := strings.NewReader("pool=pool1&SyslogURL=udp://syslog.example.com:514&HTTPBatchSize=50")
+	request, err := http.NewRequest("POST", "/logs/forward", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	conf, err := app.LogForwardConfigForPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(conf, check.DeepEquals, app.LogForwardConfig{
+		SyslogURL:     "udp://syslog.example.com:514",
+		HTTPBatchSize: 50,
+	})
+}
+
+func (s *S) TestLogForwardConfigSetInvalid(c *check.C) {
+	body := strings.NewReader("pool=pool1&SyslogURL=syslog.example.com")
+	request, err := http.NewRequest("POST", "/logs/forward", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrInvalidSyslogURL.Error()+"\n")
+}
+
+func (s *S) TestLogForwardConfigSetNoPermission(c *check.C) {
+	token := customUserWithPermission(c, "logforwarder", permission.Permission{
+		Scheme:  permission.PermPoolUpdateLogs,
+		Context: permission.Context(permission.CtxPool, "pool2"),
+	})
+	body := strings.NewReader("pool=pool1&SyslogURL=udp://syslog.example.com:514")
+	request, err := http.NewRequest("POST", "/logs/forward", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestLogForwardConfigGet(c *check.C) {
+	conf := app.LogForwardConfig{HTTPURL: "http://logs.example.com/bulk"}
+	err := conf.Save("pool1")
+	c.Assert(err, check.IsNil)
+	conf = app.LogForwardConfig{SyslogURL: "tcp://syslog.example.com:514"}
+	err = conf.Save("pool2")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "logforwarder", permission.Permission{
+		Scheme:  permission.PermPoolUpdateLogs,
+		Context: permission.Context(permission.CtxPool, "pool2"),
+	})
+	request, err := http.NewRequest("GET", "/logs/forward", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result map[string]app.LogForwardConfig
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string]app.LogForwardConfig{"pool2": conf})
+}
+
+func (s *S) TestLogForwardFailuresListEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/logs/forward/failures", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
@@ -413,6 +415,13 @@ func assignRole(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	defer func() { evt.Done(err) }()
 	email := r.FormValue("email")
 	contextValue := r.FormValue("context")
+	var expiration time.Duration
+	if expires := r.FormValue("expires"); expires != "" {
+		expiration, err = time.ParseDuration(expires)
+		if err != nil || expiration <= 0 {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid expires: %q", expires)}
+		}
+	}
 	user, err := auth.GetUserByEmail(email)
 	if err != nil {
 		return err
@@ -422,6 +431,9 @@ func assignRole(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 		return err
 	}
 	err = runWithPermSync([]auth.User{*user}, func() error {
+		if expiration > 0 {
+			return user.AddTemporaryRole(roleName, contextValue, time.Now().UTC().Add(expiration))
+		}
 		return user.AddRole(roleName, contextValue)
 	})
 	return err
@@ -501,6 +513,151 @@ func listPermissions(w http.ResponseWriter, r *http.Request, t auth.Token) error
 	return json.NewEncoder(w).Encode(permList)
 }
 
+type permissionCheckMatch struct {
+	Role         string
+	RoleContext  string
+	Permission   string
+	ContextType  string
+	ContextValue string
+}
+
+type permissionCheckResult struct {
+	User       string
+	Permission string
+	Contexts   []rolePermissionData
+	Allowed    bool
+	Matches    []permissionCheckMatch
+}
+
+// checkContexts returns the contexts to be checked for a context in the form
+// <type>:<value>. Like the handlers do, an app context is expanded into the
+// contexts of the app teams and pool, which requires permission to read the
+// app.
+func checkContexts(t auth.Token, value string) ([]permission.PermissionContext, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	ctxType, err := permission.ParseContext(parts[0])
+	if err != nil || len(parts) != 2 || parts[1] == "" {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid context %q, it must be in the form <type>:<value>", value)}
+	}
+	if ctxType != permission.CtxApp {
+		return []permission.PermissionContext{permission.Context(ctxType, parts[1])}, nil
+	}
+	a, err := app.GetByName(parts[1])
+	if err != nil {
+		if err == app.ErrAppNotFound {
+			return nil, &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+		}
+		return nil, err
+	}
+	contexts := append(permission.Contexts(permission.CtxTeam, a.Teams),
+		permission.Context(permission.CtxApp, a.Name),
+		permission.Context(permission.CtxPool, a.Pool),
+	)
+	if !permission.Check(t, permission.PermAppRead, contexts...) {
+		return nil, permission.ErrUnauthorized
+	}
+	return contexts, nil
+}
+
+// title: check permission
+// path: /permissions/check
+// method: GET
+// produce: application/json
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Not found
+func checkPermission(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	email := r.URL.Query().Get("user")
+	if email == "" {
+		email = t.GetUserName()
+	}
+	if email != t.GetUserName() && !permission.Check(t, permission.PermRoleUpdateAssign) {
+		return permission.ErrUnauthorized
+	}
+	permName := r.URL.Query().Get("permission")
+	if permName == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "permission is required"}
+	}
+	scheme, err := permission.SafeGet(permName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	contexts, err := checkContexts(t, r.URL.Query().Get("context"))
+	if err != nil {
+		return err
+	}
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		return handleAuthError(err)
+	}
+	result := permissionCheckResult{
+		User:       user.Email,
+		Permission: scheme.FullName(),
+		Contexts:   make([]rolePermissionData, len(contexts)),
+	}
+	for i, ctx := range contexts {
+		result.Contexts[i] = rolePermissionData{ContextType: string(ctx.CtxType), ContextValue: ctx.Value}
+	}
+	// The token permissions take personal token scopes into account, so
+	// they're used when the user checks their own permissions.
+	var perms []permission.Permission
+	if email == t.GetUserName() {
+		perms, err = t.Permissions()
+	} else {
+		perms, err = user.Permissions()
+	}
+	if err != nil {
+		return err
+	}
+	result.Allowed = permission.CheckFromPermList(perms, scheme, contexts...)
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Allowed {
+		return json.NewEncoder(w).Encode(result)
+	}
+	roleCache := make(map[string]*permission.Role)
+	now := time.Now()
+	for _, roleInstance := range user.Roles {
+		if roleInstance.Expired(now) {
+			continue
+		}
+		role := roleCache[roleInstance.Name]
+		if role == nil {
+			foundRole, err := permission.FindRole(roleInstance.Name)
+			if err != nil {
+				if err == permission.ErrRoleNotFound {
+					continue
+				}
+				return err
+			}
+			role = &foundRole
+			roleCache[roleInstance.Name] = role
+		}
+		for _, perm := range role.PermissionsFor(roleInstance.ContextValue) {
+			if !perm.Grants(scheme, contexts...) {
+				continue
+			}
+			permName := perm.Scheme.FullName()
+			if permName == "" {
+				permName = "*"
+			}
+			result.Matches = append(result.Matches, permissionCheckMatch{
+				Role:         roleInstance.Name,
+				RoleContext:  roleInstance.ContextValue,
+				Permission:   permName,
+				ContextType:  string(perm.Context.CtxType),
+				ContextValue: perm.Context.Value,
+			})
+		}
+	}
+	return json.NewEncoder(w).Encode(result)
+}
+
 // title: add default role
 // path: /role/default
 // method: POST
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"time"
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
@@ -441,6 +442,50 @@ func (s *S) TestAssignRole(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestAssignRoleWithExpiration(c *check.C) {
+	role, err := permission.NewRole("test", "team", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("app.create")
+	c.Assert(err, check.IsNil)
+	emptyToken := customUserWithPermission(c, "user2")
+	roleBody := bytes.NewBufferString(fmt.Sprintf("email=%s&context=myteam&expires=2h", emptyToken.GetUserName()))
+	req, err := http.NewRequest("POST", "/roles/test/user", roleBody)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "user1", permission.Permission{
+		Scheme:  permission.PermRoleUpdateAssign,
+		Context: permission.Context(permission.CtxGlobal, ""),
+	}, permission.Permission{
+		Scheme:  permission.PermAppCreate,
+		Context: permission.Context(permission.CtxTeam, "myteam"),
+	})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, req)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	emptyUser, err := emptyToken.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(emptyUser.Roles, check.HasLen, 1)
+	expiresIn := emptyUser.Roles[0].ExpiresAt.Sub(time.Now())
+	c.Assert(expiresIn > time.Hour && expiresIn <= 2*time.Hour, check.Equals, true)
+}
+
+func (s *S) TestAssignRoleInvalidExpiration(c *check.C) {
+	_, err := permission.NewRole("test", "team", "")
+	c.Assert(err, check.IsNil)
+	roleBody := bytes.NewBufferString("email=" + s.user.Email + "&context=myteam&expires=-2h")
+	req, err := http.NewRequest("POST", "/roles/test/user", roleBody)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, req)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid expires: \"-2h\"\n")
+}
+
 func (s *S) TestAssignRoleNotFound(c *check.C) {
 	emptyToken := customUserWithPermission(c, "user2")
 	roleBody := bytes.NewBufferString(fmt.Sprintf("email=%s&context=myteam", emptyToken.GetUserName()))
@@ -684,6 +729,159 @@ func (s *S) TestListPermissions(c *check.C) {
 	})
 }
 
+func (s *S) TestCheckPermission(c *check.C) {
+	a := app.App{Name: "myapp", Teams: []string{"team1"}, Pool: "pool1"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermApp,
+		Context: permission.Context(permission.CtxTeam, "team1"),
+	})
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&context=app:myapp", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	var result permissionCheckResult
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, permissionCheckResult{
+		User:       token.GetUserName(),
+		Permission: "app.deploy",
+		Contexts: []rolePermissionData{
+			{ContextType: "team", ContextValue: "team1"},
+			{ContextType: "app", ContextValue: "myapp"},
+			{ContextType: "pool", ContextValue: "pool1"},
+		},
+		Allowed: true,
+		Matches: []permissionCheckMatch{
+			{Role: "majortomappteam1", RoleContext: "team1", Permission: "app", ContextType: "team", ContextValue: "team1"},
+		},
+	})
+}
+
+func (s *S) TestCheckPermissionDenied(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermApp,
+		Context: permission.Context(permission.CtxTeam, "team1"),
+	})
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&context=team:team2", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	var result permissionCheckResult
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Allowed, check.Equals, false)
+	c.Assert(result.Matches, check.HasLen, 0)
+}
+
+func (s *S) TestCheckPermissionOtherUser(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermRoleUpdateAssign,
+		Context: permission.Context(permission.CtxGlobal, ""),
+	})
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&user="+s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	var result permissionCheckResult
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.User, check.Equals, s.user.Email)
+	c.Assert(result.Allowed, check.Equals, true)
+	c.Assert(result.Matches, check.DeepEquals, []permissionCheckMatch{
+		{Role: "super-root-toremove", Permission: "*", ContextType: "global"},
+	})
+}
+
+func (s *S) TestCheckPermissionOtherUserWithoutPermission(c *check.C) {
+	token := userWithPermission(c)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&user="+s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestCheckPermissionPersonalTokenScope(c *check.C) {
+	scope := auth.TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := auth.CreatePersonalToken(s.user, "reader", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	server := RunServer(true)
+	for perm, allowed := range map[string]bool{"app.read": true, "app.deploy": false} {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/permissions/check?permission="+perm, nil)
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Authorization", "bearer "+token.Token)
+		server.ServeHTTP(rec, req)
+		c.Assert(rec.Code, check.Equals, http.StatusOK)
+		var result permissionCheckResult
+		err = json.Unmarshal(rec.Body.Bytes(), &result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.User, check.Equals, s.user.Email)
+		c.Assert(result.Allowed, check.Equals, allowed, check.Commentf(perm))
+		c.Assert(result.Matches != nil, check.Equals, allowed, check.Commentf(perm))
+	}
+}
+
+func (s *S) TestCheckPermissionAppContextWithoutReadPermission(c *check.C) {
+	a := app.App{Name: "myapp", Teams: []string{"team1"}, Pool: "pool1"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxTeam, "team2"),
+	})
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&context=app:myapp", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestCheckPermissionInvalid(c *check.C) {
+	token := userWithPermission(c)
+	tests := []string{
+		"/permissions/check",
+		"/permissions/check?permission=app.invalid",
+		"/permissions/check?permission=app.deploy&context=app",
+		"/permissions/check?permission=app.deploy&context=invalid:x",
+	}
+	for _, url := range tests {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Authorization", "bearer "+token.GetValue())
+		server := RunServer(true)
+		server.ServeHTTP(rec, req)
+		c.Assert(rec.Code, check.Equals, http.StatusBadRequest, check.Commentf(url))
+	}
+}
+
+func (s *S) TestCheckPermissionAppNotFound(c *check.C) {
+	token := userWithPermission(c)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/permissions/check?permission=app.deploy&context=app:unknown", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer "+token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusNotFound)
+}
+
 func (s *S) TestAddDefaultRole(c *check.C) {
 	_, err := permission.NewRole("r1", "team", "")
 	c.Assert(err, check.IsNil)
@@ -0,0 +1,139 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// isPersonalToken reports whether the request is authenticated by a personal
+// access token, which can't be used to obtain other tokens nor to change the
+// account of its owner.
+func isPersonalToken(t auth.Token) bool {
+	apiToken, ok := t.(*auth.APIToken)
+	return ok && apiToken.IsPersonal()
+}
+
+// title: personal token list
+// path: /users/personal-tokens
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func personalTokenList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	tokens, err := auth.ListPersonalTokens(t.GetUserName())
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(tokens)
+}
+
+// title: personal token create
+// path: /users/personal-tokens
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Token created
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   409: Token already exists
+func personalTokenCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	var scopes []auth.TokenScope
+	for _, value := range r.Form["permission"] {
+		scope, err := auth.ParseTokenScope(value)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		scopes = append(scopes, scope)
+	}
+	var expiration time.Duration
+	if expires := r.FormValue("expires"); expires != "" {
+		expiration, err = time.ParseDuration(expires)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid expires: " + err.Error()}
+		}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(u.Email),
+		Kind:       permission.PermUserUpdateToken,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	token, err := auth.CreatePersonalToken(u, r.FormValue("name"), scopes, expiration)
+	if err != nil {
+		if err == auth.ErrPersonalTokenAlreadyExists {
+			return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+		}
+		if _, ok := err.(*errors.ValidationError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(token)
+}
+
+// title: personal token revoke
+// path: /users/personal-tokens/{name}
+// method: DELETE
+// responses:
+//   200: Token revoked
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Token not found
+func personalTokenRevoke(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if isPersonalToken(t) {
+		return permission.ErrUnauthorized
+	}
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(t.GetUserName()),
+		Kind:       permission.PermUserUpdateToken,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = auth.RevokePersonalToken(t.GetUserName(), name)
+	if err == auth.ErrPersonalTokenNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
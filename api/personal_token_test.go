@@ -0,0 +1,203 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPersonalTokenCreate(c *check.C) {
+	body := strings.NewReader("name=ci&permission=app.deploy:team:" + s.team.Name + "&expires=2h")
+	request, err := http.NewRequest("POST", "/users/personal-tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var token auth.APIToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &token)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.Token, check.Not(check.Equals), "")
+	c.Assert(token.Name, check.Equals, "ci")
+	c.Assert(token.ExpiresAt.Sub(token.CreatedAt), check.Equals, 2*time.Hour)
+	c.Assert(token.Scopes, check.DeepEquals, []auth.TokenScope{
+		{Permission: "app.deploy", Context: permission.Context(permission.CtxTeam, s.team.Name)},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.token.GetUserName()),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.token",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "ci"},
+			{"name": "permission", "value": "app.deploy:team:" + s.team.Name},
+			{"name": "expires", "value": "2h"},
+		},
+	}, eventtest.HasEvent)
+	request, err = http.NewRequest("GET", "/apps", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestPersonalTokenCreateInvalidScope(c *check.C) {
+	body := strings.NewReader("name=ci&permission=app.explode")
+	request, err := http.NewRequest("POST", "/users/personal-tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, `permission named "app.explode" not found`+"\n")
+}
+
+func (s *S) TestPersonalTokenCreateWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=ci&permission=app.deploy:team:" + s.team.Name)
+	request, err := http.NewRequest("POST", "/users/personal-tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "user does not have permission app.deploy(team "+s.team.Name+")\n")
+}
+
+func (s *S) TestPersonalTokenCannotCreateTokens(c *check.C) {
+	scope := auth.TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := auth.CreatePersonalToken(s.user, "reader", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	m := RunServer(true)
+	body := strings.NewReader("name=other&permission=app")
+	request, err := http.NewRequest("POST", "/users/personal-tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	request, err = http.NewRequest("GET", "/users/api-key", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestPersonalTokenList(c *check.C) {
+	request, err := http.NewRequest("GET", "/users/personal-tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	scope := auth.TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	_, err = auth.CreatePersonalToken(s.user, "reader", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var tokens []auth.APIToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &tokens)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0].Name, check.Equals, "reader")
+	c.Assert(tokens[0].Token, check.Equals, "")
+}
+
+func (s *S) TestPersonalTokenRevoke(c *check.C) {
+	scope := auth.TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := auth.CreatePersonalToken(s.user, "reader", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/users/personal-tokens/reader", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = auth.APIAuth("bearer " + token.Token)
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) personalTokenRequest(c *check.C, method, url, body string) *httptest.ResponseRecorder {
+	scope := auth.TokenScope{Permission: "app", Context: permission.Context(permission.CtxApp, "myapp")}
+	token, err := auth.CreatePersonalToken(s.user, "scoped", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.Token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *S) TestPersonalTokenCannotChangePassword(c *check.C) {
+	recorder := s.personalTokenRequest(c, "PUT", "/users/password", "old=123456&new=654321&confirm=654321")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestPersonalTokenCannotDisableTwoFactor(c *check.C) {
+	recorder := s.personalTokenRequest(c, "DELETE", "/users/two-factor?otp=123456", "")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestPersonalTokenCannotAddKey(c *check.C) {
+	recorder := s.personalTokenRequest(c, "POST", "/users/keys", "name=some-key&key=my-key")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	keys, err := s.user.ListKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 0)
+}
+
+func (s *S) TestPersonalTokenCannotRemoveKey(c *check.C) {
+	recorder := s.personalTokenRequest(c, "DELETE", "/users/keys/some-key", "")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestPersonalTokenCannotRemoveUser(c *check.C) {
+	recorder := s.personalTokenRequest(c, "DELETE", "/users", "")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestPersonalTokenCannotRevokeTokens(c *check.C) {
+	scope := auth.TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := auth.CreatePersonalToken(s.user, "reader", []auth.TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	recorder := s.personalTokenRequest(c, "DELETE", "/users/personal-tokens/reader", "")
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = auth.APIAuth("bearer " + token.Token)
+	c.Assert(err, check.IsNil)
+}
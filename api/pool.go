@@ -81,11 +81,13 @@ func addPoolHandler(w http.ResponseWriter, r *http.Request, t auth.Token) (err e
 	public, _ := strconv.ParseBool(r.FormValue("public"))
 	isDefault, _ := strconv.ParseBool(r.FormValue("default"))
 	force, _ := strconv.ParseBool(r.FormValue("force"))
+	requireApproval, _ := strconv.ParseBool(r.FormValue("requireDeployApproval"))
 	p := provision.AddPoolOptions{
-		Name:    r.FormValue("name"),
-		Public:  public,
-		Default: isDefault,
-		Force:   force,
+		Name:                  r.FormValue("name"),
+		Public:                public,
+		Default:               isDefault,
+		Force:                 force,
+		RequireDeployApproval: requireApproval,
 	}
 	if p.Name == "" {
 		return &terrors.HTTP{
@@ -266,6 +268,10 @@ func poolUpdateHandler(w http.ResponseWriter, r *http.Request, t auth.Token) (er
 		public, _ := strconv.ParseBool(v)
 		query["public"] = public
 	}
+	if v := r.FormValue("requireDeployApproval"); v != "" {
+		requireApproval, _ := strconv.ParseBool(v)
+		query["requiredeployapproval"] = requireApproval
+	}
 	forceDefault, _ := strconv.ParseBool(r.FormValue("force"))
 	err = provision.PoolUpdate(poolName, query, forceDefault)
 	if err == provision.ErrPoolNotFound {
@@ -400,6 +400,26 @@ func (s *S) TestPoolUpdateToPublicHandler(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestPoolUpdateRequireDeployApprovalHandler(c *check.C) {
+	opts := provision.AddPoolOptions{Name: "pool1"}
+	err := provision.AddPool(opts)
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	b := bytes.NewBufferString("requireDeployApproval=true")
+	req, err := http.NewRequest("PUT", "/pools/pool1", b)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	rec := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	p, err := provision.GetPoolByName("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.RequireDeployApproval, check.Equals, true)
+	c.Assert(p.Public, check.Equals, false)
+}
+
 func (s *S) TestPoolUpdateToDefaultPoolHandler(c *check.C) {
 	provision.RemovePool("test1")
 	opts := provision.AddPoolOptions{Name: "pool1"}
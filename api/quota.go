@@ -6,6 +6,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -159,3 +160,121 @@ func changeAppQuota(w http.ResponseWriter, r *http.Request, t auth.Token) (err e
 	}
 	return app.ChangeQuota(&a, limit)
 }
+
+type teamQuotaItem struct {
+	Limit int64
+	InUse int64
+}
+
+type teamQuotaData struct {
+	Apps   teamQuotaItem
+	Units  teamQuotaItem
+	Memory teamQuotaItem
+}
+
+// title: team quota
+// path: /teams/{name}/quota
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Team not found
+func getTeamQuota(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamReadQuota,
+		permission.Context(permission.CtxTeam, name),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	team, err := auth.GetTeam(name)
+	if err == auth.ErrTeamNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	usage, err := app.GetTeamQuotaUsage(name)
+	if err != nil {
+		return err
+	}
+	limits := team.GetQuota()
+	data := teamQuotaData{
+		Apps:   teamQuotaItem{Limit: int64(limits.Apps), InUse: int64(usage.Apps)},
+		Units:  teamQuotaItem{Limit: int64(limits.Units), InUse: int64(usage.Units)},
+		Memory: teamQuotaItem{Limit: limits.Memory, InUse: usage.Memory},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(data)
+}
+
+// title: update team quota
+// path: /teams/{name}/quota
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Quota updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Team not found
+func changeTeamQuota(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdateQuota)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	team, err := auth.GetTeam(name)
+	if err == auth.ErrTeamNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(name),
+		Kind:       permission.PermTeamUpdateQuota,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	limits := team.GetQuota()
+	apps, err := teamQuotaLimit(r, "apps", int64(limits.Apps))
+	if err != nil {
+		return err
+	}
+	units, err := teamQuotaLimit(r, "units", int64(limits.Units))
+	if err != nil {
+		return err
+	}
+	memory, err := teamQuotaLimit(r, "memory", limits.Memory)
+	if err != nil {
+		return err
+	}
+	limits = auth.TeamQuota{Apps: int(apps), Units: int(units), Memory: memory}
+	err = app.ChangeTeamQuota(team, limits)
+	if err == app.ErrTeamQuotaLimitBelowUsage {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+// teamQuotaLimit returns the limit in the given form field, or current when
+// the field is not set.
+func teamQuotaLimit(r *http.Request, field string, current int64) (int64, error) {
+	if _, ok := r.Form[field]; !ok {
+		return current, nil
+	}
+	limit, err := strconv.ParseInt(r.FormValue(field), 10, 64)
+	if err != nil {
+		return 0, &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Invalid %s limit", field),
+		}
+	}
+	return limit, nil
+}
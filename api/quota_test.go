@@ -53,6 +53,12 @@ func (s *QuotaSuite) SetUpTest(c *check.C) {
 	}, permission.Permission{
 		Scheme:  permission.PermUserUpdateQuota,
 		Context: permission.Context(permission.CtxGlobal, ""),
+	}, permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermTeamUpdateQuota,
+		Context: permission.Context(permission.CtxGlobal, ""),
 	})
 	s.user, err = s.token.User()
 	c.Assert(err, check.IsNil)
@@ -393,3 +399,151 @@ func (s *QuotaSuite) TestChangeAppQuotaAppNotFound(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
 	c.Assert(recorder.Body.String(), check.Equals, app.ErrAppNotFound.Error()+"\n")
 }
+
+func (s *QuotaSuite) TestGetTeamQuota(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = s.team.SetQuota(auth.TeamQuota{Apps: 5, Units: -1, Memory: 1000})
+	c.Assert(err, check.IsNil)
+	err = conn.Apps().Insert(app.App{
+		Name:      "shangrila",
+		TeamOwner: s.team.Name,
+		Plan:      app.Plan{Memory: 100},
+		Quota:     quota.Quota{Limit: -1, InUse: 3},
+	})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/teams/superteam/quota", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var data teamQuotaData
+	err = json.NewDecoder(recorder.Body).Decode(&data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.DeepEquals, teamQuotaData{
+		Apps:   teamQuotaItem{Limit: 5, InUse: 1},
+		Units:  teamQuotaItem{Limit: -1, InUse: 3},
+		Memory: teamQuotaItem{Limit: 1000, InUse: 300},
+	})
+}
+
+func (s *QuotaSuite) TestGetTeamQuotaRequiresPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxTeam, "otherteam"),
+	})
+	request, _ := http.NewRequest("GET", "/teams/superteam/quota", nil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *QuotaSuite) TestGetTeamQuotaTeamNotFound(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxGlobal, ""),
+	})
+	request, _ := http.NewRequest("GET", "/teams/unknown/quota", nil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrTeamNotFound.Error()+"\n")
+}
+
+func (s *QuotaSuite) TestChangeTeamQuota(c *check.C) {
+	err := s.team.SetQuota(auth.TeamQuota{Apps: 5, Units: 10, Memory: 1000})
+	c.Assert(err, check.IsNil)
+	body := bytes.NewBufferString("apps=8&memory=-1")
+	request, _ := http.NewRequest("PUT", "/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.DeepEquals, &auth.TeamQuota{Apps: 8, Units: 10, Memory: -1})
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget(s.team.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.update.quota",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": s.team.Name},
+			{"name": "apps", "value": "8"},
+			{"name": "memory", "value": "-1"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaRequiresPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := bytes.NewBufferString("apps=8")
+	request, _ := http.NewRequest("PUT", "/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaTeamAdmin(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeam,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := bytes.NewBufferString("apps=8")
+	request, _ := http.NewRequest("PUT", "/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaInvalidLimitValue(c *check.C) {
+	handler := RunServer(true)
+	for _, body := range []string{"apps=a", "units=", "memory=1.5"} {
+		request, _ := http.NewRequest("PUT", "/teams/superteam/quota", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(body))
+		c.Check(recorder.Body.String(), check.Matches, "Invalid (apps|units|memory) limit\n")
+	}
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaLesserThanUsage(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Apps().Insert(app.App{
+		Name:      "shangrila",
+		TeamOwner: s.team.Name,
+		Quota:     quota.Quota{Limit: -1, InUse: 3},
+	})
+	c.Assert(err, check.IsNil)
+	body := bytes.NewBufferString("units=2")
+	request, _ := http.NewRequest("PUT", "/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrTeamQuotaLimitBelowUsage.Error()+"\n")
+}
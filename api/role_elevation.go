@@ -0,0 +1,278 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+)
+
+const (
+	roleExpireEventKind = "role.expire"
+	roleReaperLogTag    = "[role reaper]"
+)
+
+var (
+	roleReaperInterval = time.Minute
+	roleReaperMut      sync.Mutex
+	roleReaperInstance *roleReaper
+)
+
+func roleElevationError(err error) error {
+	switch err {
+	case auth.ErrElevationNotFound, permission.ErrRoleNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case auth.ErrElevationNotPending:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	case auth.ErrElevationSelfApproval:
+		return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+	}
+	if _, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+// title: request role elevation
+// path: /roles/{name}/elevations
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Request created
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Role not found
+func requestRoleElevation(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	roleName := r.URL.Query().Get(":name")
+	duration, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid duration: %q", r.FormValue("duration"))}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	elevation, err := auth.RequestRoleElevation(u, roleName, r.FormValue("context"), duration, r.FormValue("reason"))
+	if err != nil {
+		return roleElevationError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(elevation)
+}
+
+// title: list role elevations
+// path: /roles/elevations
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func listRoleElevations(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var email string
+	if !permission.Check(t, permission.PermRoleUpdateAssign) {
+		email = t.GetUserName()
+	}
+	elevations, err := auth.ListRoleElevations(email)
+	if err != nil {
+		return err
+	}
+	if len(elevations) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(elevations)
+}
+
+// title: approve role elevation
+// path: /roles/elevations/{id}/approve
+// method: POST
+// responses:
+//   200: Ok
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Request not found
+//   409: Request not pending
+func approveRoleElevation(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if !permission.Check(t, permission.PermRoleUpdateAssign) {
+		return permission.ErrUnauthorized
+	}
+	elevation, err := auth.GetRoleElevation(r.URL.Query().Get(":id"))
+	if err != nil {
+		return roleElevationError(err)
+	}
+	err = canUseRole(t, elevation.RoleName, elevation.ContextValue)
+	if err != nil {
+		return err
+	}
+	user, err := auth.GetUserByEmail(elevation.Email)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: event.TargetTypeRole, Value: elevation.RoleName},
+		Kind:   permission.PermRoleUpdateAssign,
+		Owner:  t,
+		CustomData: []map[string]interface{}{
+			{"name": "email", "value": elevation.Email},
+			{"name": "context", "value": elevation.ContextValue},
+			{"name": "expires", "value": elevation.Duration.String()},
+			{"name": "elevation", "value": elevation.ID.Hex()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = runWithPermSync([]auth.User{*user}, func() error {
+		return elevation.Approve(t.GetUserName())
+	})
+	return roleElevationError(err)
+}
+
+// title: reject role elevation
+// path: /roles/elevations/{id}/reject
+// method: POST
+// responses:
+//   200: Ok
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Request not found
+//   409: Request not pending
+func rejectRoleElevation(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if !permission.Check(t, permission.PermRoleUpdateAssign) {
+		return permission.ErrUnauthorized
+	}
+	elevation, err := auth.GetRoleElevation(r.URL.Query().Get(":id"))
+	if err != nil {
+		return roleElevationError(err)
+	}
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: event.TargetTypeRole, Value: elevation.RoleName},
+		Kind:   permission.PermRoleUpdateAssign,
+		Owner:  t,
+		CustomData: []map[string]interface{}{
+			{"name": "email", "value": elevation.Email},
+			{"name": "context", "value": elevation.ContextValue},
+			{"name": "elevation", "value": elevation.ID.Hex()},
+			{"name": "rejected", "value": true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return roleElevationError(elevation.Reject(t.GetUserName()))
+}
+
+// roleReaper periodically removes expired temporary roles from users.
+type roleReaper struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startRoleReaper() {
+	roleReaperMut.Lock()
+	defer roleReaperMut.Unlock()
+	if roleReaperInstance != nil {
+		return
+	}
+	roleReaperInstance = &roleReaper{
+		interval: roleReaperInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	shutdown.Register(roleReaperInstance)
+	go roleReaperInstance.run()
+}
+
+func (r *roleReaper) Shutdown() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *roleReaper) String() string {
+	return "role reaper"
+}
+
+func (r *roleReaper) run() {
+	defer close(r.done)
+	for {
+		err := r.runOnce(time.Now().UTC())
+		if err != nil {
+			log.Errorf("%s %s", roleReaperLogTag, err)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+func (r *roleReaper) runOnce(now time.Time) error {
+	users, err := auth.ListUsersWithExpiredRoles(now)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		u := &users[i]
+		roles := make([]auth.RoleInstance, len(u.Roles))
+		copy(roles, u.Roles)
+		for _, role := range roles {
+			if !role.Expired(now) {
+				continue
+			}
+			err = expireRole(u, role)
+			if err != nil {
+				log.Errorf("%s unable to remove role %q from %s: %s", roleReaperLogTag, role.Name, u.Email, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expireRole removes the expired role from the user, recording an event only
+// when the role was removed by this call, as the reaper runs in every tsurud
+// replica.
+func expireRole(u *auth.User, role auth.RoleInstance) error {
+	var removed bool
+	err := runWithPermSync([]auth.User{*u}, func() error {
+		var err error
+		removed, err = u.RemoveExpiredRole(role)
+		return err
+	})
+	if err != nil || !removed {
+		return err
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeRole, Value: role.Name},
+		InternalKind: roleExpireEventKind,
+		CustomData: map[string]interface{}{
+			"email":     u.Email,
+			"context":   role.ContextValue,
+			"expiresAt": role.ExpiresAt,
+		},
+		DisableLock: true,
+	})
+	if err != nil {
+		return err
+	}
+	return evt.Done(nil)
+}
@@ -0,0 +1,221 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRequestRoleElevation(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "requester")
+	body := strings.NewReader("context=prod&duration=2h&reason=incident")
+	request, err := http.NewRequest("POST", "/roles/pool-admin/elevations", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var elevation auth.RoleElevation
+	err = json.Unmarshal(recorder.Body.Bytes(), &elevation)
+	c.Assert(err, check.IsNil)
+	c.Assert(elevation.Email, check.Equals, token.GetUserName())
+	c.Assert(elevation.RoleName, check.Equals, "pool-admin")
+	c.Assert(elevation.ContextValue, check.Equals, "prod")
+	c.Assert(elevation.Duration, check.Equals, 2*time.Hour)
+	c.Assert(elevation.Status, check.Equals, auth.ElevationPending)
+}
+
+func (s *S) TestRequestRoleElevationInvalidDuration(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("context=prod&duration=48h")
+	request, err := http.NewRequest("POST", "/roles/pool-admin/elevations", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrInvalidElevationPeriod.Error()+"\n")
+}
+
+func (s *S) TestListRoleElevations(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "requester")
+	requester, err := token.User()
+	c.Assert(err, check.IsNil)
+	_, err = auth.RequestRoleElevation(requester, "pool-admin", "prod", time.Hour, "")
+	c.Assert(err, check.IsNil)
+	_, err = auth.RequestRoleElevation(s.user, "pool-admin", "prod", time.Hour, "")
+	c.Assert(err, check.IsNil)
+	server := RunServer(true)
+	request, err := http.NewRequest("GET", "/roles/elevations", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var elevations []auth.RoleElevation
+	err = json.Unmarshal(recorder.Body.Bytes(), &elevations)
+	c.Assert(err, check.IsNil)
+	c.Assert(elevations, check.HasLen, 2)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	elevations = nil
+	err = json.Unmarshal(recorder.Body.Bytes(), &elevations)
+	c.Assert(err, check.IsNil)
+	c.Assert(elevations, check.HasLen, 1)
+	c.Assert(elevations[0].Email, check.Equals, token.GetUserName())
+}
+
+func (s *S) TestApproveRoleElevation(c *check.C) {
+	role, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("pool")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "requester")
+	requester, err := token.User()
+	c.Assert(err, check.IsNil)
+	elevation, err := auth.RequestRoleElevation(requester, "pool-admin", "prod", 2*time.Hour, "incident")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/roles/elevations/"+elevation.ID.Hex()+"/approve", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(permission.Check(token, permission.PermPoolUpdate, permission.Context(permission.CtxPool, "prod")), check.Equals, true)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRole, Value: "pool-admin"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "role.update.assign",
+		StartCustomData: []map[string]interface{}{
+			{"name": "email", "value": token.GetUserName()},
+			{"name": "context", "value": "prod"},
+			{"name": "expires", "value": "2h0m0s"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestApproveRoleElevationWithoutPermission(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "requester")
+	requester, err := token.User()
+	c.Assert(err, check.IsNil)
+	elevation, err := auth.RequestRoleElevation(requester, "pool-admin", "prod", time.Hour, "")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/roles/elevations/"+elevation.ID.Hex()+"/approve", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRejectRoleElevation(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "requester")
+	requester, err := token.User()
+	c.Assert(err, check.IsNil)
+	elevation, err := auth.RequestRoleElevation(requester, "pool-admin", "prod", time.Hour, "")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/roles/elevations/"+elevation.ID.Hex()+"/reject", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbElevation, err := auth.GetRoleElevation(elevation.ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(dbElevation.Status, check.Equals, auth.ElevationRejected)
+	c.Assert(dbElevation.ReviewedBy, check.Equals, s.token.GetUserName())
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRole, Value: "pool-admin"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "role.update.assign",
+		StartCustomData: []map[string]interface{}{
+			{"name": "email", "value": requester.Email},
+			{"name": "context", "value": "prod"},
+			{"name": "elevation", "value": elevation.ID.Hex()},
+			{"name": "rejected", "value": true},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRoleReaperRunOnce(c *check.C) {
+	role, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("pool")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "elevated")
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	err = u.AddTemporaryRole("pool-admin", "prod", now.Add(time.Hour))
+	c.Assert(err, check.IsNil)
+	reaper := &roleReaper{}
+	err = reaper.runOnce(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(permission.Check(token, permission.PermPoolUpdate, permission.Context(permission.CtxPool, "prod")), check.Equals, true)
+	err = reaper.runOnce(now.Add(2 * time.Hour))
+	c.Assert(err, check.IsNil)
+	err = u.Reload()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 0)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRole, Value: "pool-admin"},
+		Kind:   roleExpireEventKind,
+		StartCustomData: map[string]interface{}{
+			"email":   token.GetUserName(),
+			"context": "prod",
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestExpireRoleAlreadyRemoved(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "elevated")
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	err = u.AddTemporaryRole("pool-admin", "prod", time.Now().UTC().Add(-time.Minute))
+	c.Assert(err, check.IsNil)
+	role := u.Roles[len(u.Roles)-1]
+	stale := *u
+	err = expireRole(u, role)
+	c.Assert(err, check.IsNil)
+	err = expireRole(&stale, role)
+	c.Assert(err, check.IsNil)
+	evts, err := event.List(&event.Filter{Target: event.Target{Type: event.TargetTypeRole, Value: "pool-admin"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Kind.Name, check.Equals, roleExpireEventKind)
+}
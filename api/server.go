@@ -20,10 +20,13 @@ import (
 	"github.com/tsuru/tsuru/api/shutdown"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
+	_ "github.com/tsuru/tsuru/auth/ldap"
 	_ "github.com/tsuru/tsuru/auth/native"
 	_ "github.com/tsuru/tsuru/auth/oauth"
 	_ "github.com/tsuru/tsuru/auth/saml"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/webhook"
 	"github.com/tsuru/tsuru/hc"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
@@ -129,6 +132,18 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Get", "/apps/{app}", AuthorizationRequiredHandler(appInfo))
 	m.Add("1.0", "Post", "/apps/{app}/cname", AuthorizationRequiredHandler(setCName))
 	m.Add("1.0", "Delete", "/apps/{app}/cname", AuthorizationRequiredHandler(unsetCName))
+	m.Add("1.0", "Put", "/apps/{app}/certificate", AuthorizationRequiredHandler(setCertificate))
+	m.Add("1.0", "Delete", "/apps/{app}/certificate", AuthorizationRequiredHandler(unsetCertificate))
+	m.Add("1.0", "Get", "/apps/{app}/certificate", AuthorizationRequiredHandler(listCertificates))
+	m.Add("1.0", "Get", "/apps/{app}/routers", AuthorizationRequiredHandler(listAppRouters))
+	m.Add("1.0", "Post", "/apps/{app}/routers", AuthorizationRequiredHandler(addAppRouter))
+	m.Add("1.0", "Delete", "/apps/{app}/routers/{router}", AuthorizationRequiredHandler(removeAppRouter))
+	m.Add("1.0", "Get", "/apps/{app}/jobs", AuthorizationRequiredHandler(jobList))
+	m.Add("1.0", "Post", "/apps/{app}/jobs", AuthorizationRequiredHandler(jobCreate))
+	m.Add("1.0", "Delete", "/apps/{app}/jobs/{job}", AuthorizationRequiredHandler(jobDelete))
+	m.Add("1.0", "Get", "/apps/{app}/autoscale", AuthorizationRequiredHandler(autoScaleRuleList))
+	m.Add("1.0", "Post", "/apps/{app}/autoscale", AuthorizationRequiredHandler(autoScaleRuleSet))
+	m.Add("1.0", "Delete", "/apps/{app}/autoscale", AuthorizationRequiredHandler(autoScaleRuleUnset))
 	runHandler := AuthorizationRequiredHandler(runCommand)
 	m.Add("1.0", "Post", "/apps/{app}/run", runHandler)
 	m.Add("1.0", "Post", "/apps/{app}/restart", AuthorizationRequiredHandler(restart))
@@ -157,8 +172,15 @@ func RunServer(dry bool) http.Handler {
 	logPostHandler := AuthorizationRequiredHandler(addLog)
 	m.Add("1.0", "Post", "/apps/{app}/log", logPostHandler)
 	m.Add("1.0", "Post", "/apps/{appname}/deploy/rollback", AuthorizationRequiredHandler(deployRollback))
+	m.Add("1.0", "Post", "/apps/{appname}/deploy/canary/promote", AuthorizationRequiredHandler(deployCanaryPromote))
+	m.Add("1.0", "Post", "/apps/{appname}/deploy/canary/abort", AuthorizationRequiredHandler(deployCanaryAbort))
+	m.Add("1.0", "Post", "/apps/{appname}/deploy/blue-green/revert", AuthorizationRequiredHandler(deployBlueGreenRevert))
+	m.Add("1.0", "Get", "/apps/{appname}/deploy/approvals", AuthorizationRequiredHandler(deployApprovalList))
+	m.Add("1.0", "Post", "/apps/{appname}/deploy/approvals/{id}/approve", AuthorizationRequiredHandler(deployApprove))
+	m.Add("1.0", "Post", "/apps/{appname}/deploy/approvals/{id}/reject", AuthorizationRequiredHandler(deployReject))
 	m.Add("1.0", "Get", "/apps/{app}/metric/envs", AuthorizationRequiredHandler(appMetricEnvs))
 	m.Add("1.0", "Post", "/apps/{app}/routes", AuthorizationRequiredHandler(appRebuildRoutes))
+	m.Add("1.0", "Get", "/routes/drift", AuthorizationRequiredHandler(routesDrift))
 
 	m.Add("1.0", "Post", "/node/status", AuthorizationRequiredHandler(setNodeStatus))
 
@@ -170,6 +192,13 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.1", "Get", "/events/{uuid}", AuthorizationRequiredHandler(eventInfo))
 	m.Add("1.1", "Post", "/events/{uuid}/cancel", AuthorizationRequiredHandler(eventCancel))
 
+	m.Add("1.1", "Get", "/webhooks", AuthorizationRequiredHandler(webhookList))
+	m.Add("1.1", "Post", "/webhooks", AuthorizationRequiredHandler(webhookCreate))
+	m.Add("1.1", "Get", "/webhooks/{name}", AuthorizationRequiredHandler(webhookInfo))
+	m.Add("1.1", "Put", "/webhooks/{name}", AuthorizationRequiredHandler(webhookUpdate))
+	m.Add("1.1", "Delete", "/webhooks/{name}", AuthorizationRequiredHandler(webhookDelete))
+	m.Add("1.1", "Get", "/webhooks/{name}/deliveries", AuthorizationRequiredHandler(webhookDeliveries))
+
 	m.Add("1.0", "Get", "/platforms", AuthorizationRequiredHandler(platformList))
 	m.Add("1.0", "Post", "/platforms", AuthorizationRequiredHandler(platformAdd))
 	m.Add("1.0", "Put", "/platforms/{name}", AuthorizationRequiredHandler(platformUpdate))
@@ -198,6 +227,9 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.0", "Post", "/users/{email}/password", Handler(resetPassword))
 	m.Add("1.0", "Post", "/users/{email}/tokens", Handler(login))
+	m.Add("1.0", "Post", "/users/{email}/two-factor", Handler(enrollTwoFactor))
+	m.Add("1.0", "Post", "/users/{email}/two-factor/confirm", Handler(confirmTwoFactor))
+	m.Add("1.0", "Delete", "/users/two-factor", AuthorizationRequiredHandler(disableTwoFactor))
 	m.Add("1.0", "Get", "/users/{email}/quota", AuthorizationRequiredHandler(getUserQuota))
 	m.Add("1.0", "Put", "/users/{email}/quota", AuthorizationRequiredHandler(changeUserQuota))
 	m.Add("1.0", "Delete", "/users/tokens", AuthorizationRequiredHandler(logout))
@@ -208,12 +240,26 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/users/keys/{key}", AuthorizationRequiredHandler(removeKeyFromUser))
 	m.Add("1.0", "Get", "/users/api-key", AuthorizationRequiredHandler(showAPIToken))
 	m.Add("1.0", "Post", "/users/api-key", AuthorizationRequiredHandler(regenerateAPIToken))
+	m.Add("1.0", "Get", "/users/personal-tokens", AuthorizationRequiredHandler(personalTokenList))
+	m.Add("1.0", "Post", "/users/personal-tokens", AuthorizationRequiredHandler(personalTokenCreate))
+	m.Add("1.0", "Delete", "/users/personal-tokens/{name}", AuthorizationRequiredHandler(personalTokenRevoke))
 
 	m.Add("1.0", "Get", "/logs", websocket.Handler(addLogs))
+	m.Add("1.0", "Get", "/logs/forward", AuthorizationRequiredHandler(logForwardConfigGet))
+	m.Add("1.0", "Post", "/logs/forward", AuthorizationRequiredHandler(logForwardConfigSet))
+	m.Add("1.0", "Get", "/logs/forward/failures", AuthorizationRequiredHandler(logForwardFailuresList))
 
 	m.Add("1.0", "Get", "/teams", AuthorizationRequiredHandler(teamList))
 	m.Add("1.0", "Post", "/teams", AuthorizationRequiredHandler(createTeam))
 	m.Add("1.0", "Delete", "/teams/{name}", AuthorizationRequiredHandler(removeTeam))
+	m.Add("1.0", "Get", "/teams/{name}/quota", AuthorizationRequiredHandler(getTeamQuota))
+	m.Add("1.0", "Put", "/teams/{name}/quota", AuthorizationRequiredHandler(changeTeamQuota))
+	m.Add("1.0", "Get", "/teams/{team}/service-accounts", AuthorizationRequiredHandler(serviceAccountList))
+	m.Add("1.0", "Post", "/teams/{team}/service-accounts", AuthorizationRequiredHandler(serviceAccountCreate))
+	m.Add("1.0", "Delete", "/teams/{team}/service-accounts/{name}", AuthorizationRequiredHandler(serviceAccountRemove))
+	m.Add("1.0", "Post", "/teams/{team}/service-accounts/{name}/token", AuthorizationRequiredHandler(serviceAccountRegenerateToken))
+	m.Add("1.0", "Post", "/teams/{team}/service-accounts/{name}/roles", AuthorizationRequiredHandler(serviceAccountAssignRole))
+	m.Add("1.0", "Delete", "/teams/{team}/service-accounts/{name}/roles/{role}", AuthorizationRequiredHandler(serviceAccountDissociateRole))
 
 	m.Add("1.0", "Post", "/swap", AuthorizationRequiredHandler(swap))
 
@@ -241,16 +287,21 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.0", "Get", "/roles", AuthorizationRequiredHandler(listRoles))
 	m.Add("1.0", "Post", "/roles", AuthorizationRequiredHandler(addRole))
+	m.Add("1.0", "Get", "/roles/elevations", AuthorizationRequiredHandler(listRoleElevations))
+	m.Add("1.0", "Post", "/roles/elevations/{id}/approve", AuthorizationRequiredHandler(approveRoleElevation))
+	m.Add("1.0", "Post", "/roles/elevations/{id}/reject", AuthorizationRequiredHandler(rejectRoleElevation))
 	m.Add("1.0", "Get", "/roles/{name}", AuthorizationRequiredHandler(roleInfo))
 	m.Add("1.0", "Delete", "/roles/{name}", AuthorizationRequiredHandler(removeRole))
 	m.Add("1.0", "Post", "/roles/{name}/permissions", AuthorizationRequiredHandler(addPermissions))
 	m.Add("1.0", "Delete", "/roles/{name}/permissions/{permission}", AuthorizationRequiredHandler(removePermissions))
 	m.Add("1.0", "Post", "/roles/{name}/user", AuthorizationRequiredHandler(assignRole))
 	m.Add("1.0", "Delete", "/roles/{name}/user/{email}", AuthorizationRequiredHandler(dissociateRole))
+	m.Add("1.0", "Post", "/roles/{name}/elevations", AuthorizationRequiredHandler(requestRoleElevation))
 	m.Add("1.0", "Get", "/role/default", AuthorizationRequiredHandler(listDefaultRoles))
 	m.Add("1.0", "Post", "/role/default", AuthorizationRequiredHandler(addDefaultRole))
 	m.Add("1.0", "Delete", "/role/default", AuthorizationRequiredHandler(removeDefaultRole))
 	m.Add("1.0", "Get", "/permissions", AuthorizationRequiredHandler(listPermissions))
+	m.Add("1.0", "Get", "/permissions/check", AuthorizationRequiredHandler(checkPermission))
 
 	m.Add("1.0", "Get", "/debug/goroutines", AuthorizationRequiredHandler(dumpGoroutines))
 	m.Add("1.0", "Get", "/debug/pprof/", AuthorizationRequiredHandler(indexHandler))
@@ -293,6 +344,7 @@ func RunServer(dry bool) http.Handler {
 		idleTracker := newIdleTracker()
 		shutdown.Register(idleTracker)
 		shutdown.Register(&logTracker)
+		shutdown.Register(&eventStreams)
 		readTimeout, _ := config.GetInt("server:read-timeout")
 		writeTimeout, _ := config.GetInt("server:write-timeout")
 		listen, err := config.GetString("listen")
@@ -377,6 +429,24 @@ func RunServer(dry bool) http.Handler {
 				fatal(err)
 			}
 		}
+		if _, ok := app.Provisioner.(provision.IsolatedRunner); ok {
+			err = app.StartJobScheduler()
+			if err != nil {
+				fatal(err)
+			}
+		}
+		app.StartAutoScaler()
+		app.StartRoutesDriftChecker()
+		app.StartDeployApprovalExpirer()
+		startRoleReaper()
+		err = webhook.Initialize()
+		if err != nil {
+			fatal(err)
+		}
+		err = event.StartExporter()
+		if err != nil {
+			fatal(err)
+		}
 		if messageProvisioner, ok := app.Provisioner.(provision.MessageProvisioner); ok {
 			startupMessage, err = messageProvisioner.StartupMessage()
 			if err == nil && startupMessage != "" {
@@ -0,0 +1,289 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+type serviceAccountData struct {
+	Name  string
+	Email string
+	Team  string
+	Roles []auth.RoleInstance
+	Token string `json:",omitempty"`
+}
+
+func newServiceAccountData(u *auth.User) serviceAccountData {
+	return serviceAccountData{
+		Name:  strings.TrimSuffix(u.Email, auth.ServiceAccountEmail(u.Team, "")),
+		Email: u.Email,
+		Team:  u.Team,
+		Roles: u.Roles,
+	}
+}
+
+func getServiceAccount(team, name string) (*auth.User, error) {
+	u, err := auth.GetServiceAccount(team, name)
+	if err == auth.ErrServiceAccountNotFound {
+		return nil, &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return u, err
+}
+
+// title: service account list
+// path: /teams/{team}/service-accounts
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   403: Forbidden
+func serviceAccountList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	team := r.URL.Query().Get(":team")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountRead,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	accounts, err := auth.ListServiceAccounts(team)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	result := make([]serviceAccountData, len(accounts))
+	for i := range accounts {
+		result[i] = newServiceAccountData(&accounts[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+// title: service account create
+// path: /teams/{team}/service-accounts
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Service account created
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Team not found
+//   409: Service account already exists
+func serviceAccountCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	team := r.URL.Query().Get(":team")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountCreate,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(team),
+		Kind:       permission.PermTeamServiceAccountCreate,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	u, token, err := auth.CreateServiceAccount(team, r.FormValue("name"))
+	switch err {
+	case nil:
+	case auth.ErrInvalidServiceAccountName:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case auth.ErrTeamNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case auth.ErrServiceAccountAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	default:
+		return err
+	}
+	data := newServiceAccountData(u)
+	data.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(data)
+}
+
+// title: service account remove
+// path: /teams/{team}/service-accounts/{name}
+// method: DELETE
+// responses:
+//   200: Service account removed
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Service account not found
+func serviceAccountRemove(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	team := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountDelete,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(team),
+		Kind:       permission.PermTeamServiceAccountDelete,
+		Owner:      t,
+		CustomData: append(formToEvents(r.Form), map[string]interface{}{"name": "name", "value": name}),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = auth.RemoveServiceAccount(team, name)
+	if err == auth.ErrServiceAccountNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+// title: service account regenerate token
+// path: /teams/{team}/service-accounts/{name}/token
+// method: POST
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Service account not found
+func serviceAccountRegenerateToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	team := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountUpdateToken,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	u, err := getServiceAccount(team, name)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(team),
+		Kind:       permission.PermTeamServiceAccountUpdateToken,
+		Owner:      t,
+		CustomData: append(formToEvents(r.Form), map[string]interface{}{"name": "name", "value": name}),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	token, err := u.RegenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	data := newServiceAccountData(u)
+	data.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(data)
+}
+
+// title: assign role to service account
+// path: /teams/{team}/service-accounts/{name}/roles
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Role or service account not found
+func serviceAccountAssignRole(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	team := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountUpdateRole,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	roleName := r.FormValue("role")
+	if roleName == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "role is required"}
+	}
+	contextValue := r.FormValue("context")
+	u, err := getServiceAccount(team, name)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(team),
+		Kind:       permission.PermTeamServiceAccountUpdateRole,
+		Owner:      t,
+		CustomData: append(formToEvents(r.Form), map[string]interface{}{"name": "name", "value": name}),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = canUseRole(t, roleName, contextValue)
+	if err != nil {
+		return err
+	}
+	return u.AddRole(roleName, contextValue)
+}
+
+// title: dissociate role from service account
+// path: /teams/{team}/service-accounts/{name}/roles/{role}
+// method: DELETE
+// responses:
+//   200: Ok
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Role or service account not found
+func serviceAccountDissociateRole(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	team := r.URL.Query().Get(":team")
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamServiceAccountUpdateRole,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	roleName := r.URL.Query().Get(":role")
+	contextValue := r.URL.Query().Get("context")
+	u, err := getServiceAccount(team, name)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(team),
+		Kind:       permission.PermTeamServiceAccountUpdateRole,
+		Owner:      t,
+		CustomData: append(formToEvents(r.Form), map[string]interface{}{"name": "name", "value": name}),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = canUseRole(t, roleName, contextValue)
+	if err != nil {
+		return err
+	}
+	return u.RemoveRole(roleName, contextValue)
+}
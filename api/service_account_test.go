@@ -0,0 +1,182 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestServiceAccountCreate(c *check.C) {
+	body := strings.NewReader("name=ci-bot")
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var data serviceAccountData
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Name, check.Equals, "ci-bot")
+	c.Assert(data.Team, check.Equals, s.team.Name)
+	c.Assert(data.Email, check.Equals, auth.ServiceAccountEmail(s.team.Name, "ci-bot"))
+	c.Assert(data.Token, check.Not(check.Equals), "")
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget(s.team.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.service-account.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "ci-bot"},
+		},
+	}, eventtest.HasEvent)
+	token, err := auth.APIAuth("bearer " + data.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.IsServiceAccount(), check.Equals, true)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts", strings.NewReader("name=ci-bot"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestServiceAccountCreateInvalidName(c *check.C) {
+	body := strings.NewReader("name=CI_Bot")
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, auth.ErrInvalidServiceAccountName.Error()+"\n")
+}
+
+func (s *S) TestServiceAccountCreateWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamServiceAccountCreate,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	body := strings.NewReader("name=ci-bot")
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestServiceAccountList(c *check.C) {
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name+"/service-accounts", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	_, _, err = auth.CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var data []serviceAccountData
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.HasLen, 1)
+	c.Assert(data[0].Name, check.Equals, "ci-bot")
+	c.Assert(data[0].Token, check.Equals, "")
+}
+
+func (s *S) TestServiceAccountRemove(c *check.C) {
+	_, _, err := auth.CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/teams/"+s.team.Name+"/service-accounts/ci-bot", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = auth.GetServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.Equals, auth.ErrServiceAccountNotFound)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestServiceAccountRegenerateToken(c *check.C) {
+	_, oldToken, err := auth.CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts/ci-bot/token", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var data serviceAccountData
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Token, check.Not(check.Equals), oldToken)
+	_, err = auth.APIAuth("bearer " + oldToken)
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+	_, err = auth.APIAuth("bearer " + data.Token)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestServiceAccountAssignAndDissociateRole(c *check.C) {
+	role, err := permission.NewRole("deployer", string(permission.CtxTeam), "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions("app.deploy")
+	c.Assert(err, check.IsNil)
+	_, token, err := auth.CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("role=deployer&context=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts/ci-bot/roles", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	apiToken, err := auth.APIAuth("bearer " + token)
+	c.Assert(err, check.IsNil)
+	c.Assert(permission.Check(apiToken, permission.PermAppDeploy, permission.Context(permission.CtxTeam, s.team.Name)), check.Equals, true)
+	request, err = http.NewRequest("DELETE", "/teams/"+s.team.Name+"/service-accounts/ci-bot/roles/deployer?context="+s.team.Name, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(permission.Check(apiToken, permission.PermAppDeploy, permission.Context(permission.CtxTeam, s.team.Name)), check.Equals, false)
+}
+
+func (s *S) TestServiceAccountAssignRoleServiceAccountNotFound(c *check.C) {
+	body := strings.NewReader("role=deployer&context=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/teams/"+s.team.Name+"/service-accounts/ci-bot/roles", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,306 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/webhook"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// webhookCreateData is the response of the webhook creation, the only time
+// the secret is displayed.
+type webhookCreateData struct {
+	webhook.Webhook
+	Secret string
+}
+
+func webhookTarget(name string) event.Target {
+	return event.Target{Type: event.TargetTypeWebhook, Value: name}
+}
+
+func webhookError(err error) error {
+	switch err {
+	case webhook.ErrWebhookNotFound, auth.ErrTeamNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case webhook.ErrWebhookAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	case webhook.ErrInvalidWebhookName, webhook.ErrInvalidWebhookURL,
+		webhook.ErrWebhookTeamRequired, webhook.ErrInvalidEventFilter,
+		webhook.ErrWebhookHostNotFound, webhook.ErrWebhookHostNotAllowed:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+// webhookFromForm applies the values in the request form to a copy of base.
+// Fields missing in the form keep the values in base, lists with only empty
+// values are cleared.
+func webhookFromForm(r *http.Request, base webhook.Webhook) (*webhook.Webhook, error) {
+	w := base
+	for name, dst := range map[string]*string{
+		"description": &w.Description,
+		"team":        &w.TeamOwner,
+		"url":         &w.URL,
+		"secret":      &w.Secret,
+	} {
+		if _, ok := r.Form[name]; ok {
+			*dst = r.FormValue(name)
+		}
+	}
+	for name, dst := range map[string]*[]string{
+		"target-type":  &w.EventFilter.TargetTypes,
+		"target-value": &w.EventFilter.TargetValues,
+		"kind":         &w.EventFilter.KindNames,
+	} {
+		values, ok := r.Form[name]
+		if !ok {
+			continue
+		}
+		*dst = nil
+		for _, v := range values {
+			if v != "" {
+				*dst = append(*dst, v)
+			}
+		}
+	}
+	for name, dst := range map[string]*bool{
+		"error-only":   &w.EventFilter.ErrorOnly,
+		"success-only": &w.EventFilter.SuccessOnly,
+	} {
+		value := r.FormValue(name)
+		if value == "" {
+			continue
+		}
+		var err error
+		*dst, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid value for " + name + ": " + value}
+		}
+	}
+	return &w, nil
+}
+
+func getWebhook(t auth.Token, name string, perm *permission.PermissionScheme) (*webhook.Webhook, error) {
+	w, err := webhook.Get(name)
+	if err != nil {
+		return nil, webhookError(err)
+	}
+	if !permission.Check(t, perm, permission.Context(permission.CtxTeam, w.TeamOwner)) {
+		return nil, permission.ErrUnauthorized
+	}
+	return w, nil
+}
+
+// title: webhook list
+// path: /webhooks
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func webhookList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	contexts := permission.ContextsForPermission(t, permission.PermWebhookRead)
+	if len(contexts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	teams := []string{}
+	for _, ctx := range contexts {
+		if ctx.CtxType == permission.CtxGlobal {
+			teams = nil
+			break
+		}
+		teams = append(teams, ctx.Value)
+	}
+	webhooks, err := webhook.List(teams)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(webhooks)
+}
+
+// title: webhook info
+// path: /webhooks/{name}
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Webhook not found
+func webhookInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	wh, err := getWebhook(t, r.URL.Query().Get(":name"), permission.PermWebhookRead)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(wh)
+}
+
+// title: webhook create
+// path: /webhooks
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Webhook created
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Team not found
+//   409: Webhook already exists
+func webhookCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	wh, err := webhookFromForm(r, webhook.Webhook{Name: r.FormValue("name")})
+	if err != nil {
+		return err
+	}
+	if wh.Name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: webhook.ErrInvalidWebhookName.Error()}
+	}
+	if wh.TeamOwner == "" {
+		wh.TeamOwner, err = permission.TeamForPermission(t, permission.PermWebhookCreate)
+		if err != nil {
+			return err
+		}
+	}
+	allowed := permission.Check(t, permission.PermWebhookCreate,
+		permission.Context(permission.CtxTeam, wh.TeamOwner),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	delete(r.Form, "secret")
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(wh.Name),
+		Kind:       permission.PermWebhookCreate,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = webhook.Create(wh)
+	if err != nil {
+		return webhookError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(webhookCreateData{Webhook: *wh, Secret: wh.Secret})
+}
+
+// title: webhook update
+// path: /webhooks/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Webhook updated
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Webhook not found
+func webhookUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	current, err := getWebhook(t, name, permission.PermWebhookUpdate)
+	if err != nil {
+		return err
+	}
+	current.Secret = ""
+	wh, err := webhookFromForm(r, *current)
+	if err != nil {
+		return err
+	}
+	if wh.TeamOwner != current.TeamOwner {
+		allowed := permission.Check(t, permission.PermWebhookUpdate,
+			permission.Context(permission.CtxTeam, wh.TeamOwner),
+		)
+		if !allowed {
+			return permission.ErrUnauthorized
+		}
+	}
+	delete(r.Form, "secret")
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(name),
+		Kind:       permission.PermWebhookUpdate,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(webhook.Update(wh))
+}
+
+// title: webhook delete
+// path: /webhooks/{name}
+// method: DELETE
+// responses:
+//   200: Webhook removed
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Webhook not found
+func webhookDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	_, err = getWebhook(t, name, permission.PermWebhookDelete)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     webhookTarget(name),
+		Kind:       permission.PermWebhookDelete,
+		Owner:      t,
+		CustomData: formToEvents(r.Form),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(webhook.Delete(name))
+}
+
+// title: webhook deliveries
+// path: /webhooks/{name}/deliveries
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   403: Forbidden
+//   404: Webhook not found
+func webhookDeliveries(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.URL.Query().Get(":name")
+	_, err := getWebhook(t, name, permission.PermWebhookRead)
+	if err != nil {
+		return err
+	}
+	deliveries, err := webhook.ListDeliveries(name)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deliveries)
+}
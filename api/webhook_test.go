@@ -0,0 +1,247 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/event/webhook"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) createTestWebhook(c *check.C, name, team string) *webhook.Webhook {
+	w := webhook.Webhook{Name: name, TeamOwner: team, URL: "https://203.0.113.10/" + name, Secret: "mysecret"}
+	err := webhook.Create(&w)
+	c.Assert(err, check.IsNil)
+	return &w
+}
+
+func (s *S) TestWebhookCreate(c *check.C) {
+	body := strings.NewReader("name=myhook&url=https://203.0.113.10/hook&kind=app.deploy&kind=app.update&error-only=true")
+	request, err := http.NewRequest("POST", "/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var data webhookCreateData
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Name, check.Equals, "myhook")
+	c.Assert(data.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(data.Secret, check.Not(check.Equals), "")
+	w, err := webhook.Get("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(w.Secret, check.Equals, data.Secret)
+	c.Assert(w.EventFilter, check.DeepEquals, webhook.EventFilter{
+		KindNames: []string{"app.deploy", "app.update"},
+		ErrorOnly: true,
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("myhook"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "myhook"},
+			{"name": "url", "value": "https://203.0.113.10/hook"},
+			{"name": "kind", "value": []string{"app.deploy", "app.update"}},
+			{"name": "error-only", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/webhooks", strings.NewReader("name=myhook&url=https://203.0.113.10/hook"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestWebhookCreateInvalid(c *check.C) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"url=https://203.0.113.10/hook", webhook.ErrInvalidWebhookName.Error()},
+		{"name=myhook&url=example.com", webhook.ErrInvalidWebhookURL.Error()},
+		{"name=myhook&url=https://203.0.113.10&error-only=yes", "invalid value for error-only: yes"},
+	}
+	m := RunServer(true)
+	for _, tt := range tests {
+		request, err := http.NewRequest("POST", "/webhooks", strings.NewReader(tt.body))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Equals, tt.expected+"\n")
+	}
+}
+
+func (s *S) TestWebhookCreateWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookCreate,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	body := strings.NewReader("name=myhook&url=https://203.0.113.10/hook&team=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestWebhookList(c *check.C) {
+	request, err := http.NewRequest("GET", "/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Not(check.Matches), "(?s).*mysecret.*")
+	var data []webhook.Webhook
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.HasLen, 1)
+	c.Assert(data[0].Name, check.Equals, "myhook")
+}
+
+func (s *S) TestWebhookListFilteredByTeam(c *check.C) {
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookRead,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	request, err := http.NewRequest("GET", "/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestWebhookInfo(c *check.C) {
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	request, err := http.NewRequest("GET", "/webhooks/myhook", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var data webhook.Webhook
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.URL, check.Equals, "https://203.0.113.10/myhook")
+	c.Assert(data.Secret, check.Equals, "")
+	request, err = http.NewRequest("GET", "/webhooks/otherhook", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookUpdate(c *check.C) {
+	w := s.createTestWebhook(c, "myhook", s.team.Name)
+	w.EventFilter = webhook.EventFilter{KindNames: []string{"app.deploy"}, TargetTypes: []string{"app"}}
+	err := webhook.Update(w)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("description=my+hook&kind=app.update&target-type=")
+	request, err := http.NewRequest("PUT", "/webhooks/myhook", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbWebhook, err := webhook.Get("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbWebhook, check.DeepEquals, &webhook.Webhook{
+		Name:        "myhook",
+		Description: "my hook",
+		TeamOwner:   s.team.Name,
+		URL:         "https://203.0.113.10/myhook",
+		Secret:      "mysecret",
+		EventFilter: webhook.EventFilter{KindNames: []string{"app.update"}},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("myhook"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": "description", "value": "my hook"},
+			{"name": "kind", "value": "app.update"},
+			{"name": "target-type", "value": ""},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookUpdateWithoutPermission(c *check.C) {
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermWebhookUpdate,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	request, err := http.NewRequest("PUT", "/webhooks/myhook", strings.NewReader("description=mine"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestWebhookDelete(c *check.C) {
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	request, err := http.NewRequest("DELETE", "/webhooks/myhook", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = webhook.Get("myhook")
+	c.Assert(err, check.Equals, webhook.ErrWebhookNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: webhookTarget("myhook"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.delete",
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookDeliveries(c *check.C) {
+	s.createTestWebhook(c, "myhook", s.team.Name)
+	request, err := http.NewRequest("GET", "/webhooks/myhook/deliveries", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
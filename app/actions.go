@@ -71,6 +71,31 @@ var reserveUserApp = action.Action{
 	MinParams: 2,
 }
 
+// reserveTeamApp reserves an app in the quota of the team owning the app in
+// Forward and releases it in Backward.
+var reserveTeamApp = action.Action{
+	Name: "reserve-team-app",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		app, ok := ctx.Params[0].(*App)
+		if !ok {
+			return nil, errors.New("First parameter must be *App.")
+		}
+		err := reserveTeamQuota(app.TeamOwner, TeamQuotaUsage{Apps: 1})
+		if err != nil {
+			return nil, err
+		}
+		return app.TeamOwner, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		teamName := ctx.FWResult.(string)
+		err := adjustTeamQuota(teamName, TeamQuotaUsage{Apps: -1})
+		if err != nil {
+			log.Errorf("Failed to rollback reserveTeamApp: %s", err)
+		}
+	},
+	MinParams: 1,
+}
+
 // insertApp is an action that inserts an app in the database in Forward and
 // removes it in the Backward.
 //
@@ -38,10 +38,11 @@ var AuthScheme auth.Scheme
 var (
 	nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
 
-	ErrAlreadyHaveAccess = stderr.New("team already have access to this app")
-	ErrNoAccess          = stderr.New("team does not have access to this app")
-	ErrCannotOrphanApp   = stderr.New("cannot revoke access from this team, as it's the unique team with access to the app")
-	ErrDisabledPlatform  = stderr.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrAlreadyHaveAccess       = stderr.New("team already have access to this app")
+	ErrNoAccess                = stderr.New("team does not have access to this app")
+	ErrCannotOrphanApp         = stderr.New("cannot revoke access from this team, as it's the unique team with access to the app")
+	ErrDisabledPlatform        = stderr.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrIsolatedRunNotSupported = stderr.New("provisioner does not support running commands in isolated units")
 )
 
 const (
@@ -122,6 +123,10 @@ type App struct {
 	Pool           string
 	Description    string
 	RouterOpts     map[string]string
+	StructuredLogs bool
+	// Routers holds the routers attached to the app in addition to the
+	// router of its plan.
+	Routers []AppRouter
 
 	quota.Quota
 }
@@ -157,16 +162,22 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["teamowner"] = app.TeamOwner
 	result["plan"] = app.Plan
 	result["lock"] = app.Lock
+	result["structuredLogs"] = app.StructuredLogs
+	if routers, err := app.RoutersWithAddr(); err == nil {
+		result["routers"] = routers
+	}
 	return json.Marshal(&result)
 }
 
-// Applog represents a log entry.
+// Applog represents a log entry. Fields holds the fields parsed from the
+// message, when structured logs are enabled for the app.
 type Applog struct {
 	Date    time.Time
 	Message string
 	Source  string
 	AppName string
 	Unit    string
+	Fields  map[string]string `bson:",omitempty" json:",omitempty"`
 }
 
 // AcquireApplicationLock acquires an application lock by setting the lock
@@ -273,6 +284,7 @@ func CreateApp(app *App, user *auth.User) error {
 	}
 	actions := []*action.Action{
 		&reserveUserApp,
+		&reserveTeamApp,
 		&insertApp,
 		&exportEnvironmentsAction,
 		&createRepository,
@@ -288,7 +300,7 @@ func CreateApp(app *App, user *auth.User) error {
 }
 
 // Update changes informations of the application.
-func (app *App) Update(updateData App, w io.Writer) error {
+func (app *App) Update(updateData App, w io.Writer) (err error) {
 	description := updateData.Description
 	planName := updateData.Plan.Name
 	poolName := updateData.Pool
@@ -298,7 +310,7 @@ func (app *App) Update(updateData App, w io.Writer) error {
 	}
 	if poolName != "" {
 		app.Pool = poolName
-		_, err := app.GetPoolForApp(app.Pool)
+		_, err = app.GetPoolForApp(app.Pool)
 		if err != nil {
 			return err
 		}
@@ -308,11 +320,30 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		return err
 	}
 	defer conn.Close()
+	var plan *Plan
 	if planName != "" {
-		plan, err := findPlanByName(planName)
+		plan, err = findPlanByName(planName)
 		if err != nil {
 			return err
 		}
+	}
+	change := app.teamQuotaChange(plan, teamOwner)
+	err = reserveTeamQuota(change.reserveTeam, change.reserve)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var quotaErr error
+		if err != nil {
+			quotaErr = adjustTeamQuota(change.reserveTeam, change.reserve.negate())
+		} else {
+			quotaErr = adjustTeamQuota(change.releaseTeam, change.release.negate())
+		}
+		if quotaErr != nil {
+			log.Errorf("unable to update the team quota usage of app %q: %s", app.Name, quotaErr)
+		}
+	}()
+	if plan != nil {
 		var oldPlan Plan
 		oldPlan, app.Plan = app.Plan, *plan
 		actions := []*action.Action{
@@ -327,7 +358,8 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		}
 	}
 	if teamOwner != "" {
-		team, err := auth.GetTeam(teamOwner)
+		var team *auth.Team
+		team, err = auth.GetTeam(teamOwner)
 		if err != nil {
 			return err
 		}
@@ -341,6 +373,55 @@ func (app *App) Update(updateData App, w io.Writer) error {
 	return conn.Apps().Update(bson.M{"name": app.Name}, app)
 }
 
+// teamQuotaChange holds the resources reserved in and released from team
+// quotas when an app changes its plan or team owner.
+type teamQuotaChange struct {
+	reserveTeam string
+	reserve     TeamQuotaUsage
+	releaseTeam string
+	release     TeamQuotaUsage
+}
+
+// teamQuotaChange returns the change in the usage of team quotas after the
+// app is updated to the given plan and team owner, which may be empty when
+// they don't change.
+func (app *App) teamQuotaChange(plan *Plan, teamOwner string) teamQuotaChange {
+	newPlan := app.Plan
+	if plan != nil {
+		newPlan = *plan
+	}
+	units := int64(app.Quota.InUse)
+	if teamOwner != "" && teamOwner != app.TeamOwner {
+		return teamQuotaChange{
+			reserveTeam: teamOwner,
+			reserve:     TeamQuotaUsage{Apps: 1, Units: app.Quota.InUse, Memory: newPlan.Memory * units},
+			releaseTeam: app.TeamOwner,
+			release:     TeamQuotaUsage{Apps: 1, Units: app.Quota.InUse, Memory: app.Plan.Memory * units},
+		}
+	}
+	delta := (newPlan.Memory - app.Plan.Memory) * units
+	if delta > 0 {
+		return teamQuotaChange{reserveTeam: app.TeamOwner, reserve: TeamQuotaUsage{Memory: delta}}
+	}
+	return teamQuotaChange{releaseTeam: app.TeamOwner, release: TeamQuotaUsage{Memory: -delta}}
+}
+
+// SetStructuredLogs enables or disables the parsing of JSON log messages of
+// the app into structured fields.
+func (app *App) SetStructuredLogs(enabled bool) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"structuredlogs": enabled}})
+	if err != nil {
+		return err
+	}
+	app.StructuredLogs = enabled
+	return nil
+}
+
 // unbind takes all service instances that are bound to the app, and unbind
 // them. This method is used by Destroy (before destroying the app, it unbinds
 // all service instances). Refer to Destroy docs for more details.
@@ -420,13 +501,24 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to release app quota", err)
 	}
-	logConn, err := db.LogConn()
+	logStorage, err := GetLogStorage()
 	if err == nil {
-		defer logConn.Close()
-		err = logConn.Logs(appName).DropCollection()
+		err = logStorage.Remove(appName)
 	}
 	if err != nil {
-		logErr("Unable to remove logs collection", err)
+		logErr("Unable to remove logs", err)
+	}
+	err = removeAppJobs(appName)
+	if err != nil {
+		logErr("Unable to remove jobs", err)
+	}
+	err = removeAppAutoScaleRules(appName)
+	if err != nil {
+		logErr("Unable to remove autoscale rules", err)
+	}
+	err = app.removeCertificates()
+	if err != nil {
+		logErr("Unable to remove certificates", err)
 	}
 	conn, err := db.Conn()
 	if err == nil {
@@ -435,6 +527,15 @@ func Delete(app *App, w io.Writer) error {
 	}
 	if err != nil {
 		logErr("Unable to remove app from db", err)
+	} else {
+		err = adjustTeamQuota(app.TeamOwner, TeamQuotaUsage{
+			Apps:   -1,
+			Units:  -1 * app.Quota.InUse,
+			Memory: -1 * app.Plan.Memory * int64(app.Quota.InUse),
+		})
+		if err != nil {
+			logErr("Unable to release team quota", err)
+		}
 	}
 	err = event.MarkAsRemoved(event.Target{Type: event.TargetTypeApp, Value: appName})
 	if err != nil {
@@ -530,14 +631,7 @@ func (app *App) RemoveUnits(n uint, process string, writer io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return conn.Apps().Update(
-		bson.M{"name": app.Name},
-		bson.M{
-			"$set": bson.M{
-				"quota.inuse": len(units),
-			},
-		},
-	)
+	return setUnitsInUse(conn, app.Name, len(units))
 }
 
 // SetUnitStatus changes the status of the given unit.
@@ -571,6 +665,12 @@ func UpdateNodeStatus(node provision.NodeStatusData) ([]UpdateUnitsResult, error
 		if err != nil && !ok {
 			return nil, err
 		}
+		if !ok && len(unitData.Metrics) > 0 {
+			err = storeUnitMetrics(unitData.ID, unitData.Metrics)
+			if err != nil {
+				log.Errorf("unable to store metrics for unit %s: %s", unitData.ID, err)
+			}
+		}
 	}
 	if nodeProvisioner, ok := Provisioner.(provision.NodeProvisioner); ok {
 		err := nodeProvisioner.SetNodeStatus(node)
@@ -830,11 +930,36 @@ func (app *App) Run(cmd string, w io.Writer, once bool) error {
 	return app.sourced(cmd, io.MultiWriter(w, &logWriter), once)
 }
 
+// RunIsolated runs the command in a new temporary unit, created from the
+// current image of the app, instead of the units serving it. Cancel requests
+// in the given event stop the command and remove the unit.
+func (app *App) RunIsolated(cmd string, w io.Writer, evt *event.Event) error {
+	runner, ok := Provisioner.(provision.IsolatedRunner)
+	if !ok {
+		return ErrIsolatedRunNotSupported
+	}
+	app.Log(fmt.Sprintf("running '%s' in an isolated unit", cmd), "tsuru", "api")
+	logWriter := LogWriter{App: app, Source: "app-run"}
+	logWriter.Async()
+	defer logWriter.Close()
+	w = io.MultiWriter(w, &logWriter)
+	return runner.ExecuteCommandIsolated(provision.IsolatedRunOptions{
+		App:    app,
+		Stdout: w,
+		Stderr: w,
+		Cmd:    sourcedCommand(cmd),
+		Event:  evt,
+	})
+}
+
 func (app *App) sourced(cmd string, w io.Writer, once bool) error {
+	return app.run(sourcedCommand(cmd), w, once)
+}
+
+func sourcedCommand(cmd string) string {
 	source := "[ -f /home/application/apprc ] && source /home/application/apprc"
 	cd := fmt.Sprintf("[ -d %s ] && cd %s", defaultAppDir, defaultAppDir)
-	cmd = fmt.Sprintf("%s; %s; %s", source, cd, cmd)
-	return app.run(cmd, w, once)
+	return fmt.Sprintf("%s; %s; %s", source, cd, cmd)
 }
 
 func (app *App) run(cmd string, w io.Writer, once bool) error {
@@ -883,36 +1008,44 @@ func (app *App) Sleep(w io.Writer, process string, proxyURL *url.URL) error {
 		msg = fmt.Sprintf("\n ---> Putting the app %q to sleep\n", app.Name)
 	}
 	log.Write(w, []byte(msg))
-	routerName, err := app.GetRouter()
-	if err != nil {
-		log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
-		return err
-	}
-	r, err := router.Get(routerName)
+	routerNames, err := app.GetRouters()
 	if err != nil {
 		log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
 		return err
 	}
-	oldRoutes, err := r.Routes(app.GetName())
-	if err != nil {
-		log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
-		return err
-	}
-	for _, route := range oldRoutes {
-		r.RemoveRoute(app.GetName(), route)
+	routers := make([]router.Router, len(routerNames))
+	oldRoutes := make([][]*url.URL, len(routerNames))
+	for i, routerName := range routerNames {
+		routers[i], err = router.Get(routerName)
+		if err != nil {
+			log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
+			return err
+		}
+		oldRoutes[i], err = routers[i].Routes(app.GetName())
+		if err != nil {
+			log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
+			return err
+		}
 	}
-	err = r.AddRoute(app.GetName(), proxyURL)
-	if err != nil {
-		log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
-		return err
+	for i, r := range routers {
+		for _, route := range oldRoutes[i] {
+			r.RemoveRoute(app.GetName(), route)
+		}
+		err = r.AddRoute(app.GetName(), proxyURL)
+		if err != nil {
+			log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
+			return err
+		}
 	}
 	err = Provisioner.Sleep(app, process)
 	if err != nil {
 		log.Errorf("[sleep] error on sleep the app %s - %s", app.Name, err)
-		for _, route := range oldRoutes {
-			r.AddRoute(app.GetName(), route)
+		for i, r := range routers {
+			for _, route := range oldRoutes[i] {
+				r.AddRoute(app.GetName(), route)
+			}
+			r.RemoveRoute(app.GetName(), proxyURL)
 		}
-		r.RemoveRoute(app.GetName(), proxyURL)
 		log.Errorf("[sleep] rolling back the sleep %s", app.Name)
 		return err
 	}
@@ -991,7 +1124,7 @@ func (app *App) SetQuotaInUse(inUse int) error {
 		return err
 	}
 	defer conn.Close()
-	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"quota.inuse": inUse}})
+	err = setUnitsInUse(conn, app.Name, inUse)
 	if err == mgo.ErrNotFound {
 		return ErrAppNotFound
 	}
@@ -1152,7 +1285,11 @@ func (app *App) RemoveCName(cnames ...string) error {
 		&removeCNameFromDatabase,
 		&removeCNameFromApp,
 	}
-	return action.NewPipeline(actions...).Execute(app, cnames)
+	err := action.NewPipeline(actions...).Execute(app, cnames)
+	if err != nil {
+		return err
+	}
+	return app.removeCertificates(cnames...)
 }
 
 func (app *App) parsedTsuruServices() map[string][]bind.ServiceInstance {
@@ -1303,7 +1440,7 @@ func (app *App) RemoveInstance(instanceApp bind.InstanceApp, writer io.Writer) e
 // user can filter where the message come from.
 func (app *App) Log(message, source, unit string) error {
 	messages := strings.Split(message, "\n")
-	logs := make([]interface{}, 0, len(messages))
+	logs := make([]Applog, 0, len(messages))
 	for _, msg := range messages {
 		if msg != "" {
 			l := Applog{
@@ -1313,17 +1450,24 @@ func (app *App) Log(message, source, unit string) error {
 				AppName: app.Name,
 				Unit:    unit,
 			}
+			if app.StructuredLogs {
+				l.Fields = parseLogFields(msg)
+			}
 			logs = append(logs, l)
 		}
 	}
 	if len(logs) > 0 {
-		notify(app.Name, logs)
-		conn, err := db.LogConn()
+		notifyMessages := make([]interface{}, len(logs))
+		for i := range logs {
+			notifyMessages[i] = logs[i]
+		}
+		notify(app.Name, notifyMessages)
+		forwardLogs(app.Pool, logs)
+		logStorage, err := GetLogStorage()
 		if err != nil {
 			return err
 		}
-		defer conn.Close()
-		return conn.Logs(app.Name).Insert(logs...)
+		return logStorage.Insert(app.Name, logs)
 	}
 	return nil
 }
@@ -1331,38 +1475,36 @@ func (app *App) Log(message, source, unit string) error {
 // LastLogs returns a list of the last `lines` log of the app, matching the
 // fields in the log instance received as an example.
 func (app *App) LastLogs(lines int, filterLog Applog) ([]Applog, error) {
+	logs, _, err := app.QueryLogs(LogQuery{
+		Lines:  lines,
+		Source: filterLog.Source,
+		Unit:   filterLog.Unit,
+	})
+	return logs, err
+}
+
+// QueryLogs returns the last log entries of the app matching the given query,
+// along with a cursor that can be used to fetch older entries.
+func (app *App) QueryLogs(query LogQuery) ([]Applog, string, error) {
 	logsProvisioner, ok := Provisioner.(provision.OptionalLogsProvisioner)
 	if ok {
 		enabled, doc, err := logsProvisioner.LogsEnabled(app)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if !enabled {
-			return nil, stderr.New(doc)
+			return nil, "", stderr.New(doc)
 		}
 	}
-	conn, err := db.LogConn()
+	err := query.Validate()
 	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	logs := []Applog{}
-	q := bson.M{}
-	if filterLog.Source != "" {
-		q["source"] = filterLog.Source
-	}
-	if filterLog.Unit != "" {
-		q["unit"] = filterLog.Unit
+		return nil, "", err
 	}
-	err = conn.Logs(app.Name).Find(q).Sort("-$natural").Limit(lines).All(&logs)
+	logStorage, err := GetLogStorage()
 	if err != nil {
-		return nil, err
-	}
-	l := len(logs)
-	for i := 0; i < l/2; i++ {
-		logs[i], logs[l-1-i] = logs[l-1-i], logs[i]
+		return nil, "", err
 	}
-	return logs, nil
+	return logStorage.List(app.Name, query)
 }
 
 type Filter struct {
@@ -1557,14 +1699,27 @@ type RebuildRoutesResult struct {
 	Removed []string
 }
 
+// RebuildRoutes makes the backend of the app in each of its routers match
+// the routable units and cnames of the app.
 func (app *App) RebuildRoutes() (*RebuildRoutesResult, error) {
-	routerName, err := app.GetRouter()
+	routers, err := app.GetRouters()
 	if err != nil {
 		return nil, err
 	}
+	var result RebuildRoutesResult
+	for i, routerName := range routers {
+		err = app.rebuildRouterRoutes(routerName, i == 0, &result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+func (app *App) rebuildRouterRoutes(routerName string, planRouter bool, result *RebuildRoutesResult) error {
 	r, err := router.Get(routerName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if optsRouter, ok := r.(router.OptsRouter); ok {
 		err = optsRouter.AddBackendOpts(app.Name, app.RouterOpts)
@@ -1572,64 +1727,104 @@ func (app *App) RebuildRoutes() (*RebuildRoutesResult, error) {
 		err = r.AddBackend(app.Name)
 	}
 	if err != nil && err != router.ErrBackendExists {
-		return nil, err
+		return err
 	}
-	var newAddr string
-	if newAddr, err = r.Addr(app.GetName()); err == nil && newAddr != app.Ip {
-		var conn *db.Storage
-		conn, err = db.Conn()
-		if err != nil {
-			return nil, err
-		}
-		defer conn.Close()
-		err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"ip": newAddr}})
+	if newAddr, err := r.Addr(app.GetName()); err == nil {
+		err = app.updateRouterAddr(routerName, planRouter, newAddr)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		app.Ip = newAddr
 	}
 	if cnameRouter, ok := r.(router.CNameRouter); ok {
 		for _, cname := range app.CName {
 			err = cnameRouter.SetCName(cname, app.Name)
 			if err != nil && err != router.ErrCNameExists {
-				return nil, err
+				return err
 			}
 		}
 	}
+	if tlsRouter, ok := r.(router.TLSRouter); ok && planRouter {
+		err = app.restoreCertificates(tlsRouter)
+		if err != nil {
+			return err
+		}
+	}
 	oldRoutes, err := r.Routes(app.GetName())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	expectedMap := make(map[string]*url.URL)
 	units, err := Provisioner.RoutableUnits(app)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for _, unit := range units {
 		expectedMap[unit.Address.Host] = unit.Address
 	}
 	var toRemove []*url.URL
+	// Routes are compared by host only, so routes kept by the router
+	// preserve any weight set on them.
+	seen := make(map[string]bool, len(oldRoutes))
 	for _, url := range oldRoutes {
+		if seen[url.Host] {
+			continue
+		}
+		seen[url.Host] = true
 		if _, isPresent := expectedMap[url.Host]; isPresent {
 			delete(expectedMap, url.Host)
 		} else {
 			toRemove = append(toRemove, url)
 		}
 	}
-	var result RebuildRoutesResult
 	for _, toAddUrl := range expectedMap {
 		err := r.AddRoute(app.GetName(), toAddUrl)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		result.Added = append(result.Added, toAddUrl.String())
 	}
 	for _, toRemoveUrl := range toRemove {
 		err := r.RemoveRoute(app.GetName(), toRemoveUrl)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		result.Removed = append(result.Removed, toRemoveUrl.String())
 	}
-	return &result, nil
+	return nil
+}
+
+// updateRouterAddr stores the address of the app in the router. The address
+// in the router of the plan is the ip of the app.
+func (app *App) updateRouterAddr(routerName string, planRouter bool, addr string) error {
+	current := &app.Ip
+	query := bson.M{"name": app.Name}
+	update := bson.M{"$set": bson.M{"ip": addr}}
+	if !planRouter {
+		current = nil
+		for i := range app.Routers {
+			if app.Routers[i].Name == routerName {
+				current = &app.Routers[i].Address
+				break
+			}
+		}
+		if current == nil {
+			return nil
+		}
+		query = bson.M{"name": app.Name, "routers.name": routerName}
+		update = bson.M{"$set": bson.M{"routers.$.address": addr}}
+	}
+	if *current == addr {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(query, update)
+	if err != nil {
+		return err
+	}
+	*current = addr
+	return nil
 }
@@ -2600,19 +2600,20 @@ func (s *S) TestAppMarshalJSON(c *check.C) {
 		TeamOwner:   "myteam",
 	}
 	expected := map[string]interface{}{
-		"name":        "name",
-		"platform":    "Framework",
-		"repository":  "git@" + repositorytest.ServerHost + ":name.git",
-		"teams":       []interface{}{"team1"},
-		"units":       nil,
-		"ip":          "10.10.10.1",
-		"cname":       []interface{}{"name.mycompany.com"},
-		"owner":       "appOwner",
-		"deploys":     float64(7),
-		"pool":        "test",
-		"description": "description",
-		"teamowner":   "myteam",
-		"lock":        s.zeroLock,
+		"name":           "name",
+		"platform":       "Framework",
+		"repository":     "git@" + repositorytest.ServerHost + ":name.git",
+		"teams":          []interface{}{"team1"},
+		"units":          nil,
+		"ip":             "10.10.10.1",
+		"cname":          []interface{}{"name.mycompany.com"},
+		"owner":          "appOwner",
+		"deploys":        float64(7),
+		"pool":           "test",
+		"description":    "description",
+		"teamowner":      "myteam",
+		"lock":           s.zeroLock,
+		"structuredLogs": false,
 		"plan": map[string]interface{}{
 			"name":     "myplan",
 			"memory":   float64(64),
@@ -2643,19 +2644,20 @@ func (s *S) TestAppMarshalJSONWithoutRepository(c *check.C) {
 		TeamOwner:   "myteam",
 	}
 	expected := map[string]interface{}{
-		"name":        "name",
-		"platform":    "Framework",
-		"repository":  "",
-		"teams":       []interface{}{"team1"},
-		"units":       nil,
-		"ip":          "10.10.10.1",
-		"cname":       []interface{}{"name.mycompany.com"},
-		"owner":       "appOwner",
-		"deploys":     float64(7),
-		"pool":        "pool1",
-		"description": "description",
-		"teamowner":   "myteam",
-		"lock":        s.zeroLock,
+		"name":           "name",
+		"platform":       "Framework",
+		"repository":     "",
+		"teams":          []interface{}{"team1"},
+		"units":          nil,
+		"ip":             "10.10.10.1",
+		"cname":          []interface{}{"name.mycompany.com"},
+		"owner":          "appOwner",
+		"deploys":        float64(7),
+		"pool":           "pool1",
+		"description":    "description",
+		"teamowner":      "myteam",
+		"lock":           s.zeroLock,
+		"structuredLogs": false,
 		"plan": map[string]interface{}{
 			"name":     "myplan",
 			"memory":   float64(64),
@@ -2726,6 +2728,23 @@ func (s *S) TestRunOnce(c *check.C) {
 	c.Assert(cmds, check.HasLen, 1)
 }
 
+func (s *S) TestRunIsolated(c *check.C) {
+	s.provisioner.PrepareOutput([]byte("migrated"))
+	app := App{Name: "myapp"}
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	var buf bytes.Buffer
+	err := app.RunIsolated("python manage.py migrate", &buf, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "migrated")
+	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	expected += " [ -d /home/application/current ] && cd /home/application/current;"
+	expected += " python manage.py migrate"
+	cmds := s.provisioner.GetCmds(expected, &app)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Isolated, check.Equals, true)
+}
+
 func (s *S) TestRunWithoutEnv(c *check.C) {
 	s.provisioner.PrepareOutput([]byte("a lot of files"))
 	app := App{
@@ -3935,6 +3954,31 @@ func (s *S) TestRebuildRoutesTCPRoutes(c *check.C) {
 	c.Assert(app.Ip, check.Equals, addr)
 }
 
+func (s *S) TestRebuildRoutesPreservesRouteWeights(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.SetRouteWeight(a.Name, units[0].Address, 5)
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	changes, err := a.RebuildRoutes()
+	c.Assert(err, check.IsNil)
+	c.Assert(changes.Added, check.DeepEquals, []string{units[1].Address.String()})
+	c.Assert(changes.Removed, check.IsNil)
+	weight, err := routertest.FakeRouter.RouteWeight(a.Name, units[0].Address)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 5)
+	weight, err = routertest.FakeRouter.RouteWeight(a.Name, units[1].Address)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 1)
+}
+
 type URLList []*url.URL
 
 func (l URLList) Len() int           { return len(l) }
@@ -4052,6 +4096,41 @@ func (s *S) TestUpdateDescription(c *check.C) {
 	c.Assert(dbApp.Description, check.Equals, "bleble")
 }
 
+func (s *S) TestSetStructuredLogs(c *check.C) {
+	app := App{Name: "example", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&app, s.user)
+	defer Delete(&app, nil)
+	c.Assert(err, check.IsNil)
+	err = app.SetStructuredLogs(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.StructuredLogs, check.Equals, true)
+	dbApp, err := GetByName(app.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.StructuredLogs, check.Equals, true)
+	err = app.SetStructuredLogs(false)
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(app.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.StructuredLogs, check.Equals, false)
+}
+
+func (s *S) TestLogWithStructuredLogs(c *check.C) {
+	a := App{Name: "newApp", StructuredLogs: true}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer func() {
+		s.conn.Apps().Remove(bson.M{"name": a.Name})
+		s.logConn.Logs(a.Name).DropCollection()
+	}()
+	err = a.Log(`{"level": "error", "msg": "failed"}`+"\nplain message", "app", "unit1")
+	c.Assert(err, check.IsNil)
+	logs, err := a.LastLogs(10, Applog{})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Fields, check.DeepEquals, map[string]string{"level": "error", "msg": "failed"})
+	c.Assert(logs[1].Fields, check.IsNil)
+}
+
 func (s *S) TestUpdateTeamOwner(c *check.C) {
 	app := App{Name: "example", Platform: "python", TeamOwner: s.team.Name, Description: "blabla"}
 	err := CreateApp(&app, s.user)
@@ -0,0 +1,404 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	autoScaleEventKind        = "app.autoscale"
+	autoScaleLogTag           = "[app autoscale]"
+	defaultAutoScaleInterval  = 30 * time.Second
+	defaultAutoScaleCooldown  = 5 * time.Minute
+	autoScaleMetricsAgeFactor = 3
+)
+
+var (
+	ErrAutoScaleRuleNotFound = stderr.New("autoscale rule not found")
+
+	autoScaleMetrics = []string{"cpu", "memory", "requests"}
+)
+
+// AutoScaleRule controls the number of units of a process in an app. Units
+// are added, one at a time, while the average of the metric reported for the
+// units of the process is above Threshold, and removed while it's below
+// ScaleDownThreshold, always respecting MinUnits and MaxUnits.
+type AutoScaleRule struct {
+	App                string    `json:"app"`
+	Process            string    `json:"process"`
+	Metric             string    `json:"metric"`
+	Threshold          float64   `json:"threshold"`
+	ScaleDownThreshold float64   `json:"scaleDownThreshold"`
+	MinUnits           uint      `json:"minUnits"`
+	MaxUnits           uint      `json:"maxUnits"`
+	Cooldown           int       `json:"cooldown,omitempty"`
+	LastScale          time.Time `json:"lastScale"`
+}
+
+type unitMetrics struct {
+	UnitID    string `bson:"_id"`
+	Metrics   map[string]float64
+	UpdatedAt time.Time
+}
+
+type autoScaleDecision struct {
+	Rule   AutoScaleRule
+	Units  int
+	Value  float64
+	Delta  int
+	Reason string
+}
+
+func (r *AutoScaleRule) validate() error {
+	if r.Process == "" {
+		return &errors.ValidationError{Message: "autoscale process is required"}
+	}
+	validMetric := false
+	for _, m := range autoScaleMetrics {
+		if r.Metric == m {
+			validMetric = true
+			break
+		}
+	}
+	if !validMetric {
+		return &errors.ValidationError{Message: fmt.Sprintf("invalid autoscale metric %q, must be one of %v", r.Metric, autoScaleMetrics)}
+	}
+	if r.Threshold <= 0 {
+		return &errors.ValidationError{Message: "autoscale threshold must be greater than 0"}
+	}
+	if r.ScaleDownThreshold == 0 {
+		r.ScaleDownThreshold = r.Threshold / 2
+	}
+	if r.ScaleDownThreshold < 0 || r.ScaleDownThreshold >= r.Threshold {
+		return &errors.ValidationError{Message: "autoscale scale down threshold must be between 0 and the threshold"}
+	}
+	if r.MinUnits == 0 {
+		return &errors.ValidationError{Message: "autoscale minimum units must be greater than 0"}
+	}
+	if r.MaxUnits < r.MinUnits {
+		return &errors.ValidationError{Message: "autoscale maximum units must not be less than the minimum units"}
+	}
+	if r.Cooldown < 0 {
+		return &errors.ValidationError{Message: "autoscale cooldown must not be negative"}
+	}
+	return nil
+}
+
+func (r *AutoScaleRule) cooldown() time.Duration {
+	if r.Cooldown > 0 {
+		return time.Duration(r.Cooldown) * time.Second
+	}
+	cooldown, err := config.GetInt("autoscale:cooldown")
+	if err != nil || cooldown <= 0 {
+		return defaultAutoScaleCooldown
+	}
+	return time.Duration(cooldown) * time.Second
+}
+
+// decide returns how many units should be added (positive) or removed
+// (negative) given the current number of units and the average value of the
+// metric, along with the reason for it.
+func (r *AutoScaleRule) decide(units int, value float64, hasValue bool) (int, string) {
+	if units < int(r.MinUnits) {
+		return int(r.MinUnits) - units, fmt.Sprintf("%d units is below the minimum of %d", units, r.MinUnits)
+	}
+	if units > int(r.MaxUnits) {
+		return int(r.MaxUnits) - units, fmt.Sprintf("%d units is above the maximum of %d", units, r.MaxUnits)
+	}
+	if !hasValue {
+		return 0, ""
+	}
+	if value > r.Threshold && units < int(r.MaxUnits) {
+		return 1, fmt.Sprintf("%s %.2f is above the threshold of %.2f", r.Metric, value, r.Threshold)
+	}
+	if value < r.ScaleDownThreshold && units > int(r.MinUnits) {
+		return -1, fmt.Sprintf("%s %.2f is below the scale down threshold of %.2f", r.Metric, value, r.ScaleDownThreshold)
+	}
+	return 0, ""
+}
+
+// SetAutoScaleRule validates and stores the rule, replacing any existing rule
+// for the same process in the app.
+func SetAutoScaleRule(rule *AutoScaleRule) error {
+	err := rule.validate()
+	if err != nil {
+		return err
+	}
+	_, err = GetByName(rule.App)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.AutoScaleRules().Upsert(bson.M{"app": rule.App, "process": rule.Process}, bson.M{
+		"$set": bson.M{
+			"metric":             rule.Metric,
+			"threshold":          rule.Threshold,
+			"scaledownthreshold": rule.ScaleDownThreshold,
+			"minunits":           rule.MinUnits,
+			"maxunits":           rule.MaxUnits,
+			"cooldown":           rule.Cooldown,
+		},
+		"$setOnInsert": bson.M{"lastscale": time.Time{}},
+	})
+	return err
+}
+
+// ListAutoScaleRules returns the autoscale rules of the app, sorted by
+// process.
+func ListAutoScaleRules(appName string) ([]AutoScaleRule, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var rules []AutoScaleRule
+	err = conn.AutoScaleRules().Find(bson.M{"app": appName}).Sort("process").All(&rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// RemoveAutoScaleRule removes the autoscale rule of the given process from the
+// app.
+func RemoveAutoScaleRule(appName, process string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AutoScaleRules().Remove(bson.M{"app": appName, "process": process})
+	if err == mgo.ErrNotFound {
+		return ErrAutoScaleRuleNotFound
+	}
+	return err
+}
+
+func removeAppAutoScaleRules(appName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.AutoScaleRules().RemoveAll(bson.M{"app": appName})
+	return err
+}
+
+func storeUnitMetrics(unitID string, metrics map[string]float64) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.UnitMetrics().UpsertId(unitID, unitMetrics{
+		UnitID:    unitID,
+		Metrics:   metrics,
+		UpdatedAt: time.Now().UTC(),
+	})
+	return err
+}
+
+// averageUnitMetric returns the average of the metric reported by the given
+// units since the given time, units without recent reports are ignored.
+func averageUnitMetric(unitIDs []string, metric string, since time.Time) (float64, bool, error) {
+	if len(unitIDs) == 0 {
+		return 0, false, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	var reports []unitMetrics
+	query := bson.M{"_id": bson.M{"$in": unitIDs}, "updatedat": bson.M{"$gte": since}}
+	err = conn.UnitMetrics().Find(query).All(&reports)
+	if err != nil {
+		return 0, false, err
+	}
+	var sum float64
+	var count int
+	for _, report := range reports {
+		if value, ok := report.Metrics[metric]; ok {
+			sum += value
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false, nil
+	}
+	return sum / float64(count), true, nil
+}
+
+type appAutoScaler struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	appAutoScalerMut      sync.Mutex
+	appAutoScalerInstance *appAutoScaler
+)
+
+func autoScaleInterval() time.Duration {
+	interval, err := config.GetInt("autoscale:run-interval")
+	if err != nil || interval <= 0 {
+		return defaultAutoScaleInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// StartAutoScaler starts the routine that periodically evaluates the
+// autoscale rules of all apps, adding or removing units as needed.
+func StartAutoScaler() {
+	appAutoScalerMut.Lock()
+	defer appAutoScalerMut.Unlock()
+	if appAutoScalerInstance != nil {
+		return
+	}
+	appAutoScalerInstance = &appAutoScaler{
+		interval: autoScaleInterval(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	shutdown.Register(appAutoScalerInstance)
+	go appAutoScalerInstance.run()
+}
+
+// Shutdown stops the autoscaler, waiting for running scale operations.
+func (s *appAutoScaler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *appAutoScaler) String() string {
+	return "app autoscaler"
+}
+
+func (s *appAutoScaler) run() {
+	defer close(s.done)
+	for {
+		err := s.runOnce(time.Now().UTC())
+		if err != nil {
+			log.Errorf("%s %s", autoScaleLogTag, err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *appAutoScaler) runOnce(now time.Time) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	var rules []AutoScaleRule
+	err = conn.AutoScaleRules().Find(nil).All(&rules)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		err = s.scale(&rules[i], now)
+		if err != nil {
+			log.Errorf("%s unable to scale %s/%s: %s", autoScaleLogTag, rules[i].App, rules[i].Process, err)
+		}
+	}
+	return nil
+}
+
+func (s *appAutoScaler) scale(rule *AutoScaleRule, now time.Time) (err error) {
+	if now.Before(rule.LastScale.Add(rule.cooldown())) {
+		return nil
+	}
+	a, err := GetByName(rule.App)
+	if err != nil {
+		if err == ErrAppNotFound {
+			return nil
+		}
+		return err
+	}
+	units, err := a.Units()
+	if err != nil {
+		return err
+	}
+	var unitIDs []string
+	for _, u := range units {
+		if u.ProcessName == rule.Process {
+			unitIDs = append(unitIDs, u.ID)
+		}
+	}
+	since := now.Add(-autoScaleMetricsAgeFactor * s.interval)
+	value, hasValue, err := averageUnitMetric(unitIDs, rule.Metric, since)
+	if err != nil {
+		return err
+	}
+	delta, reason := rule.decide(len(unitIDs), value, hasValue)
+	if delta == 0 {
+		return nil
+	}
+	decision := autoScaleDecision{
+		Rule:   *rule,
+		Units:  len(unitIDs),
+		Value:  value,
+		Delta:  delta,
+		Reason: reason,
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		InternalKind: autoScaleEventKind,
+		CustomData:   decision,
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			log.Debugf("%s skipping locked app %s", autoScaleLogTag, a.Name)
+			return nil
+		}
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		evt.Abort()
+		return err
+	}
+	err = conn.AutoScaleRules().Update(
+		bson.M{"app": rule.App, "process": rule.Process, "lastscale": rule.LastScale},
+		bson.M{"$set": bson.M{"lastscale": now}},
+	)
+	conn.Close()
+	if err != nil {
+		evt.Abort()
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	defer func() { evt.DoneCustomData(err, decision) }()
+	evt.Logf("%s: scaling process %q from %d to %d units", reason, rule.Process, len(unitIDs), len(unitIDs)+delta)
+	if delta > 0 {
+		return a.AddUnits(uint(delta), rule.Process, evt)
+	}
+	return a.RemoveUnits(uint(-delta), rule.Process, evt)
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAutoScaleRuleDecide(c *check.C) {
+	rule := AutoScaleRule{Metric: "cpu", Threshold: 70, ScaleDownThreshold: 30, MinUnits: 2, MaxUnits: 4}
+	var tests = []struct {
+		units    int
+		value    float64
+		hasValue bool
+		delta    int
+	}{
+		{0, 0, false, 2},
+		{1, 90, true, 1},
+		{6, 10, true, -2},
+		{2, 0, false, 0},
+		{2, 80, true, 1},
+		{4, 80, true, 0},
+		{3, 50, true, 0},
+		{3, 20, true, -1},
+		{2, 20, true, 0},
+	}
+	for _, t := range tests {
+		delta, reason := rule.decide(t.units, t.value, t.hasValue)
+		c.Check(delta, check.Equals, t.delta, check.Commentf("units: %d, value: %f", t.units, t.value))
+		c.Check(reason == "", check.Equals, t.delta == 0)
+	}
+}
+
+func (s *S) TestSetAutoScaleRule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{App: "myapp", Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 5}
+	err = SetAutoScaleRule(&rule)
+	c.Assert(err, check.IsNil)
+	rule = AutoScaleRule{App: "myapp", Process: "web", Metric: "requests", Threshold: 100, MinUnits: 2, MaxUnits: 10}
+	err = SetAutoScaleRule(&rule)
+	c.Assert(err, check.IsNil)
+	rules, err := ListAutoScaleRules("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.HasLen, 1)
+	c.Assert(rules[0].Metric, check.Equals, "requests")
+	c.Assert(rules[0].ScaleDownThreshold, check.Equals, 50.0)
+	c.Assert(rules[0].MinUnits, check.Equals, uint(2))
+	c.Assert(rules[0].MaxUnits, check.Equals, uint(10))
+	c.Assert(rules[0].LastScale.IsZero(), check.Equals, true)
+	err = RemoveAutoScaleRule("myapp", "web")
+	c.Assert(err, check.IsNil)
+	err = RemoveAutoScaleRule("myapp", "web")
+	c.Assert(err, check.Equals, ErrAutoScaleRuleNotFound)
+}
+
+func (s *S) TestSetAutoScaleRuleValidation(c *check.C) {
+	var tests = []struct {
+		rule AutoScaleRule
+		err  string
+	}{
+		{AutoScaleRule{Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 2}, "autoscale process is required"},
+		{AutoScaleRule{Process: "web", Metric: "disk", Threshold: 70, MinUnits: 1, MaxUnits: 2}, "invalid autoscale metric \"disk\".*"},
+		{AutoScaleRule{Process: "web", Metric: "cpu", MinUnits: 1, MaxUnits: 2}, "autoscale threshold must be greater than 0"},
+		{AutoScaleRule{Process: "web", Metric: "cpu", Threshold: 70, ScaleDownThreshold: 80, MinUnits: 1, MaxUnits: 2}, "autoscale scale down threshold .*"},
+		{AutoScaleRule{Process: "web", Metric: "cpu", Threshold: 70, MaxUnits: 2}, "autoscale minimum units must be greater than 0"},
+		{AutoScaleRule{Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 3, MaxUnits: 2}, "autoscale maximum units .*"},
+		{AutoScaleRule{Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 2, Cooldown: -1}, "autoscale cooldown must not be negative"},
+	}
+	for _, t := range tests {
+		t.rule.App = "myapp"
+		err := SetAutoScaleRule(&t.rule)
+		c.Check(err, check.FitsTypeOf, &errors.ValidationError{})
+		c.Check(err, check.ErrorMatches, t.err)
+	}
+}
+
+func (s *S) TestUpdateNodeStatusStoresUnitMetrics(c *check.C) {
+	a := App{Name: "lapname", Platform: "python"}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	unitStates := []provision.UnitStatusData{
+		{ID: units[0].ID, Status: provision.StatusStarted, Metrics: map[string]float64{"cpu": 40}},
+		{ID: units[1].ID, Status: provision.StatusStarted, Metrics: map[string]float64{"cpu": 80, "memory": 1024}},
+		{ID: "not-found", Status: provision.StatusStarted, Metrics: map[string]float64{"cpu": 100}},
+	}
+	_, err = UpdateNodeStatus(provision.NodeStatusData{Units: unitStates})
+	c.Assert(err, check.IsNil)
+	count, err := s.conn.UnitMetrics().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 2)
+	since := time.Now().Add(-time.Minute)
+	value, ok, err := averageUnitMetric([]string{units[0].ID, units[1].ID, "not-found"}, "cpu", since)
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(value, check.Equals, 60.0)
+	value, ok, err = averageUnitMetric([]string{units[0].ID, units[1].ID}, "memory", since)
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(value, check.Equals, 1024.0)
+	_, ok, err = averageUnitMetric([]string{units[0].ID}, "cpu", time.Now().Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestAutoScalerRunOnce(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	s.provisioner.AddUnits(&a, 1, "worker", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		err = storeUnitMetrics(u.ID, map[string]float64{"cpu": 90})
+		c.Assert(err, check.IsNil)
+	}
+	rule := AutoScaleRule{App: "myapp", Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 3}
+	err = SetAutoScaleRule(&rule)
+	c.Assert(err, check.IsNil)
+	scaler := &appAutoScaler{interval: time.Minute}
+	now := time.Now().UTC()
+	err = scaler.runOnce(now)
+	c.Assert(err, check.IsNil)
+	units, err = a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 4)
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:       autoScaleEventKind,
+		LogMatches: `(?s).*cpu 90.00 is above the threshold of 70.00: scaling process "web" from 2 to 3 units.*`,
+	}, eventtest.HasEvent)
+	err = scaler.runOnce(now.Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	units, err = a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 4)
+	rules, err := ListAutoScaleRules("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(rules[0].LastScale.Equal(now.Truncate(time.Millisecond)), check.Equals, true)
+}
+
+func (s *S) TestAutoScalerRunOnceNoMetrics(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	rule := AutoScaleRule{App: "myapp", Process: "web", Metric: "cpu", Threshold: 70, MinUnits: 1, MaxUnits: 3}
+	err = SetAutoScaleRule(&rule)
+	c.Assert(err, check.IsNil)
+	scaler := &appAutoScaler{interval: time.Minute}
+	err = scaler.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	c.Assert(eventtest.EventDesc{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:    autoScaleEventKind,
+		IsEmpty: true,
+	}, eventtest.HasEvent)
+}
@@ -0,0 +1,271 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrTLSNotSupported          = stderr.New("the router of the app does not support TLS certificates")
+	ErrCertificateKeyNotSet     = stderr.New("certificates:encryption-key is not set, certificates cannot be stored")
+	ErrCertificateCNameNotFound = stderr.New("cname not found in app")
+)
+
+// appCertificate is the copy of a certificate added to the router of an app
+// kept by tsuru, so it can be restored when the routes of the app are
+// rebuilt. The private key is encrypted with the key in the
+// certificates:encryption-key config entry.
+type appCertificate struct {
+	CName       string `bson:"_id"`
+	App         string
+	Certificate string
+	Key         []byte
+	NotAfter    time.Time
+}
+
+func certificateCipher() (cipher.AEAD, error) {
+	secret, err := config.GetString("certificates:encryption-key")
+	if err != nil || secret == "" {
+		return nil, ErrCertificateKeyNotSet
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptCertificateKey(key string) ([]byte, error) {
+	aead, err := certificateCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(key), nil), nil
+}
+
+func decryptCertificateKey(data []byte) (string, error) {
+	aead, err := certificateCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", stderr.New("invalid encrypted certificate key")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+func (app *App) tlsRouter() (router.TLSRouter, error) {
+	routerName, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	r, err := router.Get(routerName)
+	if err != nil {
+		return nil, err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return nil, ErrTLSNotSupported
+	}
+	return tlsRouter, nil
+}
+
+func (app *App) hasCName(cname string) bool {
+	for _, c := range app.CName {
+		if c == cname {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificate checks that the certificate and key match and that the
+// certificate is valid for the cname, returning the parsed certificate.
+func parseCertificate(cname, certificate, key string) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair([]byte(certificate), []byte(key))
+	if err != nil {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("invalid certificate: %s", err)}
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("invalid certificate: %s", err)}
+	}
+	err = cert.VerifyHostname(cname)
+	if err != nil {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("invalid certificate: %s", err)}
+	}
+	return cert, nil
+}
+
+// SetCertificate adds the PEM encoded certificate and key to the router of
+// the app, to be used to serve the cname over TLS. The cname must be one of
+// the CNames of the app.
+func (app *App) SetCertificate(cname, certificate, key string) error {
+	if !app.hasCName(cname) {
+		return ErrCertificateCNameNotFound
+	}
+	cert, err := parseCertificate(cname, certificate, key)
+	if err != nil {
+		return err
+	}
+	encryptedKey, err := encryptCertificateKey(key)
+	if err != nil {
+		return err
+	}
+	tlsRouter, err := app.tlsRouter()
+	if err != nil {
+		return err
+	}
+	err = tlsRouter.AddCertificate(cname, certificate, key)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Certificates().UpsertId(cname, appCertificate{
+		CName:       cname,
+		App:         app.Name,
+		Certificate: certificate,
+		Key:         encryptedKey,
+		NotAfter:    cert.NotAfter,
+	})
+	return err
+}
+
+// RemoveCertificate removes the certificate of the cname from the router of
+// the app.
+func (app *App) RemoveCertificate(cname string) error {
+	if !app.hasCName(cname) {
+		return ErrCertificateCNameNotFound
+	}
+	tlsRouter, err := app.tlsRouter()
+	if err != nil {
+		return err
+	}
+	err = tlsRouter.RemoveCertificate(cname)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Certificates().Remove(bson.M{"_id": cname, "app": app.Name})
+}
+
+// GetCertificates returns the certificates in the router of the app, by
+// cname. CNames without a certificate are mapped to an empty string.
+func (app *App) GetCertificates() (map[string]string, error) {
+	tlsRouter, err := app.tlsRouter()
+	if err != nil {
+		return nil, err
+	}
+	certificates := make(map[string]string, len(app.CName))
+	for _, cname := range app.CName {
+		certificate, err := tlsRouter.GetCertificate(cname)
+		if err != nil && err != router.ErrCertificateNotFound {
+			return nil, err
+		}
+		certificates[cname] = certificate
+	}
+	return certificates, nil
+}
+
+// restoreCertificates adds the certificates stored for the CNames of the app
+// back to the router.
+func (app *App) restoreCertificates(tlsRouter router.TLSRouter) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var certificates []appCertificate
+	err = conn.Certificates().Find(bson.M{"app": app.Name}).All(&certificates)
+	if err != nil {
+		return err
+	}
+	for _, c := range certificates {
+		if !app.hasCName(c.CName) {
+			continue
+		}
+		key, err := decryptCertificateKey(c.Key)
+		if err != nil {
+			return err
+		}
+		err = tlsRouter.AddCertificate(c.CName, c.Certificate, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCertificates removes the certificates of the given cnames of the
+// app, or all the certificates stored for the app when no cname is given,
+// from its router and from the database.
+func (app *App) removeCertificates(cnames ...string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	query := bson.M{"app": app.Name}
+	if len(cnames) > 0 {
+		query["_id"] = bson.M{"$in": cnames}
+	} else {
+		var certificates []appCertificate
+		err = conn.Certificates().Find(query).All(&certificates)
+		if err != nil || len(certificates) == 0 {
+			return err
+		}
+		for _, c := range certificates {
+			cnames = append(cnames, c.CName)
+		}
+	}
+	tlsRouter, err := app.tlsRouter()
+	if err == nil {
+		for _, cname := range cnames {
+			err = tlsRouter.RemoveCertificate(cname)
+			if err != nil && err != router.ErrCertificateNotFound {
+				return err
+			}
+		}
+	} else if err != ErrTLSNotSupported {
+		return err
+	}
+	_, err = conn.Certificates().RemoveAll(query)
+	return err
+}
@@ -0,0 +1,180 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func generateCertificate(c *check.C, hosts ...string) (string, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"tsuru"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     hosts,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	c.Assert(err, check.IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	c.Assert(err, check.IsNil)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(cert), string(key)
+}
+
+func (s *S) TestEncryptCertificateKey(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	data, err := encryptCertificateKey("mykey")
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Not(check.Matches), ".*mykey.*")
+	key, err := decryptCertificateKey(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(key, check.Equals, "mykey")
+	config.Set("certificates:encryption-key", "othersecret")
+	_, err = decryptCertificateKey(data)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestEncryptCertificateKeyNotSet(c *check.C) {
+	_, err := encryptCertificateKey("mykey")
+	c.Assert(err, check.Equals, ErrCertificateKeyNotSet)
+}
+
+func (s *S) TestSetCertificate(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", CName: []string{"app.example.com"}, Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "app.example.com")
+	err = a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	certificate, err := routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(certificate, check.Equals, cert)
+	var stored appCertificate
+	err = s.conn.Certificates().FindId("app.example.com").One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.App, check.Equals, a.Name)
+	c.Assert(stored.Certificate, check.Equals, cert)
+	c.Assert(string(stored.Key), check.Not(check.Equals), key)
+	decrypted, err := decryptCertificateKey(stored.Key)
+	c.Assert(err, check.IsNil)
+	c.Assert(decrypted, check.Equals, key)
+}
+
+func (s *S) TestSetCertificateInvalid(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", CName: []string{"app.example.com"}, Plan: Plan{Router: "fake"}}
+	cert, key := generateCertificate(c, "app.example.com")
+	err := a.SetCertificate("other.example.com", cert, key)
+	c.Assert(err, check.Equals, ErrCertificateCNameNotFound)
+	_, otherKey := generateCertificate(c, "app.example.com")
+	err = a.SetCertificate("app.example.com", cert, otherKey)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	a.CName = append(a.CName, "other.example.com")
+	err = a.SetCertificate("other.example.com", cert, key)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, "invalid certificate: .*other.example.com.*")
+}
+
+func (s *S) TestRemoveCertificate(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", CName: []string{"app.example.com"}, Plan: Plan{Router: "fake"}}
+	cert, key := generateCertificate(c, "app.example.com")
+	err := a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	err = a.RemoveCertificate("app.example.com")
+	c.Assert(err, check.IsNil)
+	_, err = routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	n, err := s.conn.Certificates().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	err = a.RemoveCertificate("app.example.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
+func (s *S) TestRemoveCNameRemovesCertificate(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddCName("app.example.com", "www.example.com")
+	c.Assert(err, check.IsNil)
+	cert, key := generateCertificate(c, "app.example.com", "www.example.com")
+	err = a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	err = a.SetCertificate("www.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	err = a.RemoveCName("app.example.com")
+	c.Assert(err, check.IsNil)
+	_, err = routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	certificate, err := routertest.FakeRouter.GetCertificate("www.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(certificate, check.Equals, cert)
+	n, err := s.conn.Certificates().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
+func (s *S) TestGetCertificates(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", CName: []string{"app.example.com", "www.example.com"}, Plan: Plan{Router: "fake"}}
+	cert, key := generateCertificate(c, "app.example.com")
+	err := a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	certificates, err := a.GetCertificates()
+	c.Assert(err, check.IsNil)
+	c.Assert(certificates, check.DeepEquals, map[string]string{
+		"app.example.com": cert,
+		"www.example.com": "",
+	})
+}
+
+func (s *S) TestRebuildRoutesRestoresCertificates(c *check.C) {
+	config.Set("certificates:encryption-key", "mysecret")
+	defer config.Unset("certificates:encryption-key")
+	a := App{Name: "my-test-app", CName: []string{"app.example.com"}, Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	cert, key := generateCertificate(c, "app.example.com")
+	err = a.SetCertificate("app.example.com", cert, key)
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.Reset()
+	_, err = a.RebuildRoutes()
+	c.Assert(err, check.IsNil)
+	certificate, err := routertest.FakeRouter.GetCertificate("app.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(certificate, check.Equals, cert)
+}
@@ -5,12 +5,14 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/event"
 	tsuruIo "github.com/tsuru/tsuru/io"
@@ -34,6 +36,13 @@ const (
 
 var reImageVersion = regexp.MustCompile("v[0-9]+$")
 
+var (
+	ErrCanaryNotSupported    = errors.New("provisioner doesn't support canary deploys")
+	ErrInvalidCanaryFraction = errors.New("canary fraction must be greater than 0 and less than 1")
+	ErrBlueGreenNotSupported = errors.New("provisioner doesn't support blue/green deploys")
+	ErrCanaryWithBlueGreen   = errors.New("canary and blue/green deploys can't be used together")
+)
+
 type DeployData struct {
 	ID          bson.ObjectId `bson:"_id,omitempty"`
 	App         string
@@ -121,6 +130,9 @@ func eventToDeployData(evt *event.Event, validImages set, full bool) *DeployData
 		Error:     evt.Error,
 		User:      evt.Owner.Name,
 	}
+	if evt.Owner.Type == event.OwnerTypeServiceAccount {
+		data.User = auth.ServiceAccountDisplayName(evt.Owner.Name)
+	}
 	var startOpts DeployOptions
 	err := evt.StartData(&startOpts)
 	if err == nil {
@@ -165,6 +177,25 @@ type DeployOptions struct {
 	Event        *event.Event `bson:"-"`
 	Kind         DeployKind
 	Message      string
+	Canary       *CanaryOptions `bson:",omitempty"`
+	BlueGreen    bool
+	// approved is set when the deploy was approved, allowing it to run in
+	// pools requiring approval.
+	approved bool
+}
+
+// CanaryOptions holds the settings for a canary deploy. In a canary deploy
+// only a fraction of the units of each process are replaced by units running
+// the new image, the deploy must later be promoted or aborted.
+type CanaryOptions struct {
+	Fraction float64
+}
+
+func (o *CanaryOptions) Validate() error {
+	if o.Fraction <= 0 || o.Fraction >= 1 {
+		return ErrInvalidCanaryFraction
+	}
+	return nil
 }
 
 func (o *DeployOptions) GetKind() (kind DeployKind) {
@@ -196,6 +227,15 @@ func Deploy(opts DeployOptions) (string, error) {
 	if opts.Event == nil {
 		return "", fmt.Errorf("missing event in deploy opts")
 	}
+	if !opts.approved {
+		required, err := requiresDeployApproval(opts.App)
+		if err != nil {
+			return "", err
+		}
+		if required {
+			return "", ErrDeployApprovalRequired
+		}
+	}
 	if opts.Rollback && !regexp.MustCompile(":v[0-9]+$").MatchString(opts.Image) {
 		validImages, err := findValidImages(opts.App.Name)
 		if err == nil {
@@ -226,6 +266,22 @@ func Deploy(opts DeployOptions) (string, error) {
 }
 
 func deployToProvisioner(opts *DeployOptions, evt *event.Event) (string, error) {
+	if opts.Canary != nil {
+		if _, ok := Provisioner.(provision.CanaryDeployer); !ok {
+			return "", ErrCanaryNotSupported
+		}
+		if err := opts.Canary.Validate(); err != nil {
+			return "", err
+		}
+		if opts.BlueGreen {
+			return "", ErrCanaryWithBlueGreen
+		}
+	}
+	if opts.BlueGreen {
+		if _, ok := Provisioner.(provision.BlueGreenDeployer); !ok {
+			return "", ErrBlueGreenNotSupported
+		}
+	}
 	switch opts.GetKind() {
 	case DeployRollback:
 		return Provisioner.Rollback(opts.App, opts.Image, evt)
@@ -244,6 +300,37 @@ func deployToProvisioner(opts *DeployOptions, evt *event.Event) (string, error)
 	}
 }
 
+// PromoteCanary finishes the canary deploy in progress for the app, replacing
+// all remaining units with units running the new image.
+func PromoteCanary(app *App, evt *event.Event) error {
+	deployer, ok := Provisioner.(provision.CanaryDeployer)
+	if !ok {
+		return ErrCanaryNotSupported
+	}
+	return deployer.CanaryPromote(app, evt)
+}
+
+// AbortCanary rolls back the canary deploy in progress for the app, replacing
+// the units running the new image with units running the previous one.
+func AbortCanary(app *App, evt *event.Event) error {
+	deployer, ok := Provisioner.(provision.CanaryDeployer)
+	if !ok {
+		return ErrCanaryNotSupported
+	}
+	return deployer.CanaryAbort(app, evt)
+}
+
+// RevertBlueGreen reverts the last blue/green deploy of the app, sending the
+// traffic back to the units running the previous image, as long as they're
+// still available.
+func RevertBlueGreen(app *App, evt *event.Event) error {
+	deployer, ok := Provisioner.(provision.BlueGreenDeployer)
+	if !ok {
+		return ErrBlueGreenNotSupported
+	}
+	return deployer.BlueGreenRevert(app, evt)
+}
+
 func ValidateOrigin(origin string) bool {
 	originList := []string{"app-deploy", "git", "rollback", "drag-and-drop", "image"}
 	for _, ol := range originList {
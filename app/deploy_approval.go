@@ -0,0 +1,335 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DeployApprovalPending  = "pending"
+	DeployApprovalApproved = "approved"
+	DeployApprovalRejected = "rejected"
+	DeployApprovalExpired  = "expired"
+
+	deployApprovalEventKind       = "app.deploy.approval"
+	deployApprovalExpireEventKind = "app.deploy.approval.expire"
+	deployApprovalLogTag          = "[deploy approval]"
+	deployApprovalExpireInterval  = time.Minute
+	defaultDeployApprovalTimeout  = 30 * time.Minute
+)
+
+var (
+	ErrDeployApprovalNotFound    = errors.New("deploy approval not found")
+	ErrDeployApprovalNotPending  = errors.New("deploy is no longer waiting for approval")
+	ErrDeployApprovalByRequester = errors.New("deploy must be approved by a user other than the one who started it")
+	ErrDeployApprovalRequired    = errors.New("deploy requires approval")
+	ErrDeployApprovalUpload      = errors.New("deploys waiting for approval can't use uploaded files, deploy an image or an archive URL instead")
+)
+
+// DeployApproval represents a deploy to an app in a pool requiring approval.
+// The options of the deploy are stored, so it can be started once approved.
+type DeployApproval struct {
+	ID        string        `bson:"_id" json:"id"`
+	App       string        `json:"app"`
+	Pool      string        `json:"pool"`
+	Requester string        `json:"requester"`
+	Status    string        `json:"status"`
+	Approver  string        `json:"approver,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Options   DeployOptions `json:"-"`
+}
+
+func deployApprovalTimeout() time.Duration {
+	timeout, err := config.GetInt("deploy:approval-timeout")
+	if err != nil || timeout <= 0 {
+		return defaultDeployApprovalTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+func requiresDeployApproval(app *App) (bool, error) {
+	pool, err := provision.GetPoolByName(app.Pool)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pool.RequireDeployApproval, nil
+}
+
+// RequestDeployApproval stores the deploy as waiting for approval when the
+// pool of the app requires it, returning the pending approval. It returns nil
+// when the deploy doesn't require approval and may start right away. The
+// request is recorded as an event owned by the user who requested the deploy.
+func RequestDeployApproval(opts DeployOptions) (approval *DeployApproval, err error) {
+	required, err := requiresDeployApproval(opts.App)
+	if err != nil || !required {
+		return nil, err
+	}
+	if opts.File != nil {
+		return nil, ErrDeployApprovalUpload
+	}
+	now := time.Now().UTC()
+	approval = &DeployApproval{
+		ID:        bson.NewObjectId().Hex(),
+		App:       opts.App.Name,
+		Pool:      opts.App.Pool,
+		Requester: opts.User,
+		Status:    DeployApprovalPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(deployApprovalTimeout()),
+		Options:   opts,
+	}
+	approval.Options.App = nil
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: approval.App},
+		InternalKind: deployApprovalEventKind,
+		RawOwner:     event.Owner{Type: event.OwnerTypeUser, Name: approval.Requester},
+		CustomData:   approval,
+		DisableLock:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { evt.Done(err) }()
+	evt.Logf("deploy %s waiting for approval until %s", approval.ID, approval.ExpiresAt.Format(time.RFC3339))
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.DeployApprovals().Insert(approval)
+	if err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// ListDeployApprovals returns the deploys of the app waiting for approval.
+func ListDeployApprovals(appName string) ([]DeployApproval, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var approvals []DeployApproval
+	query := bson.M{
+		"app":       appName,
+		"status":    DeployApprovalPending,
+		"expiresat": bson.M{"$gt": time.Now().UTC()},
+	}
+	err = conn.DeployApprovals().Find(query).Sort("createdat").All(&approvals)
+	if err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// ApproveDeploy approves the pending deploy and runs it, writing its output
+// to w. The deploy event is created, locking the app, before the approval is
+// recorded, so a deploy that can't start yet remains pending.
+func ApproveDeploy(appName, id, approver string, w io.Writer) (imageID string, err error) {
+	approval, err := findPendingDeployApproval(appName, id, approver)
+	if err != nil {
+		return "", err
+	}
+	a, err := GetByName(appName)
+	if err != nil {
+		return "", err
+	}
+	opts := approval.Options
+	opts.App = a
+	opts.OutputStream = w
+	opts.approved = true
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:       permission.PermAppDeploy,
+		RawOwner:   event.Owner{Type: event.OwnerTypeUser, Name: approval.Requester},
+		CustomData: opts,
+		Cancelable: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = setDeployApprovalStatus(id, DeployApprovalApproved, approver, "")
+	if err != nil {
+		evt.Abort()
+		return "", err
+	}
+	defer func() { evt.DoneCustomData(err, map[string]string{"image": imageID}) }()
+	opts.Event = evt
+	evt.SetLogWriter(w)
+	evt.Logf("---- Deploy approved by %s ----", approver)
+	return Deploy(opts)
+}
+
+// RejectDeploy rejects the pending deploy with the given reason.
+func RejectDeploy(appName, id, approver, reason string) error {
+	_, err := findPendingDeployApproval(appName, id, approver)
+	if err != nil {
+		return err
+	}
+	return setDeployApprovalStatus(id, DeployApprovalRejected, approver, reason)
+}
+
+func findPendingDeployApproval(appName, id, approver string) (*DeployApproval, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var approval DeployApproval
+	err = conn.DeployApprovals().Find(bson.M{"_id": id, "app": appName}).One(&approval)
+	if err == mgo.ErrNotFound {
+		return nil, ErrDeployApprovalNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if approval.Requester == approver {
+		return nil, ErrDeployApprovalByRequester
+	}
+	if approval.Status != DeployApprovalPending || time.Now().After(approval.ExpiresAt) {
+		return nil, ErrDeployApprovalNotPending
+	}
+	return &approval, nil
+}
+
+func setDeployApprovalStatus(id, status, approver, reason string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.DeployApprovals().Update(
+		bson.M{"_id": id, "status": DeployApprovalPending, "expiresat": bson.M{"$gt": time.Now().UTC()}},
+		bson.M{"$set": bson.M{"status": status, "approver": approver, "reason": reason}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrDeployApprovalNotPending
+	}
+	return err
+}
+
+type deployApprovalExpirer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	deployApprovalExpirerMut      sync.Mutex
+	deployApprovalExpirerInstance *deployApprovalExpirer
+)
+
+// StartDeployApprovalExpirer starts the routine that periodically marks the
+// pending deploys past their timeout as expired, recording an event for each
+// of them.
+func StartDeployApprovalExpirer() {
+	deployApprovalExpirerMut.Lock()
+	defer deployApprovalExpirerMut.Unlock()
+	if deployApprovalExpirerInstance != nil {
+		return
+	}
+	deployApprovalExpirerInstance = &deployApprovalExpirer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	shutdown.Register(deployApprovalExpirerInstance)
+	go deployApprovalExpirerInstance.run()
+}
+
+// Shutdown stops the expirer, waiting for the running expiration.
+func (e *deployApprovalExpirer) Shutdown() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *deployApprovalExpirer) String() string {
+	return "deploy approval expirer"
+}
+
+func (e *deployApprovalExpirer) run() {
+	defer close(e.done)
+	for {
+		err := expireDeployApprovals()
+		if err != nil {
+			log.Errorf("%s %s", deployApprovalLogTag, err)
+		}
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(deployApprovalExpireInterval):
+		}
+	}
+}
+
+func expireDeployApprovals() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	var approvals []DeployApproval
+	err = conn.DeployApprovals().Find(bson.M{
+		"status":    DeployApprovalPending,
+		"expiresat": bson.M{"$lte": now},
+	}).All(&approvals)
+	if err != nil {
+		return err
+	}
+	for i := range approvals {
+		err = expireDeployApproval(conn, &approvals[i], now)
+		if err != nil {
+			log.Errorf("%s unable to expire deploy %s of app %s: %s", deployApprovalLogTag, approvals[i].ID, approvals[i].App, err)
+		}
+	}
+	return nil
+}
+
+func expireDeployApproval(conn *db.Storage, approval *DeployApproval, now time.Time) (err error) {
+	approval.Status = DeployApprovalExpired
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: approval.App},
+		InternalKind: deployApprovalExpireEventKind,
+		CustomData:   approval,
+		DisableLock:  true,
+	})
+	if err != nil {
+		return err
+	}
+	err = conn.DeployApprovals().Update(
+		bson.M{"_id": approval.ID, "status": DeployApprovalPending, "expiresat": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": DeployApprovalExpired}},
+	)
+	if err == mgo.ErrNotFound {
+		// Decided or expired by another tsurud in the meantime.
+		evt.Abort()
+		return nil
+	}
+	defer func() { evt.Done(err) }()
+	if err != nil {
+		return err
+	}
+	evt.Logf("deploy %s requested by %s expired without approval", approval.ID, approval.Requester)
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestRequestDeployApproval(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage", User: s.user.Email})
+	c.Assert(err, check.IsNil)
+	c.Assert(approval, check.NotNil)
+	c.Assert(approval.Requester, check.Equals, s.user.Email)
+	c.Assert(approval.Pool, check.Equals, "protected")
+	c.Assert(approval.Status, check.Equals, DeployApprovalPending)
+	approvals, err := ListDeployApprovals(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 1)
+	c.Assert(approvals[0].ID, check.Equals, approval.ID)
+	c.Assert(approvals[0].Options.Image, check.Equals, "myimage")
+	c.Assert(approvals[0].Options.App, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Owner:      s.user.Email,
+		Kind:       deployApprovalEventKind,
+		LogMatches: `deploy ` + approval.ID + ` waiting for approval`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRequestDeployApprovalNotRequired(c *check.C) {
+	a := App{Name: "myapp", Platform: "django", Pool: "pool1"}
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage"})
+	c.Assert(err, check.IsNil)
+	c.Assert(approval, check.IsNil)
+}
+
+func (s *S) TestRequestDeployApprovalUpload(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	file := ioutil.NopCloser(bytes.NewBufferString("file"))
+	_, err = RequestDeployApproval(DeployOptions{App: &a, File: file, FileSize: 4})
+	c.Assert(err, check.Equals, ErrDeployApprovalUpload)
+	approvals, err := ListDeployApprovals(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 0)
+}
+
+func (s *S) TestDeployRequiresApproval(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+	})
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	_, err = Deploy(DeployOptions{App: &a, Image: "myimage", OutputStream: writer, Event: evt})
+	c.Assert(err, check.Equals, ErrDeployApprovalRequired)
+	evt.Done(err)
+	c.Assert(writer.String(), check.Not(check.Matches), `(?s).*Image deploy called.*`)
+}
+
+func (s *S) TestDeployApproved(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage", User: s.user.Email})
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	_, err = ApproveDeploy(a.Name, approval.ID, s.user.Email, writer)
+	c.Assert(err, check.Equals, ErrDeployApprovalByRequester)
+	_, err = ApproveDeploy("otherapp", approval.ID, "approver@example.com", writer)
+	c.Assert(err, check.Equals, ErrDeployApprovalNotFound)
+	imageID, err := ApproveDeploy(a.Name, approval.ID, "approver@example.com", writer)
+	c.Assert(err, check.IsNil)
+	c.Assert(imageID, check.Equals, "myimage")
+	c.Assert(writer.String(), check.Matches, `(?s).*approved by approver@example.com.*Image deploy called.*`)
+	c.Assert(eventtest.EventDesc{
+		Target:        event.Target{Type: "app", Value: a.Name},
+		Owner:         s.user.Email,
+		Kind:          "app.deploy",
+		EndCustomData: map[string]interface{}{"image": "myimage"},
+		LogMatches:    `Image deploy called`,
+	}, eventtest.HasEvent)
+	var dbApproval DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, DeployApprovalApproved)
+	c.Assert(dbApproval.Approver, check.Equals, "approver@example.com")
+	err = RejectDeploy(a.Name, approval.ID, "approver@example.com", "")
+	c.Assert(err, check.Equals, ErrDeployApprovalNotPending)
+}
+
+func (s *S) TestDeployApprovedAppLocked(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage", User: s.user.Email})
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppUpdateEnvSet,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+	})
+	c.Assert(err, check.IsNil)
+	_, err = ApproveDeploy(a.Name, approval.ID, "approver@example.com", &bytes.Buffer{})
+	c.Assert(err, check.FitsTypeOf, event.ErrEventLocked{})
+	evt.Done(nil)
+	approvals, err := ListDeployApprovals(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 1)
+	c.Assert(approvals[0].Status, check.Equals, DeployApprovalPending)
+}
+
+func (s *S) TestDeployRejected(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage", User: s.user.Email})
+	c.Assert(err, check.IsNil)
+	err = RejectDeploy(a.Name, approval.ID, "approver@example.com", "frozen for the holidays")
+	c.Assert(err, check.IsNil)
+	var dbApproval DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, DeployApprovalRejected)
+	c.Assert(dbApproval.Reason, check.Equals, "frozen for the holidays")
+	_, err = ApproveDeploy(a.Name, approval.ID, "approver@example.com", &bytes.Buffer{})
+	c.Assert(err, check.Equals, ErrDeployApprovalNotPending)
+}
+
+func (s *S) TestDeployApprovalExpired(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a := App{Name: "myapp", Platform: "django", Pool: "protected", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	approval, err := RequestDeployApproval(DeployOptions{App: &a, Image: "myimage", User: s.user.Email})
+	c.Assert(err, check.IsNil)
+	err = s.conn.DeployApprovals().UpdateId(approval.ID, bson.M{"$set": bson.M{"expiresat": time.Now().Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	approvals, err := ListDeployApprovals(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(approvals, check.HasLen, 0)
+	_, err = ApproveDeploy(a.Name, approval.ID, "approver@example.com", &bytes.Buffer{})
+	c.Assert(err, check.Equals, ErrDeployApprovalNotPending)
+	err = setDeployApprovalStatus(approval.ID, DeployApprovalApproved, "approver@example.com", "")
+	c.Assert(err, check.Equals, ErrDeployApprovalNotPending)
+	err = expireDeployApprovals()
+	c.Assert(err, check.IsNil)
+	err = expireDeployApprovals()
+	c.Assert(err, check.IsNil)
+	var dbApproval DeployApproval
+	err = s.conn.DeployApprovals().FindId(approval.ID).One(&dbApproval)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApproval.Status, check.Equals, DeployApprovalExpired)
+	c.Assert(dbApproval.Approver, check.Equals, "")
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:       deployApprovalExpireEventKind,
+		LogMatches: `deploy ` + approval.ID + ` requested by ` + s.user.Email + ` expired`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestDeployApprovalNotRequired(c *check.C) {
+	a := App{Name: "myapp", Pool: "pool1"}
+	required, err := requiresDeployApproval(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, false)
+	err = provision.AddPool(provision.AddPoolOptions{Name: "protected", RequireDeployApproval: true})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("protected")
+	a.Pool = "protected"
+	required, err = requiresDeployApproval(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(required, check.Equals, true)
+}
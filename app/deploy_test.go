@@ -101,6 +101,24 @@ func (s *S) TestListAppDeploys(c *check.C) {
 	}
 }
 
+func (s *S) TestListAppDeploysByServiceAccount(c *check.C) {
+	a := App{Name: "g1"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: "g1"},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeServiceAccount, Name: "ci-bot@myteam.service-account"},
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	deploys, err := ListDeploys(nil, 0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deploys, check.HasLen, 1)
+	c.Assert(deploys[0].User, check.Equals, "ci-bot of team myteam")
+}
+
 func (s *S) TestListAppDeploysWithImage(c *check.C) {
 	a := App{Name: "g1"}
 	err := s.conn.Apps().Insert(a)
@@ -810,3 +828,68 @@ func (s *S) TestMigrateDeploysToEvents(c *check.C) {
 	normalizeTS(insert)
 	c.Assert(deploys, check.DeepEquals, []DeployData{insert[1], insert[0]})
 }
+
+func (s *S) TestDeployToProvisionerCanaryNotSupported(c *check.C) {
+	a := App{
+		Name:     "someApp",
+		Platform: "django",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+	})
+	c.Assert(err, check.IsNil)
+	opts := DeployOptions{App: &a, Image: "myimage", Canary: &CanaryOptions{Fraction: 0.5}}
+	_, err = deployToProvisioner(&opts, evt)
+	c.Assert(err, check.Equals, ErrCanaryNotSupported)
+	err = PromoteCanary(&a, evt)
+	c.Assert(err, check.Equals, ErrCanaryNotSupported)
+	err = AbortCanary(&a, evt)
+	c.Assert(err, check.Equals, ErrCanaryNotSupported)
+}
+
+func (s *S) TestCanaryOptionsValidate(c *check.C) {
+	var tests = []struct {
+		fraction float64
+		err      error
+	}{
+		{0.1, nil},
+		{0.5, nil},
+		{0.99, nil},
+		{0, ErrInvalidCanaryFraction},
+		{1, ErrInvalidCanaryFraction},
+		{-0.5, ErrInvalidCanaryFraction},
+		{1.5, ErrInvalidCanaryFraction},
+	}
+	for _, t := range tests {
+		opts := CanaryOptions{Fraction: t.fraction}
+		c.Check(opts.Validate(), check.Equals, t.err)
+	}
+}
+
+func (s *S) TestDeployToProvisionerBlueGreenNotSupported(c *check.C) {
+	a := App{
+		Name:     "someApp",
+		Platform: "django",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+	})
+	c.Assert(err, check.IsNil)
+	opts := DeployOptions{App: &a, Image: "myimage", BlueGreen: true}
+	_, err = deployToProvisioner(&opts, evt)
+	c.Assert(err, check.Equals, ErrBlueGreenNotSupported)
+	err = RevertBlueGreen(&a, evt)
+	c.Assert(err, check.Equals, ErrBlueGreenNotSupported)
+}
@@ -0,0 +1,405 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/cron"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	jobRunTaskName     = "run-app-job"
+	jobRunEventKind    = "job.run"
+	defaultJobTimeout  = time.Hour
+	jobLogSource       = "job"
+	jobSchedulerLogTag = "[job-scheduler]"
+)
+
+var (
+	ErrJobNotFound      = stderr.New("job not found")
+	ErrJobAlreadyExists = stderr.New("there is already a job with this name in the app")
+	ErrJobsNotSupported = stderr.New("provisioner does not support running jobs")
+
+	jobSchedulerInterval = 10 * time.Second
+)
+
+// Job represents a command that is periodically executed in a new unit of an
+// app, following a cron expression evaluated in UTC.
+type Job struct {
+	Name     string    `json:"name"`
+	App      string    `json:"app"`
+	Schedule string    `json:"schedule"`
+	Command  string    `json:"command"`
+	Process  string    `json:"process,omitempty"`
+	Image    string    `json:"image,omitempty"`
+	Timeout  int       `json:"timeout,omitempty"`
+	NextRun  time.Time `json:"nextRun"`
+	LastRun  time.Time `json:"lastRun"`
+}
+
+// JobTarget returns the event target used for recording the runs of the job.
+func JobTarget(appName, jobName string) event.Target {
+	return event.Target{Type: event.TargetTypeJob, Value: appName + "/" + jobName}
+}
+
+func (j *Job) validate() (*cron.Schedule, error) {
+	if !nameRegexp.MatchString(j.Name) {
+		msg := "Invalid job name, your job should have at most 63 " +
+			"characters, containing only lower case letters, numbers or dashes, " +
+			"starting with a letter."
+		return nil, &errors.ValidationError{Message: msg}
+	}
+	if j.Command == "" {
+		return nil, &errors.ValidationError{Message: "job command is required"}
+	}
+	if j.Timeout < 0 {
+		return nil, &errors.ValidationError{Message: "job timeout must not be negative"}
+	}
+	sched, err := cron.Parse(j.Schedule)
+	if err != nil {
+		return nil, &errors.ValidationError{Message: err.Error()}
+	}
+	return sched, nil
+}
+
+func (j *Job) timeout() time.Duration {
+	if j.Timeout > 0 {
+		return time.Duration(j.Timeout) * time.Second
+	}
+	timeout, err := config.GetInt("jobs:default-timeout")
+	if err != nil || timeout <= 0 {
+		return defaultJobTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// CreateJob validates and stores a new job, scheduling its first run.
+func CreateJob(job *Job) error {
+	sched, err := job.validate()
+	if err != nil {
+		return err
+	}
+	if _, ok := Provisioner.(provision.IsolatedRunner); !ok {
+		return ErrJobsNotSupported
+	}
+	_, err = GetByName(job.App)
+	if err != nil {
+		return err
+	}
+	if job.Image != "" {
+		err = checkJobImage(job.App, job.Image)
+		if err != nil {
+			return err
+		}
+	}
+	job.NextRun = sched.Next(time.Now().UTC())
+	job.LastRun = time.Time{}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Jobs().Insert(job)
+	if mgo.IsDup(err) {
+		return ErrJobAlreadyExists
+	}
+	return err
+}
+
+// checkJobImage ensures jobs only run images deployed to the app, as they run
+// with the app environment.
+func checkJobImage(appName, image string) error {
+	imgs, err := Provisioner.ValidAppImages(appName)
+	if err != nil {
+		return err
+	}
+	for _, img := range imgs {
+		if img == image {
+			return nil
+		}
+	}
+	return &errors.ValidationError{Message: fmt.Sprintf("image %q not found in app", image)}
+}
+
+// GetJob returns the job with the given name in the app.
+func GetJob(appName, name string) (*Job, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var job Job
+	err = conn.Jobs().Find(bson.M{"app": appName, "name": name}).One(&job)
+	if err == mgo.ErrNotFound {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns the jobs of the app, sorted by name.
+func ListJobs(appName string) ([]Job, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var jobs []Job
+	err = conn.Jobs().Find(bson.M{"app": appName}).Sort("name").All(&jobs)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ListJobsByApps returns the jobs of all the given apps.
+func ListJobsByApps(appNames []string) ([]Job, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var jobs []Job
+	err = conn.Jobs().Find(bson.M{"app": bson.M{"$in": appNames}}).Sort("app", "name").All(&jobs)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// RemoveJob removes the job with the given name from the app.
+func RemoveJob(appName, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Jobs().Remove(bson.M{"app": appName, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrJobNotFound
+	}
+	return err
+}
+
+func removeAppJobs(appName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Jobs().RemoveAll(bson.M{"app": appName})
+	return err
+}
+
+// Run executes the job in a new unit of the app, writing its output to the
+// event and to the app logs. Canceling the event stops the job.
+func (j *Job) Run(evt *event.Event) error {
+	runner, ok := Provisioner.(provision.IsolatedRunner)
+	if !ok {
+		return ErrJobsNotSupported
+	}
+	a, err := GetByName(j.App)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	err = conn.Jobs().Update(bson.M{"app": j.App, "name": j.Name}, bson.M{"$set": bson.M{"lastrun": time.Now().UTC()}})
+	conn.Close()
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	logWriter := LogWriter{App: a, Source: jobLogSource}
+	logWriter.Async()
+	defer logWriter.Close()
+	w := io.MultiWriter(evt, &logWriter)
+	fmt.Fprintf(w, "---- Running job %q ----\n", j.Name)
+	return runner.ExecuteCommandIsolated(provision.IsolatedRunOptions{
+		App:     a,
+		Stdout:  w,
+		Stderr:  w,
+		Cmd:     sourcedCommand(j.Command),
+		Process: j.Process,
+		Image:   j.Image,
+		Timeout: j.timeout(),
+		Event:   evt,
+	})
+}
+
+type runJobTask struct{}
+
+func (t *runJobTask) Name() string {
+	return jobRunTaskName
+}
+
+func (t *runJobTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	appName, _ := params["app"].(string)
+	jobName, _ := params["job"].(string)
+	if appName == "" || jobName == "" {
+		job.Error(stderr.New("invalid parameters, expected app and job"))
+		return
+	}
+	err := runJob(appName, jobName)
+	if err != nil {
+		job.Error(err)
+		return
+	}
+	job.Success(nil)
+}
+
+func runJob(appName, jobName string) (err error) {
+	j, err := GetJob(appName, jobName)
+	if err != nil {
+		if err == ErrJobNotFound {
+			return nil
+		}
+		return err
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       JobTarget(appName, jobName),
+		InternalKind: jobRunEventKind,
+		CustomData:   j,
+		Cancelable:   true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return j.Run(evt)
+}
+
+type jobScheduler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	jobSchedulerMut      sync.Mutex
+	jobSchedulerInstance *jobScheduler
+)
+
+// StartJobScheduler registers the task used to run jobs in the queue and
+// starts the routine responsible for enqueueing jobs as they become due.
+func StartJobScheduler() error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	err = q.RegisterTask(&runJobTask{})
+	if err != nil {
+		return err
+	}
+	jobSchedulerMut.Lock()
+	defer jobSchedulerMut.Unlock()
+	if jobSchedulerInstance != nil {
+		return nil
+	}
+	jobSchedulerInstance = &jobScheduler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	shutdown.Register(jobSchedulerInstance)
+	go jobSchedulerInstance.run()
+	return nil
+}
+
+// Shutdown stops the job scheduler, already enqueued runs are not affected.
+func (s *jobScheduler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *jobScheduler) String() string {
+	return "job scheduler"
+}
+
+func (s *jobScheduler) run() {
+	defer close(s.done)
+	for {
+		err := s.runOnce(time.Now().UTC())
+		if err != nil {
+			log.Errorf("%s %s", jobSchedulerLogTag, err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(jobSchedulerInterval):
+		}
+	}
+}
+
+// runOnce enqueues the runs of all jobs due at the given time. The next run
+// of each job is updated before enqueueing it, using the previous value as
+// a guard, so concurrent schedulers never enqueue the same run twice.
+func (s *jobScheduler) runOnce(now time.Time) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var jobs []Job
+	err = conn.Jobs().Find(bson.M{"nextrun": bson.M{"$lte": now, "$ne": time.Time{}}}).All(&jobs)
+	if err != nil {
+		return err
+	}
+	var q monsterqueue.Queue
+	for _, j := range jobs {
+		var next time.Time
+		sched, parseErr := cron.Parse(j.Schedule)
+		if parseErr != nil {
+			log.Errorf("%s invalid schedule for job %s/%s: %s", jobSchedulerLogTag, j.App, j.Name, parseErr)
+		} else {
+			next = sched.Next(now)
+		}
+		err = conn.Jobs().Update(
+			bson.M{"app": j.App, "name": j.Name, "nextrun": j.NextRun},
+			bson.M{"$set": bson.M{"nextrun": next}},
+		)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if parseErr != nil {
+			continue
+		}
+		if q == nil {
+			q, err = queue.Queue()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = q.Enqueue(jobRunTaskName, monsterqueue.JobParams{
+			"app": j.App,
+			"job": j.Name,
+		})
+		if err != nil {
+			log.Errorf("%s unable to enqueue job %s/%s: %s", jobSchedulerLogTag, j.App, j.Name, err)
+		}
+	}
+	return nil
+}
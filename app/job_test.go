@@ -0,0 +1,239 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestCreateJob(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "*/5 * * * *", Command: "./cleanup.sh", Timeout: 60}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	c.Assert(job.NextRun.After(time.Now()), check.Equals, true)
+	c.Assert(job.NextRun.Minute()%5, check.Equals, 0)
+	dbJob, err := GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.Command, check.Equals, "./cleanup.sh")
+	c.Assert(dbJob.Schedule, check.Equals, "*/5 * * * *")
+	c.Assert(dbJob.Timeout, check.Equals, 60)
+	c.Assert(dbJob.NextRun.Equal(job.NextRun.Truncate(time.Millisecond)), check.Equals, true)
+}
+
+func (s *S) TestCreateJobValidation(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	var tests = []struct {
+		job Job
+		err string
+	}{
+		{Job{Name: "Invalid_Name", App: "myapp", Schedule: "@daily", Command: "ls"}, "Invalid job name.*"},
+		{Job{Name: "myjob", App: "myapp", Schedule: "@daily"}, "job command is required"},
+		{Job{Name: "myjob", App: "myapp", Schedule: "@daily", Command: "ls", Timeout: -1}, "job timeout must not be negative"},
+		{Job{Name: "myjob", App: "myapp", Schedule: "* * *", Command: "ls"}, "invalid cron expression.*"},
+	}
+	for _, t := range tests {
+		err := CreateJob(&t.job)
+		c.Check(err, check.FitsTypeOf, &errors.ValidationError{})
+		c.Check(err, check.ErrorMatches, t.err)
+	}
+	count, err := s.conn.Jobs().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestCreateJobWithImage(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls", Image: "app-image-old"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	dbJob, err := GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.Image, check.Equals, "app-image-old")
+}
+
+func (s *S) TestCreateJobInvalidImage(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls", Image: "evil/image"}
+	err = CreateJob(&job)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, `image "evil/image" not found in app`)
+	count, err := s.conn.Jobs().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestCreateJobAlreadyExists(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	job = Job{Name: "cleanup", App: "myapp", Schedule: "@hourly", Command: "ls"}
+	err = CreateJob(&job)
+	c.Assert(err, check.Equals, ErrJobAlreadyExists)
+}
+
+func (s *S) TestCreateJobAppNotFound(c *check.C) {
+	job := Job{Name: "cleanup", App: "unknown", Schedule: "@daily", Command: "ls"}
+	err := CreateJob(&job)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestListAndRemoveJobs(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	for _, name := range []string{"job2", "job1"} {
+		job := Job{Name: name, App: "myapp", Schedule: "@daily", Command: "ls"}
+		err := CreateJob(&job)
+		c.Assert(err, check.IsNil)
+	}
+	jobs, err := ListJobs("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 2)
+	c.Assert(jobs[0].Name, check.Equals, "job1")
+	c.Assert(jobs[1].Name, check.Equals, "job2")
+	err = RemoveJob("myapp", "job1")
+	c.Assert(err, check.IsNil)
+	err = RemoveJob("myapp", "job1")
+	c.Assert(err, check.Equals, ErrJobNotFound)
+	jobs, err = ListJobsByApps([]string{"myapp", "other"})
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "job2")
+}
+
+func (s *S) TestDeleteAppRemovesJobs(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "ls"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	_, err = GetJob("myapp", "cleanup")
+	c.Assert(err, check.Equals, ErrJobNotFound)
+}
+
+func (s *S) TestJobRun(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "./cleanup.sh"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareOutput([]byte("cleaned up"))
+	err = runJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Isolated, check.Equals, true)
+	c.Assert(cmds[0].Cmd, check.Equals, sourcedCommand("./cleanup.sh"))
+	dbJob, err := GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.LastRun.IsZero(), check.Equals, false)
+	c.Assert(eventtest.EventDesc{
+		Target:     JobTarget("myapp", "cleanup"),
+		Kind:       jobRunEventKind,
+		LogMatches: `(?s).*Running job "cleanup".*cleaned up.*`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestJobRunEventIsCancelable(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "./cleanup.sh"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareOutput([]byte("cleaned up"))
+	err = runJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	evts, err := event.List(&event.Filter{Target: JobTarget("myapp", "cleanup")})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Cancelable, check.Equals, true)
+}
+
+func (s *S) TestJobRunFailure(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "@daily", Command: "./cleanup.sh"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("ExecuteCommandIsolated", stderr.New("unexpected exit code: 1"))
+	err = runJob("myapp", "cleanup")
+	c.Assert(err, check.ErrorMatches, "unexpected exit code: 1")
+	c.Assert(eventtest.EventDesc{
+		Target:       JobTarget("myapp", "cleanup"),
+		Kind:         jobRunEventKind,
+		ErrorMatches: "unexpected exit code: 1",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRunJobNotFound(c *check.C) {
+	err := runJob("myapp", "unknown")
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target:  event.Target{Type: event.TargetTypeJob, Value: "myapp/unknown"},
+		IsEmpty: true,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestJobTimeout(c *check.C) {
+	job := Job{Timeout: 30}
+	c.Assert(job.timeout(), check.Equals, 30*time.Second)
+	job = Job{}
+	c.Assert(job.timeout(), check.Equals, defaultJobTimeout)
+}
+
+func (s *S) TestJobSchedulerRunOnce(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	job := Job{Name: "cleanup", App: "myapp", Schedule: "0 * * * *", Command: "ls"}
+	err = CreateJob(&job)
+	c.Assert(err, check.IsNil)
+	defer queue.ResetQueue()
+	now := job.NextRun.Add(time.Minute)
+	scheduler := &jobScheduler{}
+	err = scheduler.runOnce(now)
+	c.Assert(err, check.IsNil)
+	dbJob, err := GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.NextRun.Equal(job.NextRun.Add(time.Hour)), check.Equals, true)
+	err = s.conn.Jobs().Update(bson.M{"name": "cleanup"}, bson.M{"$set": bson.M{"schedule": "invalid"}})
+	c.Assert(err, check.IsNil)
+	err = scheduler.runOnce(now)
+	c.Assert(err, check.IsNil)
+	dbJob, err = GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.NextRun.Equal(job.NextRun.Add(time.Hour)), check.Equals, true)
+	err = scheduler.runOnce(dbJob.NextRun)
+	c.Assert(err, check.IsNil)
+	dbJob, err = GetJob("myapp", "cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbJob.NextRun.IsZero(), check.Equals, true)
+}
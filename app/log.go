@@ -7,15 +7,17 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/queue"
 )
 
 var LogPubSubQueuePrefix = "pubsub:"
 var bulkMaxWaitTime = time.Second
+var appSettingsCacheTime = time.Minute
 
 type LogListener struct {
 	c <-chan Applog
@@ -50,7 +52,8 @@ func NewLogListener(a *App, filterLog Applog) (*LogListener, error) {
 				continue
 			}
 			if (filterLog.Source == "" || filterLog.Source == applog.Source) &&
-				(filterLog.Unit == "" || filterLog.Unit == applog.Unit) {
+				(filterLog.Unit == "" || filterLog.Unit == applog.Unit) &&
+				matchLogFields(filterLog.Fields, applog.Fields) {
 				c <- applog
 			}
 		}
@@ -73,6 +76,45 @@ func (l *LogListener) Close() (err error) {
 	return
 }
 
+// parseLogFields parses a log message containing a JSON object, returning
+// its top level fields. Values that are not strings are kept in their JSON
+// representation, and dots and dollar signs in keys are replaced with
+// underscores, so the fields can be stored in MongoDB. It returns nil if the
+// message is not a JSON object.
+func parseLogFields(message string) map[string]string {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		return nil
+	}
+	var data map[string]json.RawMessage
+	err := json.Unmarshal([]byte(message), &data)
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]string, len(data))
+	for key, value := range data {
+		key = logFieldKeyReplacer.Replace(key)
+		var str string
+		if json.Unmarshal(value, &str) == nil {
+			fields[key] = str
+		} else {
+			fields[key] = string(value)
+		}
+	}
+	return fields
+}
+
+var logFieldKeyReplacer = strings.NewReplacer(".", "_", "$", "_")
+
+func matchLogFields(filter, fields map[string]string) bool {
+	for key, value := range filter {
+		if fieldValue, ok := fields[key]; !ok || fieldValue != value {
+			return false
+		}
+	}
+	return true
+}
+
 func notify(appName string, messages []interface{}) {
 	factory, err := queue.Factory()
 	if err != nil {
@@ -121,8 +163,13 @@ func NewlogDispatcher(chanSize, numberGoroutines int) *logDispatcher {
 func (d *logDispatcher) runWriter() {
 	notifyMessages := make([]interface{}, 1)
 	for msgWithDispatcher := range d.msgCh {
+		structuredLogs, pool := msgWithDispatcher.dispatcher.appSettings()
+		if msgWithDispatcher.msg.Fields == nil && structuredLogs {
+			msgWithDispatcher.msg.Fields = parseLogFields(msgWithDispatcher.msg.Message)
+		}
 		notifyMessages[0] = msgWithDispatcher.msg
 		notify(msgWithDispatcher.msg.AppName, notifyMessages)
+		forwardLogs(pool, []Applog{*msgWithDispatcher.msg})
 		select {
 		case msgWithDispatcher.dispatcher.toFlush <- msgWithDispatcher.msg:
 		case <-msgWithDispatcher.dispatcher.done:
@@ -154,6 +201,11 @@ type appLogDispatcher struct {
 	appName string
 	done    chan bool
 	toFlush chan *Applog
+
+	settingsMut       sync.Mutex
+	structuredEnabled bool
+	pool              string
+	settingsExpire    time.Time
 }
 
 func newAppLogDispatcher(appName string) *appLogDispatcher {
@@ -166,11 +218,31 @@ func newAppLogDispatcher(appName string) *appLogDispatcher {
 	return d
 }
 
+// appSettings returns whether structured logs are enabled for the app and
+// the pool of the app, caching them for appSettingsCacheTime.
+func (d *appLogDispatcher) appSettings() (bool, string) {
+	d.settingsMut.Lock()
+	defer d.settingsMut.Unlock()
+	now := time.Now()
+	if now.Before(d.settingsExpire) {
+		return d.structuredEnabled, d.pool
+	}
+	d.settingsExpire = now.Add(appSettingsCacheTime)
+	a, err := GetByName(d.appName)
+	if err != nil {
+		log.Errorf("[log dispatcher] unable to get app %q: %s", d.appName, err)
+		d.structuredEnabled, d.pool = false, ""
+	} else {
+		d.structuredEnabled, d.pool = a.StructuredLogs, a.Pool
+	}
+	return d.structuredEnabled, d.pool
+}
+
 func (d *appLogDispatcher) runFlusher() {
 	t := time.NewTimer(bulkMaxWaitTime)
 	pos := 0
 	sz := 200
-	bulkBuffer := make([]interface{}, sz)
+	bulkBuffer := make([]Applog, sz)
 	for {
 		var flush bool
 		select {
@@ -181,7 +253,7 @@ func (d *appLogDispatcher) runFlusher() {
 				flush = true
 				break
 			}
-			bulkBuffer[pos] = msg
+			bulkBuffer[pos] = *msg
 			pos++
 			flush = sz == pos
 		case <-t.C:
@@ -189,14 +261,12 @@ func (d *appLogDispatcher) runFlusher() {
 			t.Reset(bulkMaxWaitTime)
 		}
 		if flush {
-			conn, err := db.LogConn()
+			logStorage, err := GetLogStorage()
 			if err != nil {
-				log.Errorf("[log flusher] unable to connect to mongodb: %s", err)
+				log.Errorf("[log flusher] unable to get log storage: %s", err)
 				continue
 			}
-			coll := conn.Logs(d.appName)
-			err = coll.Insert(bulkBuffer[:pos]...)
-			coll.Close()
+			err = logStorage.Insert(d.appName, bulkBuffer[:pos])
 			if err != nil {
 				log.Errorf("[log flusher] unable to insert logs: %s", err)
 				continue
@@ -258,3 +258,72 @@ loop:
 	}
 	dispatcher.Stop()
 }
+
+func (s *S) TestLogDispatcherSendStructuredLogs(c *check.C) {
+	app := App{Name: "myapp1", Platform: "zend", TeamOwner: s.team.Name}
+	err := CreateApp(&app, s.user)
+	c.Assert(err, check.IsNil)
+	err = app.SetStructuredLogs(true)
+	c.Assert(err, check.IsNil)
+	dispatcher := NewlogDispatcher(2000000, runtime.NumCPU())
+	logMsg := Applog{
+		Date: time.Now(), Message: `{"level": "error", "msg": "failed"}`, Source: "web", AppName: "myapp1", Unit: "unit1",
+	}
+	dispatcher.Send(&logMsg)
+	timeout := time.After(5 * time.Second)
+loop:
+	for {
+		logs, logsErr := app.LastLogs(1, Applog{})
+		c.Assert(logsErr, check.IsNil)
+		if len(logs) == 1 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for logs")
+			break loop
+		default:
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	dispatcher.Stop()
+	logs, _, err := app.QueryLogs(LogQuery{Fields: map[string]string{"level": "error"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Fields, check.DeepEquals, map[string]string{"level": "error", "msg": "failed"})
+}
+
+func (s *S) TestParseLogFields(c *check.C) {
+	var tests = []struct {
+		message  string
+		expected map[string]string
+	}{
+		{"plain text", nil},
+		{"{invalid json", nil},
+		{"[1, 2]", nil},
+		{`{"level": "info", "msg": "started"}`, map[string]string{"level": "info", "msg": "started"}},
+		{`  {"status": 200, "ok": true, "user": {"id": 1}}`, map[string]string{"status": "200", "ok": "true", "user": `{"id": 1}`}},
+		{`{"http.status": "500", "$where": "x"}`, map[string]string{"http_status": "500", "_where": "x"}},
+	}
+	for i, t := range tests {
+		c.Check(parseLogFields(t.message), check.DeepEquals, t.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestNewLogListenerFilterFields(c *check.C) {
+	app := App{Name: "myapp"}
+	l, err := NewLogListener(&app, Applog{Fields: map[string]string{"level": "error"}})
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	ms := []interface{}{
+		Applog{Message: "info", Fields: map[string]string{"level": "info"}},
+		Applog{Message: "error", Fields: map[string]string{"level": "error"}},
+	}
+	notify(app.Name, ms)
+	select {
+	case msg := <-l.ListenChan():
+		c.Assert(msg.Message, check.Equals, "error")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for log")
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/scopedconfig"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	logForwardConfigCollection   = "log_forward"
+	logForwardFailuresCollection = "log_forward_failures"
+	logForwardEventKind          = "log-forward"
+
+	defaultLogForwardBufferSize    = 10000
+	defaultLogForwardSendBuffer    = 10
+	defaultLogForwardMaxRetries    = 3
+	defaultLogForwardHTTPBatchSize = 100
+
+	// syslog facility user-level messages, severity informational.
+	syslogPriority = 1*8 + 6
+)
+
+var (
+	ErrInvalidSyslogURL = tsuruNet.ErrInvalidSyslogURL
+	ErrInvalidHTTPURL   = errors.New("http url must be in the form http(s)://host/path")
+
+	errLogForwardBufferFull = errors.New("send buffer is full, the oldest log entries were dropped")
+
+	logForwardInterval  = time.Second
+	logForwardCacheTime = time.Minute
+	logForwardBackoff   = 500 * time.Millisecond
+
+	globalLogForwarder     *logForwarder
+	globalLogForwarderOnce sync.Once
+)
+
+// LogForwardConfig holds the sinks to which tsuru forwards the logs of the
+// apps in a pool.
+type LogForwardConfig struct {
+	// SyslogURL is the address of a syslog server, receiving messages in
+	// the RFC 5424 format, e.g. udp://logs.example.com:514.
+	SyslogURL string
+	// HTTPURL is the address of an endpoint receiving batches of logs, as
+	// JSON arrays, through POST requests.
+	HTTPURL string
+	// HTTPBatchSize is the maximum number of entries sent in each request
+	// to the HTTP endpoint.
+	HTTPBatchSize int
+}
+
+// LogForwardFailure holds the number of log entries that couldn't be
+// delivered to a sink.
+type LogForwardFailure struct {
+	Pool        string
+	Sink        string
+	Count       int
+	LastError   string
+	LastFailure time.Time
+}
+
+func loadLogForwardConfig() *scopedconfig.ScopedConfig {
+	conf := scopedconfig.FindScopedConfig(logForwardConfigCollection)
+	conf.ShallowMerge = true
+	return conf
+}
+
+// LogForwardConfigForPool returns the log forwarding config of the given
+// pool, merged with the default config.
+func LogForwardConfigForPool(pool string) (LogForwardConfig, error) {
+	var conf LogForwardConfig
+	err := loadLogForwardConfig().Load(pool, &conf)
+	return conf, err
+}
+
+// LogForwardLoadAll returns the log forwarding config of all pools, indexed
+// by the pool name. The default config is indexed by an empty string.
+func LogForwardLoadAll() (map[string]LogForwardConfig, error) {
+	var all map[string]LogForwardConfig
+	err := loadLogForwardConfig().LoadAll(&all)
+	return all, err
+}
+
+func (c *LogForwardConfig) validate() error {
+	if c.SyslogURL != "" {
+		_, err := tsuruNet.NewSyslogWriter(c.SyslogURL)
+		if err != nil {
+			return err
+		}
+	}
+	if c.HTTPURL != "" {
+		u, err := url.Parse(c.HTTPURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidHTTPURL
+		}
+	}
+	if c.HTTPBatchSize < 0 {
+		return errors.New("http batch size must not be negative")
+	}
+	return nil
+}
+
+// Save validates and stores the config of the given pool. An empty pool
+// name stores the default config.
+func (c *LogForwardConfig) Save(pool string) error {
+	err := c.validate()
+	if err != nil {
+		return err
+	}
+	return loadLogForwardConfig().Save(pool, *c)
+}
+
+func (c *LogForwardConfig) sinks() []logSink {
+	var sinks []logSink
+	if c.SyslogURL != "" {
+		writer, err := tsuruNet.NewSyslogWriter(c.SyslogURL)
+		if err == nil {
+			sinks = append(sinks, &syslogSink{writer: writer})
+		}
+	}
+	if c.HTTPURL != "" {
+		batchSize := c.HTTPBatchSize
+		if batchSize == 0 {
+			batchSize = defaultLogForwardHTTPBatchSize
+		}
+		sinks = append(sinks, &httpSink{url: c.HTTPURL, batchSize: batchSize})
+	}
+	return sinks
+}
+
+// LogForwardFailures returns the delivery failure counters of all sinks.
+func LogForwardFailures() ([]LogForwardFailure, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var failures []LogForwardFailure
+	err = conn.Collection(logForwardFailuresCollection).Find(nil).Sort("pool", "sink").All(&failures)
+	return failures, err
+}
+
+type logSink interface {
+	Name() string
+	Send(logs []Applog) error
+}
+
+type syslogSink struct {
+	writer *tsuruNet.SyslogWriter
+}
+
+func (s *syslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) Send(logs []Applog) error {
+	msgs := make([]tsuruNet.SyslogMessage, len(logs))
+	for i := range logs {
+		msgs[i] = syslogMessage(&logs[i])
+	}
+	return s.writer.Write(msgs)
+}
+
+// syslogMessage returns the log entry as a syslog message, using the unit as
+// hostname, the app name as app-name and the source as procid.
+func syslogMessage(l *Applog) tsuruNet.SyslogMessage {
+	return tsuruNet.SyslogMessage{
+		Priority:  syslogPriority,
+		Timestamp: l.Date,
+		Hostname:  l.Unit,
+		AppName:   l.AppName,
+		ProcID:    l.Source,
+		Message:   l.Message,
+	}
+}
+
+type httpSink struct {
+	url       string
+	batchSize int
+}
+
+func (s *httpSink) Name() string {
+	return "http"
+}
+
+func (s *httpSink) Send(logs []Applog) error {
+	for len(logs) > 0 {
+		n := s.batchSize
+		if n > len(logs) {
+			n = len(logs)
+		}
+		data, err := json.Marshal(logs[:n])
+		if err != nil {
+			return err
+		}
+		rsp, err := tsuruNet.Dial5Full300Client.Post(s.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+			return fmt.Errorf("invalid status code sending logs to %s: %d", s.url, rsp.StatusCode)
+		}
+		logs = logs[n:]
+	}
+	return nil
+}
+
+type forwardEntry struct {
+	pool string
+	log  Applog
+}
+
+type cachedLogForwardConfig struct {
+	sinks  []logSink
+	expire time.Time
+}
+
+// logForwarder sends log entries to the sinks configured for the pool of
+// their apps. Entries are buffered and grouped in batches by the receive
+// loop, which hands them to the sender of each sink of their pool. Every
+// sender has its own goroutine and bounded buffer, so a slow or unreachable
+// sink only delays its own entries and never blocks receiving entries.
+type logForwarder struct {
+	entries        chan forwardEntry
+	pending        map[string][]Applog
+	configs        map[string]cachedLogForwardConfig
+	senders        map[string]*logSender
+	sendBufferSize int
+}
+
+func newLogForwarder(bufferSize, sendBufferSize int) *logForwarder {
+	return &logForwarder{
+		entries:        make(chan forwardEntry, bufferSize),
+		pending:        make(map[string][]Applog),
+		configs:        make(map[string]cachedLogForwardConfig),
+		senders:        make(map[string]*logSender),
+		sendBufferSize: sendBufferSize,
+	}
+}
+
+// forwardLogs enqueues the log entries of an app to be forwarded to the
+// sinks of its pool. Entries are discarded if the queue is full.
+func forwardLogs(pool string, logs []Applog) {
+	globalLogForwarderOnce.Do(func() {
+		bufferSize, _ := config.GetInt("app-log:forward:buffer-size")
+		if bufferSize <= 0 {
+			bufferSize = defaultLogForwardBufferSize
+		}
+		sendBufferSize, _ := config.GetInt("app-log:forward:send-buffer-size")
+		if sendBufferSize <= 0 {
+			sendBufferSize = defaultLogForwardSendBuffer
+		}
+		globalLogForwarder = newLogForwarder(bufferSize, sendBufferSize)
+		go globalLogForwarder.run()
+	})
+	globalLogForwarder.send(pool, logs)
+}
+
+func (f *logForwarder) send(pool string, logs []Applog) {
+	for _, l := range logs {
+		select {
+		case f.entries <- forwardEntry{pool: pool, log: l}:
+		default:
+			log.Errorf("[log forwarder] queue is full, discarding log entry from app %q", l.AppName)
+		}
+	}
+}
+
+func (f *logForwarder) run() {
+	t := time.NewTicker(logForwardInterval)
+	defer t.Stop()
+	for {
+		select {
+		case entry := <-f.entries:
+			f.pending[entry.pool] = append(f.pending[entry.pool], entry.log)
+		case <-t.C:
+			f.queuePending()
+		}
+	}
+}
+
+// queuePending hands the pending entries of each pool to the senders of the
+// sinks of the pool.
+func (f *logForwarder) queuePending() {
+	if len(f.pending) == 0 {
+		return
+	}
+	batch := f.pending
+	f.pending = make(map[string][]Applog)
+	for pool, logs := range batch {
+		sinks, err := f.sinksForPool(pool)
+		if err != nil {
+			log.Errorf("[log forwarder] unable to load config for pool %q: %s", pool, err)
+			continue
+		}
+		for _, sink := range sinks {
+			f.sender(pool, sink.Name()).queue(sinkBatch{sink: sink, logs: logs})
+		}
+	}
+}
+
+// sender returns the sender of the given sink of the pool, starting it if
+// needed.
+func (f *logForwarder) sender(pool, sinkName string) *logSender {
+	key := pool + "/" + sinkName
+	sender, ok := f.senders[key]
+	if !ok {
+		sender = newLogSender(pool, sinkName, f.sendBufferSize)
+		f.senders[key] = sender
+		go sender.run()
+	}
+	return sender
+}
+
+func (f *logForwarder) sinksForPool(pool string) ([]logSink, error) {
+	if cached, ok := f.configs[pool]; ok && time.Now().Before(cached.expire) {
+		return cached.sinks, nil
+	}
+	conf, err := LogForwardConfigForPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	sinks := conf.sinks()
+	f.configs[pool] = cachedLogForwardConfig{sinks: sinks, expire: time.Now().Add(logForwardCacheTime)}
+	return sinks, nil
+}
+
+// sinkBatch holds entries to be sent to a sink. The sink is carried along
+// the entries, as its config may change while the batch is waiting.
+type sinkBatch struct {
+	sink logSink
+	logs []Applog
+}
+
+// logSender delivers the batches of a single sink of a pool. When its
+// buffer is full, the oldest batch is dropped and its entries are reported
+// as failures.
+type logSender struct {
+	pool       string
+	sinkName   string
+	batches    chan sinkBatch
+	droppedMut sync.Mutex
+	dropped    int
+}
+
+func newLogSender(pool, sinkName string, bufferSize int) *logSender {
+	return &logSender{
+		pool:     pool,
+		sinkName: sinkName,
+		batches:  make(chan sinkBatch, bufferSize),
+	}
+}
+
+func (s *logSender) queue(batch sinkBatch) {
+	for {
+		select {
+		case s.batches <- batch:
+			return
+		default:
+		}
+		select {
+		case oldest := <-s.batches:
+			s.addDropped(len(oldest.logs))
+		default:
+		}
+	}
+}
+
+func (s *logSender) addDropped(count int) {
+	log.Errorf("[log forwarder] send buffer of %s sink of pool %q is full, dropping %d log entries", s.sinkName, s.pool, count)
+	s.droppedMut.Lock()
+	defer s.droppedMut.Unlock()
+	s.dropped += count
+}
+
+func (s *logSender) run() {
+	for batch := range s.batches {
+		s.flush(batch)
+		s.reportDropped()
+	}
+}
+
+func (s *logSender) flush(batch sinkBatch) {
+	err := sendWithRetry(batch.sink, batch.logs)
+	if err != nil {
+		log.Errorf("[log forwarder] unable to send logs to %s sink of pool %q: %s", s.sinkName, s.pool, err)
+		recordLogForwardFailure(s.pool, s.sinkName, len(batch.logs), err)
+	}
+}
+
+// reportDropped records the entries dropped since the last report as failures
+// of the sink.
+func (s *logSender) reportDropped() {
+	s.droppedMut.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.droppedMut.Unlock()
+	if dropped > 0 {
+		recordLogForwardFailure(s.pool, s.sinkName, dropped, errLogForwardBufferFull)
+	}
+}
+
+func sendWithRetry(sink logSink, logs []Applog) error {
+	maxRetries, err := config.GetInt("app-log:forward:max-retries")
+	if err != nil {
+		maxRetries = defaultLogForwardMaxRetries
+	}
+	backoff := logForwardBackoff
+	for i := 0; ; i++ {
+		err = sink.Send(logs)
+		if err == nil || i >= maxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+type logForwardEventData struct {
+	Sink     string
+	Entries  int
+	Failures int
+}
+
+// recordLogForwardFailure increments the failure counter of the sink and
+// registers an internal event for the pool, so failures are visible in the
+// event list. Failures of apps without a pool are only counted.
+func recordLogForwardFailure(pool, sink string, entries int, sendErr error) {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[log forwarder] unable to record failure: %s", err)
+		return
+	}
+	defer conn.Close()
+	var failure LogForwardFailure
+	_, err = conn.Collection(logForwardFailuresCollection).Find(bson.M{"pool": pool, "sink": sink}).Apply(mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{"count": entries},
+			"$set": bson.M{"lasterror": sendErr.Error(), "lastfailure": time.Now().UTC()},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &failure)
+	if err != nil {
+		log.Errorf("[log forwarder] unable to record failure: %s", err)
+		return
+	}
+	if pool == "" {
+		return
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypePool, Value: pool},
+		InternalKind: logForwardEventKind,
+		CustomData: logForwardEventData{
+			Sink:     sink,
+			Entries:  entries,
+			Failures: failure.Count,
+		},
+		DisableLock: true,
+	})
+	if err != nil {
+		log.Errorf("[log forwarder] unable to create event: %s", err)
+		return
+	}
+	err = evt.Done(sendErr)
+	if err != nil {
+		log.Errorf("[log forwarder] unable to finish event: %s", err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"gopkg.in/check.v1"
+)
+
+type fakeLogSink struct {
+	sync.Mutex
+	logs     []Applog
+	failures int
+	calls    int
+}
+
+func (s *fakeLogSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeLogSink) Send(logs []Applog) error {
+	s.Lock()
+	defer s.Unlock()
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func (s *S) TestLogForwardConfigSaveAndLoad(c *check.C) {
+	base := LogForwardConfig{HTTPURL: "http://logs.example.com/bulk", HTTPBatchSize: 10}
+	err := base.Save("")
+	c.Assert(err, check.IsNil)
+	poolConf := LogForwardConfig{SyslogURL: "udp://syslog.example.com:514"}
+	err = poolConf.Save("pool1")
+	c.Assert(err, check.IsNil)
+	conf, err := LogForwardConfigForPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(conf, check.DeepEquals, LogForwardConfig{
+		SyslogURL:     "udp://syslog.example.com:514",
+		HTTPURL:       "http://logs.example.com/bulk",
+		HTTPBatchSize: 10,
+	})
+	all, err := LogForwardLoadAll()
+	c.Assert(err, check.IsNil)
+	c.Assert(all, check.HasLen, 2)
+	c.Assert(all[""], check.DeepEquals, base)
+}
+
+func (s *S) TestLogForwardConfigValidate(c *check.C) {
+	var tests = []struct {
+		conf LogForwardConfig
+		err  error
+	}{
+		{LogForwardConfig{}, nil},
+		{LogForwardConfig{SyslogURL: "tcp://syslog:514", HTTPURL: "https://logs/bulk"}, nil},
+		{LogForwardConfig{SyslogURL: "syslog:514"}, ErrInvalidSyslogURL},
+		{LogForwardConfig{SyslogURL: "http://syslog:514"}, ErrInvalidSyslogURL},
+		{LogForwardConfig{HTTPURL: "logs/bulk"}, ErrInvalidHTTPURL},
+		{LogForwardConfig{HTTPURL: "ftp://logs/bulk"}, ErrInvalidHTTPURL},
+	}
+	for i, t := range tests {
+		c.Check(t.conf.validate(), check.Equals, t.err, check.Commentf("test %d", i))
+	}
+	conf := LogForwardConfig{HTTPBatchSize: -1}
+	c.Assert(conf.validate(), check.ErrorMatches, "http batch size must not be negative")
+}
+
+func (s *S) TestLogForwardConfigSinks(c *check.C) {
+	writer, err := tsuruNet.NewSyslogWriter("tcp://syslog:514")
+	c.Assert(err, check.IsNil)
+	conf := LogForwardConfig{SyslogURL: "tcp://syslog:514", HTTPURL: "https://logs/bulk"}
+	c.Assert(conf.sinks(), check.DeepEquals, []logSink{
+		&syslogSink{writer: writer},
+		&httpSink{url: "https://logs/bulk", batchSize: defaultLogForwardHTTPBatchSize},
+	})
+	conf = LogForwardConfig{}
+	c.Assert(conf.sinks(), check.HasLen, 0)
+}
+
+func (s *S) TestSyslogMessage(c *check.C) {
+	date := time.Date(2016, 10, 1, 12, 30, 0, 0, time.UTC)
+	l := Applog{Date: date, Message: "hello world", Source: "web", AppName: "myapp", Unit: "unit 1"}
+	msg := syslogMessage(&l)
+	c.Assert(msg.String(), check.Equals, "<14>1 2016-10-01T12:30:00Z unit1 myapp web - - hello world")
+	l = Applog{Date: date, Message: "hello", AppName: "myapp"}
+	msg = syslogMessage(&l)
+	c.Assert(msg.String(), check.Equals, "<14>1 2016-10-01T12:30:00Z - myapp - - - hello")
+}
+
+func (s *S) TestSyslogSinkUDP(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	writer, err := tsuruNet.NewSyslogWriter("udp://" + conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	sink := &syslogSink{writer: writer}
+	err = sink.Send([]Applog{{Message: "msg1", AppName: "myapp"}})
+	c.Assert(err, check.IsNil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buf[:n]), check.Matches, `<14>1 \S+ - myapp - - - msg1`)
+}
+
+func (s *S) TestSyslogSinkTCP(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- data
+	}()
+	writer, err := tsuruNet.NewSyslogWriter("tcp://" + listener.Addr().String())
+	c.Assert(err, check.IsNil)
+	sink := &syslogSink{writer: writer}
+	err = sink.Send([]Applog{{Message: "msg1\n", AppName: "myapp"}})
+	c.Assert(err, check.IsNil)
+	select {
+	case data := <-received:
+		c.Assert(data, check.Matches, `\d+ <14>1 \S+ - myapp - - - msg1\n`)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for syslog message")
+	}
+}
+
+func (s *S) TestHTTPSinkBatches(c *check.C) {
+	var batches [][]Applog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		var logs []Applog
+		err := json.NewDecoder(r.Body).Decode(&logs)
+		c.Check(err, check.IsNil)
+		batches = append(batches, logs)
+	}))
+	defer server.Close()
+	sink := &httpSink{url: server.URL, batchSize: 2}
+	err := sink.Send([]Applog{{Message: "1"}, {Message: "2"}, {Message: "3"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(batches, check.HasLen, 2)
+	c.Assert(batches[0], check.HasLen, 2)
+	c.Assert(batches[1], check.HasLen, 1)
+	c.Assert(batches[1][0].Message, check.Equals, "3")
+}
+
+func (s *S) TestHTTPSinkInvalidStatus(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sink := &httpSink{url: server.URL, batchSize: 2}
+	err := sink.Send([]Applog{{Message: "1"}})
+	c.Assert(err, check.ErrorMatches, "invalid status code sending logs to .*: 503")
+}
+
+func (s *S) TestSendWithRetry(c *check.C) {
+	oldBackoff := logForwardBackoff
+	logForwardBackoff = time.Millisecond
+	defer func() { logForwardBackoff = oldBackoff }()
+	sink := &fakeLogSink{failures: 2}
+	err := sendWithRetry(sink, []Applog{{Message: "1"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(sink.calls, check.Equals, 3)
+	c.Assert(sink.logs, check.HasLen, 1)
+	config.Set("app-log:forward:max-retries", 1)
+	defer config.Unset("app-log:forward:max-retries")
+	sink = &fakeLogSink{failures: 2}
+	err = sendWithRetry(sink, []Applog{{Message: "1"}})
+	c.Assert(err, check.ErrorMatches, "sink unavailable")
+	c.Assert(sink.calls, check.Equals, 2)
+}
+
+func (s *S) TestLogForwarderQueuePending(c *check.C) {
+	sink1 := &fakeLogSink{}
+	sink2 := &fakeLogSink{}
+	f := newLogForwarder(10, 10)
+	expire := time.Now().Add(time.Minute)
+	f.configs["pool1"] = cachedLogForwardConfig{sinks: []logSink{sink1}, expire: expire}
+	f.configs["pool2"] = cachedLogForwardConfig{sinks: []logSink{sink2}, expire: expire}
+	f.send("pool1", []Applog{{Message: "1"}, {Message: "2"}})
+	f.send("pool2", []Applog{{Message: "3"}})
+	for len(f.entries) > 0 {
+		entry := <-f.entries
+		f.pending[entry.pool] = append(f.pending[entry.pool], entry.log)
+	}
+	f.queuePending()
+	c.Assert(f.pending, check.HasLen, 0)
+	c.Assert(f.senders, check.HasLen, 2)
+	timeout := time.After(5 * time.Second)
+	for {
+		sink1.Lock()
+		sink2.Lock()
+		done := len(sink1.logs) == 2 && len(sink2.logs) == 1
+		sink2.Unlock()
+		sink1.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for logs to be sent")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Assert(sink1.logs, check.DeepEquals, []Applog{{Message: "1"}, {Message: "2"}})
+	c.Assert(sink2.logs, check.DeepEquals, []Applog{{Message: "3"}})
+}
+
+func (s *S) TestLogForwarderSendQueueFull(c *check.C) {
+	f := newLogForwarder(1, 1)
+	f.send("pool1", []Applog{{Message: "1"}, {Message: "2"}})
+	c.Assert(f.entries, check.HasLen, 1)
+}
+
+func (s *S) TestLogSenderFlushRecordsFailures(c *check.C) {
+	oldBackoff := logForwardBackoff
+	logForwardBackoff = time.Millisecond
+	defer func() { logForwardBackoff = oldBackoff }()
+	sink := &fakeLogSink{failures: 10}
+	sender := newLogSender("pool1", sink.Name(), 10)
+	sender.flush(sinkBatch{sink: sink, logs: []Applog{{Message: "1"}, {Message: "2"}}})
+	sender.flush(sinkBatch{sink: sink, logs: []Applog{{Message: "3"}}})
+	failures, err := LogForwardFailures()
+	c.Assert(err, check.IsNil)
+	c.Assert(failures, check.HasLen, 1)
+	c.Assert(failures[0].Pool, check.Equals, "pool1")
+	c.Assert(failures[0].Sink, check.Equals, "fake")
+	c.Assert(failures[0].Count, check.Equals, 3)
+	c.Assert(failures[0].LastError, check.Equals, "sink unavailable")
+	c.Assert(eventtest.EventDesc{
+		Target:       event.Target{Type: event.TargetTypePool, Value: "pool1"},
+		Kind:         logForwardEventKind,
+		ErrorMatches: "sink unavailable",
+		StartCustomData: map[string]interface{}{
+			"sink":     "fake",
+			"entries":  1,
+			"failures": 3,
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestLogSenderQueueDropsOldest(c *check.C) {
+	sink := &fakeLogSink{}
+	sender := newLogSender("pool1", sink.Name(), 1)
+	sender.queue(sinkBatch{sink: sink, logs: []Applog{{Message: "1"}, {Message: "2"}}})
+	sender.queue(sinkBatch{sink: sink, logs: []Applog{{Message: "3"}}})
+	c.Assert(sender.batches, check.HasLen, 1)
+	c.Assert(sender.dropped, check.Equals, 2)
+	sender.flush(<-sender.batches)
+	c.Assert(sink.logs, check.DeepEquals, []Applog{{Message: "3"}})
+	sender.reportDropped()
+	c.Assert(sender.dropped, check.Equals, 0)
+	failures, err := LogForwardFailures()
+	c.Assert(err, check.IsNil)
+	c.Assert(failures, check.HasLen, 1)
+	c.Assert(failures[0].Pool, check.Equals, "pool1")
+	c.Assert(failures[0].Sink, check.Equals, "fake")
+	c.Assert(failures[0].Count, check.Equals, 2)
+	c.Assert(failures[0].LastError, check.Equals, errLogForwardBufferFull.Error())
+}
+
+func (s *S) TestLogForwarderSlowSinkDoesNotBlockOtherPools(c *check.C) {
+	blocked := make(chan struct{})
+	defer close(blocked)
+	slow := &blockingLogSink{unblock: blocked}
+	fast := &fakeLogSink{}
+	f := newLogForwarder(10, 10)
+	expire := time.Now().Add(time.Minute)
+	f.configs["pool1"] = cachedLogForwardConfig{sinks: []logSink{slow}, expire: expire}
+	f.configs["pool2"] = cachedLogForwardConfig{sinks: []logSink{fast}, expire: expire}
+	f.pending["pool1"] = []Applog{{Message: "1"}}
+	f.pending["pool2"] = []Applog{{Message: "2"}}
+	f.queuePending()
+	timeout := time.After(5 * time.Second)
+	for {
+		fast.Lock()
+		done := len(fast.logs) == 1
+		fast.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for logs to be sent")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type blockingLogSink struct {
+	unblock chan struct{}
+}
+
+func (s *blockingLogSink) Name() string {
+	return "blocking"
+}
+
+func (s *blockingLogSink) Send(logs []Applog) error {
+	<-s.unblock
+	return nil
+}
+
+func (s *S) TestLogForwarderLoadsPoolConfig(c *check.C) {
+	conf := LogForwardConfig{SyslogURL: "udp://syslog.example.com:514"}
+	err := conf.Save("pool1")
+	c.Assert(err, check.IsNil)
+	f := newLogForwarder(10, 10)
+	sinks, err := f.sinksForPool("pool1")
+	c.Assert(err, check.IsNil)
+	writer, err := tsuruNet.NewSyslogWriter("udp://syslog.example.com:514")
+	c.Assert(err, check.IsNil)
+	c.Assert(sinks, check.DeepEquals, []logSink{&syslogSink{writer: writer}})
+	sinks, err = f.sinksForPool("pool2")
+	c.Assert(err, check.IsNil)
+	c.Assert(sinks, check.HasLen, 0)
+}
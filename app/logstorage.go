@@ -0,0 +1,291 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultLogStorage = "mongodb"
+
+var (
+	ErrInvalidLogCursor = errors.New("invalid log cursor")
+	ErrLogQueryTimeout  = errors.New("log query took too long, try a narrower time range")
+
+	// logRegexQueryMaxTime limits the time the mongodb storage spends
+	// scanning logs to match regular expressions.
+	logRegexQueryMaxTime = 10 * time.Second
+)
+
+// LogQuery holds the filters used to query application logs.
+type LogQuery struct {
+	// Lines is the maximum number of entries returned, zero means no limit.
+	Lines  int
+	Source string
+	Unit   string
+	// Since and Until limit the date of the entries, when set. Both limits
+	// are inclusive.
+	Since time.Time
+	Until time.Time
+	// Message filters entries whose message contains the given substring.
+	Message string
+	// Regex filters entries whose message matches the given regular
+	// expression, in RE2 syntax, regardless of the storage.
+	Regex string
+	// Fields filters entries with the given structured fields.
+	Fields map[string]string
+	// Cursor is the value returned by a previous query, used to fetch the
+	// page of entries older than the ones previously returned.
+	Cursor string
+
+	regex *regexp.Regexp
+}
+
+// Validate checks that the query is valid, compiling its regular expression.
+func (q *LogQuery) Validate() error {
+	if q.Lines < 0 {
+		return errors.New("the number of lines must not be negative")
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return errors.New("until must not be before since")
+	}
+	if q.Message != "" && q.Regex != "" {
+		return errors.New("message and regex filters are mutually exclusive")
+	}
+	for key := range q.Fields {
+		if key == "" || strings.ContainsAny(key, ".$") {
+			return fmt.Errorf("invalid field name: %q", key)
+		}
+	}
+	if q.Regex != "" {
+		regex, err := regexp.Compile(q.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %s", err)
+		}
+		q.regex = regex
+	}
+	return nil
+}
+
+// Match returns whether the given log entry matches the query filters.
+func (q *LogQuery) Match(l *Applog) bool {
+	if q.Source != "" && l.Source != q.Source {
+		return false
+	}
+	if q.Unit != "" && l.Unit != q.Unit {
+		return false
+	}
+	if !q.Since.IsZero() && l.Date.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && l.Date.After(q.Until) {
+		return false
+	}
+	if q.Message != "" && !strings.Contains(l.Message, q.Message) {
+		return false
+	}
+	if !matchLogFields(q.Fields, l.Fields) {
+		return false
+	}
+	if q.Regex != "" {
+		if q.regex == nil {
+			regex, err := regexp.Compile(q.Regex)
+			if err != nil {
+				return false
+			}
+			q.regex = regex
+		}
+		return q.regex.MatchString(l.Message)
+	}
+	return true
+}
+
+// LogStorage is the interface that must be implemented by backends used to
+// store and retrieve application logs.
+type LogStorage interface {
+	// Insert stores the log entries of an application.
+	Insert(appName string, logs []Applog) error
+
+	// List returns the last log entries of an application matching the
+	// given query, in chronological order. When there may be older entries
+	// matching the query, it also returns a cursor that can be used in a
+	// new query to fetch them.
+	List(appName string, query LogQuery) ([]Applog, string, error)
+
+	// Remove removes all log entries of an application.
+	Remove(appName string) error
+}
+
+// LogStorageFactory creates a new instance of a LogStorage.
+type LogStorageFactory func() (LogStorage, error)
+
+var (
+	logStorageFactories = make(map[string]LogStorageFactory)
+	logStorages         = make(map[string]LogStorage)
+	logStoragesMut      sync.Mutex
+)
+
+// RegisterLogStorage registers a new log storage, which can be selected with
+// the app-log:storage config.
+func RegisterLogStorage(name string, factory LogStorageFactory) {
+	logStoragesMut.Lock()
+	defer logStoragesMut.Unlock()
+	logStorageFactories[name] = factory
+	delete(logStorages, name)
+}
+
+// GetLogStorage returns the log storage configured with the app-log:storage
+// config, defaulting to the mongodb storage.
+func GetLogStorage() (LogStorage, error) {
+	name, err := config.GetString("app-log:storage")
+	if err != nil || name == "" {
+		name = defaultLogStorage
+	}
+	logStoragesMut.Lock()
+	defer logStoragesMut.Unlock()
+	if storage, ok := logStorages[name]; ok {
+		return storage, nil
+	}
+	factory, ok := logStorageFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log storage: %q", name)
+	}
+	storage, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	logStorages[name] = storage
+	return storage, nil
+}
+
+func init() {
+	RegisterLogStorage(defaultLogStorage, func() (LogStorage, error) {
+		return &mongoLogStorage{}, nil
+	})
+}
+
+// mongoLogStorage stores the logs of each application in a capped
+// collection in the database configured by database:logdb-url.
+type mongoLogStorage struct{}
+
+func (s *mongoLogStorage) Insert(appName string, logs []Applog) error {
+	conn, err := db.LogConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	docs := make([]interface{}, len(logs))
+	for i := range logs {
+		docs[i] = logs[i]
+	}
+	return conn.Logs(appName).Insert(docs...)
+}
+
+type mongoApplog struct {
+	ID     bson.ObjectId `bson:"_id"`
+	Applog `bson:",inline"`
+}
+
+func (s *mongoLogStorage) List(appName string, query LogQuery) ([]Applog, string, error) {
+	q := bson.M{}
+	if query.Source != "" {
+		q["source"] = query.Source
+	}
+	if query.Unit != "" {
+		q["unit"] = query.Unit
+	}
+	if !query.Since.IsZero() || !query.Until.IsZero() {
+		dateQuery := bson.M{}
+		if !query.Since.IsZero() {
+			dateQuery["$gte"] = query.Since
+		}
+		if !query.Until.IsZero() {
+			dateQuery["$lte"] = query.Until
+		}
+		q["date"] = dateQuery
+	}
+	if query.Message != "" {
+		q["message"] = bson.RegEx{Pattern: regexp.QuoteMeta(query.Message)}
+	}
+	for key, value := range query.Fields {
+		q["fields."+key] = value
+	}
+	if query.Cursor != "" {
+		if !bson.IsObjectIdHex(query.Cursor) {
+			return nil, "", ErrInvalidLogCursor
+		}
+		q["_id"] = bson.M{"$lt": bson.ObjectIdHex(query.Cursor)}
+	}
+	conn, err := db.LogConn()
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	var entries []mongoApplog
+	// Entries are sorted by id, not by insertion order, so the cursor matches
+	// the sort even when several tsurud instances write to the collection.
+	mq := conn.Logs(appName).Find(q).Sort("-_id")
+	if query.Regex != "" {
+		entries, err = matchLogRegex(mq, &query)
+	} else {
+		err = mq.Limit(query.Lines).All(&entries)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	l := len(entries)
+	logs := make([]Applog, l)
+	for i := range entries {
+		logs[l-1-i] = entries[i].Applog
+	}
+	var next string
+	if query.Lines > 0 && l == query.Lines {
+		next = entries[l-1].ID.Hex()
+	}
+	return logs, next, nil
+}
+
+// matchLogRegex evaluates the regular expression of the query in Go, as
+// mongodb uses PCRE, whose semantics differ from the ones used by other
+// storages and which is subject to catastrophic backtracking. The scan is
+// limited by logRegexQueryMaxTime.
+func matchLogRegex(mq *mgo.Query, query *LogQuery) ([]mongoApplog, error) {
+	var entries []mongoApplog
+	iter := mq.SetMaxTime(logRegexQueryMaxTime).Iter()
+	var entry mongoApplog
+	for iter.Next(&entry) {
+		if query.Match(&entry.Applog) {
+			entries = append(entries, entry)
+			if query.Lines > 0 && len(entries) == query.Lines {
+				break
+			}
+		}
+		entry = mongoApplog{}
+	}
+	err := iter.Close()
+	if qErr, ok := err.(*mgo.QueryError); ok && qErr.Code == 50 {
+		return nil, ErrLogQueryTimeout
+	}
+	return entries, err
+}
+
+func (s *mongoLogStorage) Remove(appName string) error {
+	conn, err := db.LogConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Logs(appName).DropCollection()
+}
@@ -0,0 +1,436 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package file implements a log storage that keeps application logs in
+// segment files in the local filesystem.
+//
+// Each application has its own directory, containing a list of segment files
+// with one JSON encoded log entry per line. New entries are always appended
+// to the newest segment, and a new segment is created once the newest one
+// reaches the configured segment size. Old segments are removed when the
+// total size of the application logs exceeds the configured limit, or when
+// they are older than the configured maximum age.
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+)
+
+const (
+	storageName = "file"
+
+	segmentExt = ".log"
+
+	defaultPath        = "/var/lib/tsuru/logs"
+	defaultSegmentSize = 10 << 20
+	defaultMaxSize     = 100 << 20
+	defaultMaxAge      = 7 * 24 * time.Hour
+)
+
+func init() {
+	app.RegisterLogStorage(storageName, createStorage)
+}
+
+type fileStorage struct {
+	path        string
+	segmentSize int64
+	maxSize     int64
+	maxAge      time.Duration
+	mut         sync.Mutex
+	apps        map[string]*appState
+}
+
+// appState holds the segments of an app, loaded from its directory on the
+// first insert and kept up to date by the following ones, so that inserting
+// doesn't require listing the directory. Writes of the logs of the app are
+// serialized by its mutex, reads only hold it while listing the segments.
+type appState struct {
+	mut      sync.Mutex
+	loaded   bool
+	segments []segment
+	size     int64
+}
+
+func createStorage() (app.LogStorage, error) {
+	path, err := config.GetString("app-log:file:path")
+	if err != nil {
+		path = defaultPath
+	}
+	s := &fileStorage{
+		path:        path,
+		segmentSize: defaultSegmentSize,
+		maxSize:     defaultMaxSize,
+		maxAge:      defaultMaxAge,
+		apps:        make(map[string]*appState),
+	}
+	if segmentSize, err := config.GetInt("app-log:file:segment-size"); err == nil {
+		s.segmentSize = int64(segmentSize)
+	}
+	if maxSize, err := config.GetInt("app-log:file:max-size"); err == nil {
+		s.maxSize = int64(maxSize)
+	}
+	if maxAge, err := config.GetInt("app-log:file:max-age"); err == nil {
+		s.maxAge = time.Duration(maxAge) * time.Second
+	}
+	if s.segmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size for log storage: %d", s.segmentSize)
+	}
+	err = os.MkdirAll(s.path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type segment struct {
+	seq     int64
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (s *fileStorage) appDir(appName string) string {
+	return filepath.Join(s.path, appName)
+}
+
+// lockApp locks the state of the logs of the given app, returning it.
+func (s *fileStorage) lockApp(appName string) *appState {
+	s.mut.Lock()
+	state, ok := s.apps[appName]
+	if !ok {
+		state = &appState{}
+		s.apps[appName] = state
+	}
+	s.mut.Unlock()
+	state.mut.Lock()
+	return state
+}
+
+// load reads the segments of the app from its directory, unless they were
+// already loaded. A newest segment not ending with a newline, left by a
+// write interrupted in the middle of an entry, gets one, so that the next
+// entries aren't appended to the broken line.
+func (s *fileStorage) load(appName string, state *appState) error {
+	if state.loaded {
+		return nil
+	}
+	segments, err := s.segments(appName)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, seg := range segments {
+		size += seg.size
+	}
+	if len(segments) > 0 {
+		last := &segments[len(segments)-1]
+		fixed, err := terminateLastLine(last.path)
+		if err != nil {
+			return err
+		}
+		if fixed {
+			last.size++
+			size++
+		}
+	}
+	state.segments = segments
+	state.size = size
+	state.loaded = true
+	return nil
+}
+
+func terminateLastLine(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	if err != nil || last[0] == '\n' {
+		return false, err
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err == nil, err
+}
+
+// segments returns the list of segments of the given app, ordered from the
+// oldest to the newest.
+func (s *fileStorage) segments(appName string) ([]segment, error) {
+	infos, err := ioutil.ReadDir(s.appDir(appName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segments []segment
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		var seq int64
+		_, err = fmt.Sscanf(strings.TrimSuffix(name, segmentExt), "%d", &seq)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{
+			seq:     seq,
+			path:    filepath.Join(s.appDir(appName), name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Sort(segmentList(segments))
+	return segments, nil
+}
+
+func (s *fileStorage) segmentPath(appName string, seq int64) string {
+	return filepath.Join(s.appDir(appName), fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func (s *fileStorage) expired(t time.Time) bool {
+	return s.maxAge > 0 && time.Since(t) > s.maxAge
+}
+
+func (s *fileStorage) Insert(appName string, logs []app.Applog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	state := s.lockApp(appName)
+	defer state.mut.Unlock()
+	err := os.MkdirAll(s.appDir(appName), 0755)
+	if err != nil {
+		return err
+	}
+	err = s.load(appName, state)
+	if err != nil {
+		return err
+	}
+	if n := len(state.segments); n == 0 || state.segments[n-1].size >= s.segmentSize {
+		var seq int64
+		if n > 0 {
+			seq = state.segments[n-1].seq + 1
+		}
+		state.segments = append(state.segments, segment{seq: seq, path: s.segmentPath(appName, seq)})
+	}
+	current := &state.segments[len(state.segments)-1]
+	f, err := os.OpenFile(current.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := &countingWriter{w: f}
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	for _, l := range logs {
+		err = encoder.Encode(l)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	current.size += w.n
+	current.modTime = time.Now()
+	state.size += w.n
+	if err != nil {
+		f.Close()
+		state.loaded = false
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return s.enforceRetention(state)
+}
+
+// enforceRetention removes the oldest segments of the app while they're
+// expired or while the logs of the app exceed the maximum size. The newest
+// segment is never removed.
+func (s *fileStorage) enforceRetention(state *appState) error {
+	for len(state.segments) > 1 {
+		seg := state.segments[0]
+		if !s.expired(seg.modTime) && (s.maxSize <= 0 || state.size <= s.maxSize) {
+			break
+		}
+		err := os.Remove(seg.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		state.segments = state.segments[1:]
+		state.size -= seg.size
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to the underlying writer, which
+// keeps the size of the current segment up to date.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// position identifies a log entry by its segment and its line in the
+// segment. It's used as the cursor of queries.
+type position struct {
+	seq  int64
+	line int
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("%d:%d", p.seq, p.line)
+}
+
+func (p position) before(o position) bool {
+	return p.seq < o.seq || (p.seq == o.seq && p.line < o.line)
+}
+
+func parsePosition(cursor string) (position, error) {
+	var p position
+	_, err := fmt.Sscanf(cursor, "%d:%d", &p.seq, &p.line)
+	if err != nil || p.seq < 0 || p.line < 0 {
+		return p, app.ErrInvalidLogCursor
+	}
+	return p, nil
+}
+
+type positionedLog struct {
+	pos position
+	log app.Applog
+}
+
+func (s *fileStorage) List(appName string, query app.LogQuery) ([]app.Applog, string, error) {
+	var cursor *position
+	if query.Cursor != "" {
+		pos, err := parsePosition(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cursor = &pos
+	}
+	// The segments are read without holding the lock of the app, so reading
+	// large segments doesn't block inserts. Segments are only appended to, and
+	// the ones removed by retention in the meantime are skipped.
+	state := s.lockApp(appName)
+	segments, err := s.segments(appName)
+	state.mut.Unlock()
+	if err != nil {
+		return nil, "", err
+	}
+	var entries []positionedLog
+	for i := len(segments) - 1; i >= 0; i-- {
+		if query.Lines > 0 && len(entries) >= query.Lines {
+			break
+		}
+		seg := segments[i]
+		if s.expired(seg.modTime) || (!query.Since.IsZero() && seg.modTime.Before(query.Since)) {
+			break
+		}
+		if cursor != nil && seg.seq > cursor.seq {
+			continue
+		}
+		segmentEntries, err := s.readSegment(seg, &query, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(segmentEntries, entries...)
+	}
+	if query.Lines > 0 && len(entries) > query.Lines {
+		entries = entries[len(entries)-query.Lines:]
+	}
+	logs := make([]app.Applog, len(entries))
+	for i := range entries {
+		logs[i] = entries[i].log
+	}
+	var next string
+	if query.Lines > 0 && len(entries) == query.Lines {
+		next = entries[0].pos.String()
+	}
+	return logs, next, nil
+}
+
+func (s *fileStorage) readSegment(seg segment, query *app.LogQuery, cursor *position) ([]positionedLog, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var entries []positionedLog
+	reader := bufio.NewReader(f)
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) == 0 {
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("unable to read log segment %q: %s", seg.path, err)
+			}
+			break
+		}
+		if err == io.EOF {
+			// The last line isn't terminated by a newline, it's an entry
+			// still being written by a concurrent insert.
+			break
+		}
+		pos := position{seq: seg.seq, line: line}
+		if cursor != nil && !pos.before(*cursor) {
+			break
+		}
+		var l app.Applog
+		if decodeErr := json.Unmarshal(data, &l); decodeErr != nil {
+			log.Errorf("[log storage] skipping invalid entry at line %d of log segment %q: %s", line, seg.path, decodeErr)
+			continue
+		}
+		if !s.expired(l.Date) && query.Match(&l) {
+			entries = append(entries, positionedLog{pos: pos, log: l})
+		}
+		if err != nil {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (s *fileStorage) Remove(appName string) error {
+	state := s.lockApp(appName)
+	defer state.mut.Unlock()
+	state.loaded = false
+	state.segments = nil
+	state.size = 0
+	return os.RemoveAll(s.appDir(appName))
+}
+
+type segmentList []segment
+
+func (l segmentList) Len() int           { return len(l) }
+func (l segmentList) Less(i, j int) bool { return l[i].seq < l[j].seq }
+func (l segmentList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
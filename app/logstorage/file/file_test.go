@@ -0,0 +1,343 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	dir string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "tsuru-logs")
+	c.Assert(err, check.IsNil)
+	config.Set("app-log:file:path", s.dir)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	os.RemoveAll(s.dir)
+	config.Unset("app-log:file:path")
+	config.Unset("app-log:file:segment-size")
+	config.Unset("app-log:file:max-size")
+	config.Unset("app-log:file:max-age")
+}
+
+func (s *S) newStorage(c *check.C) *fileStorage {
+	storage, err := createStorage()
+	c.Assert(err, check.IsNil)
+	return storage.(*fileStorage)
+}
+
+func newLogs(n int, source string) []app.Applog {
+	logs := make([]app.Applog, n)
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := range logs {
+		logs[i] = app.Applog{
+			Date:    now,
+			Message: string(rune('a' + i%26)),
+			Source:  source,
+			AppName: "myapp",
+			Unit:    "unit1",
+		}
+	}
+	return logs
+}
+
+func (s *S) TestCreateStorageDefaults(c *check.C) {
+	storage := s.newStorage(c)
+	c.Assert(storage.path, check.Equals, s.dir)
+	c.Assert(storage.segmentSize, check.Equals, int64(defaultSegmentSize))
+	c.Assert(storage.maxSize, check.Equals, int64(defaultMaxSize))
+	c.Assert(storage.maxAge, check.Equals, defaultMaxAge)
+}
+
+func (s *S) TestCreateStorageInvalidSegmentSize(c *check.C) {
+	config.Set("app-log:file:segment-size", 0)
+	_, err := createStorage()
+	c.Assert(err, check.ErrorMatches, "invalid segment size for log storage: 0")
+}
+
+func (s *S) TestStorageIsRegistered(c *check.C) {
+	config.Set("app-log:storage", storageName)
+	defer config.Unset("app-log:storage")
+	storage, err := app.GetLogStorage()
+	c.Assert(err, check.IsNil)
+	c.Assert(storage, check.FitsTypeOf, &fileStorage{})
+}
+
+func (s *S) TestInsertAndList(c *check.C) {
+	storage := s.newStorage(c)
+	logs := newLogs(3, "app")
+	err := storage.Insert("myapp", logs)
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 3)
+	for i := range logs {
+		c.Assert(result[i].Message, check.Equals, logs[i].Message)
+		c.Assert(result[i].Date.Equal(logs[i].Date), check.Equals, true)
+	}
+}
+
+func (s *S) TestListLastLines(c *check.C) {
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(5, "app"))
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	c.Assert(result[0].Message, check.Equals, "d")
+	c.Assert(result[1].Message, check.Equals, "e")
+}
+
+func (s *S) TestListFilter(c *check.C) {
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(2, "app"))
+	c.Assert(err, check.IsNil)
+	err = storage.Insert("myapp", newLogs(3, "tsuru"))
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 10, Source: "app"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	result, _, err = storage.List("myapp", app.LogQuery{Lines: 10, Source: "tsuru", Unit: "unit2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestListUnknownApp(c *check.C) {
+	storage := s.newStorage(c)
+	result, _, err := storage.List("unknown", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestInsertRotatesSegments(c *check.C) {
+	config.Set("app-log:file:segment-size", 1)
+	storage := s.newStorage(c)
+	for i := 0; i < 3; i++ {
+		err := storage.Insert("myapp", newLogs(1, "app"))
+		c.Assert(err, check.IsNil)
+	}
+	segments, err := storage.segments("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(segments, check.HasLen, 3)
+	result, _, err := storage.List("myapp", app.LogQuery{})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 3)
+}
+
+func (s *S) TestInsertRemovesSegmentsOverMaxSize(c *check.C) {
+	config.Set("app-log:file:segment-size", 1)
+	config.Set("app-log:file:max-size", 1)
+	storage := s.newStorage(c)
+	for i := 0; i < 3; i++ {
+		err := storage.Insert("myapp", newLogs(1, "app"))
+		c.Assert(err, check.IsNil)
+	}
+	segments, err := storage.segments("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(segments, check.HasLen, 1)
+	c.Assert(segments[0].seq, check.Equals, int64(2))
+}
+
+func (s *S) TestInsertRemovesExpiredSegments(c *check.C) {
+	config.Set("app-log:file:segment-size", 1)
+	config.Set("app-log:file:max-age", 60)
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(storage.segmentPath("myapp", 0), old, old)
+	c.Assert(err, check.IsNil)
+	storage = s.newStorage(c)
+	err = storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	segments, err := storage.segments("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(segments, check.HasLen, 1)
+	c.Assert(segments[0].seq, check.Equals, int64(1))
+}
+
+func (s *S) TestInsertDoesNotListSegments(c *check.C) {
+	config.Set("app-log:file:segment-size", 1)
+	config.Set("app-log:file:max-size", 1)
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(storage.segmentPath("myapp", 5), []byte("{}\n"), 0644)
+	c.Assert(err, check.IsNil)
+	err = storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	segments, err := storage.segments("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(segments, check.HasLen, 2)
+	c.Assert(segments[0].seq, check.Equals, int64(1))
+	c.Assert(segments[1].seq, check.Equals, int64(5))
+}
+
+func (s *S) TestListSkipsInvalidEntries(c *check.C) {
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(2, "app"))
+	c.Assert(err, check.IsNil)
+	f, err := os.OpenFile(storage.segmentPath("myapp", 0), os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, check.IsNil)
+	_, err = f.Write([]byte(`{"Message": "trunc`))
+	c.Assert(err, check.IsNil)
+	f.Close()
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	storage = s.newStorage(c)
+	err = storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	result, _, err = storage.List("myapp", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 3)
+	c.Assert(result[0].Message, check.Equals, "a")
+	c.Assert(result[1].Message, check.Equals, "b")
+	c.Assert(result[2].Message, check.Equals, "a")
+}
+
+func (s *S) TestListWhileInserting(c *check.C) {
+	config.Set("app-log:file:segment-size", 512)
+	config.Set("app-log:file:max-size", 4096)
+	storage := s.newStorage(c)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := storage.Insert("myapp", newLogs(3, "app")); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for inserting := true; inserting; {
+		select {
+		case err := <-done:
+			c.Assert(err, check.IsNil)
+			inserting = false
+		default:
+		}
+		result, _, err := storage.List("myapp", app.LogQuery{Lines: 10})
+		c.Assert(err, check.IsNil)
+		for _, l := range result {
+			c.Assert(l.AppName, check.Equals, "myapp")
+		}
+	}
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 10)
+}
+
+func (s *S) TestListIgnoresExpiredEntries(c *check.C) {
+	config.Set("app-log:file:max-age", 60)
+	storage := s.newStorage(c)
+	logs := newLogs(2, "app")
+	logs[0].Date = time.Now().Add(-time.Hour)
+	err := storage.Insert("myapp", logs)
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{Lines: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 1)
+	c.Assert(result[0].Message, check.Equals, "b")
+}
+
+func (s *S) TestRemove(c *check.C) {
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(1, "app"))
+	c.Assert(err, check.IsNil)
+	err = storage.Remove("myapp")
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.dir, "myapp"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	err = storage.Remove("myapp")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestListPagination(c *check.C) {
+	config.Set("app-log:file:segment-size", 1)
+	storage := s.newStorage(c)
+	err := storage.Insert("myapp", newLogs(3, "app"))
+	c.Assert(err, check.IsNil)
+	err = storage.Insert("myapp", newLogs(2, "app"))
+	c.Assert(err, check.IsNil)
+	result, cursor, err := storage.List("myapp", app.LogQuery{Lines: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	c.Assert(result[0].Message, check.Equals, "a")
+	c.Assert(result[1].Message, check.Equals, "b")
+	c.Assert(cursor, check.Equals, "1:0")
+	result, cursor, err = storage.List("myapp", app.LogQuery{Lines: 2, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	c.Assert(result[0].Message, check.Equals, "b")
+	c.Assert(result[1].Message, check.Equals, "c")
+	c.Assert(cursor, check.Equals, "0:1")
+	result, cursor, err = storage.List("myapp", app.LogQuery{Lines: 2, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 1)
+	c.Assert(result[0].Message, check.Equals, "a")
+	c.Assert(cursor, check.Equals, "")
+}
+
+func (s *S) TestListInvalidCursor(c *check.C) {
+	storage := s.newStorage(c)
+	_, _, err := storage.List("myapp", app.LogQuery{Cursor: "abc"})
+	c.Assert(err, check.Equals, app.ErrInvalidLogCursor)
+}
+
+func (s *S) TestListTimeRange(c *check.C) {
+	storage := s.newStorage(c)
+	logs := newLogs(3, "app")
+	now := time.Now().UTC()
+	logs[0].Date = now.Add(-3 * time.Minute)
+	logs[1].Date = now.Add(-2 * time.Minute)
+	logs[2].Date = now.Add(-time.Minute)
+	err := storage.Insert("myapp", logs)
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{
+		Since: now.Add(-150 * time.Second),
+		Until: now.Add(-90 * time.Second),
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 1)
+	c.Assert(result[0].Message, check.Equals, "b")
+}
+
+func (s *S) TestListMessageFilters(c *check.C) {
+	storage := s.newStorage(c)
+	logs := newLogs(3, "app")
+	logs[0].Message = "GET /healthcheck 200"
+	logs[1].Message = "GET /users 500"
+	logs[2].Message = "POST /users 201"
+	err := storage.Insert("myapp", logs)
+	c.Assert(err, check.IsNil)
+	result, _, err := storage.List("myapp", app.LogQuery{Message: "/users"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	result, _, err = storage.List("myapp", app.LogQuery{Regex: `^GET .* [0-9]00$`})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	c.Assert(result[0].Message, check.Equals, "GET /healthcheck 200")
+	c.Assert(result[1].Message, check.Equals, "GET /users 500")
+}
@@ -0,0 +1,291 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeLogStorage struct {
+	logs    map[string][]Applog
+	removed []string
+}
+
+func (s *fakeLogStorage) Insert(appName string, logs []Applog) error {
+	s.logs[appName] = append(s.logs[appName], logs...)
+	return nil
+}
+
+func (s *fakeLogStorage) List(appName string, query LogQuery) ([]Applog, string, error) {
+	var logs []Applog
+	for _, l := range s.logs[appName] {
+		if query.Match(&l) {
+			logs = append(logs, l)
+		}
+	}
+	if query.Lines > 0 && len(logs) > query.Lines {
+		logs = logs[len(logs)-query.Lines:]
+	}
+	return logs, "", nil
+}
+
+func (s *fakeLogStorage) Remove(appName string) error {
+	delete(s.logs, appName)
+	s.removed = append(s.removed, appName)
+	return nil
+}
+
+func (s *S) useFakeLogStorage(c *check.C) *fakeLogStorage {
+	storage := &fakeLogStorage{logs: make(map[string][]Applog)}
+	RegisterLogStorage("fake", func() (LogStorage, error) {
+		return storage, nil
+	})
+	config.Set("app-log:storage", "fake")
+	return storage
+}
+
+func (s *S) TestGetLogStorageDefault(c *check.C) {
+	storage, err := GetLogStorage()
+	c.Assert(err, check.IsNil)
+	c.Assert(storage, check.FitsTypeOf, &mongoLogStorage{})
+}
+
+func (s *S) TestGetLogStorageConfigured(c *check.C) {
+	fake := s.useFakeLogStorage(c)
+	defer config.Unset("app-log:storage")
+	storage, err := GetLogStorage()
+	c.Assert(err, check.IsNil)
+	c.Assert(storage, check.Equals, fake)
+	storage, err = GetLogStorage()
+	c.Assert(err, check.IsNil)
+	c.Assert(storage, check.Equals, fake)
+}
+
+func (s *S) TestGetLogStorageUnknown(c *check.C) {
+	config.Set("app-log:storage", "unknown")
+	defer config.Unset("app-log:storage")
+	_, err := GetLogStorage()
+	c.Assert(err, check.ErrorMatches, `unknown log storage: "unknown"`)
+}
+
+func (s *S) TestAppLogUsesLogStorage(c *check.C) {
+	fake := s.useFakeLogStorage(c)
+	defer config.Unset("app-log:storage")
+	a := App{Name: "newApp"}
+	err := a.Log("first\nsecond", "tsuru", "unit1")
+	c.Assert(err, check.IsNil)
+	c.Assert(fake.logs["newApp"], check.HasLen, 2)
+	logs, err := a.LastLogs(1, Applog{})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "second")
+}
+
+func (s *S) TestAppQueryLogsInvalidQuery(c *check.C) {
+	s.useFakeLogStorage(c)
+	defer config.Unset("app-log:storage")
+	a := App{Name: "newApp"}
+	_, _, err := a.QueryLogs(LogQuery{Regex: "(a"})
+	c.Assert(err, check.ErrorMatches, "invalid regex: .*")
+}
+
+func (s *S) TestLogQueryValidate(c *check.C) {
+	now := time.Now()
+	var tests = []struct {
+		query LogQuery
+		err   string
+	}{
+		{LogQuery{Lines: 10, Since: now.Add(-time.Hour), Until: now, Regex: "^a"}, ""},
+		{LogQuery{Lines: -1}, "the number of lines must not be negative"},
+		{LogQuery{Since: now, Until: now.Add(-time.Hour)}, "until must not be before since"},
+		{LogQuery{Message: "a", Regex: "a"}, "message and regex filters are mutually exclusive"},
+		{LogQuery{Regex: "(a"}, "invalid regex: .*"},
+		{LogQuery{Fields: map[string]string{"level": "error"}}, ""},
+		{LogQuery{Fields: map[string]string{"http.status": "500"}}, `invalid field name: "http.status"`},
+		{LogQuery{Fields: map[string]string{"$where": "1"}}, `invalid field name: "\$where"`},
+	}
+	for _, t := range tests {
+		err := t.query.Validate()
+		if t.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *S) TestLogQueryMatch(c *check.C) {
+	now := time.Now()
+	l := Applog{Date: now, Message: "GET /users 500", Source: "app", Unit: "unit1", Fields: map[string]string{"level": "error"}}
+	var tests = []struct {
+		query    LogQuery
+		expected bool
+	}{
+		{LogQuery{}, true},
+		{LogQuery{Source: "app", Unit: "unit1"}, true},
+		{LogQuery{Source: "tsuru"}, false},
+		{LogQuery{Unit: "unit2"}, false},
+		{LogQuery{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+		{LogQuery{Since: now.Add(time.Minute)}, false},
+		{LogQuery{Until: now.Add(-time.Minute)}, false},
+		{LogQuery{Message: "/users"}, true},
+		{LogQuery{Message: "/apps"}, false},
+		{LogQuery{Regex: "5[0-9]{2}$"}, true},
+		{LogQuery{Regex: "^POST"}, false},
+		{LogQuery{Regex: "(a"}, false},
+		{LogQuery{Fields: map[string]string{"level": "error"}}, true},
+		{LogQuery{Fields: map[string]string{"level": "info"}}, false},
+		{LogQuery{Fields: map[string]string{"level": "error", "status": "500"}}, false},
+	}
+	for i, t := range tests {
+		c.Check(t.query.Match(&l), check.Equals, t.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestMongoLogStorage(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	err := storage.Insert("myapp", []Applog{
+		{Message: "first", Source: "tsuru", AppName: "myapp", Unit: "unit1"},
+		{Message: "second", Source: "app", AppName: "myapp", Unit: "unit1"},
+		{Message: "third", Source: "tsuru", AppName: "myapp", Unit: "unit2"},
+	})
+	c.Assert(err, check.IsNil)
+	logs, _, err := storage.List("myapp", LogQuery{Lines: 10, Source: "tsuru"})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "first")
+	c.Assert(logs[1].Message, check.Equals, "third")
+	logs, _, err = storage.List("myapp", LogQuery{Lines: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "third")
+	err = storage.Remove("myapp")
+	c.Assert(err, check.IsNil)
+	count, err := s.logConn.Logs("myapp").Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestMongoLogStorageQuery(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err := storage.Insert("myapp", []Applog{
+		{Date: now.Add(-3 * time.Minute), Message: "GET /healthcheck 200", AppName: "myapp"},
+		{Date: now.Add(-2 * time.Minute), Message: "GET /users 500", AppName: "myapp"},
+		{Date: now.Add(-time.Minute), Message: "POST /users 201", AppName: "myapp"},
+	})
+	c.Assert(err, check.IsNil)
+	logs, _, err := storage.List("myapp", LogQuery{Since: now.Add(-150 * time.Second), Until: now})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "GET /users 500")
+	logs, _, err = storage.List("myapp", LogQuery{Message: "/users"})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	logs, _, err = storage.List("myapp", LogQuery{Regex: "^GET"})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[1].Message, check.Equals, "GET /users 500")
+}
+
+func (s *S) TestMongoLogStorageQueryRegexPagination(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	err := storage.Insert("myapp", []Applog{
+		{Message: "GET /a", AppName: "myapp"},
+		{Message: "POST /b", AppName: "myapp"},
+		{Message: "GET /c", AppName: "myapp"},
+		{Message: "GET /d", AppName: "myapp"},
+		{Message: "POST /e", AppName: "myapp"},
+	})
+	c.Assert(err, check.IsNil)
+	logs, cursor, err := storage.List("myapp", LogQuery{Lines: 2, Regex: `^GET /\pL$`})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "GET /c")
+	c.Assert(logs[1].Message, check.Equals, "GET /d")
+	c.Assert(cursor, check.Not(check.Equals), "")
+	logs, cursor, err = storage.List("myapp", LogQuery{Lines: 2, Regex: `^GET /\pL$`, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "GET /a")
+	c.Assert(cursor, check.Equals, "")
+}
+
+func (s *S) TestMongoLogStorageQueryFields(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	err := storage.Insert("myapp", []Applog{
+		{Message: "first", AppName: "myapp", Fields: map[string]string{"level": "info"}},
+		{Message: "second", AppName: "myapp", Fields: map[string]string{"level": "error"}},
+		{Message: "third", AppName: "myapp"},
+	})
+	c.Assert(err, check.IsNil)
+	logs, _, err := storage.List("myapp", LogQuery{Fields: map[string]string{"level": "error"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "second")
+	c.Assert(logs[0].Fields, check.DeepEquals, map[string]string{"level": "error"})
+}
+
+func (s *S) TestMongoLogStoragePagination(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	err := storage.Insert("myapp", []Applog{
+		{Message: "first", AppName: "myapp"},
+		{Message: "second", AppName: "myapp"},
+		{Message: "third", AppName: "myapp"},
+	})
+	c.Assert(err, check.IsNil)
+	logs, cursor, err := storage.List("myapp", LogQuery{Lines: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "second")
+	c.Assert(cursor, check.Not(check.Equals), "")
+	logs, cursor, err = storage.List("myapp", LogQuery{Lines: 2, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 1)
+	c.Assert(logs[0].Message, check.Equals, "first")
+	c.Assert(cursor, check.Equals, "")
+	_, _, err = storage.List("myapp", LogQuery{Cursor: "invalid"})
+	c.Assert(err, check.Equals, ErrInvalidLogCursor)
+}
+
+func (s *S) TestMongoLogStoragePaginationIDOrder(c *check.C) {
+	storage := &mongoLogStorage{}
+	defer storage.Remove("myapp")
+	conn, err := db.LogConn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	now := time.Now()
+	for _, i := range []int{3, 1, 4, 2} {
+		err = conn.Logs("myapp").Insert(mongoApplog{
+			ID:     bson.NewObjectIdWithTime(now.Add(time.Duration(i) * time.Second)),
+			Applog: Applog{Message: fmt.Sprintf("log %d", i), AppName: "myapp"},
+		})
+		c.Assert(err, check.IsNil)
+	}
+	logs, cursor, err := storage.List("myapp", LogQuery{Lines: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "log 3")
+	c.Assert(logs[1].Message, check.Equals, "log 4")
+	logs, cursor, err = storage.List("myapp", LogQuery{Lines: 2, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "log 1")
+	c.Assert(logs[1].Message, check.Equals, "log 2")
+	logs, _, err = storage.List("myapp", LogQuery{Lines: 2, Cursor: cursor})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 0)
+}
@@ -6,8 +6,11 @@ package app
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -37,7 +40,24 @@ func reserveUnits(app *App, quantity int) error {
 			bson.M{"$inc": bson.M{"quota.inuse": quantity}},
 		)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	err = reserveTeamQuota(app.TeamOwner, TeamQuotaUsage{
+		Units:  quantity,
+		Memory: app.Plan.Memory * int64(quantity),
+	})
+	if err != nil {
+		rollbackErr := conn.Apps().Update(
+			bson.M{"name": app.Name},
+			bson.M{"$inc": bson.M{"quota.inuse": -1 * quantity}},
+		)
+		if rollbackErr != nil {
+			log.Errorf("unable to release units of app %q after exceeding the team quota: %s", app.Name, rollbackErr)
+		}
+		return err
+	}
+	return nil
 }
 
 func checkAppLimit(name string, quantity int) (*App, error) {
@@ -78,7 +98,30 @@ func releaseUnits(app *App, quantity int) error {
 			bson.M{"$inc": bson.M{"quota.inuse": -1 * quantity}},
 		)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return adjustTeamQuota(app.TeamOwner, TeamQuotaUsage{
+		Units:  -1 * quantity,
+		Memory: -1 * app.Plan.Memory * int64(quantity),
+	})
+}
+
+// setUnitsInUse sets the number of units in use by the app, updating the
+// usage of the team owning it by the difference.
+func setUnitsInUse(conn *db.Storage, appName string, inUse int) error {
+	var old App
+	_, err := conn.Apps().Find(bson.M{"name": appName}).Select(bson.M{"quota": 1, "plan": 1, "teamowner": 1}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{"quota.inuse": inUse}},
+	}, &old)
+	if err != nil {
+		return err
+	}
+	delta := inUse - old.Quota.InUse
+	return adjustTeamQuota(old.TeamOwner, TeamQuotaUsage{
+		Units:  delta,
+		Memory: old.Plan.Memory * int64(delta),
+	})
 }
 
 func checkAppUsage(name string, quantity int) (*App, error) {
@@ -112,3 +155,214 @@ func ChangeQuota(app *App, limit int) error {
 		bson.M{"$set": bson.M{"quota.limit": limit}},
 	)
 }
+
+// ErrTeamQuotaLimitBelowUsage is returned when a team quota limit is set
+// below the resources currently used by the team.
+var ErrTeamQuotaLimitBelowUsage = errors.New("new limit is lesser than the current allocated value")
+
+// TeamQuotaUsage holds the resources used by the apps owned by a team, to be
+// compared with the limits in auth.TeamQuota. Memory is the sum of the plan
+// memory of every unit. The usage is kept as a counter in the team document,
+// updated as apps and units are reserved and released.
+type TeamQuotaUsage struct {
+	Apps   int
+	Units  int
+	Memory int64
+}
+
+func (u TeamQuotaUsage) negate() TeamQuotaUsage {
+	return TeamQuotaUsage{Apps: -u.Apps, Units: -u.Units, Memory: -u.Memory}
+}
+
+// TeamQuotaExceededError is returned when an operation would make the apps
+// owned by a team use more resources than allowed by the team quota.
+type TeamQuotaExceededError struct {
+	Team     string
+	Resource string
+	quota.QuotaExceededError
+}
+
+func (err *TeamQuotaExceededError) Error() string {
+	return fmt.Sprintf("Quota of %s exceeded for team %q. Available: %d. Requested: %d.",
+		err.Resource, err.Team, err.Available, err.Requested)
+}
+
+// GetTeamQuotaUsage returns the resources used by the apps owned by the team.
+func GetTeamQuotaUsage(teamName string) (*TeamQuotaUsage, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for {
+		var team struct {
+			QuotaUsage *TeamQuotaUsage
+		}
+		err = conn.Teams().FindId(teamName).Select(bson.M{"quotausage": 1}).One(&team)
+		if err == mgo.ErrNotFound {
+			return nil, auth.ErrTeamNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if team.QuotaUsage != nil {
+			return team.QuotaUsage, nil
+		}
+		// teams created before the counter existed have it initialized
+		// from their apps.
+		usage, err := countTeamQuotaUsage(teamName)
+		if err != nil {
+			return nil, err
+		}
+		err = conn.Teams().Update(
+			bson.M{"_id": teamName, "quotausage": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"quotausage": usage}},
+		)
+		if err != mgo.ErrNotFound {
+			return usage, err
+		}
+	}
+}
+
+func countTeamQuotaUsage(teamName string) (*TeamQuotaUsage, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []struct {
+		Quota quota.Quota
+		Plan  Plan
+	}
+	err = conn.Apps().Find(bson.M{"teamowner": teamName}).Select(bson.M{"quota": 1, "plan": 1}).All(&apps)
+	if err != nil {
+		return nil, err
+	}
+	usage := TeamQuotaUsage{Apps: len(apps)}
+	for _, a := range apps {
+		usage.Units += a.Quota.InUse
+		usage.Memory += a.Plan.Memory * int64(a.Quota.InUse)
+	}
+	return &usage, nil
+}
+
+// reserveTeamQuota adds the requested resources to the usage of the team,
+// failing when it would exceed the team quota. Like app quotas, the usage is
+// updated using the checked usage and limits as a guard, retrying when they
+// change concurrently.
+func reserveTeamQuota(teamName string, requested TeamQuotaUsage) error {
+	if teamName == "" || requested == (TeamQuotaUsage{}) {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for {
+		usage, err := GetTeamQuotaUsage(teamName)
+		if err == auth.ErrTeamNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		team, err := auth.GetTeam(teamName)
+		if err == auth.ErrTeamNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = checkTeamQuota(team, usage, requested)
+		if err != nil {
+			return err
+		}
+		err = conn.Teams().Update(
+			bson.M{"_id": teamName, "quota": team.Quota, "quotausage": usage},
+			bson.M{"$inc": bson.M{
+				"quotausage.apps":   requested.Apps,
+				"quotausage.units":  requested.Units,
+				"quotausage.memory": requested.Memory,
+			}},
+		)
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+}
+
+// adjustTeamQuota adds the given amounts, usually negative, to the usage of
+// the team without checking its quota.
+func adjustTeamQuota(teamName string, delta TeamQuotaUsage) error {
+	if teamName == "" || delta == (TeamQuotaUsage{}) {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Teams().Update(
+		bson.M{"_id": teamName, "quotausage": bson.M{"$exists": true}},
+		bson.M{"$inc": bson.M{
+			"quotausage.apps":   delta.Apps,
+			"quotausage.units":  delta.Units,
+			"quotausage.memory": delta.Memory,
+		}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// checkTeamQuota returns an error when adding the requested resources to the
+// usage of the team would exceed its quota.
+func checkTeamQuota(team *auth.Team, usage *TeamQuotaUsage, requested TeamQuotaUsage) error {
+	if team.Quota == nil {
+		return nil
+	}
+	limits := team.GetQuota()
+	for _, item := range []struct {
+		resource         string
+		limit, inUse, rq int64
+	}{
+		{"apps", int64(limits.Apps), int64(usage.Apps), int64(requested.Apps)},
+		{"units", int64(limits.Units), int64(usage.Units), int64(requested.Units)},
+		{"memory", limits.Memory, usage.Memory, requested.Memory},
+	} {
+		if item.limit < 0 || item.rq <= 0 || item.inUse+item.rq <= item.limit {
+			continue
+		}
+		available := item.limit - item.inUse
+		if available < 0 {
+			available = 0
+		}
+		return &TeamQuotaExceededError{
+			Team:     team.Name,
+			Resource: item.resource,
+			QuotaExceededError: quota.QuotaExceededError{
+				Available: uint(available),
+				Requested: uint(item.rq),
+			},
+		}
+	}
+	return nil
+}
+
+// ChangeTeamQuota redefines the limits of the team. The new limits must be
+// bigger than or equal to the resources currently used by the apps owned by
+// the team, negative limits mean unlimited.
+func ChangeTeamQuota(team *auth.Team, limits auth.TeamQuota) error {
+	usage, err := GetTeamQuotaUsage(team.Name)
+	if err != nil {
+		return err
+	}
+	if (limits.Apps >= 0 && limits.Apps < usage.Apps) ||
+		(limits.Units >= 0 && limits.Units < usage.Units) ||
+		(limits.Memory >= 0 && limits.Memory < usage.Memory) {
+		return ErrTeamQuotaLimitBelowUsage
+	}
+	return team.SetQuota(limits)
+}
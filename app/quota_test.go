@@ -5,9 +5,11 @@
 package app
 
 import (
+	"bytes"
 	"runtime"
 	"sync"
 
+	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
@@ -197,3 +199,201 @@ func (s *S) TestChangeQuotaAppNotFound(c *check.C) {
 	c.Assert(err, check.NotNil)
 	c.Assert(err, check.Equals, mgo.ErrNotFound)
 }
+
+func (s *S) setTeamQuota(c *check.C, limits auth.TeamQuota) {
+	err := s.team.SetQuota(limits)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestGetTeamQuotaUsage(c *check.C) {
+	apps := []App{
+		{Name: "app1", TeamOwner: s.team.Name, Plan: Plan{Memory: 100}, Quota: quota.Quota{Limit: -1, InUse: 2}},
+		{Name: "app2", TeamOwner: s.team.Name, Plan: Plan{Memory: 50}, Quota: quota.Quota{Limit: -1, InUse: 3}},
+		{Name: "app3", TeamOwner: "otherteam", Plan: Plan{Memory: 100}, Quota: quota.Quota{Limit: -1, InUse: 1}},
+	}
+	for _, a := range apps {
+		err := s.conn.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+	}
+	usage, err := GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 2, Units: 5, Memory: 350})
+}
+
+func (s *S) TestGetTeamQuotaUsageKeepsCounter(c *check.C) {
+	err := s.conn.Apps().Insert(App{Name: "app1", TeamOwner: s.team.Name, Quota: quota.Quota{Limit: -1, InUse: 2}})
+	c.Assert(err, check.IsNil)
+	usage, err := GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 1, Units: 2})
+	err = s.conn.Apps().Insert(App{Name: "app2", TeamOwner: s.team.Name, Quota: quota.Quota{Limit: -1, InUse: 1}})
+	c.Assert(err, check.IsNil)
+	usage, err = GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 1, Units: 2})
+	_, err = GetTeamQuotaUsage("unknown")
+	c.Assert(err, check.Equals, auth.ErrTeamNotFound)
+}
+
+func (s *S) TestReserveTeamQuota(c *check.C) {
+	err := s.conn.Apps().Insert(App{
+		Name:      "app1",
+		TeamOwner: s.team.Name,
+		Plan:      Plan{Memory: 100},
+		Quota:     quota.Quota{Limit: -1, InUse: 2},
+	})
+	c.Assert(err, check.IsNil)
+	err = reserveTeamQuota(s.team.Name, TeamQuotaUsage{Apps: 10, Units: 10, Memory: 1000})
+	c.Assert(err, check.IsNil)
+	usage, err := GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 11, Units: 12, Memory: 1200})
+	err = adjustTeamQuota(s.team.Name, TeamQuotaUsage{Apps: -10, Units: -10, Memory: -1000})
+	c.Assert(err, check.IsNil)
+	s.setTeamQuota(c, auth.TeamQuota{Apps: 2, Units: 3, Memory: -1})
+	err = reserveTeamQuota(s.team.Name, TeamQuotaUsage{Apps: 1, Units: 1, Memory: 1000})
+	c.Assert(err, check.IsNil)
+	err = reserveTeamQuota(s.team.Name, TeamQuotaUsage{Units: 1})
+	c.Assert(err, check.DeepEquals, &TeamQuotaExceededError{
+		Team:               s.team.Name,
+		Resource:           "units",
+		QuotaExceededError: quota.QuotaExceededError{Available: 0, Requested: 1},
+	})
+	c.Assert(err, check.ErrorMatches, `Quota of units exceeded for team "tsuruteam". Available: 0. Requested: 1.`)
+	err = reserveTeamQuota(s.team.Name, TeamQuotaUsage{Apps: 1})
+	c.Assert(err, check.FitsTypeOf, &TeamQuotaExceededError{})
+	c.Assert(err.(*TeamQuotaExceededError).Resource, check.Equals, "apps")
+	usage, err = GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 2, Units: 3, Memory: 1200})
+}
+
+func (s *S) TestReserveTeamQuotaTeamNotFound(c *check.C) {
+	err := reserveTeamQuota("unknown", TeamQuotaUsage{Apps: 1})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReserveTeamQuotaIsAtomic(c *check.C) {
+	ncpu := runtime.NumCPU()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(ncpu))
+	s.setTeamQuota(c, auth.TeamQuota{Apps: -1, Units: 40, Memory: -1})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reserveTeamQuota(s.team.Name, TeamQuotaUsage{Units: 3})
+		}()
+	}
+	wg.Wait()
+	usage, err := GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage.Units, check.Equals, 39)
+}
+
+func (s *S) TestReleaseUnitsReleasesTeamQuota(c *check.C) {
+	app := &App{
+		Name:      "together",
+		TeamOwner: s.team.Name,
+		Plan:      Plan{Memory: 100},
+		Quota:     quota.Quota{Limit: -1},
+	}
+	err := s.conn.Apps().Insert(app)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(app, 5)
+	c.Assert(err, check.IsNil)
+	err = releaseUnits(app, 3)
+	c.Assert(err, check.IsNil)
+	usage, err := GetTeamQuotaUsage(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, &TeamQuotaUsage{Apps: 1, Units: 2, Memory: 200})
+}
+
+func (s *S) TestReserveUnitsTeamQuotaExceeded(c *check.C) {
+	s.setTeamQuota(c, auth.TeamQuota{Apps: -1, Units: -1, Memory: 1000})
+	app := App{
+		Name:      "together",
+		TeamOwner: s.team.Name,
+		Plan:      Plan{Memory: 300},
+		Quota:     quota.Unlimited,
+	}
+	err := s.conn.Apps().Insert(app)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&app, 3)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&app, 1)
+	c.Assert(err, check.DeepEquals, &TeamQuotaExceededError{
+		Team:               s.team.Name,
+		Resource:           "memory",
+		QuotaExceededError: quota.QuotaExceededError{Available: 100, Requested: 300},
+	})
+	dbApp, err := GetByName(app.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Quota.InUse, check.Equals, 3)
+}
+
+func (s *S) TestCreateAppTeamQuotaExceeded(c *check.C) {
+	s.setTeamQuota(c, auth.TeamQuota{Apps: 0, Units: -1, Memory: -1})
+	a := App{Name: "appname", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.FitsTypeOf, &TeamQuotaExceededError{})
+	_, err = GetByName(a.Name)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestUpdatePlanTeamQuotaExceeded(c *check.C) {
+	plan := Plan{Name: "big", Memory: 400, CpuShare: 100}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, check.IsNil)
+	a := App{
+		Name:      "my-test-app",
+		TeamOwner: s.team.Name,
+		Plan:      Plan{Name: "small", Memory: 100},
+		Quota:     quota.Quota{Limit: -1, InUse: 2},
+	}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.setTeamQuota(c, auth.TeamQuota{Apps: -1, Units: -1, Memory: 500})
+	err = a.Update(App{Plan: Plan{Name: "big"}}, new(bytes.Buffer))
+	c.Assert(err, check.DeepEquals, &TeamQuotaExceededError{
+		Team:               s.team.Name,
+		Resource:           "memory",
+		QuotaExceededError: quota.QuotaExceededError{Available: 300, Requested: 600},
+	})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Plan.Name, check.Equals, "small")
+}
+
+func (s *S) TestUpdateTeamOwnerTeamQuotaExceeded(c *check.C) {
+	team := auth.Team{Name: "newteam", Quota: &auth.TeamQuota{Apps: 0, Units: -1, Memory: -1}}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "my-test-app", TeamOwner: s.team.Name, Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.Update(App{TeamOwner: team.Name}, new(bytes.Buffer))
+	c.Assert(err, check.FitsTypeOf, &TeamQuotaExceededError{})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+}
+
+func (s *S) TestChangeTeamQuota(c *check.C) {
+	err := s.conn.Apps().Insert(App{
+		Name:      "app1",
+		TeamOwner: s.team.Name,
+		Plan:      Plan{Memory: 100},
+		Quota:     quota.Quota{Limit: -1, InUse: 2},
+	})
+	c.Assert(err, check.IsNil)
+	err = ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: 1, Units: 2, Memory: -1})
+	c.Assert(err, check.IsNil)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.DeepEquals, &auth.TeamQuota{Apps: 1, Units: 2, Memory: -1})
+	err = ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: 1, Units: 1, Memory: -1})
+	c.Assert(err, check.Equals, ErrTeamQuotaLimitBelowUsage)
+	err = ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: 1, Units: 2, Memory: 199})
+	c.Assert(err, check.Equals, ErrTeamQuotaLimitBelowUsage)
+}
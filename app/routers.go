@@ -0,0 +1,155 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrRouterAlreadyAttached  = stderr.New("router already attached to the app")
+	ErrRouterNotAttached      = stderr.New("router not attached to the app")
+	ErrCannotRemovePlanRouter = stderr.New("the router of the app plan cannot be removed")
+	ErrSwappedAppRouter       = stderr.New("routers cannot be attached to a swapped app")
+)
+
+// AppRouter is a router attached to an app in addition to the router of its
+// plan, along with the address of the app in it.
+type AppRouter struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// GetRouters returns the names of all routers of the app, starting with the
+// router of its plan.
+func (app *App) GetRouters() ([]string, error) {
+	planRouter, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{planRouter}
+	for _, r := range app.Routers {
+		if r.Name != planRouter {
+			names = append(names, r.Name)
+		}
+	}
+	return names, nil
+}
+
+// RoutersWithAddr returns all routers of the app, starting with the router of
+// its plan, along with the address of the app in each of them.
+func (app *App) RoutersWithAddr() ([]AppRouter, error) {
+	planRouter, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	routers := []AppRouter{{Name: planRouter, Address: app.Ip}}
+	for _, r := range app.Routers {
+		if r.Name != planRouter {
+			routers = append(routers, r)
+		}
+	}
+	return routers, nil
+}
+
+// AddRouter attaches the router to the app, creating the backend of the app
+// in it and adding the routes and cnames of the app. The healthcheck of the
+// app is set in the router in its next deploy.
+func (app *App) AddRouter(name string) error {
+	r, err := router.Get(name)
+	if err != nil {
+		return &errors.ValidationError{Message: err.Error()}
+	}
+	names, err := app.GetRouters()
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if n == name {
+			return ErrRouterAlreadyAttached
+		}
+	}
+	swapped, _, err := router.IsSwapped(app.Name)
+	if err != nil && err != router.ErrBackendNotFound {
+		return err
+	}
+	if swapped {
+		return ErrSwappedAppRouter
+	}
+	if optsRouter, ok := r.(router.OptsRouter); ok {
+		err = optsRouter.AddBackendOpts(app.Name, app.RouterOpts)
+	} else {
+		err = r.AddBackend(app.Name)
+	}
+	if err != nil && err != router.ErrBackendExists {
+		return err
+	}
+	addr, err := r.Addr(app.Name)
+	if err != nil {
+		r.RemoveBackend(app.Name)
+		return err
+	}
+	appRouter := AppRouter{Name: name, Address: addr}
+	conn, err := db.Conn()
+	if err != nil {
+		r.RemoveBackend(app.Name)
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$push": bson.M{"routers": appRouter}})
+	if err != nil {
+		r.RemoveBackend(app.Name)
+		return err
+	}
+	app.Routers = append(app.Routers, appRouter)
+	_, err = app.RebuildRoutes()
+	return err
+}
+
+// RemoveRouter detaches the router from the app, removing the backend of the
+// app from it. The router of the app plan can't be removed.
+func (app *App) RemoveRouter(name string) error {
+	planRouter, err := app.GetRouter()
+	if err != nil {
+		return err
+	}
+	if name == planRouter {
+		return ErrCannotRemovePlanRouter
+	}
+	index := -1
+	for i, r := range app.Routers {
+		if r.Name == name {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return ErrRouterNotAttached
+	}
+	r, err := router.Get(name)
+	if err != nil {
+		return err
+	}
+	err = r.RemoveBackend(app.Name)
+	if err != nil && err != router.ErrBackendNotFound {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$pull": bson.M{"routers": bson.M{"name": name}}})
+	if err != nil {
+		return err
+	}
+	app.Routers = append(app.Routers[:index], app.Routers[index+1:]...)
+	return nil
+}
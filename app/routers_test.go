@@ -0,0 +1,211 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAppGetRouters(c *check.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	routers, err := a.GetRouters()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []string{"fake"})
+	a.Routers = []AppRouter{{Name: "fake-hc"}, {Name: "fake"}}
+	routers, err = a.GetRouters()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []string{"fake", "fake-hc"})
+}
+
+func (s *S) TestAppRoutersWithAddr(c *check.C) {
+	a := App{
+		Name:    "myapp",
+		Ip:      "myapp.fakerouter.com",
+		Plan:    Plan{Router: "fake"},
+		Routers: []AppRouter{{Name: "fake-hc", Address: "myapp.fakehcrouter.com"}},
+	}
+	routers, err := a.RoutersWithAddr()
+	c.Assert(err, check.IsNil)
+	c.Assert(routers, check.DeepEquals, []AppRouter{
+		{Name: "fake", Address: "myapp.fakerouter.com"},
+		{Name: "fake-hc", Address: "myapp.fakehcrouter.com"},
+	})
+}
+
+func (s *S) TestAppMarshalJSONWithRouters(c *check.C) {
+	a := App{
+		Name:    "myapp",
+		Ip:      "myapp.fakerouter.com",
+		Plan:    Plan{Router: "fake"},
+		Routers: []AppRouter{{Name: "fake-hc", Address: "myapp.fakehcrouter.com"}},
+	}
+	data, err := a.MarshalJSON()
+	c.Assert(err, check.IsNil)
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["routers"], check.DeepEquals, []interface{}{
+		map[string]interface{}{"name": "fake", "address": "myapp.fakerouter.com"},
+		map[string]interface{}{"name": "fake-hc", "address": "myapp.fakehcrouter.com"},
+	})
+}
+
+func (s *S) TestAppAddRouter(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	addr, err := routertest.HCRouter.Addr(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Routers, check.DeepEquals, []AppRouter{{Name: "fake-hc", Address: addr}})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.DeepEquals, []AppRouter{{Name: "fake-hc", Address: addr}})
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		c.Assert(routertest.HCRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(routertest.HCRouter.HasCName("my.cname.com"), check.Equals, true)
+}
+
+func (s *S) TestAppAddRouterAlreadyAttached(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddRouter("fake")
+	c.Assert(err, check.Equals, ErrRouterAlreadyAttached)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.Equals, ErrRouterAlreadyAttached)
+}
+
+func (s *S) TestAppAddRouterInvalidRouter(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddRouter("unknown")
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(a.Routers, check.HasLen, 0)
+}
+
+func (s *S) TestAppAddRouterSwappedApp(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	other := App{Name: "other-app", Plan: Plan{Router: "fake"}}
+	err = s.conn.Apps().Insert(other)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&other)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&other, 1, "web", nil)
+	err = routertest.FakeRouter.Swap(a.Name, other.Name, false)
+	c.Assert(err, check.IsNil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.Equals, ErrSwappedAppRouter)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, false)
+}
+
+func (s *S) TestAppRemoveRouter(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	err = a.RemoveRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Routers, check.HasLen, 0)
+	c.Assert(routertest.HCRouter.HasBackend(a.Name), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasBackend(a.Name), check.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Routers, check.HasLen, 0)
+	_, err = router.Retrieve(a.Name)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestAppRemoveRouterNotAttached(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.RemoveRouter("fake-hc")
+	c.Assert(err, check.Equals, ErrRouterNotAttached)
+	err = a.RemoveRouter("fake")
+	c.Assert(err, check.Equals, ErrCannotRemovePlanRouter)
+}
+
+func (s *S) TestRebuildRoutesMultipleRouters(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	routertest.HCRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.HCRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	changes, err := a.RebuildRoutes()
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, &RebuildRoutesResult{
+		Added:   []string{units[1].Address.String()},
+		Removed: []string{"http://invalid:1234"},
+	})
+	c.Assert(routertest.HCRouter.HasRoute(a.Name, units[1].Address.String()), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasRoute(a.Name, "http://invalid:1234"), check.Equals, false)
+}
+
+func (s *S) TestSleepMultipleRouters(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	proxyURL, err := url.Parse("http://proxy:1234")
+	c.Assert(err, check.IsNil)
+	var b bytes.Buffer
+	err = a.Sleep(&b, "web", proxyURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, units[0].Address.String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, proxyURL.String()), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasRoute(a.Name, units[0].Address.String()), check.Equals, false)
+	c.Assert(routertest.HCRouter.HasRoute(a.Name, proxyURL.String()), check.Equals, true)
+}
@@ -0,0 +1,398 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	routesDriftCollection      = "routes_drift"
+	routesDriftEventKind       = "app.routes.drift"
+	routesDriftLogTag          = "[app routes drift]"
+	defaultRoutesDriftInterval = 5 * time.Minute
+)
+
+// RoutesDrift describes the differences between the routes and cnames an app
+// has in its router and the ones expected by tsuru. Missing entries are
+// expected but absent from the router, stale entries are present in the
+// router but not expected.
+type RoutesDrift struct {
+	App           string   `json:"app"`
+	Router        string   `json:"router"`
+	MissingRoutes []string `json:"missingRoutes,omitempty"`
+	StaleRoutes   []string `json:"staleRoutes,omitempty"`
+	MissingCNames []string `json:"missingCNames,omitempty"`
+	StaleCNames   []string `json:"staleCNames,omitempty"`
+	Fixed         bool     `json:"fixed,omitempty"`
+}
+
+// HasDrift reports whether the router differs from the expected state.
+func (d *RoutesDrift) HasDrift() bool {
+	return len(d.MissingRoutes) > 0 || len(d.StaleRoutes) > 0 ||
+		len(d.MissingCNames) > 0 || len(d.StaleCNames) > 0
+}
+
+func (d *RoutesDrift) equal(other *RoutesDrift) bool {
+	return equalHosts(d.MissingRoutes, other.MissingRoutes) && equalHosts(d.StaleRoutes, other.StaleRoutes) &&
+		equalHosts(d.MissingCNames, other.MissingCNames) && equalHosts(d.StaleCNames, other.StaleCNames)
+}
+
+func equalHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// RoutesDrift compares the routes of the app in each of its routers with its
+// routable units, and the cnames in each router with the cnames of the app,
+// returning the drift of the routers differing from the expected state.
+// Routes are compared by host, so the same address with a different scheme or
+// a weighted route isn't considered a drift.
+func (app *App) RoutesDrift() ([]RoutesDrift, error) {
+	routers, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	units, err := Provisioner.RoutableUnits(app)
+	if err != nil {
+		return nil, err
+	}
+	expected := make([]string, 0, len(units))
+	for _, u := range units {
+		expected = append(expected, u.Address.Host)
+	}
+	var drifts []RoutesDrift
+	for _, routerName := range routers {
+		drift, err := app.routerRoutesDrift(routerName, expected)
+		if err != nil {
+			return nil, err
+		}
+		if drift.HasDrift() {
+			drifts = append(drifts, *drift)
+		}
+	}
+	return drifts, nil
+}
+
+func (app *App) routerRoutesDrift(routerName string, expected []string) (*RoutesDrift, error) {
+	r, err := router.Get(routerName)
+	if err != nil {
+		return nil, err
+	}
+	drift := RoutesDrift{App: app.Name, Router: routerName}
+	routes, err := r.Routes(app.Name)
+	if err != nil && err != router.ErrBackendNotFound {
+		return nil, err
+	}
+	current := make([]string, 0, len(routes))
+	for _, route := range routes {
+		current = append(current, route.Host)
+	}
+	drift.MissingRoutes, drift.StaleRoutes = diffHosts(expected, current)
+	if cnameRouter, ok := r.(router.CNameRouter); ok {
+		cnames, err := cnameRouter.CNames(app.Name)
+		if err != nil && err != router.ErrBackendNotFound {
+			return nil, err
+		}
+		current = make([]string, 0, len(cnames))
+		for _, cname := range cnames {
+			current = append(current, cname.Host)
+		}
+		drift.MissingCNames, drift.StaleCNames = diffHosts(app.CName, current)
+	}
+	return &drift, nil
+}
+
+// FixRoutesDrift rebuilds the routes of the app and removes the stale cnames
+// in the drifts from their routers.
+func (app *App) FixRoutesDrift(drifts []RoutesDrift) error {
+	_, err := app.RebuildRoutes()
+	if err != nil {
+		return err
+	}
+	for _, drift := range drifts {
+		if len(drift.StaleCNames) == 0 {
+			continue
+		}
+		r, err := router.Get(drift.Router)
+		if err != nil {
+			return err
+		}
+		cnameRouter, ok := r.(router.CNameRouter)
+		if !ok {
+			continue
+		}
+		for _, cname := range drift.StaleCNames {
+			err = cnameRouter.UnsetCName(cname, app.Name)
+			if err != nil && err != router.ErrCNameNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FindRoutesDrift checks the routes of the apps matching the filter,
+// returning the drift of each router differing from the expected state. Apps
+// whose routers can't be checked are logged and skipped.
+func FindRoutesDrift(filter *Filter) ([]RoutesDrift, error) {
+	apps, err := List(filter)
+	if err != nil {
+		return nil, err
+	}
+	var result []RoutesDrift
+	for i := range apps {
+		drifts, err := apps[i].RoutesDrift()
+		if err != nil {
+			log.Errorf("%s unable to check routes of app %s: %s", routesDriftLogTag, apps[i].Name, err)
+			continue
+		}
+		result = append(result, drifts...)
+	}
+	return result, nil
+}
+
+// diffHosts returns the hosts in expected missing from current and the hosts
+// in current absent from expected, both sorted and without duplicates.
+func diffHosts(expected, current []string) (missing, stale []string) {
+	expectedSet := make(map[string]bool, len(expected))
+	for _, h := range expected {
+		expectedSet[h] = true
+	}
+	currentSet := make(map[string]bool, len(current))
+	for _, h := range current {
+		currentSet[h] = true
+	}
+	for h := range expectedSet {
+		if !currentSet[h] {
+			missing = append(missing, h)
+		}
+	}
+	for h := range currentSet {
+		if !expectedSet[h] {
+			stale = append(stale, h)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return missing, stale
+}
+
+type routesDriftChecker struct {
+	interval time.Duration
+	autoFix  bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	routesDriftCheckerMut      sync.Mutex
+	routesDriftCheckerInstance *routesDriftChecker
+)
+
+func routesDriftInterval() time.Duration {
+	interval, err := config.GetInt("routes-drift:run-interval")
+	if err != nil || interval <= 0 {
+		return defaultRoutesDriftInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// StartRoutesDriftChecker starts the routine that periodically compares the
+// routes of all apps with their routers, recording an event when the drift
+// of an app changes. The drift is also fixed when "routes-drift:auto-fix" is
+// enabled. The routine doesn't start when "routes-drift:disabled" is true.
+func StartRoutesDriftChecker() {
+	if disabled, _ := config.GetBool("routes-drift:disabled"); disabled {
+		return
+	}
+	routesDriftCheckerMut.Lock()
+	defer routesDriftCheckerMut.Unlock()
+	if routesDriftCheckerInstance != nil {
+		return
+	}
+	autoFix, _ := config.GetBool("routes-drift:auto-fix")
+	routesDriftCheckerInstance = &routesDriftChecker{
+		interval: routesDriftInterval(),
+		autoFix:  autoFix,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	shutdown.Register(routesDriftCheckerInstance)
+	go routesDriftCheckerInstance.run()
+}
+
+// Shutdown stops the checker, waiting for the running check.
+func (c *routesDriftChecker) Shutdown() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *routesDriftChecker) String() string {
+	return "app routes drift checker"
+}
+
+func (c *routesDriftChecker) run() {
+	defer close(c.done)
+	for {
+		err := c.runOnce()
+		if err != nil {
+			log.Errorf("%s %s", routesDriftLogTag, err)
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *routesDriftChecker) runOnce() error {
+	apps, err := List(nil)
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		err = c.check(&apps[i])
+		if err != nil {
+			log.Errorf("%s unable to check routes of app %s: %s", routesDriftLogTag, apps[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *routesDriftChecker) check(a *App) (err error) {
+	drifts, err := a.RoutesDrift()
+	if err == nil {
+		drifts, err = newRoutesDrifts(a.Name, drifts)
+	}
+	if err != nil || len(drifts) == 0 {
+		return err
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		InternalKind: routesDriftEventKind,
+		CustomData:   drifts,
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			log.Debugf("%s skipping locked app %s", routesDriftLogTag, a.Name)
+			return nil
+		}
+		return err
+	}
+	// The drift is computed again after locking the app, as a running
+	// operation may have changed its routes in the meantime.
+	a, err = GetByName(a.Name)
+	if err == nil {
+		drifts, err = a.RoutesDrift()
+	}
+	if err == nil {
+		drifts, err = newRoutesDrifts(a.Name, drifts)
+	}
+	if err != nil {
+		evt.Abort()
+		return err
+	}
+	if len(drifts) == 0 {
+		evt.Abort()
+		return nil
+	}
+	defer func() {
+		evt.DoneCustomData(err, drifts)
+		if saveErr := saveRoutesDrifts(drifts); saveErr != nil {
+			log.Errorf("%s unable to save drift of app %s: %s", routesDriftLogTag, a.Name, saveErr)
+		}
+	}()
+	for _, drift := range drifts {
+		evt.Logf("routes of app %q differ from router %q: missing routes %v, stale routes %v, missing cnames %v, stale cnames %v",
+			a.Name, drift.Router, drift.MissingRoutes, drift.StaleRoutes, drift.MissingCNames, drift.StaleCNames)
+	}
+	if !c.autoFix {
+		return nil
+	}
+	err = a.FixRoutesDrift(drifts)
+	if err != nil {
+		return err
+	}
+	for i := range drifts {
+		drifts[i].Fixed = true
+	}
+	evt.Logf("routes of app %q fixed", a.Name)
+	return nil
+}
+
+// newRoutesDrifts returns the drifts differing from the last drift recorded
+// for the same app and router, so that a drift is recorded only once while it
+// lasts. The recorded drifts of routers without drift are removed.
+func newRoutesDrifts(appName string, drifts []RoutesDrift) ([]RoutesDrift, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	coll := conn.Collection(routesDriftCollection)
+	routers := make([]string, len(drifts))
+	for i := range drifts {
+		routers[i] = drifts[i].Router
+	}
+	_, err = coll.RemoveAll(bson.M{"app": appName, "router": bson.M{"$nin": routers}})
+	if err != nil {
+		return nil, err
+	}
+	var result []RoutesDrift
+	for i := range drifts {
+		var last RoutesDrift
+		err = coll.Find(bson.M{"app": appName, "router": drifts[i].Router}).One(&last)
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		if err == mgo.ErrNotFound || !drifts[i].equal(&last) {
+			result = append(result, drifts[i])
+		}
+	}
+	return result, nil
+}
+
+// saveRoutesDrifts stores the recorded drifts. Fixed drifts are removed, so
+// that the same drift is recorded again if it comes back.
+func saveRoutesDrifts(drifts []RoutesDrift) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	coll := conn.Collection(routesDriftCollection)
+	for _, drift := range drifts {
+		query := bson.M{"app": drift.App, "router": drift.Router}
+		if drift.Fixed {
+			_, err = coll.RemoveAll(query)
+		} else {
+			_, err = coll.Upsert(query, drift)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
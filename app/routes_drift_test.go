@@ -0,0 +1,278 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestDiffHosts(c *check.C) {
+	missing, stale := diffHosts([]string{"b:1", "a:1", "c:1", "a:1"}, []string{"c:1", "d:1", "d:1"})
+	c.Assert(missing, check.DeepEquals, []string{"a:1", "b:1"})
+	c.Assert(stale, check.DeepEquals, []string{"d:1"})
+	missing, stale = diffHosts([]string{"a:1"}, []string{"a:1"})
+	c.Assert(missing, check.IsNil)
+	c.Assert(stale, check.IsNil)
+}
+
+func (s *S) TestAppRoutesDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	missing := units[1].Address.Host
+	drifts, err := a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.DeepEquals, []RoutesDrift{{
+		App:           a.Name,
+		Router:        "fake",
+		MissingRoutes: []string{missing},
+		StaleRoutes:   []string{"invalid:1234"},
+		MissingCNames: []string{"my.cname.com"},
+		StaleCNames:   []string{"old.cname.com"},
+	}})
+	c.Assert(drifts[0].HasDrift(), check.Equals, true)
+}
+
+func (s *S) TestAppRoutesDriftNoDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	drifts, err := a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 0)
+	drifts, err = FindRoutesDrift(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 0)
+}
+
+func (s *S) TestAppFixRoutesDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	missing := units[1].Address.Host
+	drifts, err := a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	err = a.FixRoutesDrift(drifts)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://"+missing), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://invalid:1234"), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasCName("my.cname.com"), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasCName("old.cname.com"), check.Equals, false)
+	drifts, err = a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 0)
+}
+
+func (s *S) TestAppRoutesDriftMultipleRouters(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddRouter("fake-hc")
+	c.Assert(err, check.IsNil)
+	drifts, err := a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 0)
+	routertest.HCRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	drifts, err = a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.DeepEquals, []RoutesDrift{
+		{App: a.Name, Router: "fake-hc", StaleRoutes: []string{"invalid:1234"}},
+	})
+}
+
+func (s *S) TestFindRoutesDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	other := App{Name: "other-app", Plan: Plan{Router: "fake"}}
+	err = s.conn.Apps().Insert(other)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&other)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&other, 1, "web", nil)
+	drifts, err := FindRoutesDrift(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 1)
+	c.Assert(drifts[0].App, check.Equals, a.Name)
+	drifts, err = FindRoutesDrift(&Filter{Name: other.Name})
+	c.Assert(err, check.IsNil)
+	c.Assert(drifts, check.HasLen, 0)
+}
+
+func (s *S) TestRoutesDriftCheckerRunOnce(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	missing := units[1].Address.Host
+	checker := &routesDriftChecker{interval: time.Minute}
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:       routesDriftEventKind,
+		LogMatches: `(?s).*routes of app "my-test-app" differ from router "fake": missing routes \[` + missing + `\], stale routes \[invalid:1234\].*`,
+	}, eventtest.HasEvent)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://invalid:1234"), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasCName("old.cname.com"), check.Equals, true)
+}
+
+func (s *S) TestRoutesDriftCheckerRunOnceAutoFix(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	missing := units[1].Address.Host
+	checker := &routesDriftChecker{interval: time.Minute, autoFix: true}
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:       routesDriftEventKind,
+		LogMatches: `(?s).*routes of app "my-test-app" fixed.*`,
+	}, eventtest.HasEvent)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://"+missing), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://invalid:1234"), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasCName("old.cname.com"), check.Equals, false)
+}
+
+func (s *S) TestRoutesDriftCheckerRunOnceRecordsChangedDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("my.cname.com")
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.RemoveRoute(a.Name, units[1].Address)
+	routertest.FakeRouter.AddRoute(a.Name, &url.URL{Scheme: "http", Host: "invalid:1234"})
+	routertest.FakeRouter.UnsetCName("my.cname.com", a.Name)
+	routertest.FakeRouter.SetCName("old.cname.com", a.Name)
+	checker := &routesDriftChecker{interval: time.Minute}
+	filter := &event.Filter{
+		Target:   event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		KindName: routesDriftEventKind,
+	}
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err := event.List(filter)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	routertest.FakeRouter.SetCName("other.cname.com", a.Name)
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err = event.List(filter)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 2)
+	drifts, err := a.RoutesDrift()
+	c.Assert(err, check.IsNil)
+	err = a.FixRoutesDrift(drifts)
+	c.Assert(err, check.IsNil)
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.SetCName("other.cname.com", a.Name)
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	evts, err = event.List(filter)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 3)
+}
+
+func (s *S) TestRoutesDriftCheckerRunOnceNoDrift(c *check.C) {
+	a := App{Name: "my-test-app", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	checker := &routesDriftChecker{interval: time.Minute}
+	err = checker.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:    routesDriftEventKind,
+		IsEmpty: true,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRoutesDriftInterval(c *check.C) {
+	c.Assert(routesDriftInterval(), check.Equals, defaultRoutesDriftInterval)
+	config.Set("routes-drift:run-interval", 60)
+	defer config.Unset("routes-drift:run-interval")
+	c.Assert(routesDriftInterval(), check.Equals, time.Minute)
+}
@@ -5,15 +5,86 @@
 package auth
 
 import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/permission"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+const defaultPersonalTokenExpiration = 30 * 24 * time.Hour
+
+var (
+	ErrPersonalTokenNotFound      = stderrors.New("personal token not found")
+	ErrPersonalTokenAlreadyExists = stderrors.New("a personal token with this name already exists")
+)
+
+// TokenScope is one of the permissions given to a personal access token.
+type TokenScope struct {
+	Permission string
+	Context    permission.PermissionContext
+}
+
+func (s TokenScope) String() string {
+	value := s.Context.Value
+	if value != "" {
+		value = " " + value
+	}
+	return fmt.Sprintf("%s(%s%s)", s.Permission, s.Context.CtxType, value)
+}
+
+// ParseTokenScope parses a scope in the form <permission>[:<context
+// type>[:<context value>]], e.g. app.deploy:app:myapp. When omitted, the
+// context type is global.
+func ParseTokenScope(value string) (TokenScope, error) {
+	parts := strings.SplitN(value, ":", 3)
+	scope := TokenScope{Permission: parts[0]}
+	ctxType := string(permission.CtxGlobal)
+	if len(parts) > 1 {
+		ctxType = parts[1]
+	}
+	var err error
+	scope.Context.CtxType, err = permission.ParseContext(ctxType)
+	if err != nil {
+		return scope, &errors.ValidationError{Message: err.Error()}
+	}
+	if len(parts) > 2 {
+		scope.Context.Value = parts[2]
+	}
+	if (scope.Context.CtxType == permission.CtxGlobal) != (scope.Context.Value == "") {
+		return scope, &errors.ValidationError{Message: fmt.Sprintf("invalid context value in token scope %q", value)}
+	}
+	scheme, err := permission.SafeGet(scope.Permission)
+	if err != nil {
+		return scope, &errors.ValidationError{Message: err.Error()}
+	}
+	for _, ctxType := range scheme.AllowedContexts() {
+		if ctxType == scope.Context.CtxType {
+			return scope, nil
+		}
+	}
+	return scope, &errors.ValidationError{
+		Message: fmt.Sprintf("permission %q not allowed with context of type %q", scope.Permission, scope.Context.CtxType),
+	}
+}
+
+// APIToken is either the single API key of a user or one of its named
+// personal access tokens. Personal tokens expire and are limited to the
+// permissions in their scopes.
 type APIToken struct {
-	Token     string `json:"token" bson:"apikey"`
-	UserEmail string `json:"email" bson:"email"`
+	Token      string       `json:"token" bson:"apikey"`
+	UserEmail  string       `json:"email" bson:"email"`
+	Name       string       `json:"name,omitempty" bson:"name,omitempty"`
+	Scopes     []TokenScope `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt,omitempty" bson:"createdat,omitempty"`
+	ExpiresAt  time.Time    `json:"expiresAt,omitempty" bson:"expiresat,omitempty"`
+	LastUsedAt time.Time    `json:"lastUsedAt,omitempty" bson:"lastusedat,omitempty"`
+	UserType   string       `json:"-" bson:"type,omitempty"`
 }
 
 func (t *APIToken) GetValue() string {
@@ -36,10 +107,192 @@ func (t *APIToken) GetAppName() string {
 	return ""
 }
 
+func (t *APIToken) IsPersonal() bool {
+	return t.Name != ""
+}
+
+func (t *APIToken) IsServiceAccount() bool {
+	return t.UserType == UserTypeServiceAccount
+}
+
 func (t *APIToken) Permissions() ([]permission.Permission, error) {
-	// TODO(cezarsa): Allow creation of api tokens with a subset of user's
-	// permissions.
-	return BaseTokenPermission(t)
+	if !t.IsPersonal() {
+		return BaseTokenPermission(t)
+	}
+	user, err := t.User()
+	if err != nil {
+		return nil, err
+	}
+	userPerms, err := user.Permissions()
+	if err != nil {
+		return nil, err
+	}
+	return scopedPermissions(t.Scopes, userPerms)
+}
+
+// scopedPermissions returns the intersection between the token scopes and
+// the permissions of the user. As users usually get permissions through team
+// roles, a scope in the context of an app is also covered by permissions in
+// the context of the app teams or pool.
+func scopedPermissions(scopes []TokenScope, userPerms []permission.Permission) ([]permission.Permission, error) {
+	var result []permission.Permission
+	for _, scope := range scopes {
+		scheme, err := permission.SafeGet(scope.Permission)
+		if err != nil {
+			// permission schemes might be removed or renamed, invalid
+			// scopes shouldn't be a problem.
+			continue
+		}
+		covering, err := coveringContexts(scope.Context)
+		if err != nil {
+			return nil, err
+		}
+		for _, perm := range userPerms {
+			narrower := perm.Scheme
+			if !scheme.IsParent(perm.Scheme) {
+				if !perm.Scheme.IsParent(scheme) {
+					continue
+				}
+				narrower = scheme
+			}
+			if scope.Context.CtxType == permission.CtxGlobal {
+				result = append(result, permission.Permission{Scheme: narrower, Context: perm.Context})
+				continue
+			}
+			for _, ctx := range covering {
+				if perm.Context == ctx {
+					result = append(result, permission.Permission{Scheme: narrower, Context: scope.Context})
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func coveringContexts(ctx permission.PermissionContext) ([]permission.PermissionContext, error) {
+	contexts := []permission.PermissionContext{permission.Context(permission.CtxGlobal, ""), ctx}
+	if ctx.CtxType != permission.CtxApp {
+		return contexts, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var appData struct {
+		Teams []string
+		Pool  string
+	}
+	err = conn.Apps().Find(bson.M{"name": ctx.Value}).Select(bson.M{"teams": 1, "pool": 1}).One(&appData)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return contexts, nil
+		}
+		return nil, err
+	}
+	contexts = append(contexts, permission.Contexts(permission.CtxTeam, appData.Teams)...)
+	if appData.Pool != "" {
+		contexts = append(contexts, permission.Context(permission.CtxPool, appData.Pool))
+	}
+	return contexts, nil
+}
+
+// CreatePersonalToken creates a named token for the user, limited to the
+// given scopes and valid for the given duration. A zero duration means the
+// default expiration of 30 days.
+func CreatePersonalToken(u *User, name string, scopes []TokenScope, expiration time.Duration) (*APIToken, error) {
+	if name == "" {
+		return nil, &errors.ValidationError{Message: "personal token name is required"}
+	}
+	if len(scopes) == 0 {
+		return nil, &errors.ValidationError{Message: "personal token must have at least one scope"}
+	}
+	if expiration < 0 {
+		return nil, &errors.ValidationError{Message: "personal token expiration must be positive"}
+	}
+	if expiration == 0 {
+		expiration = defaultPersonalTokenExpiration
+	}
+	userPerms, err := u.Permissions()
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		perms, err := scopedPermissions([]TokenScope{scope}, userPerms)
+		if err != nil {
+			return nil, err
+		}
+		if len(perms) == 0 {
+			return nil, &errors.ValidationError{Message: fmt.Sprintf("user does not have permission %s", scope)}
+		}
+	}
+	value, err := generateAPIKey(u.Email)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	token := APIToken{
+		Token:     value,
+		UserEmail: u.Email,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiration),
+		UserType:  u.Type,
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.PersonalTokens().Insert(token)
+	if err != nil {
+		if mgo.IsDup(err) {
+			return nil, ErrPersonalTokenAlreadyExists
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ListPersonalTokens returns the personal tokens of the user, omitting their
+// values.
+func ListPersonalTokens(email string) ([]APIToken, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var tokens []APIToken
+	err = conn.PersonalTokens().Find(bson.M{"email": email}).Select(bson.M{"apikey": 0}).Sort("name").All(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func RevokePersonalToken(email, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.PersonalTokens().Remove(bson.M{"email": email, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrPersonalTokenNotFound
+	}
+	return err
+}
+
+func removePersonalTokens(email string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.PersonalTokens().RemoveAll(bson.M{"email": email})
+	return err
 }
 
 func getAPIToken(header string) (*APIToken, error) {
@@ -54,12 +307,28 @@ func getAPIToken(header string) (*APIToken, error) {
 		return nil, err
 	}
 	err = conn.Users().Find(bson.M{"apikey": token}).One(&t)
+	if err == nil {
+		return &t, nil
+	}
+	if err != mgo.ErrNotFound {
+		return nil, err
+	}
+	err = conn.PersonalTokens().Find(bson.M{"apikey": token}).One(&t)
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return nil, ErrInvalidToken
 		}
 		return nil, err
 	}
+	now := time.Now().UTC()
+	if !now.Before(t.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	t.LastUsedAt = now
+	err = conn.PersonalTokens().Update(bson.M{"apikey": token}, bson.M{"$set": bson.M{"lastusedat": now}})
+	if err != nil {
+		return nil, err
+	}
 	return &t, nil
 }
 
@@ -4,7 +4,14 @@
 
 package auth
 
-import "gopkg.in/check.v1"
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
 
 func (s *S) TestGetAPIToken(c *check.C) {
 	user := User{Email: "para@xmen.com", APIKey: "Quenço"}
@@ -36,3 +43,160 @@ func (s *S) TestGetAPITokenInvalid(c *check.C) {
 	c.Assert(t, check.IsNil)
 	c.Assert(err, check.Equals, ErrInvalidToken)
 }
+
+func (s *S) createRole(c *check.C, name, ctxType string, perms ...string) {
+	role, err := permission.NewRole(name, ctxType, "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions(perms...)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestParseTokenScope(c *check.C) {
+	scope, err := ParseTokenScope("app.deploy:app:myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(scope, check.DeepEquals, TokenScope{
+		Permission: "app.deploy",
+		Context:    permission.Context(permission.CtxApp, "myapp"),
+	})
+	c.Assert(scope.String(), check.Equals, "app.deploy(app myapp)")
+	scope, err = ParseTokenScope("app.read")
+	c.Assert(err, check.IsNil)
+	c.Assert(scope.Context, check.DeepEquals, permission.Context(permission.CtxGlobal, ""))
+	invalid := []string{"app.explode", "app.deploy:planet:x", "app.deploy:app", "app.deploy:global:x", "app.create:app:myapp"}
+	for _, value := range invalid {
+		_, err = ParseTokenScope(value)
+		c.Check(err, check.FitsTypeOf, &errors.ValidationError{}, check.Commentf("scope %q", value))
+	}
+}
+
+func (s *S) TestCreatePersonalToken(c *check.C) {
+	s.createRole(c, "deployer", "team", "app.deploy", "app.read")
+	err := s.user.AddRole("deployer", s.team.Name)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Insert(bson.M{"name": "myapp", "teams": []string{s.team.Name}, "pool": "pool1"})
+	c.Assert(err, check.IsNil)
+	scope := TokenScope{Permission: "app.deploy", Context: permission.Context(permission.CtxApp, "myapp")}
+	token, err := CreatePersonalToken(s.user, "ci", []TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.Token, check.Not(check.Equals), "")
+	c.Assert(token.IsPersonal(), check.Equals, true)
+	c.Assert(token.ExpiresAt.Sub(token.CreatedAt), check.Equals, time.Hour)
+	dbToken, err := getAPIToken("bearer " + token.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbToken.Name, check.Equals, "ci")
+	c.Assert(dbToken.UserEmail, check.Equals, s.user.Email)
+	perms, err := dbToken.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+	c.Assert(permission.Check(dbToken, permission.PermAppDeploy, permission.Context(permission.CtxApp, "myapp")), check.Equals, true)
+	c.Assert(permission.Check(dbToken, permission.PermAppDeploy, permission.Context(permission.CtxApp, "otherapp")), check.Equals, false)
+	c.Assert(permission.Check(dbToken, permission.PermAppRead, permission.Context(permission.CtxApp, "myapp")), check.Equals, false)
+	_, err = CreatePersonalToken(s.user, "ci", []TokenScope{scope}, time.Hour)
+	c.Assert(err, check.Equals, ErrPersonalTokenAlreadyExists)
+}
+
+func (s *S) TestCreatePersonalTokenDefaultExpiration(c *check.C) {
+	s.createRole(c, "reader", "global", "app.read")
+	err := s.user.AddRole("reader", "")
+	c.Assert(err, check.IsNil)
+	scope := TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := CreatePersonalToken(s.user, "reader", []TokenScope{scope}, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.ExpiresAt.Sub(token.CreatedAt), check.Equals, defaultPersonalTokenExpiration)
+}
+
+func (s *S) TestCreatePersonalTokenInvalid(c *check.C) {
+	s.createRole(c, "reader", "global", "app.read")
+	err := s.user.AddRole("reader", "")
+	c.Assert(err, check.IsNil)
+	scope := TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	_, err = CreatePersonalToken(s.user, "", []TokenScope{scope}, time.Hour)
+	c.Assert(err, check.ErrorMatches, "personal token name is required")
+	_, err = CreatePersonalToken(s.user, "t", nil, time.Hour)
+	c.Assert(err, check.ErrorMatches, "personal token must have at least one scope")
+	_, err = CreatePersonalToken(s.user, "t", []TokenScope{scope}, -time.Hour)
+	c.Assert(err, check.ErrorMatches, "personal token expiration must be positive")
+	deploy := TokenScope{Permission: "app.deploy", Context: permission.Context(permission.CtxGlobal, "")}
+	_, err = CreatePersonalToken(s.user, "t", []TokenScope{deploy}, time.Hour)
+	c.Assert(err, check.ErrorMatches, `user does not have permission app.deploy\(global\)`)
+}
+
+func (s *S) TestPersonalTokenGlobalScopeKeepsUserContexts(c *check.C) {
+	s.createRole(c, "team-admin", "team", "app")
+	err := s.user.AddRole("team-admin", s.team.Name)
+	c.Assert(err, check.IsNil)
+	scope := TokenScope{Permission: "app.deploy", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := CreatePersonalToken(s.user, "deploy-all", []TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	perms, err := token.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxTeam, s.team.Name)},
+	})
+}
+
+func (s *S) TestPersonalTokenPermissionsFollowUserRoles(c *check.C) {
+	s.createRole(c, "reader", "global", "app.read")
+	err := s.user.AddRole("reader", "")
+	c.Assert(err, check.IsNil)
+	scope := TokenScope{Permission: "app.read", Context: permission.Context(permission.CtxGlobal, "")}
+	token, err := CreatePersonalToken(s.user, "reader", []TokenScope{scope}, time.Hour)
+	c.Assert(err, check.IsNil)
+	err = s.user.RemoveRole("reader", "")
+	c.Assert(err, check.IsNil)
+	perms, err := token.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.HasLen, 0)
+}
+
+func (s *S) TestGetAPITokenPersonalTokenExpired(c *check.C) {
+	err := s.conn.PersonalTokens().Insert(APIToken{
+		Token:     "expired-token",
+		UserEmail: s.user.Email,
+		Name:      "old",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	c.Assert(err, check.IsNil)
+	t, err := getAPIToken("bearer expired-token")
+	c.Assert(t, check.IsNil)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestGetAPITokenPersonalTokenUpdatesLastUsed(c *check.C) {
+	err := s.conn.PersonalTokens().Insert(APIToken{
+		Token:     "my-token",
+		UserEmail: s.user.Email,
+		Name:      "mine",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	c.Assert(err, check.IsNil)
+	before := time.Now().UTC().Add(-time.Second)
+	t, err := getAPIToken("bearer my-token")
+	c.Assert(err, check.IsNil)
+	c.Assert(t.LastUsedAt.After(before), check.Equals, true)
+	tokens, err := ListPersonalTokens(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0].Token, check.Equals, "")
+	c.Assert(tokens[0].LastUsedAt.After(before), check.Equals, true)
+}
+
+func (s *S) TestRevokePersonalToken(c *check.C) {
+	err := s.conn.PersonalTokens().Insert(APIToken{
+		Token:     "my-token",
+		UserEmail: s.user.Email,
+		Name:      "mine",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	c.Assert(err, check.IsNil)
+	err = RevokePersonalToken("other@example.com", "mine")
+	c.Assert(err, check.Equals, ErrPersonalTokenNotFound)
+	err = RevokePersonalToken(s.user.Email, "mine")
+	c.Assert(err, check.IsNil)
+	_, err = getAPIToken("bearer my-token")
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	err = RevokePersonalToken(s.user.Email, "mine")
+	c.Assert(err, check.Equals, ErrPersonalTokenNotFound)
+}
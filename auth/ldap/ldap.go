@@ -0,0 +1,405 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/auth/native"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	goldap "gopkg.in/ldap.v2"
+)
+
+const (
+	defaultUserFilter     = "(uid=%s)"
+	defaultEmailAttribute = "mail"
+	defaultGroupAttribute = "memberOf"
+	defaultTimeout        = 10 * time.Second
+)
+
+var (
+	ErrMissingUsernameError = &errors.ValidationError{Message: "you must provide a username to login"}
+	ErrMissingPasswordError = &errors.ValidationError{Message: "you must provide a password to login"}
+)
+
+// GroupMapping maps members of an LDAP group to a set of tsuru roles. When
+// Team is set, the roles are given with the team as context value, making
+// the group members part of the team.
+type GroupMapping struct {
+	Group        string
+	Team         string
+	Roles        []string
+	ContextValue string
+}
+
+func (m *GroupMapping) contextValue() string {
+	if m.ContextValue != "" {
+		return m.ContextValue
+	}
+	return m.Team
+}
+
+type BaseConfig struct {
+	URL            string
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string
+	EmailAttribute string
+	GroupAttribute string
+	GroupMappings  []GroupMapping
+	Timeout        time.Duration
+	StartTLS       bool
+}
+
+type LDAPScheme struct {
+	BaseConfig BaseConfig
+}
+
+func init() {
+	auth.RegisterScheme("ldap", &LDAPScheme{})
+}
+
+func (s *LDAPScheme) loadConfig() (BaseConfig, error) {
+	if s.BaseConfig.URL != "" {
+		return s.BaseConfig, nil
+	}
+	var emptyConfig BaseConfig
+	url, err := config.GetString("auth:ldap:url")
+	if err != nil {
+		return emptyConfig, err
+	}
+	baseDN, err := config.GetString("auth:ldap:base-dn")
+	if err != nil {
+		return emptyConfig, err
+	}
+	conf := BaseConfig{URL: url, BaseDN: baseDN}
+	conf.BindDN, _ = config.GetString("auth:ldap:bind-dn")
+	conf.BindPassword, _ = config.GetString("auth:ldap:bind-password")
+	conf.UserFilter, _ = config.GetString("auth:ldap:user-filter")
+	if conf.UserFilter == "" {
+		conf.UserFilter = defaultUserFilter
+	}
+	if strings.Count(conf.UserFilter, "%s") != 1 {
+		return emptyConfig, fmt.Errorf("auth:ldap:user-filter must contain exactly one %%s, got %q", conf.UserFilter)
+	}
+	if _, err = goldap.CompileFilter(fmt.Sprintf(conf.UserFilter, "user")); err != nil {
+		return emptyConfig, fmt.Errorf("invalid auth:ldap:user-filter %q: %s", conf.UserFilter, err)
+	}
+	conf.EmailAttribute, _ = config.GetString("auth:ldap:email-attribute")
+	if conf.EmailAttribute == "" {
+		conf.EmailAttribute = defaultEmailAttribute
+	}
+	conf.GroupAttribute, _ = config.GetString("auth:ldap:group-attribute")
+	if conf.GroupAttribute == "" {
+		conf.GroupAttribute = defaultGroupAttribute
+	}
+	conf.Timeout = defaultTimeout
+	if seconds, err := config.GetInt("auth:ldap:timeout"); err == nil {
+		conf.Timeout = time.Duration(seconds) * time.Second
+	}
+	conf.StartTLS, _ = config.GetBool("auth:ldap:start-tls")
+	rawMappings, _ := config.Get("auth:ldap:group-mappings")
+	mappings, _ := rawMappings.([]interface{})
+	for _, rawMapping := range mappings {
+		mapping, err := parseGroupMapping(rawMapping)
+		if err != nil {
+			return emptyConfig, err
+		}
+		conf.GroupMappings = append(conf.GroupMappings, mapping)
+	}
+	s.BaseConfig = conf
+	return s.BaseConfig, nil
+}
+
+func parseGroupMapping(raw interface{}) (GroupMapping, error) {
+	entry := map[string]interface{}{}
+	switch data := raw.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range data {
+			entry[fmt.Sprint(k)] = v
+		}
+	case map[string]interface{}:
+		entry = data
+	}
+	var mapping GroupMapping
+	mapping.Group, _ = entry["group"].(string)
+	mapping.Team, _ = entry["team"].(string)
+	mapping.ContextValue, _ = entry["context-value"].(string)
+	roles, _ := entry["roles"].([]interface{})
+	for _, role := range roles {
+		if roleName, ok := role.(string); ok {
+			mapping.Roles = append(mapping.Roles, roleName)
+		}
+	}
+	if mapping.Group == "" {
+		return mapping, fmt.Errorf("invalid auth:ldap:group-mappings entry, group is required: %v", raw)
+	}
+	return mapping, nil
+}
+
+func (s *LDAPScheme) Login(params map[string]string) (auth.Token, error) {
+	conf, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	username := params["email"]
+	if username == "" {
+		return nil, ErrMissingUsernameError
+	}
+	// An empty password would result in an unauthenticated bind, which most
+	// servers accept regardless of the given DN.
+	password := params["password"]
+	if password == "" {
+		return nil, ErrMissingPasswordError
+	}
+	entry, err := s.authenticate(conf, username, password)
+	if err != nil {
+		return nil, err
+	}
+	email := username
+	if values := attributeValues(entry, conf.EmailAttribute); len(values) > 0 {
+		email = values[0]
+	}
+	if !strings.Contains(email, "@") {
+		return nil, auth.AuthenticationFailure{
+			Message: fmt.Sprintf("LDAP entry %q has no %s attribute", entry.DN, conf.EmailAttribute),
+		}
+	}
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		if err != auth.ErrUserNotFound {
+			return nil, err
+		}
+		user = &auth.User{Email: email}
+		err = user.Create()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = syncRoles(user, conf.GroupMappings, attributeValues(entry, conf.GroupAttribute))
+	if err != nil {
+		return nil, err
+	}
+	return createToken(user)
+}
+
+// dial connects to the LDAP server in the given url, upgrading the
+// connection with StartTLS when requested. The timeout applies to the whole
+// conversation with the server.
+func dial(conf BaseConfig) (*goldap.Conn, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if _, _, err = net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = net.DialTimeout("tcp", host, conf.Timeout)
+	case "ldaps":
+		if conf.StartTLS {
+			return nil, fmt.Errorf("invalid LDAP url %q: StartTLS requires the ldap scheme", conf.URL)
+		}
+		if _, _, err = net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "636")
+		}
+		dialer := &net.Dialer{Timeout: conf.Timeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, nil)
+	default:
+		return nil, fmt.Errorf("invalid LDAP url %q: scheme must be ldap or ldaps", conf.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(conf.Timeout))
+	ldapConn := goldap.NewConn(conn, u.Scheme == "ldaps")
+	ldapConn.SetTimeout(conf.Timeout)
+	ldapConn.Start()
+	if conf.StartTLS {
+		err = ldapConn.StartTLS(&tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			ldapConn.Close()
+			return nil, fmt.Errorf("unable to start TLS with LDAP server: %s", err)
+		}
+	}
+	return ldapConn, nil
+}
+
+// attributeValues returns the values of the given attribute in the entry,
+// matching its name case insensitively as LDAP servers may return it with a
+// different case than the requested one.
+func attributeValues(entry *goldap.Entry, name string) []string {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			return attr.Values
+		}
+	}
+	return nil
+}
+
+// authenticate looks up the entry of the given user in the directory and
+// binds as it, checking the password.
+func (s *LDAPScheme) authenticate(conf BaseConfig, username, password string) (*goldap.Entry, error) {
+	conn, err := dial(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if conf.BindDN != "" {
+		err = conn.Bind(conf.BindDN, conf.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("unable to bind to LDAP server as %q: %s", conf.BindDN, err)
+		}
+	}
+	request := goldap.NewSearchRequest(
+		conf.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(conf.UserFilter, goldap.EscapeFilter(username)),
+		[]string{conf.EmailAttribute, conf.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		if len(result.Entries) > 1 {
+			log.Errorf("[ldap] multiple entries found for %q, refusing to login", username)
+		}
+		return nil, auth.AuthenticationFailure{Message: "invalid username or password"}
+	}
+	entry := result.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, auth.AuthenticationFailure{Message: "invalid username or password"}
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+type roleInstance struct {
+	name         string
+	contextValue string
+}
+
+// syncRoles gives the user the roles mapped from its groups and removes the
+// mapped roles from groups the user is no longer a member of. Roles not
+// present in any mapping are left untouched.
+func syncRoles(user *auth.User, mappings []GroupMapping, groups []string) error {
+	managed := map[roleInstance]bool{}
+	desired := map[roleInstance]bool{}
+	var toAdd []roleInstance
+	for _, mapping := range mappings {
+		if mapping.Team != "" {
+			_, err := auth.GetTeam(mapping.Team)
+			if err != nil {
+				log.Errorf("[ldap] ignoring mapping for group %q: unable to find team %q: %s", mapping.Group, mapping.Team, err)
+				continue
+			}
+		}
+		member := false
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) {
+				member = true
+				break
+			}
+		}
+		for _, role := range mapping.Roles {
+			instance := roleInstance{name: role, contextValue: mapping.contextValue()}
+			managed[instance] = true
+			if member && !desired[instance] {
+				desired[instance] = true
+				toAdd = append(toAdd, instance)
+			}
+		}
+	}
+	current := map[roleInstance]bool{}
+	roles := append([]auth.RoleInstance{}, user.Roles...)
+	for _, role := range roles {
+		instance := roleInstance{name: role.Name, contextValue: role.ContextValue}
+		current[instance] = true
+		if managed[instance] && !desired[instance] {
+			err := user.RemoveRole(role.Name, role.ContextValue)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, instance := range toAdd {
+		if current[instance] {
+			continue
+		}
+		err := user.AddRole(instance.name, instance.contextValue)
+		if err != nil {
+			log.Errorf("[ldap] unable to add role %q(%q) to user %q: %s", instance.name, instance.contextValue, user.Email, err)
+		}
+	}
+	return nil
+}
+
+func (s *LDAPScheme) AppLogin(appName string) (auth.Token, error) {
+	nativeScheme := native.NativeScheme{}
+	return nativeScheme.AppLogin(appName)
+}
+
+func (s *LDAPScheme) AppLogout(token string) error {
+	nativeScheme := native.NativeScheme{}
+	return nativeScheme.AppLogout(token)
+}
+
+func (s *LDAPScheme) Logout(token string) error {
+	return deleteToken(token)
+}
+
+func (s *LDAPScheme) Auth(header string) (auth.Token, error) {
+	token, err := getToken(header)
+	if err != nil {
+		nativeScheme := native.NativeScheme{}
+		token, nativeErr := nativeScheme.Auth(header)
+		if nativeErr == nil && token.IsAppToken() {
+			return token, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *LDAPScheme) Name() string {
+	return "ldap"
+}
+
+func (s *LDAPScheme) Info() (auth.SchemeInfo, error) {
+	return nil, nil
+}
+
+func (s *LDAPScheme) Create(user *auth.User) (*auth.User, error) {
+	user.Password = ""
+	err := user.Create()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *LDAPScheme) Remove(u *auth.User) error {
+	err := deleteAllTokens(u.Email)
+	if err != nil {
+		return err
+	}
+	return u.Delete()
+}
@@ -0,0 +1,270 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) addPerson(uid string, groups ...string) {
+	s.server.addEntry("uid="+uid+",ou=people,dc=example,dc=com", "123456", map[string][]string{
+		"uid":      {uid},
+		"mail":     {uid + "@example.com"},
+		"memberOf": groups,
+	})
+}
+
+func (s *S) TestLDAPLoginCreatesUser(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, "john@example.com")
+	c.Assert(token.IsAppToken(), check.Equals, false)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Email, check.Equals, "john@example.com")
+	c.Assert(u.Password, check.Equals, "")
+	c.Assert(s.server.binds, check.DeepEquals, []string{
+		"cn=tsuru,ou=services,dc=example,dc=com",
+		"uid=john,ou=people,dc=example,dc=com",
+	})
+	dbToken, err := getToken("bearer " + token.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(dbToken.UserEmail, check.Equals, "john@example.com")
+}
+
+func (s *S) TestLDAPLoginExistingUser(c *check.C) {
+	s.addPerson("john")
+	u := &auth.User{Email: "john@example.com"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, "john@example.com")
+	users, err := auth.ListUsers()
+	c.Assert(err, check.IsNil)
+	c.Assert(users, check.HasLen, 1)
+}
+
+func (s *S) TestLDAPLoginInvalidPassword(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "john", "password": "wrong"})
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	_, err = auth.GetUserByEmail("john@example.com")
+	c.Assert(err, check.Equals, auth.ErrUserNotFound)
+}
+
+func (s *S) TestLDAPLoginUnknownUser(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "nobody", "password": "123456"})
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+}
+
+func (s *S) TestLDAPLoginEscapesUsername(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"email": "*", "password": "123456"})
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	c.Assert(s.server.filters, check.HasLen, 1)
+	c.Assert(s.server.filters[0], check.Equals, `(uid=\2a)`)
+}
+
+func (s *S) TestLDAPLoginMissingParams(c *check.C) {
+	scheme := LDAPScheme{}
+	_, err := scheme.Login(map[string]string{"password": "123456"})
+	c.Assert(err, check.Equals, ErrMissingUsernameError)
+	_, err = scheme.Login(map[string]string{"email": "john"})
+	c.Assert(err, check.Equals, ErrMissingPasswordError)
+	c.Assert(s.server.binds, check.HasLen, 0)
+}
+
+func (s *S) TestLDAPLoginMapsGroupsToRoles(c *check.C) {
+	_, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("admin", "global", "")
+	c.Assert(err, check.IsNil)
+	_, err = permission.NewRole("other", "global", "")
+	c.Assert(err, check.IsNil)
+	err = s.conn.Teams().Insert(auth.Team{Name: "developers"})
+	c.Assert(err, check.IsNil)
+	s.addPerson("john", "cn=developers,ou=groups,dc=example,dc=com", "CN=Admins,OU=Groups,DC=example,DC=com")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 2)
+	c.Assert(u.Roles, check.DeepEquals, []auth.RoleInstance{
+		{Name: "team-member", ContextValue: "developers"},
+		{Name: "admin", ContextValue: ""},
+	})
+	err = u.AddRole("other", "")
+	c.Assert(err, check.IsNil)
+	s.addPerson("john", "cn=developers,ou=groups,dc=example,dc=com")
+	token, err = scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	u, err = token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.DeepEquals, []auth.RoleInstance{
+		{Name: "team-member", ContextValue: "developers"},
+		{Name: "other", ContextValue: ""},
+	})
+}
+
+func (s *S) TestLDAPLoginIgnoresMappingsForMissingTeams(c *check.C) {
+	_, err := permission.NewRole("team-member", "team", "")
+	c.Assert(err, check.IsNil)
+	s.addPerson("john", "cn=developers,ou=groups,dc=example,dc=com")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 0)
+}
+
+func (s *S) TestLDAPAuth(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	authToken, err := scheme.Auth("bearer " + token.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(authToken.GetUserName(), check.Equals, "john@example.com")
+	_, err = scheme.Auth("bearer invalid")
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+}
+
+func (s *S) TestLDAPAppLogin(c *check.C) {
+	scheme := LDAPScheme{}
+	token, err := scheme.AppLogin("myApp")
+	c.Assert(err, check.IsNil)
+	c.Assert(token.IsAppToken(), check.Equals, true)
+	authToken, err := scheme.Auth("bearer " + token.GetValue())
+	c.Assert(err, check.IsNil)
+	c.Assert(authToken.GetAppName(), check.Equals, "myApp")
+}
+
+func (s *S) TestLDAPLogout(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	err = scheme.Logout(token.GetValue())
+	c.Assert(err, check.IsNil)
+	_, err = scheme.Auth("bearer " + token.GetValue())
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+}
+
+func (s *S) TestLDAPName(c *check.C) {
+	scheme := LDAPScheme{}
+	c.Assert(scheme.Name(), check.Equals, "ldap")
+}
+
+func (s *S) TestLDAPInfo(c *check.C) {
+	scheme := LDAPScheme{}
+	info, err := scheme.Info()
+	c.Assert(err, check.IsNil)
+	c.Assert(info, check.IsNil)
+}
+
+func (s *S) TestLDAPCreate(c *check.C) {
+	scheme := LDAPScheme{}
+	user := auth.User{Email: "x@x.com", Password: "something"}
+	created, err := scheme.Create(&user)
+	c.Assert(err, check.IsNil)
+	c.Assert(created.Password, check.Equals, "")
+	dbUser, err := auth.GetUserByEmail(user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbUser.Email, check.Equals, "x@x.com")
+}
+
+func (s *S) TestLDAPRemove(c *check.C) {
+	s.addPerson("john")
+	scheme := LDAPScheme{}
+	token, err := scheme.Login(map[string]string{"email": "john", "password": "123456"})
+	c.Assert(err, check.IsNil)
+	u, err := token.User()
+	c.Assert(err, check.IsNil)
+	err = scheme.Remove(u)
+	c.Assert(err, check.IsNil)
+	_, err = auth.GetUserByEmail("john@example.com")
+	c.Assert(err, check.Equals, auth.ErrUserNotFound)
+}
+
+func (s *ClientSuite) TestAuthenticate(c *check.C) {
+	s.server.addEntry("cn=tsuru,dc=example,dc=com", "secret", nil)
+	s.server.addEntry("uid=john,ou=people,dc=example,dc=com", "123456", map[string][]string{
+		"uid":  {"john"},
+		"mail": {"john@example.com"},
+	})
+	conf := BaseConfig{
+		URL:            s.server.url(),
+		BindDN:         "cn=tsuru,dc=example,dc=com",
+		BindPassword:   "secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(&(uid=%s)(mail=*))",
+		EmailAttribute: "mail",
+		Timeout:        time.Second,
+	}
+	scheme := LDAPScheme{BaseConfig: conf}
+	entry, err := scheme.authenticate(conf, "john", "123456")
+	c.Assert(err, check.IsNil)
+	c.Assert(entry.DN, check.Equals, "uid=john,ou=people,dc=example,dc=com")
+	c.Assert(attributeValues(entry, "Mail"), check.DeepEquals, []string{"john@example.com"})
+	_, err = scheme.authenticate(conf, "john", "wrong")
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	_, err = scheme.authenticate(conf, "mary", "123456")
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+	conf.BindPassword = "wrong"
+	_, err = scheme.authenticate(conf, "john", "123456")
+	c.Assert(err, check.ErrorMatches, `unable to bind to LDAP server as "cn=tsuru,dc=example,dc=com": LDAP Result Code 49 .*`)
+}
+
+func (s *ClientSuite) TestAuthenticateStartTLS(c *check.C) {
+	conf := BaseConfig{
+		URL:        s.server.url(),
+		BaseDN:     "ou=people,dc=example,dc=com",
+		UserFilter: "(uid=%s)",
+		Timeout:    time.Second,
+		StartTLS:   true,
+	}
+	scheme := LDAPScheme{BaseConfig: conf}
+	_, err := scheme.authenticate(conf, "john", "123456")
+	c.Assert(err, check.ErrorMatches, `unable to start TLS with LDAP server: LDAP Result Code 52 .*`)
+	c.Assert(s.server.startTLS, check.Equals, 1)
+	c.Assert(s.server.binds, check.HasLen, 0)
+}
+
+func (s *ClientSuite) TestDialInvalidURL(c *check.C) {
+	_, err := dial(BaseConfig{URL: "http://localhost", Timeout: time.Second})
+	c.Assert(err, check.ErrorMatches, `invalid LDAP url "http://localhost": scheme must be ldap or ldaps`)
+	_, err = dial(BaseConfig{URL: "ldaps://localhost", Timeout: time.Second, StartTLS: true})
+	c.Assert(err, check.ErrorMatches, `invalid LDAP url "ldaps://localhost": StartTLS requires the ldap scheme`)
+}
+
+func (s *ClientSuite) TestParseGroupMapping(c *check.C) {
+	mapping, err := parseGroupMapping(map[interface{}]interface{}{
+		"group": "cn=devs,dc=example,dc=com",
+		"team":  "devs",
+		"roles": []interface{}{"team-member", "deployer"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(mapping, check.DeepEquals, GroupMapping{
+		Group: "cn=devs,dc=example,dc=com",
+		Team:  "devs",
+		Roles: []string{"team-member", "deployer"},
+	})
+	c.Assert(mapping.contextValue(), check.Equals, "devs")
+	_, err = parseGroupMapping(map[interface{}]interface{}{"team": "devs"})
+	c.Assert(err, check.NotNil)
+}
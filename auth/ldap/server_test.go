@@ -0,0 +1,209 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "gopkg.in/asn1-ber.v1"
+	goldap "gopkg.in/ldap.v2"
+)
+
+type fakeEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// fakeServer is an in-process LDAP server, understanding just enough of the
+// protocol to exercise the scheme: simple binds and searches with and, or,
+// not, equality and presence filters. StartTLS requests are recorded and
+// refused.
+type fakeServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	entries  map[string]fakeEntry
+	binds    []string
+	filters  []string
+	startTLS int
+}
+
+func newFakeServer() (*fakeServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeServer{listener: listener, entries: map[string]fakeEntry{}}
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) addEntry(dn, password string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lowered := map[string][]string{}
+	for k, v := range attributes {
+		lowered[strings.ToLower(k)] = v
+	}
+	s.entries[dn] = fakeEntry{password: password, attributes: lowered}
+}
+
+func (s *fakeServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = map[string]fakeEntry{}
+	s.binds = nil
+	s.filters = nil
+	s.startTLS = 0
+}
+
+func (s *fakeServer) close() {
+	s.listener.Close()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id := msg.Children[0].Value
+		op := msg.Children[1]
+		if op.ClassType != ber.ClassApplication {
+			return
+		}
+		var responses []*ber.Packet
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			responses = []*ber.Packet{s.bind(op)}
+		case goldap.ApplicationSearchRequest:
+			responses = s.search(op)
+		case goldap.ApplicationExtendedRequest:
+			s.mu.Lock()
+			s.startTLS++
+			s.mu.Unlock()
+			responses = []*ber.Packet{result(goldap.ApplicationExtendedResponse, goldap.LDAPResultUnavailable)}
+		default:
+			return
+		}
+		for _, rsp := range responses {
+			packet := ber.NewSequence("LDAP Response")
+			packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+			packet.AppendChild(rsp)
+			_, err = conn.Write(packet.Bytes())
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Error Message"))
+	return packet
+}
+
+func (s *fakeServer) bind(op *ber.Packet) *ber.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	s.binds = append(s.binds, dn)
+	if dn == "" && password == "" {
+		return result(goldap.ApplicationBindResponse, goldap.LDAPResultSuccess)
+	}
+	entry, ok := s.entries[dn]
+	if !ok || password == "" || entry.password != password {
+		return result(goldap.ApplicationBindResponse, goldap.LDAPResultInvalidCredentials)
+	}
+	return result(goldap.ApplicationBindResponse, goldap.LDAPResultSuccess)
+}
+
+func (s *fakeServer) search(op *ber.Packet) []*ber.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	baseDN := strings.ToLower(op.Children[0].Data.String())
+	filter := op.Children[6]
+	decompiled, _ := goldap.DecompileFilter(filter)
+	s.filters = append(s.filters, decompiled)
+	var responses []*ber.Packet
+	for dn, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(dn), baseDN) || !matches(filter, entry) {
+			continue
+		}
+		attrs := ber.NewSequence("Attributes")
+		for _, attr := range op.Children[7].Children {
+			name := attr.Data.String()
+			values := entry.attributes[strings.ToLower(name)]
+			if len(values) == 0 {
+				continue
+			}
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+			}
+			pair := ber.NewSequence("Attribute")
+			pair.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			pair.AppendChild(set)
+			attrs.AppendChild(pair)
+		}
+		rsp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		rsp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+		rsp.AppendChild(attrs)
+		responses = append(responses, rsp)
+	}
+	return append(responses, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess))
+}
+
+func matches(filter *ber.Packet, entry fakeEntry) bool {
+	switch filter.Tag {
+	case goldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !matches(filter.Children[0], entry)
+	case goldap.FilterEqualityMatch:
+		values := entry.attributes[strings.ToLower(filter.Children[0].Data.String())]
+		for _, v := range values {
+			if strings.EqualFold(v, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterPresent:
+		return len(entry.attributes[strings.ToLower(filter.Data.String())]) > 0
+	}
+	return false
+}
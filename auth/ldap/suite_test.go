@@ -0,0 +1,92 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/repository/repositorytest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn   *db.Storage
+	server *fakeServer
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	var err error
+	s.server, err = newFakeServer()
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_auth_ldap_test")
+	config.Set("auth:ldap:url", s.server.url())
+	config.Set("auth:ldap:bind-dn", "cn=tsuru,ou=services,dc=example,dc=com")
+	config.Set("auth:ldap:bind-password", "secret")
+	config.Set("auth:ldap:base-dn", "ou=people,dc=example,dc=com")
+	config.Set("auth:ldap:group-mappings", []interface{}{
+		map[interface{}]interface{}{
+			"group": "cn=developers,ou=groups,dc=example,dc=com",
+			"team":  "developers",
+			"roles": []interface{}{"team-member"},
+		},
+		map[interface{}]interface{}{
+			"group": "cn=admins,ou=groups,dc=example,dc=com",
+			"roles": []interface{}{"admin"},
+		},
+	})
+	config.Set("auth:max-simultaneous-sessions", 100)
+	config.Set("repo-manager", "fake")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.conn, _ = db.Conn()
+	s.server.reset()
+	s.server.addEntry("cn=tsuru,ou=services,dc=example,dc=com", "secret", nil)
+	repositorytest.Reset()
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	err := dbtest.ClearAllCollections(s.conn.Users().Database)
+	c.Assert(err, check.IsNil)
+	s.conn.Close()
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.server.close()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Users().Database.DropDatabase()
+}
+
+// ClientSuite holds the tests that talk only to the LDAP server, not
+// depending on the database.
+type ClientSuite struct {
+	server *fakeServer
+}
+
+var _ = check.Suite(&ClientSuite{})
+
+func (s *ClientSuite) SetUpSuite(c *check.C) {
+	var err error
+	s.server, err = newFakeServer()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *ClientSuite) SetUpTest(c *check.C) {
+	s.server.reset()
+}
+
+func (s *ClientSuite) TearDownSuite(c *check.C) {
+	s.server.close()
+}
@@ -39,7 +39,7 @@ func (s NativeScheme) Login(params map[string]string) (auth.Token, error) {
 	if err != nil {
 		return nil, err
 	}
-	token, err := createToken(user, password)
+	token, err := createToken(user, password, params["otp"])
 	if err != nil {
 		return nil, err
 	}
@@ -169,13 +169,16 @@ func checkPassword(passwordHash string, password string) error {
 	return auth.AuthenticationFailure{Message: "Authentication failed, wrong password."}
 }
 
-func createToken(u *auth.User, password string) (*Token, error) {
+func createToken(u *auth.User, password, twoFactorCode string) (*Token, error) {
 	if u.Email == "" {
 		return nil, errors.New("User does not have an email")
 	}
 	if err := checkPassword(u.Password, password); err != nil {
 		return nil, err
 	}
+	if err := checkTwoFactor(u, twoFactorCode); err != nil {
+		return nil, err
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
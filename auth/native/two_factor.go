@@ -0,0 +1,270 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+)
+
+const (
+	totpIssuer         = "tsuru"
+	totpPeriod         = 30
+	totpDigits         = 6
+	totpSkew           = 1
+	totpSecretSize     = 20
+	recoveryCodesCount = 10
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled     = &errors.ConflictError{Message: "two-factor authentication is already enabled"}
+	ErrTwoFactorNotEnrolled        = &errors.ValidationError{Message: "two-factor authentication enrollment not started"}
+	ErrTwoFactorNotEnabled         = &errors.ValidationError{Message: "two-factor authentication is not enabled"}
+	ErrTwoFactorCodeMismatch       = &errors.ValidationError{Message: "invalid two-factor authentication code"}
+	ErrTwoFactorEnrollmentRequired = &errors.NotAuthorizedError{Message: "two-factor authentication is required for users with global roles, please enroll before logging in"}
+	ErrTwoFactorCannotDisable      = &errors.NotAuthorizedError{Message: "two-factor authentication is required for users with global roles and can't be disabled"}
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	timeNow      = time.Now
+)
+
+func (s NativeScheme) EnrollTwoFactor(email, password string) (*auth.TwoFactorEnrollment, error) {
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPassword(user.Password, password); err != nil {
+		return nil, err
+	}
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	user.TwoFactor = &auth.TwoFactor{Secret: secret}
+	if err = user.Update(); err != nil {
+		return nil, err
+	}
+	return &auth.TwoFactorEnrollment{Secret: secret, URI: totpURI(user.Email, secret)}, nil
+}
+
+// ConfirmTwoFactor enables the second factor enrolled by the user, given a
+// valid code. It returns the recovery codes, which may be used in place of a
+// code once each. They're not stored in plain text, so this is the only
+// chance of showing them to the user.
+func (s NativeScheme) ConfirmTwoFactor(email, password, code string) ([]string, error) {
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPassword(user.Password, password); err != nil {
+		return nil, err
+	}
+	if user.TwoFactor == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if user.TwoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	counter, ok := validateTOTP(user.TwoFactor.Secret, code, timeNow())
+	if !ok {
+		return nil, ErrTwoFactorCodeMismatch
+	}
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	user.TwoFactor.Enabled = true
+	user.TwoFactor.RecoveryCodes = hashes
+	user.TwoFactor.LastCounter = counter
+	if err = user.Update(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s NativeScheme) DisableTwoFactor(token auth.Token, code string) error {
+	user, err := token.User()
+	if err != nil {
+		return err
+	}
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	required, err := twoFactorRequired(user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorCannotDisable
+	}
+	if !useTwoFactorCode(user.TwoFactor, code) {
+		return ErrTwoFactorCodeMismatch
+	}
+	user.TwoFactor = nil
+	return user.Update()
+}
+
+// checkTwoFactor verifies the second factor of the user during login. Users
+// without two-factor authentication enabled are only allowed in when it isn't
+// required for them.
+func checkTwoFactor(u *auth.User, code string) error {
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		required, err := twoFactorRequired(u)
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrTwoFactorEnrollmentRequired
+		}
+		return nil
+	}
+	if code == "" {
+		return auth.ErrTwoFactorRequired
+	}
+	if !useTwoFactorCode(u.TwoFactor, code) {
+		return auth.ErrInvalidTwoFactorCode
+	}
+	return u.Update()
+}
+
+// twoFactorRequired reports whether the user must authenticate with a second
+// factor, which is the case for users holding global roles when the
+// auth:require-two-factor setting is enabled.
+func twoFactorRequired(u *auth.User) (bool, error) {
+	if enabled, _ := config.GetBool("auth:require-two-factor"); !enabled {
+		return false, nil
+	}
+	for _, roleInstance := range u.Roles {
+		role, err := permission.FindRole(roleInstance.Name)
+		if err != nil {
+			if err == permission.ErrRoleNotFound {
+				continue
+			}
+			return false, err
+		}
+		if role.ContextType == permission.CtxGlobal {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// useTwoFactorCode checks the code against the TOTP secret and the recovery
+// codes. TOTP codes can't be reused and recovery codes are removed once used,
+// so the caller must persist the changes in tf.
+func useTwoFactorCode(tf *auth.TwoFactor, code string) bool {
+	code = strings.TrimSpace(code)
+	if counter, ok := validateTOTP(tf.Secret, code, timeNow()); ok {
+		if counter <= tf.LastCounter {
+			return false
+		}
+		tf.LastCounter = counter
+		return true
+	}
+	hash := hashRecoveryCode(code)
+	for i, recoveryHash := range tf.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(recoveryHash)) == 1 {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func totpURI(email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + email,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// totpCode generates the code for the given counter, as described in RFC
+// 4226, using HMAC-SHA1.
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// validateTOTP checks the code against the time steps around t, returning
+// the counter of the matching step.
+func validateTOTP(secret, code string, t time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := t.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected, err := totpCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+func generateRecoveryCode() (string, error) {
+	data := make([]byte, 5)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(data)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(sum[:])
+}
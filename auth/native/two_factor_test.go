@@ -0,0 +1,165 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+// rfcSecret is the base32 encoded secret used by the test vectors in RFC
+// 6238.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func (s *S) TestTOTPCode(c *check.C) {
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, expected := range tests {
+		code, err := totpCode(rfcSecret, ts/totpPeriod)
+		c.Check(err, check.IsNil)
+		c.Check(code, check.Equals, expected, check.Commentf("time %d", ts))
+	}
+}
+
+func (s *S) TestValidateTOTP(c *check.C) {
+	now := time.Unix(1111111109, 0)
+	counter, ok := validateTOTP(rfcSecret, "081804", now)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(counter, check.Equals, int64(1111111109/totpPeriod))
+	_, ok = validateTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second))
+	c.Assert(ok, check.Equals, true)
+	_, ok = validateTOTP(rfcSecret, "081804", now.Add(3*totpPeriod*time.Second))
+	c.Assert(ok, check.Equals, false)
+	_, ok = validateTOTP(rfcSecret, "81804", now)
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestTOTPURI(c *check.C) {
+	uri := totpURI("me@tsuru.io", rfcSecret)
+	c.Assert(uri, check.Equals, "otpauth://totp/tsuru:me@tsuru.io?issuer=tsuru&secret="+rfcSecret)
+}
+
+func (s *S) TestUseTwoFactorCode(c *check.C) {
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Unix(1111111109, 0) }
+	tf := &auth.TwoFactor{
+		Secret:        rfcSecret,
+		Enabled:       true,
+		RecoveryCodes: []string{hashRecoveryCode("abcde-12345")},
+	}
+	c.Assert(useTwoFactorCode(tf, "081804"), check.Equals, true)
+	c.Assert(useTwoFactorCode(tf, "081804"), check.Equals, false)
+	c.Assert(useTwoFactorCode(tf, "000000"), check.Equals, false)
+	c.Assert(useTwoFactorCode(tf, "ABCDE-12345"), check.Equals, true)
+	c.Assert(tf.RecoveryCodes, check.HasLen, 0)
+	c.Assert(useTwoFactorCode(tf, "abcde-12345"), check.Equals, false)
+}
+
+func (s *S) enableTwoFactor(c *check.C) []string {
+	enrollment, err := nativeScheme.EnrollTwoFactor(s.user.Email, "123456")
+	c.Assert(err, check.IsNil)
+	code, err := totpCode(enrollment.Secret, timeNow().Unix()/totpPeriod)
+	c.Assert(err, check.IsNil)
+	recoveryCodes, err := nativeScheme.ConfirmTwoFactor(s.user.Email, "123456", code)
+	c.Assert(err, check.IsNil)
+	return recoveryCodes
+}
+
+func (s *S) TestEnrollTwoFactor(c *check.C) {
+	enrollment, err := nativeScheme.EnrollTwoFactor(s.user.Email, "123456")
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.Secret, check.HasLen, 32)
+	c.Assert(enrollment.URI, check.Equals, totpURI(s.user.Email, enrollment.Secret))
+	user, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.TwoFactor, check.DeepEquals, &auth.TwoFactor{Secret: enrollment.Secret})
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestEnrollTwoFactorWrongPassword(c *check.C) {
+	_, err := nativeScheme.EnrollTwoFactor(s.user.Email, "1234567")
+	c.Assert(err, check.FitsTypeOf, auth.AuthenticationFailure{})
+}
+
+func (s *S) TestConfirmTwoFactor(c *check.C) {
+	recoveryCodes := s.enableTwoFactor(c)
+	c.Assert(recoveryCodes, check.HasLen, recoveryCodesCount)
+	user, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.TwoFactor.Enabled, check.Equals, true)
+	c.Assert(user.TwoFactor.RecoveryCodes, check.HasLen, recoveryCodesCount)
+	c.Assert(user.TwoFactor.RecoveryCodes[0], check.Equals, hashRecoveryCode(recoveryCodes[0]))
+	_, err = nativeScheme.EnrollTwoFactor(s.user.Email, "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorAlreadyEnabled)
+}
+
+func (s *S) TestConfirmTwoFactorInvalidCode(c *check.C) {
+	_, err := nativeScheme.ConfirmTwoFactor(s.user.Email, "123456", "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnrolled)
+	_, err = nativeScheme.EnrollTwoFactor(s.user.Email, "123456")
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.ConfirmTwoFactor(s.user.Email, "123456", "abcdef")
+	c.Assert(err, check.Equals, ErrTwoFactorCodeMismatch)
+}
+
+func (s *S) TestLoginWithTwoFactor(c *check.C) {
+	recoveryCodes := s.enableTwoFactor(c)
+	params := map[string]string{"email": s.user.Email, "password": "123456"}
+	_, err := nativeScheme.Login(params)
+	c.Assert(err, check.Equals, auth.ErrTwoFactorRequired)
+	params["otp"] = "invalid"
+	_, err = nativeScheme.Login(params)
+	c.Assert(err, check.Equals, auth.ErrInvalidTwoFactorCode)
+	params["otp"] = recoveryCodes[0]
+	token, err := nativeScheme.Login(params)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	_, err = nativeScheme.Login(params)
+	c.Assert(err, check.Equals, auth.ErrInvalidTwoFactorCode)
+}
+
+func (s *S) TestLoginTwoFactorRequiredForGlobalRoles(c *check.C) {
+	config.Set("auth:require-two-factor", true)
+	defer config.Unset("auth:require-two-factor")
+	role, err := permission.NewRole("admin-role", string(permission.CtxGlobal), "")
+	c.Assert(err, check.IsNil)
+	params := map[string]string{"email": s.user.Email, "password": "123456"}
+	_, err = nativeScheme.Login(params)
+	c.Assert(err, check.IsNil)
+	err = s.user.AddRole(role.Name, "")
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(params)
+	c.Assert(err, check.Equals, ErrTwoFactorEnrollmentRequired)
+	recoveryCodes := s.enableTwoFactor(c)
+	params["otp"] = recoveryCodes[0]
+	token, err := nativeScheme.Login(params)
+	c.Assert(err, check.IsNil)
+	err = nativeScheme.DisableTwoFactor(token, recoveryCodes[1])
+	c.Assert(err, check.Equals, ErrTwoFactorCannotDisable)
+}
+
+func (s *S) TestDisableTwoFactor(c *check.C) {
+	err := nativeScheme.DisableTwoFactor(s.token, "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+	recoveryCodes := s.enableTwoFactor(c)
+	err = nativeScheme.DisableTwoFactor(s.token, "invalid")
+	c.Assert(err, check.Equals, ErrTwoFactorCodeMismatch)
+	err = nativeScheme.DisableTwoFactor(s.token, recoveryCodes[0])
+	c.Assert(err, check.IsNil)
+	user, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.TwoFactor, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.IsNil)
+}
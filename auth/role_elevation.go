@@ -0,0 +1,171 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	stderrors "errors"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const maxElevationDuration = 24 * time.Hour
+
+const (
+	ElevationPending  = "pending"
+	ElevationApproved = "approved"
+	ElevationRejected = "rejected"
+)
+
+var (
+	ErrElevationNotFound      = stderrors.New("role elevation request not found")
+	ErrElevationNotPending    = stderrors.New("role elevation request is not pending")
+	ErrElevationSelfApproval  = stderrors.New("role elevation requests can't be approved by the requester")
+	ErrInvalidElevationPeriod = &errors.ValidationError{Message: "role elevation duration must be positive and at most 24h"}
+)
+
+// RoleElevation is a request from a user to hold a role temporarily, which
+// must be approved by another user allowed to assign it.
+type RoleElevation struct {
+	ID           bson.ObjectId `bson:"_id" json:"id"`
+	Email        string        `json:"email"`
+	RoleName     string        `json:"role"`
+	ContextValue string        `json:"context"`
+	Duration     time.Duration `json:"duration"`
+	Reason       string        `json:"reason"`
+	Status       string        `json:"status"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	ReviewedBy   string        `json:"reviewedBy,omitempty" bson:",omitempty"`
+	ExpiresAt    time.Time     `json:"expiresAt,omitempty" bson:",omitempty"`
+}
+
+// RequestRoleElevation creates a pending request for the user to hold the
+// role in the given context for the given duration.
+func RequestRoleElevation(u *User, roleName, contextValue string, duration time.Duration, reason string) (*RoleElevation, error) {
+	if duration <= 0 || duration > maxElevationDuration {
+		return nil, ErrInvalidElevationPeriod
+	}
+	_, err := permission.FindRole(roleName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	elevation := RoleElevation{
+		ID:           bson.NewObjectId(),
+		Email:        u.Email,
+		RoleName:     roleName,
+		ContextValue: contextValue,
+		Duration:     duration,
+		Reason:       reason,
+		Status:       ElevationPending,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = conn.RoleElevations().Insert(elevation)
+	if err != nil {
+		return nil, err
+	}
+	return &elevation, nil
+}
+
+func GetRoleElevation(id string) (*RoleElevation, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrElevationNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var elevation RoleElevation
+	err = conn.RoleElevations().FindId(bson.ObjectIdHex(id)).One(&elevation)
+	if err == mgo.ErrNotFound {
+		return nil, ErrElevationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &elevation, nil
+}
+
+// ListRoleElevations returns the pending elevation requests, limited to the
+// ones made by the given user when email isn't empty.
+func ListRoleElevations(email string) ([]RoleElevation, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{"status": ElevationPending}
+	if email != "" {
+		query["email"] = email
+	}
+	var elevations []RoleElevation
+	err = conn.RoleElevations().Find(query).Sort("createdat").All(&elevations)
+	if err != nil {
+		return nil, err
+	}
+	return elevations, nil
+}
+
+// Approve assigns the requested role to the requester, for the requested
+// duration starting now.
+func (e *RoleElevation) Approve(reviewer string) error {
+	if e.Status != ElevationPending {
+		return ErrElevationNotPending
+	}
+	if reviewer == e.Email {
+		return ErrElevationSelfApproval
+	}
+	u, err := GetUserByEmail(e.Email)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().UTC().Add(e.Duration)
+	err = e.review(reviewer, ElevationApproved, expiresAt)
+	if err != nil {
+		return err
+	}
+	return u.AddTemporaryRole(e.RoleName, e.ContextValue, expiresAt)
+}
+
+func (e *RoleElevation) Reject(reviewer string) error {
+	if e.Status != ElevationPending {
+		return ErrElevationNotPending
+	}
+	return e.review(reviewer, ElevationRejected, time.Time{})
+}
+
+func (e *RoleElevation) review(reviewer, status string, expiresAt time.Time) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"status": status, "reviewedby": reviewer}
+	if !expiresAt.IsZero() {
+		update["expiresat"] = expiresAt
+	}
+	// Only pending requests are updated, so concurrent reviews don't grant
+	// the role twice.
+	err = conn.RoleElevations().Update(bson.M{"_id": e.ID, "status": ElevationPending}, bson.M{"$set": update})
+	if err == mgo.ErrNotFound {
+		return ErrElevationNotPending
+	}
+	if err != nil {
+		return err
+	}
+	e.Status = status
+	e.ReviewedBy = reviewer
+	e.ExpiresAt = expiresAt
+	return nil
+}
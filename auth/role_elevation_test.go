@@ -0,0 +1,90 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRequestRoleElevation(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	elevation, err := RequestRoleElevation(s.user, "pool-admin", "prod", 2*time.Hour, "incident")
+	c.Assert(err, check.IsNil)
+	c.Assert(elevation.Status, check.Equals, ElevationPending)
+	dbElevation, err := GetRoleElevation(elevation.ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(dbElevation.Email, check.Equals, s.user.Email)
+	c.Assert(dbElevation.RoleName, check.Equals, "pool-admin")
+	c.Assert(dbElevation.ContextValue, check.Equals, "prod")
+	c.Assert(dbElevation.Duration, check.Equals, 2*time.Hour)
+	c.Assert(dbElevation.Reason, check.Equals, "incident")
+	elevations, err := ListRoleElevations(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(elevations, check.HasLen, 1)
+	elevations, err = ListRoleElevations("other@tsuru.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(elevations, check.HasLen, 0)
+}
+
+func (s *S) TestRequestRoleElevationInvalid(c *check.C) {
+	_, err := RequestRoleElevation(s.user, "pool-admin", "prod", time.Hour, "")
+	c.Assert(err, check.Equals, permission.ErrRoleNotFound)
+	_, err = RequestRoleElevation(s.user, "pool-admin", "prod", 0, "")
+	c.Assert(err, check.Equals, ErrInvalidElevationPeriod)
+	_, err = RequestRoleElevation(s.user, "pool-admin", "prod", 25*time.Hour, "")
+	c.Assert(err, check.Equals, ErrInvalidElevationPeriod)
+}
+
+func (s *S) TestGetRoleElevationNotFound(c *check.C) {
+	_, err := GetRoleElevation("invalid")
+	c.Assert(err, check.Equals, ErrElevationNotFound)
+	_, err = GetRoleElevation("5731f18e29b9ab2dd0d0d4d4")
+	c.Assert(err, check.Equals, ErrElevationNotFound)
+}
+
+func (s *S) TestApproveRoleElevation(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	elevation, err := RequestRoleElevation(s.user, "pool-admin", "prod", 2*time.Hour, "")
+	c.Assert(err, check.IsNil)
+	err = elevation.Approve(s.user.Email)
+	c.Assert(err, check.Equals, ErrElevationSelfApproval)
+	err = elevation.Approve("admin@tsuru.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(elevation.Status, check.Equals, ElevationApproved)
+	c.Assert(elevation.ReviewedBy, check.Equals, "admin@tsuru.io")
+	err = s.user.Reload()
+	c.Assert(err, check.IsNil)
+	c.Assert(s.user.Roles, check.HasLen, 1)
+	c.Assert(s.user.Roles[0].Name, check.Equals, "pool-admin")
+	c.Assert(s.user.Roles[0].ContextValue, check.Equals, "prod")
+	c.Assert(s.user.Roles[0].ExpiresAt.Equal(elevation.ExpiresAt.Truncate(time.Millisecond)), check.Equals, true)
+	err = elevation.Approve("admin@tsuru.io")
+	c.Assert(err, check.Equals, ErrElevationNotPending)
+	elevations, err := ListRoleElevations("")
+	c.Assert(err, check.IsNil)
+	c.Assert(elevations, check.HasLen, 0)
+}
+
+func (s *S) TestRejectRoleElevation(c *check.C) {
+	_, err := permission.NewRole("pool-admin", "pool", "")
+	c.Assert(err, check.IsNil)
+	elevation, err := RequestRoleElevation(s.user, "pool-admin", "prod", 2*time.Hour, "")
+	c.Assert(err, check.IsNil)
+	err = elevation.Reject("admin@tsuru.io")
+	c.Assert(err, check.IsNil)
+	dbElevation, err := GetRoleElevation(elevation.ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(dbElevation.Status, check.Equals, ElevationRejected)
+	err = dbElevation.Approve("admin@tsuru.io")
+	c.Assert(err, check.Equals, ErrElevationNotPending)
+	err = s.user.Reload()
+	c.Assert(err, check.IsNil)
+	c.Assert(s.user.Roles, check.HasLen, 0)
+}
@@ -27,6 +27,27 @@ type ManagedScheme interface {
 	ChangePassword(token Token, oldPassword string, newPassword string) error
 }
 
+// TwoFactorScheme is implemented by schemes that support time-based one time
+// passwords as a second authentication factor. Enrollment is authenticated
+// with the user password, so users required to have a second factor are able
+// to enroll before logging in.
+type TwoFactorScheme interface {
+	Scheme
+	EnrollTwoFactor(email, password string) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactor(email, password, code string) ([]string, error)
+	DisableTwoFactor(token Token, code string) error
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+var (
+	ErrTwoFactorRequired    = AuthenticationFailure{Message: "Two-factor authentication code required."}
+	ErrInvalidTwoFactorCode = AuthenticationFailure{Message: "Authentication failed, invalid two-factor authentication code."}
+)
+
 type AuthenticationFailure struct {
 	Message string
 }
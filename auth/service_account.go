@@ -0,0 +1,134 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// UserTypeServiceAccount is the type of users that represent non-human
+// clients, like CI pipelines, owned by a team.
+const UserTypeServiceAccount = "service-account"
+
+// serviceAccountSuffix ends the identifier of every service account. As it's
+// not a valid top level domain, it never clashes with the email of a user.
+const serviceAccountSuffix = ".service-account"
+
+var (
+	ErrInvalidServiceAccountName   = errors.New("invalid service account name, it must contain only lower case letters, numbers and dashes and start with a letter")
+	ErrServiceAccountAlreadyExists = errors.New("service account already exists")
+	ErrServiceAccountNotFound      = errors.New("service account not found")
+
+	serviceAccountNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,39}$`)
+)
+
+// ServiceAccountEmail returns the identifier of the service account with the
+// given name in the team. Service accounts are stored as users, using this
+// identifier in place of an email.
+func ServiceAccountEmail(team, name string) string {
+	return name + "@" + team + serviceAccountSuffix
+}
+
+// ServiceAccountDisplayName returns a human readable description of the
+// service account with the given identifier, e.g. "ci-bot of team myteam".
+func ServiceAccountDisplayName(email string) string {
+	parts := strings.SplitN(strings.TrimSuffix(email, serviceAccountSuffix), "@", 2)
+	if len(parts) != 2 {
+		return email
+	}
+	return fmt.Sprintf("%s of team %s", parts[0], parts[1])
+}
+
+func (u *User) IsServiceAccount() bool {
+	return u.Type == UserTypeServiceAccount
+}
+
+// CreateServiceAccount creates a service account in the team, returning it
+// along with its API key. Service accounts start without any roles.
+func CreateServiceAccount(team, name string) (*User, string, error) {
+	if !serviceAccountNameRegexp.MatchString(name) {
+		return nil, "", ErrInvalidServiceAccountName
+	}
+	_, err := GetTeam(team)
+	if err != nil {
+		return nil, "", err
+	}
+	u := User{
+		Email: ServiceAccountEmail(team, name),
+		Type:  UserTypeServiceAccount,
+		Team:  team,
+		Quota: quota.Unlimited,
+	}
+	if limit, err := config.GetInt("quota:apps-per-user"); err == nil && limit > -1 {
+		u.Quota.Limit = limit
+	}
+	u.APIKey, err = generateAPIKey(u.Email)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	err = conn.Users().Insert(u)
+	if err != nil {
+		if mgo.IsDup(err) {
+			return nil, "", ErrServiceAccountAlreadyExists
+		}
+		return nil, "", err
+	}
+	return &u, u.APIKey, nil
+}
+
+// GetServiceAccount finds a service account by team and name.
+func GetServiceAccount(team, name string) (*User, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var u User
+	err = conn.Users().Find(bson.M{"email": ServiceAccountEmail(team, name), "type": UserTypeServiceAccount}).One(&u)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListServiceAccounts returns the service accounts owned by the team.
+func ListServiceAccounts(team string) ([]User, error) {
+	return listUsers(bson.M{"type": UserTypeServiceAccount, "team": team})
+}
+
+// RemoveServiceAccount removes the service account along with its tokens.
+func RemoveServiceAccount(team, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	email := ServiceAccountEmail(team, name)
+	err = conn.Users().Remove(bson.M{"email": email, "type": UserTypeServiceAccount})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrServiceAccountNotFound
+		}
+		return err
+	}
+	return removePersonalTokens(email)
+}
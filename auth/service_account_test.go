@@ -0,0 +1,79 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestServiceAccountEmail(c *check.C) {
+	c.Assert(ServiceAccountEmail("cobrateam", "ci-bot"), check.Equals, "ci-bot@cobrateam.service-account")
+	c.Assert(ServiceAccountDisplayName("ci-bot@cobrateam.service-account"), check.Equals, "ci-bot of team cobrateam")
+	c.Assert(ServiceAccountDisplayName("invalid"), check.Equals, "invalid")
+}
+
+func (s *S) TestCreateServiceAccount(c *check.C) {
+	u, token, err := CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Not(check.Equals), "")
+	c.Assert(u.Email, check.Equals, "ci-bot@cobrateam.service-account")
+	c.Assert(u.IsServiceAccount(), check.Equals, true)
+	c.Assert(u.Team, check.Equals, s.team.Name)
+	c.Assert(u.Roles, check.HasLen, 0)
+	apiToken, err := APIAuth("bearer " + token)
+	c.Assert(err, check.IsNil)
+	c.Assert(apiToken.IsServiceAccount(), check.Equals, true)
+	c.Assert(apiToken.GetUserName(), check.Equals, u.Email)
+	_, _, err = CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.Equals, ErrServiceAccountAlreadyExists)
+}
+
+func (s *S) TestCreateServiceAccountInvalidName(c *check.C) {
+	for _, name := range []string{"", "CI", "1bot", "ci_bot", "ci@bot"} {
+		_, _, err := CreateServiceAccount(s.team.Name, name)
+		c.Check(err, check.Equals, ErrInvalidServiceAccountName, check.Commentf("name %q", name))
+	}
+}
+
+func (s *S) TestCreateServiceAccountTeamNotFound(c *check.C) {
+	_, _, err := CreateServiceAccount("nonexistent", "ci-bot")
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
+func (s *S) TestListServiceAccounts(c *check.C) {
+	_, _, err := CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	_, _, err = CreateServiceAccount(s.team.Name, "deployer")
+	c.Assert(err, check.IsNil)
+	accounts, err := ListServiceAccounts(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 2)
+	accounts, err = ListServiceAccounts("other")
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 0)
+}
+
+func (s *S) TestRemoveServiceAccount(c *check.C) {
+	_, token, err := CreateServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	err = RemoveServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.IsNil)
+	_, err = GetServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.Equals, ErrServiceAccountNotFound)
+	_, err = APIAuth("bearer " + token)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	err = RemoveServiceAccount(s.team.Name, "ci-bot")
+	c.Assert(err, check.Equals, ErrServiceAccountNotFound)
+}
+
+func (s *S) TestRemoveTeamWithServiceAccounts(c *check.C) {
+	team := Team{Name: "fremen"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, check.IsNil)
+	_, _, err = CreateServiceAccount(team.Name, "stilgar")
+	c.Assert(err, check.IsNil)
+	err = RemoveTeam(team.Name)
+	c.Assert(err, check.ErrorMatches, "Service accounts: stilgar@fremen.service-account")
+}
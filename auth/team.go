@@ -28,19 +28,73 @@ var (
 type ErrTeamStillUsed struct {
 	Apps             []string
 	ServiceInstances []string
+	ServiceAccounts  []string
 }
 
 func (e *ErrTeamStillUsed) Error() string {
 	if len(e.Apps) > 0 {
 		return fmt.Sprintf("Apps: %s", strings.Join(e.Apps, ", "))
 	}
-	return fmt.Sprintf("Service instances: %s", strings.Join(e.ServiceInstances, ", "))
+	if len(e.ServiceInstances) > 0 {
+		return fmt.Sprintf("Service instances: %s", strings.Join(e.ServiceInstances, ", "))
+	}
+	return fmt.Sprintf("Service accounts: %s", strings.Join(e.ServiceAccounts, ", "))
 }
 
 // Team represents a real world team, a team has one creating user and a name.
 type Team struct {
 	Name         string `bson:"_id" json:"name"`
 	CreatingUser string
+	Quota        *TeamQuota `bson:",omitempty" json:",omitempty"`
+}
+
+// TeamQuota holds the limits of the resources used by the apps owned by a
+// team. Negative limits mean unlimited. Teams without a quota have no
+// limits.
+type TeamQuota struct {
+	Apps   int
+	Units  int
+	Memory int64
+}
+
+// UnlimitedTeamQuota is the quota of teams without limits.
+var UnlimitedTeamQuota = TeamQuota{Apps: -1, Units: -1, Memory: -1}
+
+// GetQuota returns the quota of the team, UnlimitedTeamQuota when it has
+// none.
+func (t *Team) GetQuota() TeamQuota {
+	if t.Quota == nil {
+		return UnlimitedTeamQuota
+	}
+	return *t.Quota
+}
+
+// SetQuota redefines the limits of the team. Callers must check that the new
+// limits aren't lower than the current usage.
+func (t *Team) SetQuota(q TeamQuota) error {
+	if q.Apps < 0 {
+		q.Apps = -1
+	}
+	if q.Units < 0 {
+		q.Units = -1
+	}
+	if q.Memory < 0 {
+		q.Memory = -1
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Teams().UpdateId(t.Name, bson.M{"$set": bson.M{"quota": q}})
+	if err == mgo.ErrNotFound {
+		return ErrTeamNotFound
+	}
+	if err != nil {
+		return err
+	}
+	t.Quota = &q
+	return nil
 }
 
 // AllowedApps returns the apps that the team has access.
@@ -147,6 +201,17 @@ func RemoveTeam(teamName string) error {
 	if len(serviceInstances) > 0 {
 		return &ErrTeamStillUsed{ServiceInstances: serviceInstances}
 	}
+	serviceAccounts, err := ListServiceAccounts(teamName)
+	if err != nil {
+		return err
+	}
+	if len(serviceAccounts) > 0 {
+		names := make([]string, len(serviceAccounts))
+		for i, account := range serviceAccounts {
+			names[i] = account.Email
+		}
+		return &ErrTeamStillUsed{ServiceAccounts: names}
+	}
 	err = conn.Teams().RemoveId(teamName)
 	if err == mgo.ErrNotFound {
 		return ErrTeamNotFound
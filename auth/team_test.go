@@ -105,6 +105,29 @@ func (s *S) TestGetTeam(c *check.C) {
 	c.Assert(t, check.IsNil)
 }
 
+func (s *S) TestTeamGetQuota(c *check.C) {
+	team := Team{Name: "symfonia"}
+	c.Assert(team.GetQuota(), check.Equals, UnlimitedTeamQuota)
+	team.Quota = &TeamQuota{Apps: 2, Units: -1, Memory: 1024}
+	c.Assert(team.GetQuota(), check.Equals, TeamQuota{Apps: 2, Units: -1, Memory: 1024})
+}
+
+func (s *S) TestTeamSetQuota(c *check.C) {
+	team := Team{Name: "symfonia"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	err = team.SetQuota(TeamQuota{Apps: 2, Units: -10, Memory: 1024})
+	c.Assert(err, check.IsNil)
+	expected := TeamQuota{Apps: 2, Units: -1, Memory: 1024}
+	c.Assert(team.Quota, check.DeepEquals, &expected)
+	t, err := GetTeam("symfonia")
+	c.Assert(err, check.IsNil)
+	c.Assert(t.GetQuota(), check.Equals, expected)
+	err = (&Team{Name: "wat"}).SetQuota(expected)
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
 func (s *S) TestRemoveTeam(c *check.C) {
 	team := Team{Name: "atreides"}
 	err := s.conn.Teams().Insert(team)
@@ -20,6 +20,7 @@ import (
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/validation"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -32,6 +33,13 @@ var (
 type RoleInstance struct {
 	Name         string
 	ContextValue string
+	// ExpiresAt is set for temporary role assignments, which don't grant
+	// any permissions after it.
+	ExpiresAt time.Time `bson:",omitempty" json:",omitempty"`
+}
+
+func (r RoleInstance) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
 }
 
 type User struct {
@@ -40,6 +48,21 @@ type User struct {
 	Password string
 	APIKey   string
 	Roles    []RoleInstance `bson:",omitempty"`
+	// Type is empty for regular users and UserTypeServiceAccount for
+	// service accounts, which are owned by Team.
+	Type      string     `bson:",omitempty"`
+	Team      string     `bson:",omitempty"`
+	TwoFactor *TwoFactor `bson:",omitempty"`
+}
+
+// TwoFactor holds the time-based one time password settings of a user. The
+// secret is only used for authentication after the enrollment is confirmed,
+// which enables it. Recovery codes are stored hashed.
+type TwoFactor struct {
+	Secret        string
+	Enabled       bool
+	RecoveryCodes []string `bson:",omitempty"`
+	LastCounter   int64
 }
 
 func listUsers(filter bson.M) ([]User, error) {
@@ -145,6 +168,10 @@ func (u *User) Delete() error {
 	if err != nil {
 		log.Errorf("failed to remove user %q from the database: %s", u.Email, err)
 	}
+	err = removePersonalTokens(u.Email)
+	if err != nil {
+		log.Errorf("failed to remove personal tokens of user %q: %s", u.Email, err)
+	}
 	err = repository.Manager().RemoveUser(u.Email)
 	if err != nil {
 		log.Errorf("failed to remove user %q from the repository manager: %s", u.Email, err)
@@ -209,17 +236,25 @@ func (u *User) ShowAPIKey() (string, error) {
 }
 
 func (u *User) RegenerateAPIKey() (string, error) {
+	key, err := generateAPIKey(u.Email)
+	if err != nil {
+		return "", err
+	}
+	u.APIKey = key
+	return u.APIKey, u.Update()
+}
+
+func generateAPIKey(email string) (string, error) {
 	random_byte := make([]byte, 32)
 	_, err := rand.Read(random_byte)
 	if err != nil {
 		return "", err
 	}
 	h := crypto.SHA256.New()
-	h.Write([]byte(u.Email))
+	h.Write([]byte(email))
 	h.Write(random_byte)
 	h.Write([]byte(time.Now().Format(time.RFC3339Nano)))
-	u.APIKey = fmt.Sprintf("%x", h.Sum(nil))
-	return u.APIKey, u.Update()
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
 func (u *User) Reload() error {
@@ -234,7 +269,11 @@ func (u *User) Reload() error {
 func (u *User) Permissions() ([]permission.Permission, error) {
 	var permissions []permission.Permission
 	roles := make(map[string]*permission.Role)
+	now := time.Now()
 	for _, roleData := range u.Roles {
+		if roleData.Expired(now) {
+			continue
+		}
 		role := roles[roleData.Name]
 		if role == nil {
 			foundRole, err := permission.FindRole(roleData.Name)
@@ -275,6 +314,70 @@ func (u *User) AddRole(roleName string, contextValue string) error {
 	return u.Reload()
 }
 
+// AddTemporaryRole assigns the role to the user until expiresAt, replacing
+// any other temporary assignment of the same role in the same context.
+func (u *User) AddTemporaryRole(roleName string, contextValue string, expiresAt time.Time) error {
+	_, err := permission.FindRole(roleName)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Users().Update(bson.M{"email": u.Email}, bson.M{
+		"$pull": bson.M{
+			"roles": bson.M{"name": roleName, "contextvalue": contextValue, "expiresat": bson.M{"$exists": true}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = conn.Users().Update(bson.M{"email": u.Email}, bson.M{
+		"$push": bson.M{
+			"roles": bson.D([]bson.DocElem{
+				{Name: "name", Value: roleName},
+				{Name: "contextvalue", Value: contextValue},
+				{Name: "expiresat", Value: expiresAt},
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return u.Reload()
+}
+
+// RemoveExpiredRole removes the given temporary role assignment from the
+// user, keeping permanent assignments of the same role. It returns false
+// when the assignment was already removed, e.g. by another tsurud replica.
+func (u *User) RemoveExpiredRole(role RoleInstance) (bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	assignment := bson.M{"name": role.Name, "contextvalue": role.ContextValue, "expiresat": role.ExpiresAt}
+	err = conn.Users().Update(
+		bson.M{"email": u.Email, "roles": bson.M{"$elemMatch": assignment}},
+		bson.M{"$pull": bson.M{"roles": assignment}},
+	)
+	if err == mgo.ErrNotFound {
+		return false, u.Reload()
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, u.Reload()
+}
+
+// ListUsersWithExpiredRoles returns the users holding temporary roles which
+// expired before now.
+func ListUsersWithExpiredRoles(now time.Time) ([]User, error) {
+	return listUsers(bson.M{"roles.expiresat": bson.M{"$lte": now}})
+}
+
 func RemoveRoleFromAllUsers(roleName string) error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -6,6 +6,7 @@ package auth
 
 import (
 	"sort"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
@@ -402,6 +403,73 @@ func (s *S) TestUserPermissions(c *check.C) {
 	})
 }
 
+func (s *S) TestUserPermissionsWithExpiredRole(c *check.C) {
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err := u.Create()
+	c.Assert(err, check.IsNil)
+	r1, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	err = r1.AddPermissions("app.deploy")
+	c.Assert(err, check.IsNil)
+	err = u.AddTemporaryRole("r1", "myapp", time.Now().Add(time.Hour))
+	c.Assert(err, check.IsNil)
+	err = u.AddTemporaryRole("r1", "myapp2", time.Now().Add(-time.Minute))
+	c.Assert(err, check.IsNil)
+	perms, err := u.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+}
+
+func (s *S) TestUserAddTemporaryRole(c *check.C) {
+	_, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err = u.Create()
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r1", "app1")
+	c.Assert(err, check.IsNil)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	err = u.AddTemporaryRole("r1", "app1", expiresAt.Add(-time.Minute))
+	c.Assert(err, check.IsNil)
+	err = u.AddTemporaryRole("r1", "app1", expiresAt)
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Roles, check.HasLen, 2)
+	c.Assert(u.Roles[0], check.DeepEquals, RoleInstance{Name: "r1", ContextValue: "app1"})
+	c.Assert(u.Roles[1].ExpiresAt.Equal(expiresAt), check.Equals, true)
+	err = u.AddTemporaryRole("invalid", "app1", expiresAt)
+	c.Assert(err, check.Equals, permission.ErrRoleNotFound)
+}
+
+func (s *S) TestUserRemoveExpiredRole(c *check.C) {
+	_, err := permission.NewRole("r1", "app", "")
+	c.Assert(err, check.IsNil)
+	u := User{Email: "me@tsuru.com", Password: "123"}
+	err = u.Create()
+	c.Assert(err, check.IsNil)
+	err = u.AddRole("r1", "app1")
+	c.Assert(err, check.IsNil)
+	err = u.AddTemporaryRole("r1", "app1", time.Now().Add(-time.Minute))
+	c.Assert(err, check.IsNil)
+	users, err := ListUsersWithExpiredRoles(time.Now())
+	c.Assert(err, check.IsNil)
+	c.Assert(users, check.HasLen, 1)
+	c.Assert(users[0].Email, check.Equals, u.Email)
+	expired := u.Roles[1]
+	removed, err := u.RemoveExpiredRole(expired)
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.Equals, true)
+	c.Assert(u.Roles, check.DeepEquals, []RoleInstance{{Name: "r1", ContextValue: "app1"}})
+	users, err = ListUsersWithExpiredRoles(time.Now())
+	c.Assert(err, check.IsNil)
+	c.Assert(users, check.HasLen, 0)
+	removed, err = u.RemoveExpiredRole(expired)
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.Equals, false)
+	c.Assert(u.Roles, check.DeepEquals, []RoleInstance{{Name: "r1", ContextValue: "app1"}})
+}
+
 func (s *S) TestUserPermissionsWithRemovedRole(c *check.C) {
 	role, err := permission.NewRole("test", "team", "")
 	c.Assert(err, check.IsNil)
@@ -16,9 +16,14 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/tsuru/gnuflag"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// twoFactorHeader is set by the API when the login requires a two-factor
+// authentication code.
+const twoFactorHeader = "X-Tsuru-Two-Factor"
+
 type loginScheme struct {
 	Name string
 	Data map[string]string
@@ -48,13 +53,15 @@ func nativeLogin(context *Context, client *Client) error {
 	}
 	v := url.Values{}
 	v.Set("password", password)
-	b := strings.NewReader(v.Encode())
-	request, err := http.NewRequest("POST", u, b)
-	if err != nil {
-		return err
+	response, err := doNativeLogin(client, u, v)
+	if err != nil && response != nil && response.Header.Get(twoFactorHeader) == "required" {
+		response.Body.Close()
+		fmt.Fprint(context.Stdout, "Two-factor authentication code: ")
+		var code string
+		fmt.Fscanf(context.Stdin, "%s\n", &code)
+		v.Set("otp", code)
+		response, err = doNativeLogin(client, u, v)
 	}
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
@@ -72,6 +79,15 @@ func nativeLogin(context *Context, client *Client) error {
 	return writeToken(out["token"].(string))
 }
 
+func doNativeLogin(client *Client, u string, v url.Values) (*http.Response, error) {
+	request, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return client.Do(request)
+}
+
 func (c *login) getScheme() *loginScheme {
 	if c.scheme == nil {
 		info, err := schemeInfo()
@@ -222,6 +238,93 @@ func (userInfo) Run(context *Context, client *Client) error {
 	return nil
 }
 
+type permissionCheck struct {
+	fs      *gnuflag.FlagSet
+	user    string
+	context string
+}
+
+func (c *permissionCheck) Info() *Info {
+	return &Info{
+		Name:  "permission-check",
+		Usage: "permission-check <permission> [-u/--user <email>] [-c/--context <type>:<value>]",
+		Desc: `Checks whether a user has a permission, explaining which roles grant it.
+
+The context must be in the form <type>:<value>, e.g. app:myapp. When omitted,
+the permission is checked in the global context. Checking the permissions of
+other users requires the role.update.assign permission.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *permissionCheck) Run(context *Context, client *Client) error {
+	v := url.Values{}
+	v.Set("permission", context.Args[0])
+	if c.user != "" {
+		v.Set("user", c.user)
+	}
+	if c.context != "" {
+		v.Set("context", c.context)
+	}
+	u, err := GetURL("/permissions/check?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		User       string
+		Permission string
+		Allowed    bool
+		Matches    []struct {
+			Role         string
+			RoleContext  string
+			Permission   string
+			ContextType  string
+			ContextValue string
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed {
+		fmt.Fprintf(context.Stdout, "Denied: %s does not have permission %s.\n", result.User, result.Permission)
+		return nil
+	}
+	fmt.Fprintf(context.Stdout, "Allowed: %s has permission %s, granted by:\n", result.User, result.Permission)
+	for _, m := range result.Matches {
+		role := m.Role
+		if m.RoleContext != "" {
+			role += "(" + m.RoleContext + ")"
+		}
+		ctx := m.ContextType
+		if m.ContextValue != "" {
+			ctx += " " + m.ContextValue
+		}
+		fmt.Fprintf(context.Stdout, "\t%s: %s(%s)\n", role, m.Permission, ctx)
+	}
+	return nil
+}
+
+func (c *permissionCheck) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("permission-check", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.user, "user", "", "The email of the user, defaults to the current user")
+		c.fs.StringVar(&c.user, "u", "", "The email of the user, defaults to the current user")
+		c.fs.StringVar(&c.context, "context", "", "The context in the form <type>:<value>")
+		c.fs.StringVar(&c.context, "c", "", "The context in the form <type>:<value>")
+	}
+	return c.fs
+}
+
 func PasswordFromReader(reader io.Reader) (string, error) {
 	var (
 		password []byte
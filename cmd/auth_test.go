@@ -54,6 +54,49 @@ func (s *S) TestNativeLogin(c *check.C) {
 	c.Assert(token, check.Equals, "sometoken")
 }
 
+func (s *S) TestNativeLoginWithTwoFactor(c *check.C) {
+	os.Unsetenv("TSURU_TOKEN")
+	nativeScheme()
+	fsystem = &fstest.RecordingFs{FileContent: "old-token"}
+	defer func() {
+		fsystem = nil
+	}()
+	expected := "Password: \nTwo-factor authentication code: Successfully logged in!\n"
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	transport := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{
+					Message: "Two-factor authentication code required.",
+					Status:  http.StatusUnauthorized,
+					Headers: map[string][]string{"X-Tsuru-Two-Factor": {"required"}},
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.FormValue("password") == "chico" && r.FormValue("otp") == ""
+				},
+			},
+			{
+				Transport: cmdtest.Transport{
+					Message: `{"token": "sometoken"}`,
+					Status:  http.StatusOK,
+				},
+				CondFunc: func(r *http.Request) bool {
+					return r.FormValue("password") == "chico" && r.FormValue("otp") == "123456"
+				},
+			},
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+	token, err := ReadToken()
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Equals, "sometoken")
+}
+
 func (s *S) TestNativeLoginWithoutEmailFromArg(c *check.C) {
 	os.Unsetenv("TSURU_TOKEN")
 	nativeScheme()
@@ -325,6 +368,61 @@ Permissions:
 	c.Assert(called, check.Equals, true)
 }
 
+func (s *S) TestPermissionCheckInfo(c *check.C) {
+	c.Assert((&permissionCheck{}).Info(), check.NotNil)
+}
+
+func (s *S) TestPermissionCheck(c *check.C) {
+	var called bool
+	expected := `Allowed: other@company.com has permission app.deploy, granted by:
+	deployer(team1): app.deploy(team team1)
+	admin: *(global)
+`
+	context := Context{[]string{"app.deploy"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := permissionCheck{}
+	command.Flags().Parse(true, []string{"-u", "other@company.com", "-c", "app:myapp"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"User":"other@company.com","Permission":"app.deploy","Allowed":true,"Matches":[
+	{"Role":"deployer","RoleContext":"team1","Permission":"app.deploy","ContextType":"team","ContextValue":"team1"},
+	{"Role":"admin","RoleContext":"","Permission":"*","ContextType":"global","ContextValue":""}
+]}`,
+			Status: http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "GET" && req.URL.Path == "/1.0/permissions/check" &&
+				req.URL.Query().Get("permission") == "app.deploy" &&
+				req.URL.Query().Get("user") == "other@company.com" &&
+				req.URL.Query().Get("context") == "app:myapp"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+	c.Assert(called, check.Equals, true)
+}
+
+func (s *S) TestPermissionCheckDenied(c *check.C) {
+	context := Context{[]string{"app.deploy"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := permissionCheck{}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"User":"myuser@company.com","Permission":"app.deploy","Allowed":false}`,
+			Status:  http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/1.0/permissions/check" &&
+				req.URL.Query().Get("user") == ""
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Denied: myuser@company.com does not have permission app.deploy.\n")
+}
+
 func (s *S) TestPasswordFromReaderUsingFile(c *check.C) {
 	tmpdir, err := filepath.EvalSymlinks(os.TempDir())
 	filename := path.Join(tmpdir, "password-reader.txt")
@@ -0,0 +1,204 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+)
+
+type certificateSet struct {
+	GuessingCommand
+	cname string
+	fs    *gnuflag.FlagSet
+}
+
+func (c *certificateSet) Info() *Info {
+	return &Info{
+		Name:  "certificate-set",
+		Usage: "certificate-set [-a/--app appname] -c/--cname <cname> <certificate> <key>",
+		Desc: `Sets the TLS certificate used to serve a CName of the app. The certificate
+and key are the paths to PEM encoded files, and the certificate must be valid
+for the CName.`,
+		MinArgs: 2,
+		MaxArgs: 2,
+	}
+}
+
+func (c *certificateSet) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.StringVar(&c.cname, "cname", "", "The CName served with the certificate")
+		c.fs.StringVar(&c.cname, "c", "", "The CName served with the certificate")
+	}
+	return c.fs
+}
+
+func (c *certificateSet) Run(context *Context, client *Client) error {
+	if c.cname == "" {
+		return errors.New("You must set cname.")
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	certificate, err := ioutil.ReadFile(context.Args[0])
+	if err != nil {
+		return err
+	}
+	key, err := ioutil.ReadFile(context.Args[1])
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("cname", c.cname)
+	v.Set("certificate", string(certificate))
+	v.Set("key", string(key))
+	u, err := GetURL(fmt.Sprintf("/apps/%s/certificate", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintln(context.Stdout, "Certificate successfully set.")
+	return nil
+}
+
+type certificateUnset struct {
+	GuessingCommand
+	cname string
+	fs    *gnuflag.FlagSet
+}
+
+func (c *certificateUnset) Info() *Info {
+	return &Info{
+		Name:  "certificate-unset",
+		Usage: "certificate-unset [-a/--app appname] -c/--cname <cname>",
+		Desc:  `Removes the TLS certificate of a CName of the app.`,
+	}
+}
+
+func (c *certificateUnset) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.StringVar(&c.cname, "cname", "", "The CName of the certificate")
+		c.fs.StringVar(&c.cname, "c", "", "The CName of the certificate")
+	}
+	return c.fs
+}
+
+func (c *certificateUnset) Run(context *Context, client *Client) error {
+	if c.cname == "" {
+		return errors.New("You must set cname.")
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("cname", c.cname)
+	u, err := GetURL(fmt.Sprintf("/apps/%s/certificate?%s", appName, v.Encode()))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintln(context.Stdout, "Certificate removed.")
+	return nil
+}
+
+type certificateList struct {
+	GuessingCommand
+}
+
+func (c *certificateList) Info() *Info {
+	return &Info{
+		Name:  "certificate-list",
+		Usage: "certificate-list [-a/--app appname]",
+		Desc:  `Lists the CNames of the app along with the expiration date of their TLS certificates.`,
+	}
+}
+
+func (c *certificateList) Run(context *Context, client *Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	u, err := GetURL(fmt.Sprintf("/apps/%s/certificate", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var certificates map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&certificates)
+	if err != nil {
+		return err
+	}
+	cnames := make([]string, 0, len(certificates))
+	for cname := range certificates {
+		cnames = append(cnames, cname)
+	}
+	sort.Strings(cnames)
+	table := NewTable()
+	table.Headers = Row{"CName", "Expires"}
+	for _, cname := range cnames {
+		expires := "-"
+		if certificates[cname] != "" {
+			expires, err = certificateExpiration(certificates[cname])
+			if err != nil {
+				return err
+			}
+		}
+		table.AddRow(Row{cname, expires})
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+func certificateExpiration(certificate string) (string, error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return "", errors.New("invalid certificate returned by the server")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return cert.NotAfter.UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func generateCertificate(c *check.C, notAfter time.Time) string {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{"app.example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	c.Assert(err, check.IsNil)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func (s *S) TestCertificateSetInfo(c *check.C) {
+	c.Assert((&certificateSet{}).Info(), check.NotNil)
+}
+
+func (s *S) TestCertificateSet(c *check.C) {
+	dir := c.MkDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	err := ioutil.WriteFile(certPath, []byte("mycert"), 0600)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(keyPath, []byte("mykey"), 0600)
+	c.Assert(err, check.IsNil)
+	var called bool
+	context := Context{[]string{certPath, keyPath}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			req.ParseForm()
+			return req.Method == "PUT" && req.URL.Path == "/1.0/apps/myapp/certificate" &&
+				req.Form.Get("cname") == "app.example.com" &&
+				req.Form.Get("certificate") == "mycert" &&
+				req.Form.Get("key") == "mykey"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := certificateSet{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-c", "app.example.com"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Certificate successfully set.\n")
+}
+
+func (s *S) TestCertificateSetWithoutCName(c *check.C) {
+	context := Context{[]string{"cert.pem", "key.pem"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := certificateSet{}
+	command.Flags().Parse(true, []string{"-a", "myapp"})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, "You must set cname.")
+}
+
+func (s *S) TestCertificateUnsetInfo(c *check.C) {
+	c.Assert((&certificateUnset{}).Info(), check.NotNil)
+}
+
+func (s *S) TestCertificateUnset(c *check.C) {
+	var called bool
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "DELETE" && req.URL.Path == "/1.0/apps/myapp/certificate" &&
+				req.URL.Query().Get("cname") == "app.example.com"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := certificateUnset{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-c", "app.example.com"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Certificate removed.\n")
+}
+
+func (s *S) TestCertificateListInfo(c *check.C) {
+	c.Assert((&certificateList{}).Info(), check.NotNil)
+}
+
+func (s *S) TestCertificateList(c *check.C) {
+	notAfter := time.Date(2027, 3, 20, 12, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(map[string]string{
+		"www.example.com": "",
+		"app.example.com": generateCertificate(c, notAfter),
+	})
+	c.Assert(err, check.IsNil)
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/1.0/apps/myapp/certificate"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := certificateList{}
+	command.Flags().Parse(true, []string{"-a", "myapp"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+-----------------+----------------------+
+| CName           | Expires              |
++-----------------+----------------------+
+| app.example.com | 2027-03-20T12:00:00Z |
+| www.example.com | -                    |
++-----------------+----------------------+
+`
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
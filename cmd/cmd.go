@@ -79,6 +79,17 @@ func BuildBaseManager(name, version, versionHeader string, lookup Lookup) *Manag
 	m.Register(&targetRemove{})
 	m.Register(&targetSet{})
 	m.Register(userInfo{})
+	m.Register(&permissionCheck{})
+	m.Register(&webhookList{})
+	m.Register(&webhookCreate{})
+	m.Register(&webhookUpdate{})
+	m.Register(&webhookRemove{})
+	m.Register(&webhookDeliveries{})
+	m.Register(&teamQuotaView{})
+	m.Register(&teamQuotaChange{})
+	m.Register(&certificateSet{})
+	m.Register(&certificateUnset{})
+	m.Register(&certificateList{})
 	m.RegisterTopic("target", fmt.Sprintf(targetTopic, name))
 	return m
 }
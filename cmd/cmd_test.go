@@ -652,6 +652,53 @@ func (s *S) TestUserInfoIsRegisteredByBaseManager(c *check.C) {
 	c.Assert(info, check.FitsTypeOf, userInfo{})
 }
 
+func (s *S) TestPermissionCheckIsRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	cmd, ok := mngr.Commands["permission-check"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(cmd, check.FitsTypeOf, &permissionCheck{})
+}
+
+func (s *S) TestWebhookCommandsAreRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	expected := map[string]interface{}{
+		"webhook-list":       &webhookList{},
+		"webhook-create":     &webhookCreate{},
+		"webhook-update":     &webhookUpdate{},
+		"webhook-remove":     &webhookRemove{},
+		"webhook-deliveries": &webhookDeliveries{},
+	}
+	for name, cmdType := range expected {
+		cmd, ok := mngr.Commands[name]
+		c.Assert(ok, check.Equals, true, check.Commentf(name))
+		c.Assert(cmd, check.FitsTypeOf, cmdType)
+	}
+}
+
+func (s *S) TestTeamQuotaCommandsAreRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	cmd, ok := mngr.Commands["team-quota-view"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(cmd, check.FitsTypeOf, &teamQuotaView{})
+	cmd, ok = mngr.Commands["team-quota-change"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(cmd, check.FitsTypeOf, &teamQuotaChange{})
+}
+
+func (s *S) TestCertificateCommandsAreRegisteredByBaseManager(c *check.C) {
+	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
+	expected := map[string]interface{}{
+		"certificate-set":   &certificateSet{},
+		"certificate-unset": &certificateUnset{},
+		"certificate-list":  &certificateList{},
+	}
+	for name, cmdType := range expected {
+		cmd, ok := mngr.Commands[name]
+		c.Assert(ok, check.Equals, true, check.Commentf(name))
+		c.Assert(cmd, check.FitsTypeOf, cmdType)
+	}
+}
+
 func (s *S) TestInvalidCommandFuzzyMatch01(c *check.C) {
 	mngr := BuildBaseManager("tsuru", "1.0", "", nil)
 	var stdout, stderr bytes.Buffer
@@ -704,7 +751,9 @@ func (s *S) TestInvalidCommandFuzzyMatch03(c *check.C) {
 	expectedOutput := `.*: "list" is not a tsuru command. See "tsuru help".
 
 Did you mean?
+	certificate-list
 	target-list
+	webhook-list
 `
 	expectedOutput = strings.Replace(expectedOutput, "\n", "\\W", -1)
 	expectedOutput = strings.Replace(expectedOutput, "\t", "\\W+", -1)
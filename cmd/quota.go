@@ -0,0 +1,136 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+)
+
+type teamQuotaView struct{}
+
+func (c *teamQuotaView) Info() *Info {
+	return &Info{
+		Name:    "team-quota-view",
+		Usage:   "team-quota-view <team>",
+		Desc:    "Displays the quota of a team, along with the resources used by the apps it owns.",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *teamQuotaView) Run(context *Context, client *Client) error {
+	u, err := GetURL("/teams/" + context.Args[0] + "/quota")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	type quotaItem struct {
+		Limit int64
+		InUse int64
+	}
+	var quota struct {
+		Apps   quotaItem
+		Units  quotaItem
+		Memory quotaItem
+	}
+	err = json.NewDecoder(resp.Body).Decode(&quota)
+	if err != nil {
+		return err
+	}
+	table := NewTable()
+	table.Headers = Row{"Resource", "Limit", "In use"}
+	for _, item := range []struct {
+		name string
+		quotaItem
+	}{
+		{"Apps", quota.Apps},
+		{"Units", quota.Units},
+		{"Memory", quota.Memory},
+	} {
+		limit := "unlimited"
+		if item.Limit >= 0 {
+			limit = strconv.FormatInt(item.Limit, 10)
+		}
+		table.AddRow(Row{item.name, limit, strconv.FormatInt(item.InUse, 10)})
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type teamQuotaChange struct {
+	fs     *gnuflag.FlagSet
+	apps   string
+	units  string
+	memory string
+}
+
+func (c *teamQuotaChange) Info() *Info {
+	return &Info{
+		Name:  "team-quota-change",
+		Usage: "team-quota-change <team> [--apps <limit>] [--units <limit>] [--memory <limit>]",
+		Desc: `Changes the limits of apps, units and memory of the apps owned by a team.
+Only the given limits are changed. The memory limit is in bytes, and applies
+to the sum of the plan memory of every unit. Use "unlimited" or a negative
+number to remove a limit.`,
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *teamQuotaChange) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("team-quota-change", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.apps, "apps", "", "The maximum number of apps owned by the team")
+		c.fs.StringVar(&c.units, "units", "", "The maximum number of units of the apps owned by the team")
+		c.fs.StringVar(&c.memory, "memory", "", "The maximum memory, in bytes, of the units of the apps owned by the team")
+	}
+	return c.fs
+}
+
+func (c *teamQuotaChange) Run(context *Context, client *Client) error {
+	c.Flags()
+	v := url.Values{}
+	c.fs.Visit(func(flag *gnuflag.Flag) {
+		value := flag.Value.String()
+		if value == "unlimited" {
+			value = "-1"
+		}
+		v.Set(flag.Name, value)
+	})
+	if len(v) == 0 {
+		return fmt.Errorf("at least one of --apps, --units and --memory must be given")
+	}
+	u, err := GetURL("/teams/" + context.Args[0] + "/quota")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintf(context.Stdout, "Quota of team %q successfully updated.\n", context.Args[0])
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTeamQuotaViewInfo(c *check.C) {
+	c.Assert((&teamQuotaView{}).Info(), check.NotNil)
+}
+
+func (s *S) TestTeamQuotaView(c *check.C) {
+	var called bool
+	context := Context{[]string{"myteam"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"Apps":{"Limit":5,"InUse":2},"Units":{"Limit":-1,"InUse":4},"Memory":{"Limit":1024,"InUse":512}}`,
+			Status:  http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "GET" && req.URL.Path == "/1.0/teams/myteam/quota"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := teamQuotaView{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	expected := `+----------+-----------+--------+
+| Resource | Limit     | In use |
++----------+-----------+--------+
+| Apps     | 5         | 2      |
+| Units    | unlimited | 4      |
+| Memory   | 1024      | 512    |
++----------+-----------+--------+
+`
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
+
+func (s *S) TestTeamQuotaChangeInfo(c *check.C) {
+	c.Assert((&teamQuotaChange{}).Info(), check.NotNil)
+}
+
+func (s *S) TestTeamQuotaChange(c *check.C) {
+	var called bool
+	context := Context{[]string{"myteam"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := teamQuotaChange{}
+	command.Flags().Parse(true, []string{"--apps", "10", "--memory", "unlimited"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			req.ParseForm()
+			return req.Method == "PUT" && req.URL.Path == "/1.0/teams/myteam/quota" &&
+				req.Form.Get("apps") == "10" &&
+				req.Form.Get("memory") == "-1" &&
+				req.Form["units"] == nil
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Quota of team \"myteam\" successfully updated.\n")
+}
+
+func (s *S) TestTeamQuotaChangeWithoutLimits(c *check.C) {
+	context := Context{[]string{"myteam"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := teamQuotaChange{}
+	command.Flags().Parse(true, []string{})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, "at least one of --apps, --units and --memory must be given")
+}
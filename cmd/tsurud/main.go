@@ -12,6 +12,7 @@ import (
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/api"
+	_ "github.com/tsuru/tsuru/app/logstorage/file"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/provision"
 	_ "github.com/tsuru/tsuru/provision/docker"
@@ -29,6 +30,7 @@ func buildManager() *cmd.Manager {
 	m.Register(&tsurudCommand{Command: &migrateCmd{}})
 	m.Register(&tsurudCommand{Command: gandalfSyncCmd{}})
 	m.Register(&tsurudCommand{Command: createRootUserCmd{}})
+	m.Register(&tsurudCommand{Command: &routerProxyCmd{}})
 	m.Register(&migrationListCmd{})
 	registerProvisionersCommands(m)
 	return m
@@ -68,6 +68,15 @@ func (s *S) TestGandalfSyncCmdIsRegistered(c *check.C) {
 	c.Assert(sync.Command, check.FitsTypeOf, gandalfSyncCmd{})
 }
 
+func (s *S) TestRouterProxyCmdIsRegistered(c *check.C) {
+	manager := buildManager()
+	cmd, ok := manager.Commands["router-proxy"]
+	c.Assert(ok, check.Equals, true)
+	proxy, ok := cmd.(*tsurudCommand)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(proxy.Command, check.FitsTypeOf, &routerProxyCmd{})
+}
+
 func (s *S) TestShouldRegisterAllCommandsFromProvisioners(c *check.C) {
 	fp := provisiontest.NewFakeProvisioner()
 	p := CommandableProvisioner{FakeProvisioner: fp}
@@ -0,0 +1,56 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/router/builtin"
+)
+
+type routerProxyCmd struct {
+	fs     *gnuflag.FlagSet
+	router string
+	listen string
+}
+
+func (c *routerProxyCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	proxy, err := builtin.NewProxy(c.router)
+	if err != nil {
+		return err
+	}
+	err = proxy.Start()
+	if err != nil {
+		return err
+	}
+	defer proxy.Stop()
+	fmt.Fprintf(context.Stdout, "Router proxy for %q listening at %s...\n", c.router, c.listen)
+	return http.ListenAndServe(c.listen, proxy)
+}
+
+func (c *routerProxyCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "router-proxy",
+		Usage: "router-proxy [--router name] [--listen address]",
+		Desc: `Starts the reverse proxy serving the apps of a router of type builtin.
+Routes are read from the tsuru database, so the proxy must use the same
+configuration file as the tsuru api.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *routerProxyCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("router-proxy", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.router, "router", "builtin", "name of the builtin router in the config file")
+		c.fs.StringVar(&c.router, "r", "builtin", "name of the builtin router in the config file")
+		c.fs.StringVar(&c.listen, "listen", "0.0.0.0:80", "address the proxy listens to")
+		c.fs.StringVar(&c.listen, "l", "0.0.0.0:80", "address the proxy listens to")
+	}
+	return c.fs
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRouterProxyCmdInfo(c *check.C) {
+	c.Assert((&routerProxyCmd{}).Info(), check.NotNil)
+}
+
+func (s *S) TestRouterProxyCmdFlags(c *check.C) {
+	command := routerProxyCmd{}
+	flags := command.Flags()
+	c.Assert(flags.Lookup("router").DefValue, check.Equals, "builtin")
+	c.Assert(flags.Lookup("listen").DefValue, check.Equals, "0.0.0.0:80")
+	err := flags.Parse(true, []string{"-r", "myrouter", "-l", "127.0.0.1:8000"})
+	c.Assert(err, check.IsNil)
+	c.Assert(command.router, check.Equals, "myrouter")
+	c.Assert(command.listen, check.Equals, "127.0.0.1:8000")
+}
+
+func (s *S) TestRouterProxyCmdRunNotBuiltin(c *check.C) {
+	config.Set("routers:myrouter:type", "hipache")
+	defer config.Unset("routers:myrouter")
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := routerProxyCmd{router: "myrouter", listen: "127.0.0.1:0"}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `router "myrouter" is not a builtin router`)
+}
@@ -0,0 +1,360 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+)
+
+type webhookEventFilter struct {
+	TargetTypes  []string
+	TargetValues []string
+	KindNames    []string
+	ErrorOnly    bool
+	SuccessOnly  bool
+}
+
+func (f *webhookEventFilter) String() string {
+	var parts []string
+	for _, item := range []struct {
+		name   string
+		values []string
+	}{
+		{"kind", f.KindNames},
+		{"target-type", f.TargetTypes},
+		{"target-value", f.TargetValues},
+	} {
+		for _, v := range item.values {
+			parts = append(parts, item.name+"="+v)
+		}
+	}
+	if f.ErrorOnly {
+		parts = append(parts, "error-only")
+	}
+	if f.SuccessOnly {
+		parts = append(parts, "success-only")
+	}
+	return strings.Join(parts, "\n")
+}
+
+type webhookData struct {
+	Name        string
+	Description string
+	TeamOwner   string
+	URL         string
+	EventFilter webhookEventFilter
+	Secret      string
+}
+
+// webhookFlags holds the flags shared by webhook-create and webhook-update.
+type webhookFlags struct {
+	fs           *gnuflag.FlagSet
+	team         string
+	description  string
+	url          string
+	secret       string
+	kinds        StringSliceFlag
+	targetTypes  StringSliceFlag
+	targetValues StringSliceFlag
+	errorOnly    bool
+	successOnly  bool
+}
+
+func (f *webhookFlags) flags(name string) *gnuflag.FlagSet {
+	if f.fs == nil {
+		f.fs = gnuflag.NewFlagSet(name, gnuflag.ExitOnError)
+		f.fs.StringVar(&f.team, "team", "", "The team owning the webhook")
+		f.fs.StringVar(&f.team, "t", "", "The team owning the webhook")
+		f.fs.StringVar(&f.description, "description", "", "The description of the webhook")
+		f.fs.StringVar(&f.description, "d", "", "The description of the webhook")
+		f.fs.StringVar(&f.secret, "secret", "", "The secret used to sign deliveries, a random one is generated when omitted")
+		f.fs.Var(&f.kinds, "kind", "Event kind matched by the webhook, may be used multiple times")
+		f.fs.Var(&f.kinds, "k", "Event kind matched by the webhook, may be used multiple times")
+		f.fs.Var(&f.targetTypes, "target-type", "Event target type matched by the webhook, may be used multiple times")
+		f.fs.Var(&f.targetValues, "target-value", "Event target value matched by the webhook, may be used multiple times")
+		f.fs.BoolVar(&f.errorOnly, "error-only", false, "Only match events finished with an error")
+		f.fs.BoolVar(&f.successOnly, "success-only", false, "Only match events finished successfully")
+	}
+	return f.fs
+}
+
+// values returns the form values of the flags explicitly set.
+func (f *webhookFlags) values() url.Values {
+	v := url.Values{}
+	f.fs.Visit(func(flag *gnuflag.Flag) {
+		switch flag.Name {
+		case "team", "t":
+			v.Set("team", f.team)
+		case "description", "d":
+			v.Set("description", f.description)
+		case "url":
+			v.Set("url", f.url)
+		case "secret":
+			v.Set("secret", f.secret)
+		case "kind", "k":
+			v["kind"] = f.kinds
+		case "target-type":
+			v["target-type"] = f.targetTypes
+		case "target-value":
+			v["target-value"] = f.targetValues
+		case "error-only":
+			v.Set("error-only", strconv.FormatBool(f.errorOnly))
+		case "success-only":
+			v.Set("success-only", strconv.FormatBool(f.successOnly))
+		}
+	})
+	return v
+}
+
+const webhookFlagsHelp = `The --kind, --target-type and --target-value flags may be used multiple times.
+Events match the webhook when they match any of the values of each flag. A
+kind also matches its children, e.g. app.update matches app.update.env.set.
+Webhooks only receive events of apps and jobs owned by their team, and of the
+team itself.`
+
+type webhookCreate struct {
+	webhookFlags
+}
+
+func (c *webhookCreate) Info() *Info {
+	return &Info{
+		Name:  "webhook-create",
+		Usage: "webhook-create <name> <url> [-t/--team <team>] [-d/--description <description>] [--secret <secret>] [-k/--kind <kind>]... [--target-type <type>]... [--target-value <value>]... [--error-only] [--success-only]",
+		Desc: `Creates a webhook, an HTTP endpoint receiving the events matching its filter.
+
+Events are sent as JSON in POST requests, signed with the webhook secret in
+the X-Tsuru-Signature header.
+
+` + webhookFlagsHelp,
+		MinArgs: 2,
+		MaxArgs: 2,
+	}
+}
+
+func (c *webhookCreate) Flags() *gnuflag.FlagSet {
+	return c.flags("webhook-create")
+}
+
+func (c *webhookCreate) Run(context *Context, client *Client) error {
+	c.Flags()
+	v := c.values()
+	v.Set("name", context.Args[0])
+	v.Set("url", context.Args[1])
+	u, err := GetURLVersion("1.1", "/webhooks")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var data webhookData
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Webhook %q successfully created.\n", data.Name)
+	fmt.Fprintf(context.Stdout, "Secret: %s\n", data.Secret)
+	return nil
+}
+
+type webhookUpdate struct {
+	webhookFlags
+}
+
+func (c *webhookUpdate) Info() *Info {
+	return &Info{
+		Name:  "webhook-update",
+		Usage: "webhook-update <name> [--url <url>] [-t/--team <team>] [-d/--description <description>] [--secret <secret>] [-k/--kind <kind>]... [--target-type <type>]... [--target-value <value>]... [--error-only=true|false] [--success-only=true|false]",
+		Desc: `Updates a webhook. Only the given flags are changed, a list flag replaces all
+the current values of the list.
+
+` + webhookFlagsHelp,
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *webhookUpdate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.flags("webhook-update").StringVar(&c.url, "url", "", "The URL receiving the events")
+	}
+	return c.fs
+}
+
+func (c *webhookUpdate) Run(context *Context, client *Client) error {
+	c.Flags()
+	u, err := GetURLVersion("1.1", "/webhooks/"+context.Args[0])
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", u, strings.NewReader(c.values().Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintf(context.Stdout, "Webhook %q successfully updated.\n", context.Args[0])
+	return nil
+}
+
+type webhookList struct{}
+
+func (c *webhookList) Info() *Info {
+	return &Info{
+		Name:  "webhook-list",
+		Usage: "webhook-list",
+		Desc:  "Lists the webhooks owned by the teams of the user.",
+	}
+}
+
+func (c *webhookList) Run(context *Context, client *Client) error {
+	u, err := GetURLVersion("1.1", "/webhooks")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		fmt.Fprintln(context.Stdout, "No webhooks available.")
+		return nil
+	}
+	var webhooks []webhookData
+	err = json.NewDecoder(resp.Body).Decode(&webhooks)
+	if err != nil {
+		return err
+	}
+	table := NewTable()
+	table.Headers = Row{"Name", "Team", "URL", "Filter"}
+	table.LineSeparator = true
+	for _, w := range webhooks {
+		table.AddRow(Row{w.Name, w.TeamOwner, w.URL, w.EventFilter.String()})
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type webhookRemove struct {
+	ConfirmationCommand
+}
+
+func (c *webhookRemove) Info() *Info {
+	return &Info{
+		Name:    "webhook-remove",
+		Usage:   "webhook-remove <name> [-y/--assume-yes]",
+		Desc:    "Removes a webhook along with its delivery history.",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *webhookRemove) Run(context *Context, client *Client) error {
+	name := context.Args[0]
+	if !c.Confirm(context, fmt.Sprintf("Are you sure you want to remove webhook %q?", name)) {
+		return nil
+	}
+	u, err := GetURLVersion("1.1", "/webhooks/"+name)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Fprintf(context.Stdout, "Webhook %q successfully removed.\n", name)
+	return nil
+}
+
+type webhookDeliveries struct{}
+
+func (c *webhookDeliveries) Info() *Info {
+	return &Info{
+		Name:    "webhook-deliveries",
+		Usage:   "webhook-deliveries <name>",
+		Desc:    "Lists the last delivery attempts of a webhook, newest first.",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (c *webhookDeliveries) Run(context *Context, client *Client) error {
+	u, err := GetURLVersion("1.1", "/webhooks/"+context.Args[0]+"/deliveries")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		fmt.Fprintln(context.Stdout, "No deliveries available.")
+		return nil
+	}
+	var deliveries []struct {
+		EventID    string
+		EventKind  string
+		Attempt    int
+		StatusCode int
+		Error      string
+		Timestamp  time.Time
+	}
+	err = json.NewDecoder(resp.Body).Decode(&deliveries)
+	if err != nil {
+		return err
+	}
+	table := NewTable()
+	table.Headers = Row{"Date", "Event", "Kind", "Attempt", "Status", "Error"}
+	for _, d := range deliveries {
+		status := "-"
+		if d.StatusCode != 0 {
+			status = strconv.Itoa(d.StatusCode)
+		}
+		table.AddRow(Row{
+			d.Timestamp.Local().Format(time.Stamp),
+			d.EventID,
+			d.EventKind,
+			strconv.Itoa(d.Attempt),
+			status,
+			d.Error,
+		})
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestWebhookCreateInfo(c *check.C) {
+	c.Assert((&webhookCreate{}).Info(), check.NotNil)
+}
+
+func (s *S) TestWebhookCreate(c *check.C) {
+	var called bool
+	context := Context{[]string{"myhook", "http://example.com/hook"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := webhookCreate{}
+	command.Flags().Parse(true, []string{"-t", "team1", "-k", "app.deploy", "--kind", "app.update", "--error-only"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"Name":"myhook","TeamOwner":"team1","URL":"http://example.com/hook","Secret":"abc123"}`,
+			Status:  http.StatusCreated,
+		},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			req.ParseForm()
+			return req.Method == "POST" && req.URL.Path == "/1.1/webhooks" &&
+				req.Form.Get("name") == "myhook" &&
+				req.Form.Get("url") == "http://example.com/hook" &&
+				req.Form.Get("team") == "team1" &&
+				req.Form.Get("error-only") == "true" &&
+				len(req.Form["kind"]) == 2 &&
+				req.Form["kind"][0] == "app.deploy" && req.Form["kind"][1] == "app.update" &&
+				req.Form["success-only"] == nil && req.Form["description"] == nil
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	expected := "Webhook \"myhook\" successfully created.\nSecret: abc123\n"
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
+
+func (s *S) TestWebhookUpdate(c *check.C) {
+	var called bool
+	context := Context{[]string{"myhook"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := webhookUpdate{}
+	command.Flags().Parse(true, []string{"--url", "https://example.com/new", "--target-type", "app", "--error-only=false"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			req.ParseForm()
+			return req.Method == "PUT" && req.URL.Path == "/1.1/webhooks/myhook" &&
+				req.Form.Get("url") == "https://example.com/new" &&
+				req.Form.Get("target-type") == "app" &&
+				req.Form.Get("error-only") == "false" &&
+				req.Form["team"] == nil && req.Form["kind"] == nil
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Webhook \"myhook\" successfully updated.\n")
+}
+
+func (s *S) TestWebhookList(c *check.C) {
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `[{"Name":"myhook","TeamOwner":"team1","URL":"http://example.com/hook","EventFilter":{"KindNames":["app.deploy"],"ErrorOnly":true}}]`,
+			Status:  http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/1.1/webhooks"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := (&webhookList{}).Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+--------+-------+-------------------------+-----------------+
+| Name   | Team  | URL                     | Filter          |
++--------+-------+-------------------------+-----------------+
+| myhook | team1 | http://example.com/hook | kind=app.deploy |
+|        |       |                         | error-only      |
++--------+-------+-------------------------+-----------------+
+`
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+}
+
+func (s *S) TestWebhookListEmpty(c *check.C) {
+	context := Context{[]string{}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.Transport{Status: http.StatusNoContent}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := (&webhookList{}).Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "No webhooks available.\n")
+}
+
+func (s *S) TestWebhookRemove(c *check.C) {
+	var called bool
+	context := Context{[]string{"myhook"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	command := webhookRemove{}
+	command.Flags().Parse(true, []string{"-y"})
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "DELETE" && req.URL.Path == "/1.1/webhooks/myhook"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, "Webhook \"myhook\" successfully removed.\n")
+}
+
+func (s *S) TestWebhookDeliveries(c *check.C) {
+	context := Context{[]string{"myhook"}, globalManager.stdout, globalManager.stderr, globalManager.stdin}
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `[
+	{"EventID":"57f7a3d9e4b0a5f1c2d3e4f5","EventKind":"app.deploy","Attempt":2,"StatusCode":0,"Error":"connection refused","Timestamp":"2016-10-07T13:00:00Z"},
+	{"EventID":"57f7a3d9e4b0a5f1c2d3e4f6","EventKind":"app.deploy","Attempt":1,"StatusCode":200,"Timestamp":"2016-10-07T12:00:00Z"}
+]`,
+			Status: http.StatusOK,
+		},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/1.1/webhooks/myhook/deliveries"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	err := (&webhookDeliveries{}).Run(&context, client)
+	c.Assert(err, check.IsNil)
+	out := globalManager.stdout.(*bytes.Buffer).String()
+	c.Assert(out, check.Matches, `(?s).*\| 57f7a3d9e4b0a5f1c2d3e4f5 \| app\.deploy \| 2 +\| - +\| connection refused \|.*`)
+	c.Assert(out, check.Matches, `(?s).*\| 57f7a3d9e4b0a5f1c2d3e4f6 \| app\.deploy \| 1 +\| 200 +\| +\|.*`)
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cron provides a parser for standard five-field cron expressions
+// and the calculation of their activation times.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears limits how far in the future Next looks for a matching time,
+// so expressions that never match (like "0 0 31 2 *") don't loop forever.
+const maxSearchYears = 5
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fieldBounds = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule represents a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar track whether the day fields were unrestricted,
+	// following the cron rule that a time matches when either day field
+	// matches if both are restricted.
+	domStar, dowStar bool
+}
+
+// Parse parses a cron expression in the standard five-field format (minute,
+// hour, day of month, month and day of week). Each field accepts "*", single
+// values, ranges ("1-5"), lists ("1,15") and steps ("*/10", "0-30/5"). The
+// shortcuts @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are also accepted.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shortcuts[expr]; ok {
+		expr = s
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(fieldBounds) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields, got %d", expr, len(fieldBounds), len(fields))
+	}
+	var values [5]uint64
+	for i, f := range fields {
+		v, err := parseField(f, fieldBounds[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err)
+		}
+		values[i] = v
+	}
+	s := &Schedule{
+		minute:  values[0],
+		hour:    values[1],
+		dom:     values[2],
+		month:   values[3],
+		dow:     values[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	// 7 is an alias for sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		v, err := parseRange(part, b)
+		if err != nil {
+			return 0, err
+		}
+		bits |= v
+	}
+	return bits, nil
+}
+
+func parseRange(expr string, b bounds) (uint64, error) {
+	var (
+		start, end int
+		step       = 1
+		err        error
+	)
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, fmt.Errorf("invalid %s %q", b.name, expr)
+	}
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+	switch {
+	case lowAndHigh[0] == "*" && len(lowAndHigh) == 1:
+		start, end = b.min, b.max
+	case len(lowAndHigh) == 1:
+		start, err = parseValue(lowAndHigh[0], b)
+		if err != nil {
+			return 0, err
+		}
+		end = start
+		if len(rangeAndStep) == 2 {
+			end = b.max
+		}
+	case len(lowAndHigh) == 2:
+		start, err = parseValue(lowAndHigh[0], b)
+		if err != nil {
+			return 0, err
+		}
+		end, err = parseValue(lowAndHigh[1], b)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("invalid %s %q", b.name, expr)
+	}
+	if len(rangeAndStep) == 2 {
+		step, err = strconv.Atoi(rangeAndStep[1])
+		if err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid step in %s %q", b.name, expr)
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("invalid range in %s %q", b.name, expr)
+	}
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func parseValue(value string, b bounds) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < b.min || n > b.max {
+		return 0, fmt.Errorf("invalid %s %q, must be between %d and %d", b.name, value, b.min, b.max)
+	}
+	return n, nil
+}
+
+// Next returns the first activation time of the schedule strictly after the
+// given time, in its location. It returns the zero time if the schedule
+// never activates.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(maxSearchYears, 0, 0)
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	domMatch := has(s.dom, t.Day())
+	dowMatch := has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func has(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
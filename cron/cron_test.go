@@ -0,0 +1,78 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestParseInvalid(c *check.C) {
+	var tests = []struct {
+		expr string
+		err  string
+	}{
+		{"", `invalid cron expression "": expected 5 fields, got 0`},
+		{"* * * *", `invalid cron expression "\* \* \* \*": expected 5 fields, got 4`},
+		{"60 * * * *", `.*invalid minute "60", must be between 0 and 59`},
+		{"* 24 * * *", `.*invalid hour "24", must be between 0 and 23`},
+		{"* * 0 * *", `.*invalid day of month "0", must be between 1 and 31`},
+		{"* * * 13 *", `.*invalid month "13", must be between 1 and 12`},
+		{"* * * * 8", `.*invalid day of week "8", must be between 0 and 7`},
+		{"*/0 * * * *", `.*invalid step in minute "\*/0"`},
+		{"10-5 * * * *", `.*invalid range in minute "10-5"`},
+		{"1-2-3 * * * *", `.*invalid minute "1-2-3"`},
+		{"a * * * *", `.*invalid minute "a".*`},
+		{"@often", `.*expected 5 fields, got 1`},
+	}
+	for _, t := range tests {
+		_, err := Parse(t.expr)
+		c.Check(err, check.ErrorMatches, t.err, check.Commentf("expr %q", t.expr))
+	}
+}
+
+func (s *S) TestNext(c *check.C) {
+	base := time.Date(2016, 10, 14, 10, 30, 15, 0, time.UTC) // friday
+	var tests = []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2016, 10, 14, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, 10, 14, 10, 45, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2016, 10, 14, 11, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2016, 10, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 8 * * *", time.Date(2016, 10, 15, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2016, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2016, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2016, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 1", time.Date(2016, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2016, 10, 14, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2016, 10, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2016, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2016, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, t := range tests {
+		sched, err := Parse(t.expr)
+		c.Assert(err, check.IsNil)
+		c.Check(sched.Next(base), check.DeepEquals, t.expected, check.Commentf("expr %q", t.expr))
+	}
+}
+
+func (s *S) TestNextNeverMatches(c *check.C) {
+	sched, err := Parse("0 0 31 2 *")
+	c.Assert(err, check.IsNil)
+	c.Assert(sched.Next(time.Now()).IsZero(), check.Equals, true)
+}
@@ -13,6 +13,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db/storage"
@@ -23,6 +24,10 @@ import (
 const (
 	DefaultDatabaseURL  = "127.0.0.1:27017"
 	DefaultDatabaseName = "tsuru"
+
+	// unitMetricsTTL is way longer than the age of the metrics used by
+	// autoscale, it only bounds the size of the unit_metrics collection.
+	unitMetricsTTL = 24 * time.Hour
 )
 
 type Storage struct {
@@ -218,3 +223,90 @@ func (s *Storage) Events() *storage.Collection {
 	c.EnsureIndex(kindIndex)
 	return c
 }
+
+// AutoScaleRules returns the collection of app autoscale rules from MongoDB.
+func (s *Storage) AutoScaleRules() *storage.Collection {
+	processIndex := mgo.Index{Key: []string{"app", "process"}, Unique: true}
+	c := s.Collection("autoscale_rules")
+	c.EnsureIndex(processIndex)
+	return c
+}
+
+// UnitMetrics returns the collection of metrics reported by units from
+// MongoDB. Reports expire after unitMetricsTTL, so metrics of removed units
+// don't pile up.
+func (s *Storage) UnitMetrics() *storage.Collection {
+	updatedIndex := mgo.Index{Key: []string{"updatedat"}, ExpireAfter: unitMetricsTTL}
+	c := s.Collection("unit_metrics")
+	c.EnsureIndex(updatedIndex)
+	return c
+}
+
+// DeployApprovals returns the collection of deploys waiting for approval
+// from MongoDB.
+func (s *Storage) DeployApprovals() *storage.Collection {
+	appIndex := mgo.Index{Key: []string{"app", "status"}}
+	c := s.Collection("deploy_approvals")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// Jobs returns the app jobs collection from MongoDB.
+func (s *Storage) Jobs() *storage.Collection {
+	nameIndex := mgo.Index{Key: []string{"app", "name"}, Unique: true}
+	nextRunIndex := mgo.Index{Key: []string{"nextrun"}}
+	c := s.Collection("jobs")
+	c.EnsureIndex(nameIndex)
+	c.EnsureIndex(nextRunIndex)
+	return c
+}
+
+// RoleElevations returns the collection of requests for temporary roles from
+// MongoDB.
+func (s *Storage) RoleElevations() *storage.Collection {
+	return s.Collection("role_elevations")
+}
+
+// PersonalTokens returns the collection of users' personal access tokens
+// from MongoDB.
+func (s *Storage) PersonalTokens() *storage.Collection {
+	tokenIndex := mgo.Index{Key: []string{"apikey"}, Unique: true}
+	nameIndex := mgo.Index{Key: []string{"email", "name"}, Unique: true}
+	c := s.Collection("personal_tokens")
+	c.EnsureIndex(tokenIndex)
+	c.EnsureIndex(nameIndex)
+	return c
+}
+
+// Certificates returns the collection of TLS certificates of app CNames from
+// MongoDB.
+func (s *Storage) Certificates() *storage.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.Collection("certificates")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// Webhooks returns the collection of webhooks subscribed to events from
+// MongoDB.
+func (s *Storage) Webhooks() *storage.Collection {
+	return s.Collection("webhooks")
+}
+
+// WebhookDeliveries returns the collection holding the delivery history of
+// webhooks from MongoDB.
+func (s *Storage) WebhookDeliveries() *storage.Collection {
+	webhookIndex := mgo.Index{Key: []string{"webhook", "-timestamp"}}
+	c := s.Collection("webhook_deliveries")
+	c.EnsureIndex(webhookIndex)
+	return c
+}
+
+// WebhookRetries returns the collection holding the failed webhook deliveries
+// waiting to be retried from MongoDB.
+func (s *Storage) WebhookRetries() *storage.Collection {
+	notBeforeIndex := mgo.Index{Key: []string{"notbefore"}}
+	c := s.Collection("webhook_retries")
+	c.EnsureIndex(notBeforeIndex)
+	return c
+}
@@ -86,6 +86,51 @@ func (s *S) TestTokens(c *check.C) {
 	c.Assert(tokens, check.DeepEquals, tokensc)
 }
 
+func (s *S) TestPersonalTokens(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	tokens := strg.PersonalTokens()
+	tokensc := strg.Collection("personal_tokens")
+	c.Assert(tokens, check.DeepEquals, tokensc)
+}
+
+func (s *S) TestRoleElevations(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	elevations := strg.RoleElevations()
+	elevationsc := strg.Collection("role_elevations")
+	c.Assert(elevations, check.DeepEquals, elevationsc)
+}
+
+func (s *S) TestCertificates(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	certificates := strg.Certificates()
+	certificatesc := strg.Collection("certificates")
+	c.Assert(certificates, check.DeepEquals, certificatesc)
+}
+
+func (s *S) TestWebhooks(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	webhooks := strg.Webhooks()
+	webhooksc := strg.Collection("webhooks")
+	c.Assert(webhooks, check.DeepEquals, webhooksc)
+}
+
+func (s *S) TestWebhookDeliveries(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	deliveries := strg.WebhookDeliveries()
+	deliveriesc := strg.Collection("webhook_deliveries")
+	c.Assert(deliveries, check.DeepEquals, deliveriesc)
+}
+
 func (s *S) TestPasswordTokens(c *check.C) {
 	strg, err := Conn()
 	c.Assert(err, check.IsNil)
@@ -202,3 +247,57 @@ func (s *S) TestRoles(c *check.C) {
 	rolesc := strg.Collection("roles")
 	c.Assert(roles, check.DeepEquals, rolesc)
 }
+
+func (s *S) TestJobs(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	jobs := strg.Jobs()
+	jobsc := strg.Collection("jobs")
+	c.Assert(jobs, check.DeepEquals, jobsc)
+	c.Assert(jobs, HasUniqueIndex, []string{"app", "name"})
+}
+
+func (s *S) TestAutoScaleRules(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	rules := strg.AutoScaleRules()
+	rulesc := strg.Collection("autoscale_rules")
+	c.Assert(rules, check.DeepEquals, rulesc)
+	c.Assert(rules, HasUniqueIndex, []string{"app", "process"})
+}
+
+func (s *S) TestUnitMetrics(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	metrics := strg.UnitMetrics()
+	metricsc := strg.Collection("unit_metrics")
+	c.Assert(metrics, check.DeepEquals, metricsc)
+}
+
+func (s *S) TestUnitMetricsExpireIndex(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	indexes, err := strg.UnitMetrics().Indexes()
+	c.Assert(err, check.IsNil)
+	var found bool
+	for _, index := range indexes {
+		if reflect.DeepEqual(index.Key, []string{"updatedat"}) {
+			found = true
+			c.Assert(index.ExpireAfter, check.Equals, unitMetricsTTL)
+		}
+	}
+	c.Assert(found, check.Equals, true)
+}
+
+func (s *S) TestDeployApprovals(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	approvals := strg.DeployApprovals()
+	approvalsc := strg.Collection("deploy_approvals")
+	c.Assert(approvals, check.DeepEquals, approvalsc)
+}
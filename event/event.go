@@ -25,6 +25,7 @@ import (
 var (
 	lockUpdateInterval = 30 * time.Second
 	lockExpireTimeout  = 5 * time.Minute
+	logFlushInterval   = time.Second
 	updater            = lockUpdater{
 		addCh:    make(chan *Target),
 		removeCh: make(chan *Target),
@@ -44,9 +45,10 @@ var (
 	ErrInvalidKind       = ErrValidation("event kind must not be set on internal events")
 	ErrInvalidTargetType = errors.New("invalid event target type")
 
-	OwnerTypeUser     = ownerType("user")
-	OwnerTypeApp      = ownerType("app")
-	OwnerTypeInternal = ownerType("internal")
+	OwnerTypeUser           = ownerType("user")
+	OwnerTypeApp            = ownerType("app")
+	OwnerTypeInternal       = ownerType("internal")
+	OwnerTypeServiceAccount = ownerType("service-account")
 
 	KindTypePermission = kindType("permission")
 	KindTypeInternal   = kindType("internal")
@@ -63,6 +65,8 @@ var (
 	TargetTypeRole            = TargetType("role")
 	TargetTypePlatform        = TargetType("platform")
 	TargetTypePlan            = TargetType("plan")
+	TargetTypeJob             = TargetType("job")
+	TargetTypeWebhook         = TargetType("webhook")
 )
 
 const (
@@ -183,6 +187,10 @@ func GetTargetType(t string) (TargetType, error) {
 		return TargetTypeTeam, nil
 	case "user":
 		return TargetTypeUser, nil
+	case "job":
+		return TargetTypeJob, nil
+	case "webhook":
+		return TargetTypeWebhook, nil
 	}
 	return TargetType(""), ErrInvalidTargetType
 }
@@ -233,8 +241,12 @@ func getThrottling(t *Target, k *Kind) *ThrottlingSpec {
 
 type Event struct {
 	eventData
-	logBuffer safe.Buffer
-	logWriter io.Writer
+	logBuffer    safe.Buffer
+	logWriter    io.Writer
+	logFlushMut  sync.Mutex
+	logFlushTime time.Time
+	logFlushing  bool
+	logFlushWg   sync.WaitGroup
 }
 
 type Opts struct {
@@ -462,6 +474,41 @@ func List(filter *Filter) ([]Event, error) {
 	return evts, nil
 }
 
+// ListChanged returns the events matching the filter that are running or
+// that started or finished after since, sorted by start time. The limit,
+// skip and sort options of the filter are ignored.
+func ListChanged(filter *Filter, since time.Time) ([]Event, error) {
+	query, err := filter.toQuery()
+	if err != nil {
+		if err == errInvalidQuery {
+			return nil, nil
+		}
+		return nil, err
+	}
+	changed := bson.M{"$or": []bson.M{
+		{"running": true},
+		{"starttime": bson.M{"$gt": since}},
+		{"endtime": bson.M{"$gt": since}},
+	}}
+	parts, _ := query["$and"].([]bson.M)
+	query["$and"] = append(parts, changed)
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var allData []eventData
+	err = conn.Events().Find(query).Sort("starttime").All(&allData)
+	if err != nil {
+		return nil, err
+	}
+	evts := make([]Event, len(allData))
+	for i := range evts {
+		evts[i].eventData = allData[i]
+	}
+	return evts, nil
+}
+
 func MarkAsRemoved(target Target) error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -562,6 +609,9 @@ func newEvt(opts *Opts) (*Event, error) {
 	} else if opts.Owner.IsAppToken() {
 		o.Type = OwnerTypeApp
 		o.Name = opts.Owner.GetAppName()
+	} else if apiToken, ok := opts.Owner.(*auth.APIToken); ok && apiToken.IsServiceAccount() {
+		o.Type = OwnerTypeServiceAccount
+		o.Name = apiToken.GetUserName()
 	} else {
 		o.Type = OwnerTypeUser
 		o.Name = opts.Owner.GetUserName()
@@ -696,13 +746,55 @@ func (e *Event) Logf(format string, params ...interface{}) {
 		fmt.Fprintf(e.logWriter, format, params...)
 	}
 	fmt.Fprintf(&e.logBuffer, format, params...)
+	e.flushLog()
 }
 
 func (e *Event) Write(data []byte) (int, error) {
 	if e.logWriter != nil {
 		e.logWriter.Write(data)
 	}
-	return e.logBuffer.Write(data)
+	n, err := e.logBuffer.Write(data)
+	e.flushLog()
+	return n, err
+}
+
+// flushLog stores the log of the running event, at most once every
+// logFlushInterval, so it can be followed before the event is done. The log
+// is stored in a background goroutine, keeping writers off the database round
+// trip, and flushes are skipped while a previous one is still in flight.
+func (e *Event) flushLog() {
+	e.logFlushMut.Lock()
+	defer e.logFlushMut.Unlock()
+	now := time.Now()
+	if e.logFlushing || now.Sub(e.logFlushTime) < logFlushInterval {
+		return
+	}
+	e.logFlushTime = now
+	e.logFlushing = true
+	e.logFlushWg.Add(1)
+	go e.storeLog(e.logBuffer.String())
+}
+
+func (e *Event) storeLog(logData string) {
+	defer func() {
+		e.logFlushMut.Lock()
+		e.logFlushing = false
+		e.logFlushMut.Unlock()
+		e.logFlushWg.Done()
+	}()
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[events] unable to store log of event %s: %s", e.UniqueID.Hex(), err)
+		return
+	}
+	defer conn.Close()
+	err = conn.Events().Update(
+		bson.M{"_id": e.ID, "uniqueid": e.UniqueID, "running": true},
+		bson.M{"$set": bson.M{"log": logData}},
+	)
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("[events] unable to store log of event %s: %s", e.UniqueID.Hex(), err)
+	}
 }
 
 func (e *Event) TryCancel(reason, owner string) error {
@@ -806,6 +898,7 @@ func (e *Event) done(evtErr error, customData interface{}, abort bool) (err erro
 	if err != nil {
 		return err
 	}
+	e.logFlushWg.Wait()
 	e.Running = false
 	e.Log = e.logBuffer.String()
 	var dbEvt Event
@@ -142,6 +142,17 @@ func (s *S) TestNewLocks(c *check.C) {
 	c.Assert(err, check.ErrorMatches, `event locked: app\(myapp\) running "app.update.env.set" start by user me@me.com at .+`)
 }
 
+func (s *S) TestNewServiceAccountOwner(c *check.C) {
+	token := &auth.APIToken{
+		Token:     "abc123",
+		UserEmail: auth.ServiceAccountEmail("myteam", "ci-bot"),
+		UserType:  auth.UserTypeServiceAccount,
+	}
+	evt, err := New(&Opts{Target: Target{Type: "app", Value: "myapp"}, Kind: permission.PermAppDeploy, Owner: token})
+	c.Assert(err, check.IsNil)
+	c.Assert(evt.Owner, check.DeepEquals, Owner{Type: OwnerTypeServiceAccount, Name: "ci-bot@myteam.service-account"})
+}
+
 func (s *S) TestNewDoneDisableLock(c *check.C) {
 	evt, err := New(&Opts{
 		Target:      Target{Type: "app", Value: "myapp"},
@@ -307,6 +318,32 @@ func (s *S) TestEventLogfWithWriter(c *check.C) {
 	c.Assert(evts[0].Log, check.Equals, "hey 42\n")
 }
 
+func (s *S) TestEventLogFlushedWhileRunning(c *check.C) {
+	evt, err := New(&Opts{Target: Target{Type: "app", Value: "myapp"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	evt.Logf("first")
+	evt.Write([]byte("second\n"))
+	evt.logFlushWg.Wait()
+	dbEvt, err := GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Log, check.Equals, "first\n")
+	evt.logFlushTime = time.Time{}
+	evt.Logf("third")
+	evt.logFlushWg.Wait()
+	dbEvt, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Running, check.Equals, true)
+	c.Assert(dbEvt.Log, check.Equals, "first\nsecond\nthird\n")
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	evt.logFlushTime = time.Time{}
+	evt.Logf("after done")
+	evt.logFlushWg.Wait()
+	dbEvt, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Log, check.Equals, "first\nsecond\nthird\n")
+}
+
 func (s *S) TestEventCancel(c *check.C) {
 	evt, err := New(&Opts{Target: Target{Type: "app", Value: "myapp"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token, Cancelable: true})
 	c.Assert(err, check.IsNil)
@@ -610,6 +647,7 @@ func (s *S) TestGetTargetType(c *check.C) {
 		{"service-instance", TargetTypeServiceInstance, nil},
 		{"team", TargetTypeTeam, nil},
 		{"user", TargetTypeUser, nil},
+		{"job", TargetTypeJob, nil},
 		{"invalid", "", ErrInvalidTargetType},
 	}
 	for _, t := range tests {
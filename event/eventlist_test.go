@@ -134,6 +134,36 @@ func (s *S) TestListFilterMany(c *check.C) {
 	}, Sort: "_id"}, []event.Event{allEvts[0], allEvts[4]})
 }
 
+func (s *S) TestListChanged(c *check.C) {
+	old, err := event.New(&event.Opts{Target: event.Target{Type: "app", Value: "old"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = old.Done(nil)
+	c.Assert(err, check.IsNil)
+	running, err := event.New(&event.Opts{Target: event.Target{Type: "app", Value: "running"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	since := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	finished, err := event.New(&event.Opts{Target: event.Target{Type: "app", Value: "finished"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = finished.Done(nil)
+	c.Assert(err, check.IsNil)
+	other, err := event.New(&event.Opts{Target: event.Target{Type: "team", Value: "myteam"}, Kind: permission.PermTeamCreate, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	filter := &event.Filter{AllowedTargets: []event.TargetFilter{{Type: "app"}}}
+	evts, err := event.ListChanged(filter, since)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 2)
+	c.Assert(evts[0].UniqueID, check.Equals, running.UniqueID)
+	c.Assert(evts[1].UniqueID, check.Equals, finished.UniqueID)
+	evts, err = event.ListChanged(&event.Filter{Target: event.Target{Type: "team"}}, since)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].UniqueID, check.Equals, other.UniqueID)
+	evts, err = event.ListChanged(&event.Filter{AllowedTargets: []event.TargetFilter{}}, since)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
 func (s *S) TestGetByID(c *check.C) {
 	evt, err := event.New(&event.Opts{Target: event.Target{Type: "app", Value: "myapp"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
 	c.Assert(err, check.IsNil)
@@ -0,0 +1,465 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	exportCheckpointsCollection = "event_export_checkpoints"
+	exportLogTag                = "[event exporter]"
+
+	defaultExportInterval  = 10 * time.Second
+	defaultExportBatchSize = 100
+	defaultExportDelay     = 5 * time.Second
+	defaultExportAttempts  = 5
+	defaultExportLease     = time.Minute
+)
+
+var (
+	exportSinkFactories = make(map[string]ExportSinkFactory)
+
+	exporterMut           sync.Mutex
+	exporterInstance      *exporter
+	internalExportTargets []exportTarget
+
+	errExportLeaseHeld = errors.New("checkpoint is leased by another exporter")
+)
+
+// ExportRecord is the representation of a finished event delivered to
+// export sinks.
+type ExportRecord struct {
+	ID              string      `json:"id"`
+	Target          Target      `json:"target"`
+	Kind            Kind        `json:"kind"`
+	Owner           Owner       `json:"owner"`
+	StartTime       time.Time   `json:"startTime"`
+	EndTime         time.Time   `json:"endTime"`
+	Error           string      `json:"error,omitempty"`
+	Log             string      `json:"log,omitempty"`
+	StartCustomData interface{} `json:"startCustomData,omitempty"`
+	EndCustomData   interface{} `json:"endCustomData,omitempty"`
+	OtherCustomData interface{} `json:"otherCustomData,omitempty"`
+	Canceled        bool        `json:"canceled,omitempty"`
+}
+
+// NewExportRecord returns the export representation of the event.
+func NewExportRecord(e *Event) (*ExportRecord, error) {
+	record := ExportRecord{
+		ID:        e.UniqueID.Hex(),
+		Target:    e.Target,
+		Kind:      e.Kind,
+		Owner:     e.Owner,
+		StartTime: e.StartTime,
+		EndTime:   e.EndTime,
+		Error:     e.Error,
+		Log:       e.Log,
+		Canceled:  e.CancelInfo.Canceled,
+	}
+	for _, data := range []struct {
+		raw bson.Raw
+		dst *interface{}
+	}{
+		{e.StartCustomData, &record.StartCustomData},
+		{e.EndCustomData, &record.EndCustomData},
+		{e.OtherCustomData, &record.OtherCustomData},
+	} {
+		if data.raw.Kind == 0 {
+			continue
+		}
+		err := data.raw.Unmarshal(data.dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// ExportSink is the interface that must be implemented by destinations of
+// exported events.
+type ExportSink interface {
+	// Send delivers the records, in order. Records may be sent again when
+	// an error is returned, or when tsurud is restarted before the sink
+	// progress is saved. Records that can't be delivered after many attempts
+	// are skipped.
+	Send(records []ExportRecord) error
+}
+
+// ExportSinkFactory creates a sink configured under the given config prefix,
+// e.g. event-export:sinks:<name>.
+type ExportSinkFactory func(name, configPrefix string) (ExportSink, error)
+
+// RegisterExportSink registers a new type of export sink, which can be
+// selected with the event-export:sinks:<name>:type config.
+func RegisterExportSink(sinkType string, factory ExportSinkFactory) {
+	exportSinkFactories[sinkType] = factory
+}
+
+// AddExportSink adds a sink that receives finished events regardless of the
+// event-export config. Unlike configured sinks, it only receives events
+// finished after it's first started. It must be called before StartExporter.
+func AddExportSink(name string, sink ExportSink) {
+	exporterMut.Lock()
+	defer exporterMut.Unlock()
+	internalExportTargets = append(internalExportTargets, exportTarget{
+		name:        "internal:" + name,
+		sink:        sink,
+		skipHistory: true,
+	})
+}
+
+// exportCheckpoint holds the last event delivered to a sink, along with the
+// number of failed attempts to deliver the next batch. Events are delivered
+// sorted by end time and id. The exporter sending events to the sink holds a
+// lease on its checkpoint, so that a sink doesn't receive each event once per
+// tsurud replica.
+type exportCheckpoint struct {
+	Sink        string `bson:"_id"`
+	EndTime     time.Time
+	UniqueID    bson.ObjectId `bson:",omitempty"`
+	Failures    int
+	UpdatedAt   time.Time
+	Owner       string
+	LeaseExpiry time.Time
+}
+
+func getExportCheckpoint(sink string) (*exportCheckpoint, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	cp := exportCheckpoint{Sink: sink}
+	err = conn.Collection(exportCheckpointsCollection).FindId(sink).One(&cp)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// acquireExportCheckpoint takes, or renews, the lease on the checkpoint of
+// the sink for the given owner, creating the checkpoint if it doesn't exist.
+// It returns errExportLeaseHeld when another owner holds an unexpired lease.
+func acquireExportCheckpoint(sink, owner string, lease time.Duration) (*exportCheckpoint, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	query := bson.M{
+		"_id": sink,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"leaseexpiry": bson.M{"$lt": now}},
+			{"leaseexpiry": bson.M{"$exists": false}},
+		},
+	}
+	var cp exportCheckpoint
+	_, err = conn.Collection(exportCheckpointsCollection).Find(query).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"owner": owner, "leaseexpiry": now.Add(lease)}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &cp)
+	if mgo.IsDup(err) {
+		return nil, errExportLeaseHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// save stores the checkpoint and renews its lease, as long as it's still
+// held by the checkpoint owner. It returns errExportLeaseHeld otherwise.
+func (cp *exportCheckpoint) save(lease time.Duration) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cp.UpdatedAt = time.Now().UTC()
+	cp.LeaseExpiry = cp.UpdatedAt.Add(lease)
+	err = conn.Collection(exportCheckpointsCollection).Update(bson.M{"_id": cp.Sink, "owner": cp.Owner}, cp)
+	if err == mgo.ErrNotFound {
+		return errExportLeaseHeld
+	}
+	return err
+}
+
+// releaseExportCheckpoints expires the leases held by the given owner, so
+// that other exporters may take over its sinks right away.
+func releaseExportCheckpoints(owner string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Collection(exportCheckpointsCollection).UpdateAll(
+		bson.M{"owner": owner},
+		bson.M{"$set": bson.M{"leaseexpiry": time.Time{}}},
+	)
+	return err
+}
+
+// finishedAfter returns the events finished after the checkpoint and up to
+// until, sorted by end time and id.
+func finishedAfter(cp *exportCheckpoint, until time.Time, limit int) ([]Event, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{"running": false}
+	if cp.UniqueID == "" {
+		query["endtime"] = bson.M{"$gt": cp.EndTime, "$lte": until}
+	} else {
+		query["endtime"] = bson.M{"$gte": cp.EndTime, "$lte": until}
+		query["$or"] = []bson.M{
+			{"endtime": bson.M{"$gt": cp.EndTime}},
+			{"uniqueid": bson.M{"$gt": cp.UniqueID}},
+		}
+	}
+	var allData []eventData
+	err = conn.Events().Find(query).Sort("endtime", "uniqueid").Limit(limit).All(&allData)
+	if err != nil {
+		return nil, err
+	}
+	evts := make([]Event, len(allData))
+	for i := range evts {
+		evts[i].eventData = allData[i]
+	}
+	return evts, nil
+}
+
+type exportTarget struct {
+	name        string
+	sink        ExportSink
+	skipHistory bool
+}
+
+// exporter periodically delivers finished events to the sinks configured in
+// event-export:sinks. Each sink has its own checkpoint, saved after every
+// delivered batch, so events are delivered at least once. Once a batch fails
+// maxAttempts times, its events are sent one by one and the ones that still
+// fail are logged and skipped, so that a single undeliverable event doesn't
+// block the sink. Among the exporters of all tsurud replicas, only the one
+// holding the lease on the checkpoint of a sink sends events to it.
+type exporter struct {
+	targets     []exportTarget
+	owner       string
+	lease       time.Duration
+	interval    time.Duration
+	batchSize   int
+	delay       time.Duration
+	maxAttempts int
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func exportTargets() ([]exportTarget, error) {
+	sinksConfig, err := config.Get("event-export:sinks")
+	if err != nil {
+		return nil, nil
+	}
+	sinks, _ := sinksConfig.(map[interface{}]interface{})
+	var names []string
+	for key := range sinks {
+		names = append(names, fmt.Sprint(key))
+	}
+	sort.Strings(names)
+	targets := make([]exportTarget, 0, len(names))
+	for _, name := range names {
+		prefix := "event-export:sinks:" + name
+		sinkType, err := config.GetString(prefix + ":type")
+		if err != nil {
+			return nil, fmt.Errorf("config key '%s:type' not found", prefix)
+		}
+		factory, ok := exportSinkFactories[sinkType]
+		if !ok {
+			return nil, fmt.Errorf("unknown event export sink type: %q", sinkType)
+		}
+		sink, err := factory(name, prefix)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, exportTarget{name: name, sink: sink})
+	}
+	return targets, nil
+}
+
+// StartExporter starts delivering finished events to the configured export
+// sinks, if any.
+func StartExporter() error {
+	exporterMut.Lock()
+	defer exporterMut.Unlock()
+	if exporterInstance != nil {
+		return nil
+	}
+	targets, err := exportTargets()
+	if err != nil {
+		return err
+	}
+	targets = append(targets, internalExportTargets...)
+	if len(targets) == 0 {
+		return nil
+	}
+	e := &exporter{
+		targets:     targets,
+		owner:       bson.NewObjectId().Hex(),
+		lease:       defaultExportLease,
+		interval:    defaultExportInterval,
+		batchSize:   defaultExportBatchSize,
+		delay:       defaultExportDelay,
+		maxAttempts: defaultExportAttempts,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if interval, err := config.GetInt("event-export:interval"); err == nil && interval > 0 {
+		e.interval = time.Duration(interval) * time.Second
+	}
+	if batchSize, err := config.GetInt("event-export:batch-size"); err == nil && batchSize > 0 {
+		e.batchSize = batchSize
+	}
+	if delay, err := config.GetInt("event-export:delay"); err == nil && delay >= 0 {
+		e.delay = time.Duration(delay) * time.Second
+	}
+	if maxAttempts, err := config.GetInt("event-export:max-attempts"); err == nil && maxAttempts > 0 {
+		e.maxAttempts = maxAttempts
+	}
+	exporterInstance = e
+	shutdown.Register(e)
+	go e.run()
+	return nil
+}
+
+func (e *exporter) Shutdown() {
+	close(e.stop)
+	<-e.done
+	err := releaseExportCheckpoints(e.owner)
+	if err != nil {
+		log.Errorf("%s unable to release checkpoints: %s", exportLogTag, err)
+	}
+}
+
+func (e *exporter) String() string {
+	return "event exporter"
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	for {
+		e.runOnce(time.Now().UTC())
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// runOnce delivers to each sink the events finished before now, except for
+// the most recent ones. As the end time is set before the event is stored,
+// waiting for a small delay avoids skipping events stored out of order.
+func (e *exporter) runOnce(now time.Time) {
+	until := now.Add(-e.delay)
+	for _, t := range e.targets {
+		err := e.export(t, until)
+		if err == errExportLeaseHeld {
+			continue
+		}
+		if err != nil {
+			log.Errorf("%s unable to export events to sink %q: %s", exportLogTag, t.name, err)
+		}
+	}
+}
+
+func (e *exporter) export(t exportTarget, until time.Time) error {
+	cp, err := acquireExportCheckpoint(t.name, e.owner, e.lease)
+	if err != nil {
+		return err
+	}
+	if cp.EndTime.IsZero() && t.skipHistory {
+		cp.EndTime = until
+		return cp.save(e.lease)
+	}
+	for {
+		select {
+		case <-e.stop:
+			return nil
+		default:
+		}
+		if time.Until(cp.LeaseExpiry) < e.lease/2 {
+			err = cp.save(e.lease)
+			if err != nil {
+				return err
+			}
+		}
+		evts, err := finishedAfter(cp, until, e.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(evts) == 0 {
+			return nil
+		}
+		records := make([]ExportRecord, 0, len(evts))
+		for i := range evts {
+			record, err := NewExportRecord(&evts[i])
+			if err != nil {
+				log.Errorf("%s unable to convert event %s: %s", exportLogTag, evts[i].UniqueID.Hex(), err)
+				continue
+			}
+			records = append(records, *record)
+		}
+		if len(records) > 0 {
+			err = t.sink.Send(records)
+			if err != nil {
+				cp.Failures++
+				if cp.Failures < e.maxAttempts {
+					if saveErr := cp.save(e.lease); saveErr != nil {
+						log.Errorf("%s unable to save checkpoint of sink %q: %s", exportLogTag, t.name, saveErr)
+					}
+					return err
+				}
+				log.Errorf("%s unable to export events to sink %q after %d attempts, sending them one by one: %s", exportLogTag, t.name, cp.Failures, err)
+				sendEach(t, records)
+			}
+		}
+		last := &evts[len(evts)-1]
+		cp.EndTime = last.EndTime
+		cp.UniqueID = last.UniqueID
+		cp.Failures = 0
+		err = cp.save(e.lease)
+		if err != nil {
+			return err
+		}
+		if len(evts) < e.batchSize {
+			return nil
+		}
+	}
+}
+
+// sendEach sends the records to the sink one at a time, logging and skipping
+// the ones that can't be delivered.
+func sendEach(t exportTarget, records []ExportRecord) {
+	for i := range records {
+		err := t.sink.Send(records[i : i+1])
+		if err != nil {
+			log.Errorf("%s skipping event %s in sink %q: %s", exportLogTag, records[i].ID, t.name, err)
+		}
+	}
+}
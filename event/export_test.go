@@ -0,0 +1,318 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeExportSink struct {
+	records []ExportRecord
+	err     error
+	failID  string
+}
+
+func (s *fakeExportSink) Send(records []ExportRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, r := range records {
+		if r.ID == s.failID {
+			return errors.New("invalid record")
+		}
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func newTestExporter(sink ExportSink, batchSize int) *exporter {
+	return &exporter{
+		targets:     []exportTarget{{name: "test", sink: sink}},
+		owner:       bson.NewObjectId().Hex(),
+		lease:       defaultExportLease,
+		batchSize:   batchSize,
+		maxAttempts: defaultExportAttempts,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (s *S) TestNewExportRecord(c *check.C) {
+	evt, err := New(&Opts{
+		Target:     Target{Type: "app", Value: "myapp"},
+		Kind:       permission.PermAppUpdateEnvSet,
+		Owner:      s.token,
+		CustomData: map[string]string{"env": "FOO"},
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.DoneCustomData(errors.New("failed"), []string{"a", "b"})
+	c.Assert(err, check.IsNil)
+	dbEvt, err := GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	record, err := NewExportRecord(dbEvt)
+	c.Assert(err, check.IsNil)
+	c.Assert(record.ID, check.Equals, evt.UniqueID.Hex())
+	c.Assert(record.Target, check.Equals, Target{Type: "app", Value: "myapp"})
+	c.Assert(record.Kind, check.Equals, Kind{Type: KindTypePermission, Name: "app.update.env.set"})
+	c.Assert(record.Owner, check.Equals, Owner{Type: OwnerTypeUser, Name: s.token.GetUserName()})
+	c.Assert(record.Error, check.Equals, "failed")
+	data, err := json.Marshal(record)
+	c.Assert(err, check.IsNil)
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["startCustomData"], check.DeepEquals, map[string]interface{}{"env": "FOO"})
+	c.Assert(result["endCustomData"], check.DeepEquals, []interface{}{"a", "b"})
+	c.Assert(result["otherCustomData"], check.IsNil)
+}
+
+func (s *S) TestExporterRunOnce(c *check.C) {
+	for _, name := range []string{"app1", "app2", "app3"} {
+		evt, err := New(&Opts{Target: Target{Type: "app", Value: name}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+		c.Assert(err, check.IsNil)
+		err = evt.Done(nil)
+		c.Assert(err, check.IsNil)
+	}
+	running, err := New(&Opts{Target: Target{Type: "app", Value: "app4"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	sink := &fakeExportSink{}
+	e := newTestExporter(sink, 2)
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 3)
+	for i, name := range []string{"app1", "app2", "app3"} {
+		c.Assert(sink.records[i].Target.Value, check.Equals, name)
+	}
+	cp, err := getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.UniqueID.Hex(), check.Equals, sink.records[2].ID)
+	err = running.Done(nil)
+	c.Assert(err, check.IsNil)
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 4)
+	c.Assert(sink.records[3].Target.Value, check.Equals, "app4")
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 4)
+}
+
+func (s *S) TestExporterRunOnceLeasedByAnotherExporter(c *check.C) {
+	evt, err := New(&Opts{Target: Target{Type: "app", Value: "app1"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	sink1 := &fakeExportSink{}
+	e1 := newTestExporter(sink1, 10)
+	e1.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink1.records, check.HasLen, 1)
+	evt, err = New(&Opts{Target: Target{Type: "app", Value: "app2"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	sink2 := &fakeExportSink{}
+	e2 := newTestExporter(sink2, 10)
+	e2.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink2.records, check.HasLen, 0)
+	cp, err := getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.Owner, check.Equals, e1.owner)
+	err = releaseExportCheckpoints(e1.owner)
+	c.Assert(err, check.IsNil)
+	e2.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink2.records, check.HasLen, 1)
+	c.Assert(sink2.records[0].Target.Value, check.Equals, "app2")
+	e1.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink1.records, check.HasLen, 1)
+	cp, err = getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.Owner, check.Equals, e2.owner)
+	c.Assert(cp.UniqueID, check.Equals, evt.UniqueID)
+}
+
+func (s *S) TestExporterRunOnceRespectsDelay(c *check.C) {
+	evt, err := New(&Opts{Target: Target{Type: "app", Value: "app1"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	sink := &fakeExportSink{}
+	e := newTestExporter(sink, 10)
+	e.delay = time.Minute
+	e.runOnce(time.Now().UTC())
+	c.Assert(sink.records, check.HasLen, 0)
+	e.runOnce(time.Now().UTC().Add(2 * time.Minute))
+	c.Assert(sink.records, check.HasLen, 1)
+}
+
+func (s *S) TestExporterRunOnceSinkFailure(c *check.C) {
+	evt, err := New(&Opts{Target: Target{Type: "app", Value: "app1"}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	sink := &fakeExportSink{err: errors.New("unavailable")}
+	e := newTestExporter(sink, 10)
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	cp, err := getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.UniqueID, check.Equals, bson.ObjectId(""))
+	sink.err = nil
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 1)
+	c.Assert(sink.records[0].ID, check.Equals, evt.UniqueID.Hex())
+}
+
+func (s *S) TestExporterRunOnceSkipsUndeliverableEvent(c *check.C) {
+	var evts []*Event
+	for _, name := range []string{"app1", "app2", "app3"} {
+		evt, err := New(&Opts{Target: Target{Type: "app", Value: name}, Kind: permission.PermAppUpdateEnvSet, Owner: s.token})
+		c.Assert(err, check.IsNil)
+		err = evt.Done(nil)
+		c.Assert(err, check.IsNil)
+		evts = append(evts, evt)
+	}
+	sink := &fakeExportSink{failID: evts[1].UniqueID.Hex()}
+	e := newTestExporter(sink, 10)
+	e.maxAttempts = 2
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 0)
+	cp, err := getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.UniqueID, check.Equals, bson.ObjectId(""))
+	c.Assert(cp.Failures, check.Equals, 1)
+	e.runOnce(time.Now().UTC().Add(time.Second))
+	c.Assert(sink.records, check.HasLen, 2)
+	c.Assert(sink.records[0].Target.Value, check.Equals, "app1")
+	c.Assert(sink.records[1].Target.Value, check.Equals, "app3")
+	cp, err = getExportCheckpoint("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(cp.UniqueID, check.Equals, evts[2].UniqueID)
+	c.Assert(cp.Failures, check.Equals, 0)
+}
+
+func (s *S) TestExportTargets(c *check.C) {
+	dir, err := ioutil.TempDir("", "event-export")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	config.Set("event-export:sinks:audit:type", "file")
+	config.Set("event-export:sinks:audit:path", filepath.Join(dir, "events.log"))
+	config.Set("event-export:sinks:siem:type", "webhook")
+	config.Set("event-export:sinks:siem:url", "http://siem.example.com/events")
+	defer config.Unset("event-export")
+	targets, err := exportTargets()
+	c.Assert(err, check.IsNil)
+	c.Assert(targets, check.HasLen, 2)
+	c.Assert(targets[0].name, check.Equals, "audit")
+	c.Assert(targets[0].sink, check.FitsTypeOf, &fileExportSink{})
+	c.Assert(targets[1].name, check.Equals, "siem")
+	c.Assert(targets[1].sink, check.FitsTypeOf, &webhookExportSink{})
+	config.Set("event-export:sinks:other:type", "unknown")
+	_, err = exportTargets()
+	c.Assert(err, check.ErrorMatches, `unknown event export sink type: "unknown"`)
+}
+
+func (s *S) TestFileExportSinkRotate(c *check.C) {
+	dir, err := ioutil.TempDir("", "event-export")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+	records := make([]ExportRecord, 6)
+	for i := range records {
+		records[i] = ExportRecord{ID: strings.Repeat(string(rune('a'+i)), 24), Kind: Kind{Name: "app.deploy"}}
+	}
+	data, err := json.Marshal(records[0])
+	c.Assert(err, check.IsNil)
+	lineSize := int64(len(data) + 1)
+	sink := &fileExportSink{path: path, maxSize: 2*lineSize + 1, maxBackups: 1}
+	err = sink.Send(records[:3])
+	c.Assert(err, check.IsNil)
+	err = sink.Send(records[3:])
+	c.Assert(err, check.IsNil)
+	readIDs := func(path string) []string {
+		f, err := os.Open(path)
+		c.Assert(err, check.IsNil)
+		defer f.Close()
+		var ids []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r ExportRecord
+			err = json.Unmarshal(scanner.Bytes(), &r)
+			c.Assert(err, check.IsNil)
+			ids = append(ids, r.ID[:1])
+		}
+		return ids
+	}
+	c.Assert(readIDs(path), check.DeepEquals, []string{"e", "f"})
+	c.Assert(readIDs(path+".1"), check.DeepEquals, []string{"c", "d"})
+	_, err = os.Stat(path + ".2")
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *S) TestWebhookExportSink(c *check.C) {
+	var received []ExportRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer srv.Close()
+	sink := &webhookExportSink{url: srv.URL}
+	records := []ExportRecord{{ID: "1"}, {ID: "2"}}
+	err := sink.Send(records)
+	c.Assert(err, check.IsNil)
+	c.Assert(received, check.HasLen, 2)
+	c.Assert(received[1].ID, check.Equals, "2")
+}
+
+func (s *S) TestWebhookExportSinkError(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	sink := &webhookExportSink{url: srv.URL}
+	err := sink.Send([]ExportRecord{{ID: "1"}})
+	c.Assert(err, check.ErrorMatches, `invalid status code sending events to .*: 503`)
+}
+
+func (s *S) TestSyslogExportSink(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	writer, err := tsuruNet.NewSyslogWriter("udp://" + conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	sink := &syslogExportSink{writer: writer, hostname: "tsuru-api"}
+	endTime := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	err = sink.Send([]ExportRecord{{ID: "1", Kind: Kind{Name: "app.deploy"}, EndTime: endTime}})
+	c.Assert(err, check.IsNil)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, check.IsNil)
+	msg := string(buf[:n])
+	c.Assert(strings.HasPrefix(msg, "<110>1 2016-10-01T12:00:00Z tsuru-api tsuru - app.deploy - {"), check.Equals, true, check.Commentf(msg))
+	c.Assert(strings.Contains(msg, `"id":"1"`), check.Equals, true)
+}
+
+func (s *S) TestSyslogExportSinkTruncatesLog(c *check.C) {
+	sink := &syslogExportSink{hostname: "tsuru-api"}
+	record := ExportRecord{ID: "1", Log: strings.Repeat("x", 2*exportSyslogMaxLog)}
+	msg, err := sink.format(&record)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(msg.Message) < 2*exportSyslogMaxLog, check.Equals, true)
+	c.Assert(strings.Contains(msg.Message, strings.Repeat("x", exportSyslogMaxLog)+" [truncated]"), check.Equals, true)
+	c.Assert(record.Log, check.HasLen, 2*exportSyslogMaxLog)
+}
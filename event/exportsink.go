@@ -0,0 +1,241 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/tsuru/config"
+	tsuruNet "github.com/tsuru/tsuru/net"
+)
+
+const (
+	defaultExportFileMaxSize    = 100 << 20
+	defaultExportFileMaxBackups = 5
+
+	// syslog facility log audit, severity informational.
+	exportSyslogPriority = 13*8 + 6
+	exportSyslogAppName  = "tsuru"
+	// exportSyslogMaxLog is the maximum size of the event log in syslog
+	// messages, keeping messages within the size of UDP datagrams accepted
+	// by most servers.
+	exportSyslogMaxLog = 2048
+)
+
+var (
+	errInvalidExportWebhookURL = errors.New("webhook url must be in the form http(s)://host/path")
+)
+
+func init() {
+	RegisterExportSink("file", createFileExportSink)
+	RegisterExportSink("webhook", createWebhookExportSink)
+	RegisterExportSink("syslog", createSyslogExportSink)
+}
+
+// fileExportSink appends records, one JSON document per line, to a local
+// file. The file is rotated once it reaches the maximum size, keeping a
+// limited number of old files, named <path>.1, <path>.2 and so on.
+type fileExportSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mut        sync.Mutex
+}
+
+func createFileExportSink(name, prefix string) (ExportSink, error) {
+	path, err := config.GetString(prefix + ":path")
+	if err != nil {
+		return nil, fmt.Errorf("config key '%s:path' not found", prefix)
+	}
+	s := &fileExportSink{
+		path:       path,
+		maxSize:    defaultExportFileMaxSize,
+		maxBackups: defaultExportFileMaxBackups,
+	}
+	if maxSize, err := config.GetInt(prefix + ":max-size"); err == nil {
+		s.maxSize = int64(maxSize)
+	}
+	if maxBackups, err := config.GetInt(prefix + ":max-backups"); err == nil {
+		s.maxBackups = maxBackups
+	}
+	if s.maxSize <= 0 {
+		return nil, fmt.Errorf("invalid max size for event export file: %d", s.maxSize)
+	}
+	if s.maxBackups < 0 {
+		return nil, fmt.Errorf("invalid max backups for event export file: %d", s.maxBackups)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileExportSink) Send(records []ExportRecord) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	f, size, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for i := range records {
+		data, err := json.Marshal(records[i])
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if size > 0 && size+int64(len(data)) > s.maxSize {
+			err = f.Close()
+			f = nil
+			if err != nil {
+				return err
+			}
+			err = s.rotate()
+			if err != nil {
+				return err
+			}
+			f, size, err = s.open()
+			if err != nil {
+				return err
+			}
+		}
+		n, err := f.Write(data)
+		size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+func (s *fileExportSink) open() (*os.File, int64, error) {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *fileExportSink) rotate() error {
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+// webhookExportSink sends records as a JSON array in the body of a POST
+// request.
+type webhookExportSink struct {
+	url string
+}
+
+func createWebhookExportSink(name, prefix string) (ExportSink, error) {
+	rawURL, err := config.GetString(prefix + ":url")
+	if err != nil {
+		return nil, fmt.Errorf("config key '%s:url' not found", prefix)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errInvalidExportWebhookURL
+	}
+	return &webhookExportSink{url: rawURL}, nil
+}
+
+func (s *webhookExportSink) Send(records []ExportRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	rsp, err := tsuruNet.Dial5Full300Client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("invalid status code sending events to %s: %d", s.url, rsp.StatusCode)
+	}
+	return nil
+}
+
+// syslogExportSink sends each record as a RFC 5424 syslog message, with the
+// JSON representation of the record as the message.
+type syslogExportSink struct {
+	writer   *tsuruNet.SyslogWriter
+	hostname string
+}
+
+func createSyslogExportSink(name, prefix string) (ExportSink, error) {
+	address, err := config.GetString(prefix + ":address")
+	if err != nil {
+		return nil, fmt.Errorf("config key '%s:address' not found", prefix)
+	}
+	writer, err := tsuruNet.NewSyslogWriter(address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &syslogExportSink{writer: writer, hostname: hostname}, nil
+}
+
+func (s *syslogExportSink) Send(records []ExportRecord) error {
+	msgs := make([]tsuruNet.SyslogMessage, len(records))
+	for i := range records {
+		msg, err := s.format(&records[i])
+		if err != nil {
+			return err
+		}
+		msgs[i] = msg
+	}
+	return s.writer.Write(msgs)
+}
+
+// format returns the record as a syslog message, using the event kind as
+// msgid. The log of the event is truncated to exportSyslogMaxLog bytes.
+func (s *syslogExportSink) format(r *ExportRecord) (tsuruNet.SyslogMessage, error) {
+	record := *r
+	if len(record.Log) > exportSyslogMaxLog {
+		end := exportSyslogMaxLog
+		for end > 0 && !utf8.RuneStart(record.Log[end]) {
+			end--
+		}
+		record.Log = record.Log[:end] + " [truncated]"
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return tsuruNet.SyslogMessage{}, err
+	}
+	return tsuruNet.SyslogMessage{
+		Priority:  exportSyslogPriority,
+		Timestamp: r.EndTime,
+		Hostname:  s.hostname,
+		AppName:   exportSyslogAppName,
+		MsgID:     r.Kind.Name,
+		Message:   string(data),
+	}, nil
+}
@@ -0,0 +1,698 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook implements webhooks, HTTP endpoints subscribed to events
+// matching a filter.
+//
+// Finished events are received through an export sink and matched against
+// the filter of each webhook. Each match is enqueued as a delivery task,
+// which sends the event to the webhook URL, signed with the webhook secret.
+// Failed deliveries are stored in the database along with the time of the
+// next attempt, and enqueued again by the retry scheduler once due. Every
+// attempt is recorded in the delivery history of the webhook.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	deliveryTaskName = "webhook-delivery"
+	logTag           = "[webhooks]"
+
+	// SignatureHeader holds the HMAC-SHA256 of the request body, using the
+	// webhook secret as key, in the form sha256=<hex digest>.
+	SignatureHeader = "X-Tsuru-Signature"
+	EventIDHeader   = "X-Tsuru-Event-Id"
+	EventKindHeader = "X-Tsuru-Event-Kind"
+	AttemptHeader   = "X-Tsuru-Delivery-Attempt"
+
+	defaultMaxAttempts = 5
+	maxDeliveriesList  = 100
+	secretSize         = 32
+)
+
+var (
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrWebhookAlreadyExists  = errors.New("webhook already exists")
+	ErrInvalidWebhookName    = errors.New("invalid webhook name, it must contain only lower case letters, numbers and dashes and start with a letter")
+	ErrInvalidWebhookURL     = errors.New("webhook url must be in the form http(s)://host/path")
+	ErrWebhookTeamRequired   = errors.New("webhook team owner is required")
+	ErrInvalidEventFilter    = errors.New("webhook event filter can't be both error only and success only")
+	ErrWebhookHostNotFound   = errors.New("webhook url host could not be resolved")
+	ErrWebhookHostNotAllowed = errors.New("webhook url must not resolve to a private, loopback or link-local address")
+
+	nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,39}$`)
+
+	retryBackoff           = 10 * time.Second
+	retrySchedulerInterval = 10 * time.Second
+
+	lookupIP = net.LookupIP
+
+	// client only connects to addresses allowed by checkHost, so that hosts
+	// resolving to internal addresses after the webhook is validated are
+	// still refused.
+	client = &http.Client{
+		Transport: &http.Transport{
+			Dial:                dialAllowed,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 5,
+		},
+		Timeout: 5 * time.Minute,
+	}
+
+	initMut     sync.Mutex
+	initialized bool
+)
+
+// EventFilter holds the conditions an event must match to be delivered to a
+// webhook. Empty lists match any value.
+type EventFilter struct {
+	TargetTypes  []string
+	TargetValues []string
+	// KindNames matches the event kind or any of its children, e.g.
+	// app.update matches app.update.env.set.
+	KindNames   []string
+	ErrorOnly   bool
+	SuccessOnly bool
+}
+
+// Webhook is an HTTP endpoint subscribed to events. Webhooks only receive
+// events whose targets belong to their team owner: apps and app jobs owned
+// by the team, and the team itself.
+type Webhook struct {
+	Name        string `bson:"_id"`
+	Description string
+	TeamOwner   string
+	URL         string
+	Secret      string `json:"-"`
+	EventFilter EventFilter
+}
+
+// Delivery is an attempt to deliver an event to a webhook.
+type Delivery struct {
+	ID         bson.ObjectId `bson:"_id"`
+	Webhook    string
+	EventID    string
+	EventKind  string
+	Attempt    int
+	StatusCode int
+	Error      string
+	Timestamp  time.Time
+	Duration   time.Duration
+}
+
+func (w *Webhook) validate() error {
+	if !nameRegexp.MatchString(w.Name) {
+		return ErrInvalidWebhookName
+	}
+	if w.TeamOwner == "" {
+		return ErrWebhookTeamRequired
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	_, err = checkHost(u.Hostname())
+	if err != nil {
+		return err
+	}
+	if w.EventFilter.ErrorOnly && w.EventFilter.SuccessOnly {
+		return ErrInvalidEventFilter
+	}
+	_, err = auth.GetTeam(w.TeamOwner)
+	return err
+}
+
+// allowedNetworks returns the networks in webhooks:allowed-networks, which
+// webhooks may send events to even when they're internal.
+func allowedNetworks() ([]*net.IPNet, error) {
+	cidrs, err := config.GetList("webhooks:allowed-networks")
+	if err != nil {
+		return nil, nil
+	}
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network in webhooks:allowed-networks: %s", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkHost resolves the host, returning its addresses. Hosts resolving to
+// private, loopback or link-local addresses are refused, unless the addresses
+// are in the allowed networks.
+func checkHost(host string) ([]net.IP, error) {
+	networks, err := allowedNetworks()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, ErrWebhookHostNotFound
+	}
+	for _, ip := range ips {
+		if !isInternalIP(ip) {
+			continue
+		}
+		allowed := false
+		for _, network := range networks {
+			if network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, ErrWebhookHostNotAllowed
+		}
+	}
+	return ips, nil
+}
+
+func dialAllowed(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := checkHost(host)
+	if err != nil {
+		return nil, err
+	}
+	return tsuruNet.Dial5Dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+}
+
+func generateSecret() (string, error) {
+	data := make([]byte, secretSize)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// Create validates and stores the webhook. A random secret is generated when
+// the webhook doesn't have one.
+func Create(w *Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		w.Secret, err = generateSecret()
+		if err != nil {
+			return err
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().Insert(w)
+	if mgo.IsDup(err) {
+		return ErrWebhookAlreadyExists
+	}
+	return err
+}
+
+// Update validates and stores the webhook, keeping the current secret when
+// the webhook doesn't have one.
+func Update(w *Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		current, err := Get(w.Name)
+		if err != nil {
+			return err
+		}
+		w.Secret = current.Secret
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().UpdateId(w.Name, w)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+func Get(name string) (*Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var w Webhook
+	err = conn.Webhooks().FindId(name).One(&w)
+	if err == mgo.ErrNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	return &w, err
+}
+
+// List returns the webhooks owned by the given teams, or all webhooks when
+// teams is nil.
+func List(teams []string) ([]Webhook, error) {
+	query := bson.M{}
+	if teams != nil {
+		query["teamowner"] = bson.M{"$in": teams}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var webhooks []Webhook
+	err = conn.Webhooks().Find(query).Sort("_id").All(&webhooks)
+	return webhooks, err
+}
+
+// Delete removes the webhook along with its delivery history.
+func Delete(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.WebhookDeliveries().RemoveAll(bson.M{"webhook": name})
+	return err
+}
+
+// ListDeliveries returns the last delivery attempts of the webhook, newest
+// first.
+func ListDeliveries(name string) ([]Delivery, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var deliveries []Delivery
+	err = conn.WebhookDeliveries().Find(bson.M{"webhook": name}).Sort("-timestamp").Limit(maxDeliveriesList).All(&deliveries)
+	return deliveries, err
+}
+
+func matchKind(names []string, kind string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if kind == name || strings.HasPrefix(kind, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(values []string, value string) bool {
+	return len(values) == 0 || contains(values, value)
+}
+
+// Match returns whether the event record matches the filter.
+func (f *EventFilter) Match(r *event.ExportRecord) bool {
+	if f.ErrorOnly && r.Error == "" {
+		return false
+	}
+	if f.SuccessOnly && r.Error != "" {
+		return false
+	}
+	return matchAny(f.TargetTypes, string(r.Target.Type)) &&
+		matchAny(f.TargetValues, r.Target.Value) &&
+		matchKind(f.KindNames, r.Kind.Name)
+}
+
+// dispatcher enqueues the deliveries of finished events to the webhooks
+// matching them.
+type dispatcher struct{}
+
+func (d *dispatcher) Send(records []event.ExportRecord) error {
+	webhooks, err := List(nil)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	teams := targetTeams{}
+	for i := range records {
+		r := &records[i]
+		for _, w := range webhooks {
+			if !w.EventFilter.Match(r) {
+				continue
+			}
+			owners, err := teams.get(r.Target)
+			if err != nil {
+				return err
+			}
+			if !contains(owners, w.TeamOwner) {
+				continue
+			}
+			_, err = q.Enqueue(deliveryTaskName, deliveryParams(w.Name, r.ID, 1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// targetTeams caches the teams owning event targets.
+type targetTeams map[event.Target][]string
+
+func (t targetTeams) get(target event.Target) ([]string, error) {
+	if teams, ok := t[target]; ok {
+		return teams, nil
+	}
+	var teams []string
+	switch target.Type {
+	case event.TargetTypeTeam:
+		teams = []string{target.Value}
+	case event.TargetTypeApp, event.TargetTypeJob:
+		appName := strings.SplitN(target.Value, "/", 2)[0]
+		conn, err := db.Conn()
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		var appData struct{ Teams []string }
+		err = conn.Apps().Find(bson.M{"name": appName}).Select(bson.M{"teams": 1}).One(&appData)
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		teams = appData.Teams
+	}
+	t[target] = teams
+	return teams, nil
+}
+
+func deliveryParams(webhook, eventID string, attempt int) monsterqueue.JobParams {
+	return monsterqueue.JobParams{
+		"webhook": webhook,
+		"event":   eventID,
+		"attempt": strconv.Itoa(attempt),
+	}
+}
+
+type deliveryTask struct{}
+
+func (t *deliveryTask) Name() string {
+	return deliveryTaskName
+}
+
+func (t *deliveryTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	name, _ := params["webhook"].(string)
+	eventID, _ := params["event"].(string)
+	attemptStr, _ := params["attempt"].(string)
+	attempt, _ := strconv.Atoi(attemptStr)
+	if name == "" || !bson.IsObjectIdHex(eventID) || attempt <= 0 {
+		job.Error(errors.New("invalid parameters, expected webhook, event and attempt"))
+		return
+	}
+	err := deliver(name, eventID, attempt)
+	if err != nil {
+		if retryErr := scheduleRetry(name, eventID, attempt, time.Now().UTC()); retryErr != nil {
+			log.Errorf("%s unable to retry delivering event %s to webhook %q: %s", logTag, eventID, name, retryErr)
+		}
+		job.Error(err)
+		return
+	}
+	job.Success(nil)
+}
+
+func maxAttempts() int {
+	attempts, err := config.GetInt("webhooks:max-attempts")
+	if err != nil || attempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return attempts
+}
+
+// retry is a failed delivery waiting to be enqueued again.
+type retry struct {
+	ID        bson.ObjectId `bson:"_id"`
+	Webhook   string
+	EventID   string
+	Attempt   int
+	NotBefore time.Time
+}
+
+// scheduleRetry stores the next attempt of the delivery, waiting longer after
+// each failed attempt, until the maximum number of attempts is reached.
+func scheduleRetry(name, eventID string, attempt int, now time.Time) error {
+	if attempt >= maxAttempts() {
+		log.Errorf("%s giving up delivering event %s to webhook %q after %d attempts", logTag, eventID, name, attempt)
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.WebhookRetries().Insert(retry{
+		ID:        bson.NewObjectId(),
+		Webhook:   name,
+		EventID:   eventID,
+		Attempt:   attempt + 1,
+		NotBefore: now.Add(retryBackoff * time.Duration(1<<uint(attempt-1))),
+	})
+}
+
+// retryScheduler periodically enqueues the stored retries as they become
+// due, so that retries survive restarts of tsurud.
+type retryScheduler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Shutdown stops the retry scheduler, already enqueued deliveries are not
+// affected.
+func (s *retryScheduler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *retryScheduler) String() string {
+	return "webhook retry scheduler"
+}
+
+func (s *retryScheduler) run() {
+	defer close(s.done)
+	for {
+		err := s.runOnce(time.Now().UTC())
+		if err != nil {
+			log.Errorf("%s %s", logTag, err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(retrySchedulerInterval):
+		}
+	}
+}
+
+// runOnce enqueues the retries due at the given time. Each retry is removed
+// before being enqueued, so concurrent schedulers never enqueue the same
+// retry twice.
+func (s *retryScheduler) runOnce(now time.Time) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var retries []retry
+	err = conn.WebhookRetries().Find(bson.M{"notbefore": bson.M{"$lte": now}}).All(&retries)
+	if err != nil {
+		return err
+	}
+	var q monsterqueue.Queue
+	for _, r := range retries {
+		err = conn.WebhookRetries().RemoveId(r.ID)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if q == nil {
+			q, err = queue.Queue()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = q.Enqueue(deliveryTaskName, deliveryParams(r.Webhook, r.EventID, r.Attempt))
+		if err != nil {
+			log.Errorf("%s unable to retry delivering event %s to webhook %q: %s", logTag, r.EventID, r.Webhook, err)
+		}
+	}
+	return nil
+}
+
+// Sign returns the signature of the body using the secret, as sent in the
+// SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends the event to the webhook, recording the attempt. Events and
+// webhooks removed in the meantime are ignored.
+func deliver(name, eventID string, attempt int) error {
+	w, err := Get(name)
+	if err != nil {
+		if err == ErrWebhookNotFound {
+			return nil
+		}
+		return err
+	}
+	evt, err := event.GetByID(bson.ObjectIdHex(eventID))
+	if err != nil {
+		if err == event.ErrEventNotFound {
+			return nil
+		}
+		return err
+	}
+	record, err := event.NewExportRecord(evt)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	delivery := Delivery{
+		ID:        bson.NewObjectId(),
+		Webhook:   w.Name,
+		EventID:   record.ID,
+		EventKind: record.Kind.Name,
+		Attempt:   attempt,
+		Timestamp: time.Now().UTC(),
+	}
+	sendErr := send(w, record, body, attempt, &delivery)
+	if sendErr != nil {
+		delivery.Error = sendErr.Error()
+	}
+	delivery.Duration = time.Since(delivery.Timestamp)
+	err = saveDelivery(&delivery)
+	if err != nil {
+		log.Errorf("%s unable to record delivery to webhook %q: %s", logTag, w.Name, err)
+	}
+	return sendErr
+}
+
+func send(w *Webhook, record *event.ExportRecord, body []byte, attempt int, delivery *Delivery) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	req.Header.Set(EventIDHeader, record.ID)
+	req.Header.Set(EventKindHeader, record.Kind.Name)
+	req.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	delivery.StatusCode = rsp.StatusCode
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("invalid status code sending event to webhook %q: %d", w.Name, rsp.StatusCode)
+	}
+	return nil
+}
+
+func saveDelivery(d *Delivery) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.WebhookDeliveries().Insert(d)
+}
+
+// Initialize registers the delivery task in the queue, starts the retry
+// scheduler and subscribes the webhooks to finished events. It must be called
+// before event.StartExporter.
+func Initialize() error {
+	initMut.Lock()
+	defer initMut.Unlock()
+	if initialized {
+		return nil
+	}
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	err = q.RegisterTask(&deliveryTask{})
+	if err != nil {
+		return err
+	}
+	scheduler := &retryScheduler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	shutdown.Register(scheduler)
+	go scheduler.run()
+	event.AddExportSink("webhooks", &dispatcher{})
+	initialized = true
+	return nil
+}
@@ -0,0 +1,378 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_webhook_tests")
+	config.Set("queue:mongo-url", "127.0.0.1:27017")
+	config.Set("queue:mongo-database", "tsuru_webhook_tests_queue")
+	config.Set("webhooks:allowed-networks", []interface{}{"127.0.0.0/8"})
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "example.com" {
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		}
+		return net.LookupIP(host)
+	}
+	queue.ResetQueue()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = dbtest.ClearAllCollections(conn.Webhooks().Database)
+	c.Assert(err, check.IsNil)
+	err = conn.Teams().Insert(auth.Team{Name: "team1"}, auth.Team{Name: "team2"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	config.Unset("webhooks:allowed-networks")
+	lookupIP = net.LookupIP
+	queue.ResetQueue()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Webhooks().Database.DropDatabase()
+}
+
+func newFinishedEvent(c *check.C, target event.Target, evtErr error) *event.Event {
+	evt, err := event.NewInternal(&event.Opts{Target: target, InternalKind: "healer"})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(evtErr)
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func (s *S) TestEventFilterMatch(c *check.C) {
+	record := &event.ExportRecord{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   event.Kind{Name: "app.update.env.set"},
+	}
+	failed := *record
+	failed.Error = "failed"
+	tests := []struct {
+		filter   EventFilter
+		record   *event.ExportRecord
+		expected bool
+	}{
+		{EventFilter{}, record, true},
+		{EventFilter{KindNames: []string{"app.update"}}, record, true},
+		{EventFilter{KindNames: []string{"app.update.env.set"}}, record, true},
+		{EventFilter{KindNames: []string{"app.upd"}}, record, false},
+		{EventFilter{KindNames: []string{"app.deploy", "app.update"}}, record, true},
+		{EventFilter{TargetTypes: []string{"app"}, TargetValues: []string{"myapp"}}, record, true},
+		{EventFilter{TargetTypes: []string{"team"}}, record, false},
+		{EventFilter{TargetValues: []string{"otherapp"}}, record, false},
+		{EventFilter{ErrorOnly: true}, record, false},
+		{EventFilter{ErrorOnly: true}, &failed, true},
+		{EventFilter{SuccessOnly: true}, record, true},
+		{EventFilter{SuccessOnly: true}, &failed, false},
+	}
+	for i, tt := range tests {
+		c.Check(tt.filter.Match(tt.record), check.Equals, tt.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestSign(c *check.C) {
+	c.Assert(Sign("secret", []byte("body")), check.Equals, "sha256=dc46983557fea127b43af721467eb9b3fde2338fe3e14f51952aa8478c13d355")
+}
+
+func (s *S) TestCreate(c *check.C) {
+	w := Webhook{
+		Name:        "myhook",
+		TeamOwner:   "team1",
+		URL:         "https://example.com/hook",
+		EventFilter: EventFilter{KindNames: []string{"app.deploy"}},
+	}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	c.Assert(w.Secret, check.HasLen, 2*secretSize)
+	dbWebhook, err := Get("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbWebhook, check.DeepEquals, &w)
+	err = Create(&w)
+	c.Assert(err, check.Equals, ErrWebhookAlreadyExists)
+}
+
+func (s *S) TestCreateKeepsSecret(c *check.C) {
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: "https://example.com/hook", Secret: "mysecret"}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	dbWebhook, err := Get("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbWebhook.Secret, check.Equals, "mysecret")
+}
+
+func (s *S) TestCreateValidation(c *check.C) {
+	tests := []struct {
+		webhook  Webhook
+		expected error
+	}{
+		{Webhook{Name: "My_Hook", TeamOwner: "team1", URL: "https://example.com"}, ErrInvalidWebhookName},
+		{Webhook{Name: "myhook", URL: "https://example.com"}, ErrWebhookTeamRequired},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "ftp://example.com"}, ErrInvalidWebhookURL},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "example.com/hook"}, ErrInvalidWebhookURL},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "https://example.com", EventFilter: EventFilter{ErrorOnly: true, SuccessOnly: true}}, ErrInvalidEventFilter},
+		{Webhook{Name: "myhook", TeamOwner: "unknown", URL: "https://example.com"}, auth.ErrTeamNotFound},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "https://unknown.invalid"}, ErrWebhookHostNotFound},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "http://10.0.0.1/hook"}, ErrWebhookHostNotAllowed},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "http://169.254.169.254/latest"}, ErrWebhookHostNotAllowed},
+		{Webhook{Name: "myhook", TeamOwner: "team1", URL: "http://[::1]:8080/hook"}, ErrWebhookHostNotAllowed},
+	}
+	config.Unset("webhooks:allowed-networks")
+	for i, tt := range tests {
+		c.Check(Create(&tt.webhook), check.Equals, tt.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestCreateAllowedNetwork(c *check.C) {
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: "http://10.0.0.1/hook"}
+	config.Unset("webhooks:allowed-networks")
+	err := Create(&w)
+	c.Assert(err, check.Equals, ErrWebhookHostNotAllowed)
+	config.Set("webhooks:allowed-networks", []interface{}{"10.0.0.0/24"})
+	err = Create(&w)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestDeliverHostNotAllowed(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: srv.URL}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	config.Unset("webhooks:allowed-networks")
+	evt := newFinishedEvent(c, event.Target{Type: event.TargetTypeTeam, Value: "team1"}, nil)
+	err = deliver("myhook", evt.UniqueID.Hex(), 1)
+	c.Assert(err, check.ErrorMatches, `.*`+ErrWebhookHostNotAllowed.Error())
+}
+
+func (s *S) TestUpdate(c *check.C) {
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: "https://example.com/hook", Secret: "mysecret"}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	err = Update(&Webhook{Name: "myhook", TeamOwner: "team2", URL: "https://example.com/other", Description: "my hook"})
+	c.Assert(err, check.IsNil)
+	dbWebhook, err := Get("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbWebhook, check.DeepEquals, &Webhook{
+		Name:        "myhook",
+		Description: "my hook",
+		TeamOwner:   "team2",
+		URL:         "https://example.com/other",
+		Secret:      "mysecret",
+	})
+	err = Update(&Webhook{Name: "otherhook", TeamOwner: "team2", URL: "https://example.com/other"})
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestList(c *check.C) {
+	for _, w := range []Webhook{
+		{Name: "hook2", TeamOwner: "team1", URL: "https://example.com/2"},
+		{Name: "hook1", TeamOwner: "team2", URL: "https://example.com/1"},
+	} {
+		err := Create(&w)
+		c.Assert(err, check.IsNil)
+	}
+	webhooks, err := List(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(webhooks, check.HasLen, 2)
+	c.Assert(webhooks[0].Name, check.Equals, "hook1")
+	c.Assert(webhooks[1].Name, check.Equals, "hook2")
+	webhooks, err = List([]string{"team1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(webhooks, check.HasLen, 1)
+	c.Assert(webhooks[0].Name, check.Equals, "hook2")
+	webhooks, err = List([]string{})
+	c.Assert(err, check.IsNil)
+	c.Assert(webhooks, check.HasLen, 0)
+}
+
+func (s *S) TestDelete(c *check.C) {
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: "https://example.com/hook"}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	err = saveDelivery(&Delivery{ID: bson.NewObjectId(), Webhook: "myhook", Timestamp: time.Now()})
+	c.Assert(err, check.IsNil)
+	err = Delete("myhook")
+	c.Assert(err, check.IsNil)
+	_, err = Get("myhook")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	deliveries, err := ListDeliveries("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 0)
+	err = Delete("myhook")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestDispatcherSend(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Apps().Insert(bson.M{"name": "myapp", "teams": []string{"team1", "team2"}})
+	c.Assert(err, check.IsNil)
+	for _, w := range []Webhook{
+		{Name: "deploys", TeamOwner: "team1", URL: "https://example.com/1", EventFilter: EventFilter{KindNames: []string{"app.deploy"}}},
+		{Name: "all", TeamOwner: "team2", URL: "https://example.com/2"},
+	} {
+		err = Create(&w)
+		c.Assert(err, check.IsNil)
+	}
+	records := []event.ExportRecord{
+		{ID: bson.NewObjectId().Hex(), Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"}, Kind: event.Kind{Name: "app.deploy"}},
+		{ID: bson.NewObjectId().Hex(), Target: event.Target{Type: event.TargetTypeApp, Value: "otherapp"}, Kind: event.Kind{Name: "app.deploy"}},
+		{ID: bson.NewObjectId().Hex(), Target: event.Target{Type: event.TargetTypeTeam, Value: "team2"}, Kind: event.Kind{Name: "team.update"}},
+	}
+	err = (&dispatcher{}).Send(records)
+	c.Assert(err, check.IsNil)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	jobs, err := q.ListJobs()
+	c.Assert(err, check.IsNil)
+	var delivered []string
+	for _, j := range jobs {
+		c.Assert(j.TaskName(), check.Equals, deliveryTaskName)
+		params := j.Parameters()
+		c.Assert(params["attempt"], check.Equals, "1")
+		delivered = append(delivered, params["webhook"].(string)+":"+params["event"].(string))
+	}
+	c.Assert(delivered, check.DeepEquals, []string{
+		"deploys:" + records[0].ID,
+		"all:" + records[0].ID,
+		"all:" + records[2].ID,
+	})
+}
+
+func (s *S) TestDeliver(c *check.C) {
+	var received event.ExportRecord
+	var header http.Header
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = Sign("mysecret", body)
+		json.Unmarshal(body, &received)
+	}))
+	defer srv.Close()
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: srv.URL, Secret: "mysecret"}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	evt := newFinishedEvent(c, event.Target{Type: event.TargetTypeTeam, Value: "team1"}, nil)
+	err = deliver("myhook", evt.UniqueID.Hex(), 2)
+	c.Assert(err, check.IsNil)
+	c.Assert(received.ID, check.Equals, evt.UniqueID.Hex())
+	c.Assert(received.Kind.Name, check.Equals, "healer")
+	c.Assert(header.Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(header.Get(SignatureHeader), check.Equals, signature)
+	c.Assert(header.Get(EventIDHeader), check.Equals, evt.UniqueID.Hex())
+	c.Assert(header.Get(EventKindHeader), check.Equals, "healer")
+	c.Assert(header.Get(AttemptHeader), check.Equals, "2")
+	deliveries, err := ListDeliveries("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].EventID, check.Equals, evt.UniqueID.Hex())
+	c.Assert(deliveries[0].Attempt, check.Equals, 2)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(deliveries[0].Error, check.Equals, "")
+}
+
+func (s *S) TestDeliverFailure(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	w := Webhook{Name: "myhook", TeamOwner: "team1", URL: srv.URL}
+	err := Create(&w)
+	c.Assert(err, check.IsNil)
+	evt := newFinishedEvent(c, event.Target{Type: event.TargetTypeTeam, Value: "team1"}, errors.New("failed"))
+	err = deliver("myhook", evt.UniqueID.Hex(), 1)
+	c.Assert(err, check.ErrorMatches, `invalid status code sending event to webhook "myhook": 500`)
+	deliveries, err := ListDeliveries("myhook")
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusInternalServerError)
+	c.Assert(deliveries[0].Error, check.Equals, err.Error())
+}
+
+func (s *S) TestDeliverRemovedWebhook(c *check.C) {
+	evt := newFinishedEvent(c, event.Target{Type: event.TargetTypeTeam, Value: "team1"}, nil)
+	err := deliver("myhook", evt.UniqueID.Hex(), 1)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestScheduleRetry(c *check.C) {
+	config.Set("webhooks:max-attempts", 3)
+	defer config.Unset("webhooks:max-attempts")
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	eventID := bson.NewObjectId().Hex()
+	err := scheduleRetry("myhook", eventID, 3, now)
+	c.Assert(err, check.IsNil)
+	err = scheduleRetry("myhook", eventID, 2, now)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	var retries []retry
+	err = conn.WebhookRetries().Find(nil).All(&retries)
+	c.Assert(err, check.IsNil)
+	c.Assert(retries, check.HasLen, 1)
+	c.Assert(retries[0].Webhook, check.Equals, "myhook")
+	c.Assert(retries[0].EventID, check.Equals, eventID)
+	c.Assert(retries[0].Attempt, check.Equals, 3)
+	c.Assert(retries[0].NotBefore.Equal(now.Add(2*retryBackoff)), check.Equals, true)
+}
+
+func (s *S) TestRetrySchedulerRunOnce(c *check.C) {
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	eventID := bson.NewObjectId().Hex()
+	err := scheduleRetry("myhook", eventID, 1, now)
+	c.Assert(err, check.IsNil)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	scheduler := &retryScheduler{}
+	err = scheduler.runOnce(now)
+	c.Assert(err, check.IsNil)
+	jobs, err := q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+	err = scheduler.runOnce(now.Add(retryBackoff))
+	c.Assert(err, check.IsNil)
+	jobs, err = q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Parameters()["webhook"], check.Equals, "myhook")
+	c.Assert(jobs[0].Parameters()["event"], check.Equals, eventID)
+	c.Assert(jobs[0].Parameters()["attempt"], check.Equals, "2")
+	err = scheduler.runOnce(now.Add(retryBackoff))
+	c.Assert(err, check.IsNil)
+	jobs, err = q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+const syslogTimeout = 5 * time.Second
+
+var ErrInvalidSyslogURL = errors.New("syslog url must be in the form udp://host:port or tcp://host:port")
+
+// SyslogMessage is a RFC 5424 syslog message, without structured data.
+// Empty header fields are sent as the nil value, "-".
+type SyslogMessage struct {
+	Priority  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	Message   string
+}
+
+// String formats the message, removing invalid characters from the header
+// fields and truncating them to their maximum length.
+func (m *SyslogMessage) String() string {
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s - %s",
+		m.Priority,
+		m.Timestamp.UTC().Format(time.RFC3339Nano),
+		syslogField(m.Hostname, 255),
+		syslogField(m.AppName, 48),
+		syslogField(m.ProcID, 128),
+		syslogField(m.MsgID, 32),
+		m.Message,
+	)
+}
+
+func syslogField(value string, maxLen int) string {
+	field := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(field) < maxLen; i++ {
+		if value[i] > ' ' && value[i] < 127 {
+			field = append(field, value[i])
+		}
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return string(field)
+}
+
+// SyslogWriter sends messages to a syslog server, over UDP or TCP. Messages
+// sent over TCP use the octet counting framing from RFC 6587.
+type SyslogWriter struct {
+	network string
+	address string
+}
+
+// NewSyslogWriter returns a writer to the server in the given url, in the
+// form udp://host:port or tcp://host:port.
+func NewSyslogWriter(rawURL string) (*SyslogWriter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, ErrInvalidSyslogURL
+	}
+	return &SyslogWriter{network: u.Scheme, address: u.Host}, nil
+}
+
+// Write sends the messages, in order, using a single connection.
+func (w *SyslogWriter) Write(msgs []SyslogMessage) error {
+	conn, err := net.DialTimeout(w.network, w.address, syslogTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for i := range msgs {
+		msg := msgs[i].String()
+		if w.network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err = conn.Write([]byte(msg))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
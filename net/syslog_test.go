@@ -0,0 +1,76 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package net
+
+import (
+	"io/ioutil"
+	"net"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSyslogMessageString(c *check.C) {
+	msg := SyslogMessage{
+		Priority:  14,
+		Timestamp: time.Date(2016, 10, 1, 12, 30, 0, 0, time.UTC),
+		Hostname:  "my host",
+		AppName:   "myapp",
+		ProcID:    "web",
+		MsgID:     "deploy",
+		Message:   "hello world",
+	}
+	c.Assert(msg.String(), check.Equals, "<14>1 2016-10-01T12:30:00Z myhost myapp web deploy - hello world")
+	msg = SyslogMessage{Priority: 14, Timestamp: msg.Timestamp, Hostname: " ", Message: "hello"}
+	c.Assert(msg.String(), check.Equals, "<14>1 2016-10-01T12:30:00Z - - - - - hello")
+}
+
+func (s *S) TestNewSyslogWriterInvalidURL(c *check.C) {
+	for _, rawURL := range []string{"syslog:514", "http://syslog:514", "udp://"} {
+		_, err := NewSyslogWriter(rawURL)
+		c.Check(err, check.Equals, ErrInvalidSyslogURL, check.Commentf("url %q", rawURL))
+	}
+}
+
+func (s *S) TestSyslogWriterUDP(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	w, err := NewSyslogWriter("udp://" + conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	err = w.Write([]SyslogMessage{{Priority: 14, AppName: "myapp", Message: "msg1"}})
+	c.Assert(err, check.IsNil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buf[:n]), check.Matches, `<14>1 \S+ - myapp - - - msg1`)
+}
+
+func (s *S) TestSyslogWriterTCP(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+	w, err := NewSyslogWriter("tcp://" + listener.Addr().String())
+	c.Assert(err, check.IsNil)
+	err = w.Write([]SyslogMessage{{Priority: 14, Message: "msg1"}, {Priority: 14, Message: "msg2"}})
+	c.Assert(err, check.IsNil)
+	select {
+	case data := <-received:
+		c.Assert(data, check.Matches, `\d+ <14>1 \S+ - - - - - msg1\d+ <14>1 \S+ - - - - - msg2`)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for messages")
+	}
+}
@@ -57,7 +57,8 @@ var (
 	}
 )
 
-func parseContext(ctx string) (contextType, error) {
+// ParseContext returns the context type with the given name.
+func ParseContext(ctx string) (contextType, error) {
 	for _, t := range ContextTypes {
 		if string(t) == ctx {
 			return t, nil
@@ -66,6 +67,20 @@ func parseContext(ctx string) (contextType, error) {
 	return "", fmt.Errorf("invalid context type %q", ctx)
 }
 
+// SafeGet returns the permission scheme registered with the given name, or an
+// error if there's no such permission. Unlike the values in permitems.go, it
+// may be used with names coming from user input.
+func SafeGet(name string) (*PermissionScheme, error) {
+	if name == "*" {
+		name = ""
+	}
+	reg := PermissionRegistry.getSubRegistry(name)
+	if reg == nil {
+		return nil, &ErrPermissionNotFound{permission: name}
+	}
+	return &reg.PermissionScheme, nil
+}
+
 func (l PermissionSchemeList) Len() int           { return len(l) }
 func (l PermissionSchemeList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
 func (l PermissionSchemeList) Less(i, j int) bool { return l[i].FullName() < l[j].FullName() }
@@ -186,16 +201,27 @@ func Check(token Token, scheme *PermissionScheme, contexts ...PermissionContext)
 }
 
 func CheckFromPermList(perms []Permission, scheme *PermissionScheme, contexts ...PermissionContext) bool {
-	for _, perm := range perms {
-		if perm.Scheme.IsParent(scheme) {
-			if perm.Context.CtxType == CtxGlobal {
-				return true
-			}
-			for _, ctx := range contexts {
-				if ctx.CtxType == perm.Context.CtxType && ctx.Value == perm.Context.Value {
-					return true
-				}
-			}
+	for i := range perms {
+		if perms[i].Grants(scheme, contexts...) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grants reports whether the permission gives access to scheme in any of
+// the contexts. It happens when the permission scheme is scheme itself or
+// one of its parents, in the global context or in one of the given contexts.
+func (p *Permission) Grants(scheme *PermissionScheme, contexts ...PermissionContext) bool {
+	if !p.Scheme.IsParent(scheme) {
+		return false
+	}
+	if p.Context.CtxType == CtxGlobal {
+		return true
+	}
+	for _, ctx := range contexts {
+		if ctx.CtxType == p.Context.CtxType && ctx.Value == p.Context.Value {
+			return true
 		}
 	}
 	return false
@@ -56,6 +56,25 @@ func (s *S) TestPermissionSchemeAllowedContexts(c *check.C) {
 	}
 }
 
+func (s *S) TestSafeGet(c *check.C) {
+	scheme, err := SafeGet("app.deploy")
+	c.Assert(err, check.IsNil)
+	c.Assert(scheme, check.Equals, PermAppDeploy)
+	scheme, err = SafeGet("*")
+	c.Assert(err, check.IsNil)
+	c.Assert(scheme, check.Equals, PermAll)
+	_, err = SafeGet("app.explode")
+	c.Assert(err, check.ErrorMatches, `permission named "app.explode" not found`)
+}
+
+func (s *S) TestParseContext(c *check.C) {
+	ctxType, err := ParseContext("app")
+	c.Assert(err, check.IsNil)
+	c.Assert(ctxType, check.Equals, CtxApp)
+	_, err = ParseContext("planet")
+	c.Assert(err, check.ErrorMatches, `invalid context type "planet"`)
+}
+
 type userToken struct {
 	permissions []Permission
 }
@@ -92,6 +111,18 @@ func (s *S) TestCheckSuperToken(c *check.C) {
 	c.Assert(Check(t, PermAppUpdateEnvUnset), check.Equals, true)
 }
 
+func (s *S) TestPermissionGrants(c *check.C) {
+	perm := Permission{Scheme: PermAppUpdate, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}}
+	c.Assert(perm.Grants(PermAppUpdateEnvSet, PermissionContext{CtxType: CtxTeam, Value: "team1"}), check.Equals, true)
+	c.Assert(perm.Grants(PermAppUpdate, PermissionContext{CtxType: CtxApp, Value: "myapp"}, PermissionContext{CtxType: CtxTeam, Value: "team1"}), check.Equals, true)
+	c.Assert(perm.Grants(PermAppUpdate, PermissionContext{CtxType: CtxTeam, Value: "team2"}), check.Equals, false)
+	c.Assert(perm.Grants(PermAppDeploy, PermissionContext{CtxType: CtxTeam, Value: "team1"}), check.Equals, false)
+	c.Assert(perm.Grants(PermAppUpdate), check.Equals, false)
+	global := Permission{Scheme: PermAll, Context: PermissionContext{CtxType: CtxGlobal}}
+	c.Assert(global.Grants(PermAppDeploy, PermissionContext{CtxType: CtxTeam, Value: "team1"}), check.Equals, true)
+	c.Assert(global.Grants(PermAppDeploy), check.Equals, true)
+}
+
 func (s *S) TestGetTeamForPermission(c *check.C) {
 	t := &userToken{
 		permissions: []Permission{
@@ -14,25 +14,42 @@ var (
 	PermAppAdminQuota                    = PermissionRegistry.get("app.admin.quota")                     // [global app team pool]
 	PermAppAdminRoutes                   = PermissionRegistry.get("app.admin.routes")                    // [global app team pool]
 	PermAppAdminUnlock                   = PermissionRegistry.get("app.admin.unlock")                    // [global app team pool]
+	PermAppApproveDeploy                 = PermissionRegistry.get("app.approve-deploy")                  // [global app team pool]
 	PermAppCreate                        = PermissionRegistry.get("app.create")                          // [global team]
 	PermAppDelete                        = PermissionRegistry.get("app.delete")                          // [global app team pool]
 	PermAppDeploy                        = PermissionRegistry.get("app.deploy")                          // [global app team pool]
 	PermAppDeployArchiveUrl              = PermissionRegistry.get("app.deploy.archive-url")              // [global app team pool]
+	PermAppDeployBlueGreen               = PermissionRegistry.get("app.deploy.blue-green")               // [global app team pool]
+	PermAppDeployBlueGreenRevert         = PermissionRegistry.get("app.deploy.blue-green.revert")        // [global app team pool]
 	PermAppDeployBuild                   = PermissionRegistry.get("app.deploy.build")                    // [global app team pool]
+	PermAppDeployCanary                  = PermissionRegistry.get("app.deploy.canary")                   // [global app team pool]
+	PermAppDeployCanaryAbort             = PermissionRegistry.get("app.deploy.canary.abort")             // [global app team pool]
+	PermAppDeployCanaryPromote           = PermissionRegistry.get("app.deploy.canary.promote")           // [global app team pool]
 	PermAppDeployGit                     = PermissionRegistry.get("app.deploy.git")                      // [global app team pool]
 	PermAppDeployImage                   = PermissionRegistry.get("app.deploy.image")                    // [global app team pool]
 	PermAppDeployRollback                = PermissionRegistry.get("app.deploy.rollback")                 // [global app team pool]
 	PermAppDeployUpload                  = PermissionRegistry.get("app.deploy.upload")                   // [global app team pool]
 	PermAppRead                          = PermissionRegistry.get("app.read")                            // [global app team pool]
+	PermAppReadAutoscale                 = PermissionRegistry.get("app.read.autoscale")                  // [global app team pool]
+	PermAppReadCertificate               = PermissionRegistry.get("app.read.certificate")                // [global app team pool]
 	PermAppReadDeploy                    = PermissionRegistry.get("app.read.deploy")                     // [global app team pool]
 	PermAppReadEnv                       = PermissionRegistry.get("app.read.env")                        // [global app team pool]
 	PermAppReadEvents                    = PermissionRegistry.get("app.read.events")                     // [global app team pool]
+	PermAppReadJob                       = PermissionRegistry.get("app.read.job")                        // [global app team pool]
 	PermAppReadLog                       = PermissionRegistry.get("app.read.log")                        // [global app team pool]
 	PermAppReadMetric                    = PermissionRegistry.get("app.read.metric")                     // [global app team pool]
+	PermAppReadRouter                    = PermissionRegistry.get("app.read.router")                     // [global app team pool]
+	PermAppReadRoutes                    = PermissionRegistry.get("app.read.routes")                     // [global app team pool]
 	PermAppRun                           = PermissionRegistry.get("app.run")                             // [global app team pool]
 	PermAppRunShell                      = PermissionRegistry.get("app.run.shell")                       // [global app team pool]
 	PermAppUpdate                        = PermissionRegistry.get("app.update")                          // [global app team pool]
+	PermAppUpdateAutoscale               = PermissionRegistry.get("app.update.autoscale")                // [global app team pool]
+	PermAppUpdateAutoscaleSet            = PermissionRegistry.get("app.update.autoscale.set")            // [global app team pool]
+	PermAppUpdateAutoscaleUnset          = PermissionRegistry.get("app.update.autoscale.unset")          // [global app team pool]
 	PermAppUpdateBind                    = PermissionRegistry.get("app.update.bind")                     // [global app team pool]
+	PermAppUpdateCertificate             = PermissionRegistry.get("app.update.certificate")              // [global app team pool]
+	PermAppUpdateCertificateSet          = PermissionRegistry.get("app.update.certificate.set")          // [global app team pool]
+	PermAppUpdateCertificateUnset        = PermissionRegistry.get("app.update.certificate.unset")        // [global app team pool]
 	PermAppUpdateCname                   = PermissionRegistry.get("app.update.cname")                    // [global app team pool]
 	PermAppUpdateCnameAdd                = PermissionRegistry.get("app.update.cname.add")                // [global app team pool]
 	PermAppUpdateCnameRemove             = PermissionRegistry.get("app.update.cname.remove")             // [global app team pool]
@@ -42,14 +59,21 @@ var (
 	PermAppUpdateEnvUnset                = PermissionRegistry.get("app.update.env.unset")                // [global app team pool]
 	PermAppUpdateEvents                  = PermissionRegistry.get("app.update.events")                   // [global app team pool]
 	PermAppUpdateGrant                   = PermissionRegistry.get("app.update.grant")                    // [global app team pool]
+	PermAppUpdateJob                     = PermissionRegistry.get("app.update.job")                      // [global app team pool]
+	PermAppUpdateJobCreate               = PermissionRegistry.get("app.update.job.create")               // [global app team pool]
+	PermAppUpdateJobDelete               = PermissionRegistry.get("app.update.job.delete")               // [global app team pool]
 	PermAppUpdateLog                     = PermissionRegistry.get("app.update.log")                      // [global app team pool]
 	PermAppUpdatePlan                    = PermissionRegistry.get("app.update.plan")                     // [global app team pool]
 	PermAppUpdatePool                    = PermissionRegistry.get("app.update.pool")                     // [global app team pool]
 	PermAppUpdateRestart                 = PermissionRegistry.get("app.update.restart")                  // [global app team pool]
 	PermAppUpdateRevoke                  = PermissionRegistry.get("app.update.revoke")                   // [global app team pool]
+	PermAppUpdateRouter                  = PermissionRegistry.get("app.update.router")                   // [global app team pool]
+	PermAppUpdateRouterAdd               = PermissionRegistry.get("app.update.router.add")               // [global pool]
+	PermAppUpdateRouterRemove            = PermissionRegistry.get("app.update.router.remove")            // [global app team pool]
 	PermAppUpdateSleep                   = PermissionRegistry.get("app.update.sleep")                    // [global app team pool]
 	PermAppUpdateStart                   = PermissionRegistry.get("app.update.start")                    // [global app team pool]
 	PermAppUpdateStop                    = PermissionRegistry.get("app.update.stop")                     // [global app team pool]
+	PermAppUpdateStructuredLogs          = PermissionRegistry.get("app.update.structured-logs")          // [global app team pool]
 	PermAppUpdateSwap                    = PermissionRegistry.get("app.update.swap")                     // [global app team pool]
 	PermAppUpdateTeamowner               = PermissionRegistry.get("app.update.teamowner")                // [global app team pool]
 	PermAppUpdateUnbind                  = PermissionRegistry.get("app.update.unbind")                   // [global app team pool]
@@ -151,8 +175,17 @@ var (
 	PermTeamDelete                       = PermissionRegistry.get("team.delete")                         // [global team]
 	PermTeamRead                         = PermissionRegistry.get("team.read")                           // [global team]
 	PermTeamReadEvents                   = PermissionRegistry.get("team.read.events")                    // [global team]
+	PermTeamReadQuota                    = PermissionRegistry.get("team.read.quota")                     // [global team]
+	PermTeamServiceAccount               = PermissionRegistry.get("team.service-account")                // [global team]
+	PermTeamServiceAccountCreate         = PermissionRegistry.get("team.service-account.create")         // [global team]
+	PermTeamServiceAccountDelete         = PermissionRegistry.get("team.service-account.delete")         // [global team]
+	PermTeamServiceAccountRead           = PermissionRegistry.get("team.service-account.read")           // [global team]
+	PermTeamServiceAccountUpdate         = PermissionRegistry.get("team.service-account.update")         // [global team]
+	PermTeamServiceAccountUpdateRole     = PermissionRegistry.get("team.service-account.update.role")    // [global team]
+	PermTeamServiceAccountUpdateToken    = PermissionRegistry.get("team.service-account.update.token")   // [global team]
 	PermTeamUpdate                       = PermissionRegistry.get("team.update")                         // [global team]
 	PermTeamUpdateEvents                 = PermissionRegistry.get("team.update.events")                  // [global team]
+	PermTeamUpdateQuota                  = PermissionRegistry.get("team.update.quota")                   // [global]
 	PermUser                             = PermissionRegistry.get("user")                                // [global]
 	PermUserCreate                       = PermissionRegistry.get("user.create")                         // [global]
 	PermUserDelete                       = PermissionRegistry.get("user.delete")                         // [global]
@@ -167,4 +200,10 @@ var (
 	PermUserUpdateQuota                  = PermissionRegistry.get("user.update.quota")                   // [global]
 	PermUserUpdateReset                  = PermissionRegistry.get("user.update.reset")                   // [global]
 	PermUserUpdateToken                  = PermissionRegistry.get("user.update.token")                   // [global]
+	PermUserUpdateTwoFactor              = PermissionRegistry.get("user.update.two-factor")              // [global]
+	PermWebhook                          = PermissionRegistry.get("webhook")                             // [global team]
+	PermWebhookCreate                    = PermissionRegistry.get("webhook.create")                      // [global team]
+	PermWebhookDelete                    = PermissionRegistry.get("webhook.delete")                      // [global team]
+	PermWebhookRead                      = PermissionRegistry.get("webhook.read")                        // [global team]
+	PermWebhookUpdate                    = PermissionRegistry.get("webhook.update")                      // [global team]
 )
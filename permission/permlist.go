@@ -13,6 +13,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 ).add(
 	"app.update.description",
 	"app.update.log",
+	"app.update.structured-logs",
 	"app.update.pool",
 	"app.update.unit.add",
 	"app.update.unit.remove",
@@ -30,13 +31,27 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.teamowner",
 	"app.update.cname.add",
 	"app.update.cname.remove",
+	"app.update.certificate.set",
+	"app.update.certificate.unset",
+).addWithCtx(
+	"app.update.router.add", []contextType{CtxPool},
+).add(
+	"app.update.router.remove",
 	"app.update.plan",
 	"app.update.bind",
 	"app.update.events",
 	"app.update.unbind",
+	"app.update.job.create",
+	"app.update.job.delete",
+	"app.update.autoscale.set",
+	"app.update.autoscale.unset",
+	"app.approve-deploy",
 	"app.deploy",
 	"app.deploy.archive-url",
+	"app.deploy.blue-green.revert",
 	"app.deploy.build",
+	"app.deploy.canary.promote",
+	"app.deploy.canary.abort",
 	"app.deploy.git",
 	"app.deploy.image",
 	"app.deploy.rollback",
@@ -47,6 +62,11 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.read.events",
 	"app.read.metric",
 	"app.read.log",
+	"app.read.job",
+	"app.read.autoscale",
+	"app.read.certificate",
+	"app.read.router",
+	"app.read.routes",
 	"app.delete",
 	"app.run",
 	"app.run.shell",
@@ -77,10 +97,18 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"team", []contextType{CtxTeam},
 ).addWithCtx(
 	"team.create", []contextType{},
+).addWithCtx(
+	"team.update.quota", []contextType{},
 ).add(
 	"team.read.events",
 	"team.delete",
 	"team.update.events",
+	"team.read.quota",
+	"team.service-account.create",
+	"team.service-account.read",
+	"team.service-account.delete",
+	"team.service-account.update.token",
+	"team.service-account.update.role",
 ).add(
 	"user.create",
 	"user.delete",
@@ -89,6 +117,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"user.update.events",
 	"user.update.quota",
 	"user.update.password",
+	"user.update.two-factor",
 	"user.update.reset",
 	"user.update.key.add",
 	"user.update.key.remove",
@@ -167,4 +196,11 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"nodecontainer.update",
 	"nodecontainer.update.upgrade",
 	"nodecontainer.delete",
+).addWithCtx(
+	"webhook", []contextType{CtxTeam},
+).add(
+	"webhook.create",
+	"webhook.read",
+	"webhook.update",
+	"webhook.delete",
 )
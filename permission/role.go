@@ -85,7 +85,7 @@ type Role struct {
 }
 
 func NewRole(name string, ctx string, description string) (Role, error) {
-	ctxType, err := parseContext(ctx)
+	ctxType, err := ParseContext(ctx)
 	if err != nil {
 		return Role{}, err
 	}
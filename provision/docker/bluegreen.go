@@ -0,0 +1,665 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/db"
+	dbStorage "github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	tsuruNet "github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/queue"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	blueGreenCleanupTaskName  = "blueGreenCleanupTask"
+	defaultBlueGreenGraceTime = 5 * time.Minute
+)
+
+var (
+	errNoBlueGreenDeploy = errors.New("no blue/green deploy available to be reverted")
+	errNoUnitsToRevertTo = errors.New("units running the previous image are no longer available")
+
+	blueGreenCleanupInterval  = 10 * time.Second
+	blueGreenCleanupRetryTime = time.Minute
+
+	// shadowHealthcheckClient is used to verify the new units through the
+	// shadow backend in the routers.
+	shadowHealthcheckClient = tsuruNet.Dial5Full60ClientNoKeepAlive
+
+	blueGreenCleanupMut      sync.Mutex
+	blueGreenCleanupInstance *blueGreenCleanupScheduler
+)
+
+// blueGreenDeploy holds the state of the last blue/green deploy of an app,
+// while the units running the previous image are still kept. The units are
+// removed by the cleanup task, enqueued once RemoveAfter is reached.
+type blueGreenDeploy struct {
+	App           string `bson:"_id"`
+	Image         string
+	PreviousImage string
+	StandbyUnits  []string
+	RemoveAfter   time.Time
+}
+
+func blueGreenColl() (*dbStorage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_blue_green", name)), nil
+}
+
+func saveBlueGreenDeploy(bg *blueGreenDeploy) error {
+	coll, err := blueGreenColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(bg.App, bg)
+	return err
+}
+
+func findBlueGreenDeploy(appName string) (*blueGreenDeploy, error) {
+	coll, err := blueGreenColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var bg blueGreenDeploy
+	err = coll.FindId(appName).One(&bg)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errNoBlueGreenDeploy
+		}
+		return nil, err
+	}
+	return &bg, nil
+}
+
+func removeBlueGreenDeploy(appName string) error {
+	coll, err := blueGreenColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(appName)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// standbyUnits returns the IDs of the units kept after a blue/green deploy,
+// they must not receive any traffic.
+func standbyUnits(appName string) (map[string]bool, error) {
+	bg, err := findBlueGreenDeploy(appName)
+	if err != nil {
+		if err == errNoBlueGreenDeploy {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := make(map[string]bool, len(bg.StandbyUnits))
+	for _, id := range bg.StandbyUnits {
+		result[id] = true
+	}
+	return result, nil
+}
+
+func blueGreenGraceTime() time.Duration {
+	seconds, err := config.GetInt("docker:blue-green:grace-period")
+	if err != nil {
+		return defaultBlueGreenGraceTime
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func shadowBackendName(appName string) string {
+	return appName + "-shadow"
+}
+
+// addShadowRoutes adds the new units to the shadow backend of the app in all
+// of its routers, so that they can be verified before receiving the traffic
+// of the app.
+var addShadowRoutes = action.Action{
+	Name: "add-shadow-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		if err := checkCanceled(args.event); err != nil {
+			return nil, err
+		}
+		webProcessName, err := getImageWebProcessName(args.imageId)
+		if err != nil {
+			log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+		}
+		newContainers := ctx.Previous.([]container.Container)
+		routers, err := getRoutersForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		writer := args.writer
+		if writer == nil {
+			writer = ioutil.Discard
+		}
+		shadowName := shadowBackendName(args.app.GetName())
+		routes := routesForProcess(newContainers, webProcessName)
+		for i, r := range routers {
+			err = addShadowBackend(r, shadowName, routes)
+			if err != nil {
+				for _, r := range routers[:i] {
+					r.RemoveBackend(shadowName)
+				}
+				return nil, err
+			}
+			addr, err := r.Addr(shadowName)
+			if err == nil {
+				fmt.Fprintf(writer, "\n---- New units available in shadow backend at %s ----\n", addr)
+			}
+		}
+		return newContainers, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		removeShadowBackends(args.app)
+	},
+	OnError:   rollbackNotice,
+	MinParams: 1,
+}
+
+// addShadowBackend creates the shadow backend with the given routes. A shadow
+// backend left by a failed deploy is created again, so that it only holds the
+// new units.
+func addShadowBackend(r router.Router, shadowName string, routes []*url.URL) error {
+	err := r.AddBackend(shadowName)
+	if err == router.ErrBackendExists {
+		err = r.RemoveBackend(shadowName)
+		if err != nil {
+			return err
+		}
+		err = r.AddBackend(shadowName)
+	}
+	if err != nil {
+		return err
+	}
+	err = r.AddRoutes(shadowName, routes)
+	if err != nil {
+		r.RemoveBackend(shadowName)
+	}
+	return err
+}
+
+func removeShadowBackends(a provision.App) {
+	routers, err := getRoutersForApp(a)
+	if err != nil {
+		log.Errorf("unable to get routers of app %q: %s", a.GetName(), err)
+		return
+	}
+	for _, r := range routers {
+		err = r.RemoveBackend(shadowBackendName(a.GetName()))
+		if err != nil && err != router.ErrBackendNotFound {
+			log.Errorf("unable to remove shadow backend of app %q: %s", a.GetName(), err)
+		}
+	}
+}
+
+// verifyShadowRoutes runs the healthcheck of the new image through the
+// shadow backend in each router of the app, which ensures the routers are
+// able to reach the new units before the traffic is moved to them.
+var verifyShadowRoutes = action.Action{
+	Name: "verify-shadow-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		if err := checkCanceled(args.event); err != nil {
+			return nil, err
+		}
+		newContainers := ctx.Previous.([]container.Container)
+		yamlData, err := getImageTsuruYamlData(args.imageId)
+		if err != nil {
+			return nil, err
+		}
+		if yamlData.Healthcheck.Path == "" {
+			return newContainers, nil
+		}
+		routers, err := getRoutersForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		writer := args.writer
+		if writer == nil {
+			writer = ioutil.Discard
+		}
+		shadowName := shadowBackendName(args.app.GetName())
+		for _, r := range routers {
+			addr, err := r.Addr(shadowName)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(writer, "\n---- Verifying new units through %s ----\n", addr)
+			err = checkHealthcheck(shadowHealthcheckClient, "http://"+addr, addr, yamlData.Healthcheck, writer)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newContainers, nil
+	},
+	Backward: func(ctx action.BWContext) {
+	},
+	OnError:   rollbackNotice,
+	MinParams: 1,
+}
+
+// swapShadowRoutes moves the traffic of the app to the new units at once,
+// swapping the routes of the app backend with the routes of the shadow
+// backend in all routers of the app. The shadow backend is left with the
+// routes of the old units.
+var swapShadowRoutes = action.Action{
+	Name: "swap-shadow-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		if err := checkCanceled(args.event); err != nil {
+			return nil, err
+		}
+		webProcessName, err := getImageWebProcessName(args.imageId)
+		if err != nil {
+			log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+		}
+		newContainers := ctx.Previous.([]container.Container)
+		routers, err := getRoutersForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		writer := args.writer
+		if writer == nil {
+			writer = ioutil.Discard
+		}
+		fmt.Fprintf(writer, "\n---- Swapping routes to new units ----\n")
+		err = router.SwapRoutes(routers, args.app.GetName(), shadowBackendName(args.app.GetName()))
+		if err != nil {
+			return nil, err
+		}
+		for i, c := range newContainers {
+			if c.ProcessName == webProcessName && c.ValidAddr() {
+				newContainers[i].Routable = true
+				fmt.Fprintf(writer, " ---> Added route to unit %s [%s]\n", c.ShortID(), c.ProcessName)
+			}
+		}
+		return newContainers, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		routers, err := getRoutersForApp(args.app)
+		if err != nil {
+			log.Errorf("[swap-shadow-routes:Backward] Error getting routers: %s", err)
+			return
+		}
+		err = router.SwapRoutes(routers, args.app.GetName(), shadowBackendName(args.app.GetName()))
+		if err != nil {
+			log.Errorf("[swap-shadow-routes:Backward] Error swapping routes back: %s", err)
+		}
+	},
+	OnError:   rollbackNotice,
+	MinParams: 1,
+}
+
+func (p *dockerProvisioner) blueGreenDeploy(a provision.App, imageId string, oldContainers []container.Container, evt *event.Event) error {
+	previousImage, err := appCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	imageData, err := getImageCustomData(imageId)
+	if err != nil {
+		return err
+	}
+	toAdd := getContainersToAdd(imageData, oldContainers)
+	if err = setQuota(a, toAdd); err != nil {
+		return err
+	}
+	fmt.Fprintf(evt, "\n---- Starting blue/green deploy ----\n")
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toAdd:       toAdd,
+		toRemove:    oldContainers,
+		writer:      evt,
+		imageId:     imageId,
+		provisioner: p,
+		event:       evt,
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&bindAndHealthcheck,
+		&addShadowRoutes,
+		&verifyShadowRoutes,
+		&swapShadowRoutes,
+		&setRouterHealthcheck,
+		&updateAppImage,
+	)
+	err = pipeline.Execute(args)
+	if err != nil {
+		return err
+	}
+	removeShadowBackends(a)
+	graceTime := blueGreenGraceTime()
+	bg := blueGreenDeploy{
+		App:           a.GetName(),
+		Image:         imageId,
+		PreviousImage: previousImage,
+		RemoveAfter:   time.Now().UTC().Add(graceTime),
+	}
+	for _, c := range oldContainers {
+		bg.StandbyUnits = append(bg.StandbyUnits, c.ID)
+	}
+	err = saveBlueGreenDeploy(&bg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(evt, "\n---- Keeping %d old %s for %s ----\n", len(oldContainers), pluralize("unit", len(oldContainers)), graceTime)
+	return nil
+}
+
+// BlueGreenRevert sends the traffic back to the units kept after the last
+// blue/green deploy of the app, removing the units running the new image.
+func (p *dockerProvisioner) BlueGreenRevert(a provision.App, evt *event.Event) error {
+	bg, err := findBlueGreenDeploy(a.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	standby := make(map[string]bool, len(bg.StandbyUnits))
+	for _, id := range bg.StandbyUnits {
+		standby[id] = true
+	}
+	var oldContainers, newContainers []container.Container
+	for _, c := range containers {
+		if standby[c.ID] {
+			oldContainers = append(oldContainers, c)
+		} else if c.Image == bg.Image {
+			newContainers = append(newContainers, c)
+		}
+	}
+	if len(oldContainers) == 0 {
+		return errNoUnitsToRevertTo
+	}
+	routers, err := getRoutersForApp(a)
+	if err != nil {
+		return err
+	}
+	oldWebProcess, err := getImageWebProcessName(bg.PreviousImage)
+	if err != nil {
+		log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+	}
+	newWebProcess, err := getImageWebProcessName(bg.Image)
+	if err != nil {
+		log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+	}
+	fmt.Fprintf(evt, "\n---- Reverting blue/green deploy to %d old %s ----\n", len(oldContainers), pluralize("unit", len(oldContainers)))
+	for _, r := range routers {
+		err = r.AddRoutes(a.GetName(), routesForProcess(oldContainers, oldWebProcess))
+		if err != nil {
+			return err
+		}
+		err = r.RemoveRoutes(a.GetName(), routesForProcess(newContainers, newWebProcess))
+		if err != nil {
+			return err
+		}
+	}
+	err = appendAppImageName(a.GetName(), bg.PreviousImage)
+	if err != nil {
+		return err
+	}
+	err = removeBlueGreenDeploy(a.GetName())
+	if err != nil {
+		return err
+	}
+	err = a.SetQuotaInUse(len(oldContainers))
+	if err != nil {
+		log.Errorf("unable to set quota for app %q: %s", a.GetName(), err)
+	}
+	pipeline := action.NewPipeline(
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	err = pipeline.Execute(changeUnitsPipelineArgs{
+		app:         a,
+		toRemove:    newContainers,
+		writer:      evt,
+		provisioner: p,
+		event:       evt,
+	})
+	if err != nil {
+		return err
+	}
+	routesRebuildOrEnqueue(a.GetName())
+	return nil
+}
+
+// finishBlueGreenDeploy removes the units kept after the last blue/green
+// deploy of the app, after which the deploy can no longer be reverted.
+func (p *dockerProvisioner) finishBlueGreenDeploy(a provision.App) error {
+	bg, err := findBlueGreenDeploy(a.GetName())
+	if err != nil {
+		if err == errNoBlueGreenDeploy {
+			return nil
+		}
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	standby := make(map[string]bool, len(bg.StandbyUnits))
+	for _, id := range bg.StandbyUnits {
+		standby[id] = true
+	}
+	var toRemove []container.Container
+	for _, c := range containers {
+		if standby[c.ID] {
+			toRemove = append(toRemove, c)
+		}
+	}
+	pipeline := action.NewPipeline(
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	err = pipeline.Execute(changeUnitsPipelineArgs{
+		app:         a,
+		toRemove:    toRemove,
+		provisioner: p,
+	})
+	if err != nil {
+		return err
+	}
+	return removeBlueGreenDeploy(a.GetName())
+}
+
+func routesForProcess(containers []container.Container, processName string) []*url.URL {
+	var routes []*url.URL
+	for _, c := range containers {
+		if c.ProcessName == processName && c.ValidAddr() {
+			routes = append(routes, c.Address())
+		}
+	}
+	return routes
+}
+
+type blueGreenCleanupTask struct {
+	p *dockerProvisioner
+}
+
+func (t *blueGreenCleanupTask) Name() string {
+	return blueGreenCleanupTaskName
+}
+
+func (t *blueGreenCleanupTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	appName, _ := params["appName"].(string)
+	image, _ := params["image"].(string)
+	if appName == "" || image == "" {
+		job.Error(errors.New("invalid parameters, expected appName and image"))
+		return
+	}
+	bg, err := findBlueGreenDeploy(appName)
+	if err != nil {
+		if err == errNoBlueGreenDeploy {
+			job.Success(nil)
+		} else {
+			job.Error(err)
+		}
+		return
+	}
+	if bg.Image != image {
+		job.Success(nil)
+		return
+	}
+	done, err := t.runOnce(appName)
+	if err != nil {
+		job.Error(err)
+		return
+	}
+	if !done {
+		log.Debugf("app %q is locked, its blue/green cleanup will be retried in %s", appName, blueGreenCleanupRetryTime)
+	}
+	job.Success(nil)
+}
+
+func (t *blueGreenCleanupTask) runOnce(appName string) (bool, error) {
+	locked, err := app.AcquireApplicationLock(appName, app.InternalAppName, "blue-green-cleanup-task")
+	if err != nil || !locked {
+		return false, nil
+	}
+	defer app.ReleaseApplicationLock(appName)
+	a, err := app.GetByName(appName)
+	if err == app.ErrAppNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, t.p.finishBlueGreenDeploy(a)
+}
+
+// registerBlueGreenCleanupTask registers the cleanup task in the queue and
+// starts the routine enqueueing it as blue/green deploys become due.
+func registerBlueGreenCleanupTask(p *dockerProvisioner) error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	err = q.RegisterTask(&blueGreenCleanupTask{p: p})
+	if err != nil {
+		return err
+	}
+	blueGreenCleanupMut.Lock()
+	defer blueGreenCleanupMut.Unlock()
+	if blueGreenCleanupInstance != nil {
+		return nil
+	}
+	blueGreenCleanupInstance = &blueGreenCleanupScheduler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	shutdown.Register(blueGreenCleanupInstance)
+	go blueGreenCleanupInstance.run()
+	return nil
+}
+
+type blueGreenCleanupScheduler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Shutdown stops the blue/green cleanup scheduler, already enqueued cleanups
+// are not affected.
+func (s *blueGreenCleanupScheduler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *blueGreenCleanupScheduler) String() string {
+	return "blue/green cleanup scheduler"
+}
+
+func (s *blueGreenCleanupScheduler) run() {
+	defer close(s.done)
+	for {
+		err := s.runOnce(time.Now().UTC())
+		if err != nil {
+			log.Errorf("unable to schedule blue/green cleanups: %s", err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(blueGreenCleanupInterval):
+		}
+	}
+}
+
+// runOnce enqueues the cleanup of the blue/green deploys due at the given
+// time. RemoveAfter is postponed before enqueueing, using the previous value
+// as a guard, so that concurrent schedulers never enqueue the same cleanup
+// twice and cleanups unable to lock the app are retried later.
+func (s *blueGreenCleanupScheduler) runOnce(now time.Time) error {
+	coll, err := blueGreenColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	var deploys []blueGreenDeploy
+	err = coll.Find(bson.M{"removeafter": bson.M{"$lte": now}}).All(&deploys)
+	if err != nil {
+		return err
+	}
+	var q monsterqueue.Queue
+	for _, bg := range deploys {
+		err = coll.Update(
+			bson.M{"_id": bg.App, "removeafter": bg.RemoveAfter},
+			bson.M{"$set": bson.M{"removeafter": now.Add(blueGreenCleanupRetryTime)}},
+		)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if q == nil {
+			q, err = queue.Queue()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = q.Enqueue(blueGreenCleanupTaskName, monsterqueue.JobParams{
+			"appName": bg.App,
+			"image":   bg.Image,
+		})
+		if err != nil {
+			log.Errorf("unable to enqueue blue/green cleanup of app %q: %s", bg.App, err)
+		}
+	}
+	return nil
+}
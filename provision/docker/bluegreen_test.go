@@ -0,0 +1,302 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/queue"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) newBlueGreenEvent(c *check.C, a *app.App) *event.Event {
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: "app", Value: a.Name},
+		Kind:       permission.PermAppDeploy,
+		Owner:      s.token,
+		CustomData: app.DeployOptions{BlueGreen: true},
+	})
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func (s *S) TestBlueGreenDeploy(c *check.C) {
+	a := s.setupCanaryApp(c, 2)
+	defer s.p.Destroy(a)
+	oldContainers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(oldContainers, check.HasLen, 2)
+	evt := s.newBlueGreenEvent(c, a)
+	err = s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 2,
+		"tsuru/app-otherapp:v2": 2,
+	})
+	for _, cont := range containers {
+		hasRoute := routertest.FakeRouter.HasRoute(a.Name, cont.Address().String())
+		c.Assert(hasRoute, check.Equals, cont.Image == "tsuru/app-otherapp:v2")
+	}
+	c.Assert(routertest.FakeRouter.HasBackend(shadowBackendName(a.Name)), check.Equals, false)
+	units, err := s.p.RoutableUnits(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v2")
+	bg, err := findBlueGreenDeploy(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(bg.Image, check.Equals, "tsuru/app-otherapp:v2")
+	c.Assert(bg.PreviousImage, check.Equals, "tsuru/app-otherapp:v1")
+	c.Assert(bg.StandbyUnits, check.DeepEquals, []string{oldContainers[0].ID, oldContainers[1].ID})
+	c.Assert(bg.RemoveAfter.After(time.Now().UTC()), check.Equals, true)
+}
+
+func (s *S) TestBlueGreenRevert(c *check.C) {
+	a := s.setupCanaryApp(c, 2)
+	defer s.p.Destroy(a)
+	evt := s.newBlueGreenEvent(c, a)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.BlueGreenRevert(a, evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 2,
+	})
+	for _, cont := range containers {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+	}
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v1")
+	_, err = findBlueGreenDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+	err = s.p.BlueGreenRevert(a, evt)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+}
+
+func (s *S) TestFinishBlueGreenDeploy(c *check.C) {
+	a := s.setupCanaryApp(c, 2)
+	defer s.p.Destroy(a)
+	evt := s.newBlueGreenEvent(c, a)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.finishBlueGreenDeploy(a)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v2": 2,
+	})
+	_, err = findBlueGreenDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+	err = s.p.BlueGreenRevert(a, evt)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+}
+
+func (s *S) TestDeployRemovesBlueGreenStandbyUnits(c *check.C) {
+	a := s.setupCanaryApp(c, 2)
+	defer s.p.Destroy(a)
+	evt := s.newBlueGreenEvent(c, a)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	evt, err = event.New(&event.Opts{
+		Target: event.Target{Type: "app", Value: a.Name},
+		Kind:   permission.PermAppDeploy,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	err = s.p.deploy(a, "tsuru/app-otherapp:v1", evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 2,
+	})
+	_, err = findBlueGreenDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+}
+
+func (s *S) TestBlueGreenCleanupTask(c *check.C) {
+	err := s.p.Initialize()
+	c.Assert(err, check.IsNil)
+	config.Set("docker:blue-green:grace-period", 0)
+	defer config.Unset("docker:blue-green:grace-period")
+	a := s.setupCanaryApp(c, 1)
+	defer s.p.Destroy(a)
+	evt := s.newBlueGreenEvent(c, a)
+	err = s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	scheduler := &blueGreenCleanupScheduler{}
+	err = scheduler.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	err = queue.TestingWaitQueueTasks(1, 10*time.Second)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v2": 1,
+	})
+	_, err = findBlueGreenDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoBlueGreenDeploy)
+}
+
+func (s *S) TestBlueGreenCleanupSchedulerRunOnce(c *check.C) {
+	removeAfter := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	err := saveBlueGreenDeploy(&blueGreenDeploy{
+		App:         "myapp",
+		Image:       "tsuru/app-myapp:v2",
+		RemoveAfter: removeAfter,
+	})
+	c.Assert(err, check.IsNil)
+	q, err := queue.Queue()
+	c.Assert(err, check.IsNil)
+	scheduler := &blueGreenCleanupScheduler{}
+	err = scheduler.runOnce(removeAfter.Add(-time.Second))
+	c.Assert(err, check.IsNil)
+	jobs, err := q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+	err = scheduler.runOnce(removeAfter)
+	c.Assert(err, check.IsNil)
+	jobs, err = q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].TaskName(), check.Equals, blueGreenCleanupTaskName)
+	c.Assert(jobs[0].Parameters()["appName"], check.Equals, "myapp")
+	c.Assert(jobs[0].Parameters()["image"], check.Equals, "tsuru/app-myapp:v2")
+	bg, err := findBlueGreenDeploy("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(bg.RemoveAfter.Equal(removeAfter.Add(blueGreenCleanupRetryTime)), check.Equals, true)
+	err = scheduler.runOnce(removeAfter)
+	c.Assert(err, check.IsNil)
+	jobs, err = q.ListJobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+}
+
+func shadowHealthcheckServer(status int, host, path *string) func() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*host = r.Host
+		*path = r.URL.Path
+		w.WriteHeader(status)
+	}))
+	oldClient := shadowHealthcheckClient
+	shadowHealthcheckClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial(network, srv.Listener.Addr().String())
+			},
+		},
+	}
+	return func() {
+		shadowHealthcheckClient = oldClient
+		srv.Close()
+	}
+}
+
+func (s *S) TestVerifyShadowRoutesForward(c *check.C) {
+	var host, path string
+	defer shadowHealthcheckServer(http.StatusOK, &host, &path)()
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	imageName := "tsuru/app-myapp:v2"
+	err := saveImageCustomData(imageName, map[string]interface{}{
+		"healthcheck": map[string]interface{}{"path": "/hc"},
+	})
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend(shadowBackendName(a.GetName()))
+	c.Assert(err, check.IsNil)
+	defer routertest.FakeRouter.RemoveBackend(shadowBackendName(a.GetName()))
+	args := changeUnitsPipelineArgs{app: a, provisioner: s.p, imageId: imageName}
+	conts := []container.Container{{ID: "new-1", AppName: a.GetName()}}
+	ctx := action.FWContext{Previous: conts, Params: []interface{}{args}}
+	result, err := verifyShadowRoutes.Forward(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, conts)
+	c.Assert(host, check.Equals, "myapp-shadow.fakerouter.com")
+	c.Assert(path, check.Equals, "/hc")
+}
+
+func (s *S) TestVerifyShadowRoutesForwardFailure(c *check.C) {
+	var host, path string
+	defer shadowHealthcheckServer(http.StatusInternalServerError, &host, &path)()
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	imageName := "tsuru/app-myapp:v2"
+	err := saveImageCustomData(imageName, map[string]interface{}{
+		"healthcheck": map[string]interface{}{"path": "/hc"},
+	})
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend(shadowBackendName(a.GetName()))
+	c.Assert(err, check.IsNil)
+	defer routertest.FakeRouter.RemoveBackend(shadowBackendName(a.GetName()))
+	args := changeUnitsPipelineArgs{app: a, provisioner: s.p, imageId: imageName}
+	ctx := action.FWContext{Previous: []container.Container{}, Params: []interface{}{args}}
+	_, err = verifyShadowRoutes.Forward(ctx)
+	c.Assert(err, check.ErrorMatches, `healthcheck fail\(myapp-shadow.fakerouter.com\): wrong status code, expected 200, got: 500`)
+}
+
+func (s *S) TestVerifyShadowRoutesForwardWithoutHealthcheck(c *check.C) {
+	var host, path string
+	defer shadowHealthcheckServer(http.StatusOK, &host, &path)()
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	args := changeUnitsPipelineArgs{app: a, provisioner: s.p, imageId: "tsuru/app-myapp:v2"}
+	ctx := action.FWContext{Previous: []container.Container{}, Params: []interface{}{args}}
+	_, err := verifyShadowRoutes.Forward(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "")
+}
+
+func (s *S) TestSwapShadowRoutes(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	shadowName := shadowBackendName(a.GetName())
+	oldAddr, _ := url.Parse("http://10.0.0.1:1234")
+	newAddr, _ := url.Parse("http://10.0.0.2:1234")
+	err := routertest.FakeRouter.AddBackend(a.GetName())
+	c.Assert(err, check.IsNil)
+	defer routertest.FakeRouter.RemoveBackend(a.GetName())
+	err = routertest.FakeRouter.AddRoute(a.GetName(), oldAddr)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend(shadowName)
+	c.Assert(err, check.IsNil)
+	defer routertest.FakeRouter.RemoveBackend(shadowName)
+	err = routertest.FakeRouter.AddRoute(shadowName, newAddr)
+	c.Assert(err, check.IsNil)
+	args := changeUnitsPipelineArgs{app: a, provisioner: s.p, imageId: "tsuru/app-myapp:v2"}
+	conts := []container.Container{{ID: "new-1", AppName: a.GetName(), HostAddr: "10.0.0.2", HostPort: "1234"}}
+	ctx := action.FWContext{Previous: conts, Params: []interface{}{args}}
+	result, err := swapShadowRoutes.Forward(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.([]container.Container)[0].Routable, check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.GetName(), newAddr.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.GetName(), oldAddr.String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(shadowName, oldAddr.String()), check.Equals, true)
+	swapShadowRoutes.Backward(action.BWContext{FWResult: result, Params: []interface{}{args}})
+	c.Assert(routertest.FakeRouter.HasRoute(a.GetName(), oldAddr.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.GetName(), newAddr.String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(shadowName, newAddr.String()), check.Equals, true)
+}
+
+func (s *S) TestBlueGreenGraceTime(c *check.C) {
+	c.Assert(blueGreenGraceTime(), check.Equals, defaultBlueGreenGraceTime)
+	config.Set("docker:blue-green:grace-period", 30)
+	defer config.Unset("docker:blue-green:grace-period")
+	c.Assert(blueGreenGraceTime(), check.Equals, 30*time.Second)
+}
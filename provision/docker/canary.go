@@ -0,0 +1,318 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/db"
+	dbStorage "github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/mgo.v2"
+)
+
+var (
+	errNoCanaryDeploy         = errors.New("no canary deploy in progress for app")
+	errCanaryDeployInProgress = errors.New("there is already a canary deploy in progress for app, promote or abort it first")
+	errCanaryWithoutUnits     = errors.New("canary deploys require the app to have units running")
+)
+
+// canaryDeploy holds the state of a canary deploy waiting to be promoted or
+// aborted.
+type canaryDeploy struct {
+	App           string `bson:"_id"`
+	Image         string
+	PreviousImage string
+	Fraction      float64
+}
+
+func canaryColl() (*dbStorage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_canary", name)), nil
+}
+
+func saveCanaryDeploy(canary *canaryDeploy) error {
+	coll, err := canaryColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(canary.App, canary)
+	return err
+}
+
+func findCanaryDeploy(appName string) (*canaryDeploy, error) {
+	coll, err := canaryColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var canary canaryDeploy
+	err = coll.FindId(appName).One(&canary)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errNoCanaryDeploy
+		}
+		return nil, err
+	}
+	return &canary, nil
+}
+
+func removeCanaryDeploy(appName string) error {
+	coll, err := canaryColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(appName)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// startDeployOptions returns the deploy options stored as start data in the
+// deploy event.
+func startDeployOptions(evt *event.Event) app.DeployOptions {
+	var opts app.DeployOptions
+	if evt != nil {
+		evt.StartData(&opts)
+	}
+	return opts
+}
+
+// canaryFraction returns the fraction of units that should be replaced in a
+// canary deploy, as requested in the deploy options stored in the event. A
+// zero value means a regular deploy.
+func canaryFraction(evt *event.Event) float64 {
+	opts := startDeployOptions(evt)
+	if opts.Canary == nil {
+		return 0
+	}
+	return opts.Canary.Fraction
+}
+
+// canaryContainers chooses, for each process in the new image, which of the
+// old containers will be replaced by canary units.
+func canaryContainers(data ImageMetadata, oldContainers []container.Container, fraction float64) (map[string]*containersToAdd, []container.Container) {
+	byProcess := make(map[string][]container.Container)
+	for _, c := range oldContainers {
+		if _, ok := data.Processes[c.ProcessName]; ok {
+			byProcess[c.ProcessName] = append(byProcess[c.ProcessName], c)
+		}
+	}
+	toAdd := make(map[string]*containersToAdd, len(byProcess))
+	var toRemove []container.Container
+	for processName, containers := range byProcess {
+		quantity := int(math.Ceil(float64(len(containers)) * fraction))
+		if quantity > len(containers) {
+			quantity = len(containers)
+		}
+		toAdd[processName] = &containersToAdd{Quantity: quantity}
+		toRemove = append(toRemove, containers[:quantity]...)
+	}
+	return toAdd, toRemove
+}
+
+func (p *dockerProvisioner) canaryDeploy(a provision.App, imageId string, fraction float64, evt *event.Event) error {
+	_, err := findCanaryDeploy(a.GetName())
+	if err == nil {
+		return errCanaryDeployInProgress
+	}
+	if err != errNoCanaryDeploy {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return errCanaryWithoutUnits
+	}
+	previousImage, err := appCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	imageData, err := getImageCustomData(imageId)
+	if err != nil {
+		return err
+	}
+	toAdd, toRemove := canaryContainers(imageData, containers, fraction)
+	if len(toRemove) == 0 {
+		return errCanaryWithoutUnits
+	}
+	fmt.Fprintf(evt, "\n---- Starting canary deploy: replacing %d of %d %s ----\n", len(toRemove), len(containers), pluralize("unit", len(containers)))
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toAdd:       toAdd,
+		toRemove:    toRemove,
+		writer:      evt,
+		imageId:     imageId,
+		provisioner: p,
+		event:       evt,
+	}
+	// The canary deploy is saved before replacing the units, so canary units
+	// are never left running without a record to promote or abort them. The
+	// pipeline rolls back the units when it fails, the record is removed.
+	err = saveCanaryDeploy(&canaryDeploy{
+		App:           a.GetName(),
+		Image:         imageId,
+		PreviousImage: previousImage,
+		Fraction:      fraction,
+	})
+	if err != nil {
+		return err
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&bindAndHealthcheck,
+		&addNewRoutes,
+		&removeOldRoutes,
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	err = pipeline.Execute(args)
+	if err != nil {
+		if removeErr := removeCanaryDeploy(a.GetName()); removeErr != nil {
+			log.Errorf("unable to remove canary deploy for app %q: %s", a.GetName(), removeErr)
+		}
+		return err
+	}
+	fmt.Fprintf(evt, "\n---- Canary deploy running, promote or abort it to finish the deploy ----\n")
+	return nil
+}
+
+// CanaryPromote replaces the units still running the previous image of the
+// app with units running the image under test in the canary deploy.
+func (p *dockerProvisioner) CanaryPromote(a provision.App, evt *event.Event) error {
+	canary, err := findCanaryDeploy(a.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	var oldContainers []container.Container
+	canaryProcesses := make(map[string]bool)
+	for _, c := range containers {
+		if c.Image == canary.Image {
+			canaryProcesses[c.ProcessName] = true
+			continue
+		}
+		oldContainers = append(oldContainers, c)
+	}
+	imageData, err := getImageCustomData(canary.Image)
+	if err != nil {
+		return err
+	}
+	toAdd := getContainersToAdd(imageData, oldContainers)
+	for processName := range toAdd {
+		if canaryProcesses[processName] && !hasProcess(oldContainers, processName) {
+			delete(toAdd, processName)
+		}
+	}
+	fmt.Fprintf(evt, "\n---- Promoting canary deploy: replacing %d %s ----\n", len(oldContainers), pluralize("unit", len(oldContainers)))
+	if len(toAdd) == 0 {
+		pipeline := action.NewPipeline(&updateAppImage)
+		err = pipeline.Execute(changeUnitsPipelineArgs{
+			app:         a,
+			writer:      evt,
+			imageId:     canary.Image,
+			provisioner: p,
+			event:       evt,
+		})
+	} else {
+		_, err = p.runReplaceUnitsPipeline(evt, a, toAdd, oldContainers, canary.Image)
+	}
+	if err != nil {
+		return err
+	}
+	routesRebuildOrEnqueue(a.GetName())
+	return removeCanaryDeploy(a.GetName())
+}
+
+// CanaryAbort replaces the units running the image under test in the canary
+// deploy with units running the previous image of the app.
+func (p *dockerProvisioner) CanaryAbort(a provision.App, evt *event.Event) error {
+	canary, err := findCanaryDeploy(a.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	var canaryUnits []container.Container
+	toAdd := make(map[string]*containersToAdd)
+	for _, c := range containers {
+		if c.Image != canary.Image {
+			continue
+		}
+		canaryUnits = append(canaryUnits, c)
+		if toAdd[c.ProcessName] == nil {
+			toAdd[c.ProcessName] = &containersToAdd{}
+		}
+		toAdd[c.ProcessName].Quantity++
+	}
+	fmt.Fprintf(evt, "\n---- Aborting canary deploy: replacing %d %s ----\n", len(canaryUnits), pluralize("unit", len(canaryUnits)))
+	if len(canaryUnits) > 0 {
+		_, err = p.runReplaceUnitsPipeline(evt, a, toAdd, canaryUnits, canary.PreviousImage)
+		if err != nil {
+			return err
+		}
+	}
+	routesRebuildOrEnqueue(a.GetName())
+	err = removeCanaryDeploy(a.GetName())
+	if err != nil {
+		return err
+	}
+	p.cleanImage(a.GetName(), canary.Image)
+	return nil
+}
+
+// discardCanaryDeploy forgets about any canary deploy in progress for the
+// app, after a regular deploy replaced all of its units.
+func (p *dockerProvisioner) discardCanaryDeploy(appName, imageId string) {
+	canary, err := findCanaryDeploy(appName)
+	if err != nil {
+		if err != errNoCanaryDeploy {
+			log.Errorf("unable to find canary deploy for app %q: %s", appName, err)
+		}
+		return
+	}
+	err = removeCanaryDeploy(appName)
+	if err != nil {
+		log.Errorf("unable to remove canary deploy for app %q: %s", appName, err)
+		return
+	}
+	if canary.Image != imageId {
+		p.cleanImage(appName, canary.Image)
+	}
+}
+
+func hasProcess(containers []container.Container, processName string) bool {
+	for _, c := range containers {
+		if c.ProcessName == processName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) setupCanaryApp(c *check.C, units uint) *app.App {
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(s.p, "tsuru/app-otherapp:v2", nil)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	a := &app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.p.Provision(a)
+	c.Assert(err, check.IsNil)
+	if units > 0 {
+		_, err = s.p.AddUnits(a, units, "web", nil)
+		c.Assert(err, check.IsNil)
+	}
+	return a
+}
+
+func (s *S) newCanaryEvent(c *check.C, a *app.App, fraction float64) *event.Event {
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: "app", Value: a.Name},
+		Kind:       permission.PermAppDeploy,
+		Owner:      s.token,
+		CustomData: app.DeployOptions{Canary: &app.CanaryOptions{Fraction: fraction}},
+	})
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func countContainersByImage(containers []container.Container) map[string]int {
+	result := make(map[string]int)
+	for _, c := range containers {
+		result[c.Image]++
+	}
+	return result
+}
+
+func (s *S) TestCanaryContainers(c *check.C) {
+	data := ImageMetadata{Processes: map[string]string{"web": "python web.py", "worker": "python worker.py"}}
+	oldContainers := []container.Container{
+		{ID: "1", ProcessName: "web"},
+		{ID: "2", ProcessName: "web"},
+		{ID: "3", ProcessName: "web"},
+		{ID: "4", ProcessName: "web"},
+		{ID: "5", ProcessName: "worker"},
+		{ID: "6", ProcessName: "removed"},
+	}
+	toAdd, toRemove := canaryContainers(data, oldContainers, 0.25)
+	c.Assert(toAdd, check.DeepEquals, map[string]*containersToAdd{
+		"web":    {Quantity: 1},
+		"worker": {Quantity: 1},
+	})
+	c.Assert(toRemove, check.HasLen, 2)
+	toAdd, toRemove = canaryContainers(data, oldContainers, 0.5)
+	c.Assert(toAdd, check.DeepEquals, map[string]*containersToAdd{
+		"web":    {Quantity: 2},
+		"worker": {Quantity: 1},
+	})
+	c.Assert(toRemove, check.HasLen, 3)
+}
+
+func (s *S) TestCanaryFraction(c *check.C) {
+	a := &app.App{Name: "otherapp"}
+	evt := s.newCanaryEvent(c, a, 0.3)
+	c.Assert(canaryFraction(evt), check.Equals, 0.3)
+	evt, err := event.New(&event.Opts{
+		Target: event.Target{Type: "app", Value: "otherapp2"},
+		Kind:   permission.PermAppDeploy,
+		Owner:  s.token,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(canaryFraction(evt), check.Equals, float64(0))
+	c.Assert(canaryFraction(nil), check.Equals, float64(0))
+}
+
+func (s *S) TestCanaryDeploy(c *check.C) {
+	a := s.setupCanaryApp(c, 4)
+	defer s.p.Destroy(a)
+	evt := s.newCanaryEvent(c, a, 0.5)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 2,
+		"tsuru/app-otherapp:v2": 2,
+	})
+	for _, cont := range containers {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+	}
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v1")
+	canary, err := findCanaryDeploy(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(canary, check.DeepEquals, &canaryDeploy{
+		App:           a.Name,
+		Image:         "tsuru/app-otherapp:v2",
+		PreviousImage: "tsuru/app-otherapp:v1",
+		Fraction:      0.5,
+	})
+	evt = s.newCanaryEvent(c, a, 0.5)
+	err = s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.Equals, errCanaryDeployInProgress)
+}
+
+func (s *S) TestCanaryDeployPipelineFailure(c *check.C) {
+	a := s.setupCanaryApp(c, 4)
+	defer s.p.Destroy(a)
+	s.server.PrepareFailure("canary-create-failure", "/containers/create")
+	defer s.server.ResetFailure("canary-create-failure")
+	evt := s.newCanaryEvent(c, a, 0.5)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.NotNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 4,
+	})
+	_, err = findCanaryDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoCanaryDeploy)
+}
+
+func (s *S) TestCanaryDeployWithoutUnits(c *check.C) {
+	a := s.setupCanaryApp(c, 0)
+	defer s.p.Destroy(a)
+	evt := s.newCanaryEvent(c, a, 0.5)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.Equals, errCanaryWithoutUnits)
+}
+
+func (s *S) TestCanaryPromote(c *check.C) {
+	a := s.setupCanaryApp(c, 4)
+	defer s.p.Destroy(a)
+	evt := s.newCanaryEvent(c, a, 0.25)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.CanaryPromote(a, evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v2": 4,
+	})
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v2")
+	_, err = findCanaryDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoCanaryDeploy)
+}
+
+func (s *S) TestCanaryAbort(c *check.C) {
+	a := s.setupCanaryApp(c, 4)
+	defer s.p.Destroy(a)
+	evt := s.newCanaryEvent(c, a, 0.25)
+	err := s.p.deploy(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.CanaryAbort(a, evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(countContainersByImage(containers), check.DeepEquals, map[string]int{
+		"tsuru/app-otherapp:v1": 4,
+	})
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v1")
+	_, err = findCanaryDeploy(a.Name)
+	c.Assert(err, check.Equals, errNoCanaryDeploy)
+}
+
+func (s *S) TestCanaryPromoteWithoutCanary(c *check.C) {
+	a := s.setupCanaryApp(c, 1)
+	defer s.p.Destroy(a)
+	err := s.p.CanaryPromote(a, nil)
+	c.Assert(err, check.Equals, errNoCanaryDeploy)
+	err = s.p.CanaryAbort(a, nil)
+	c.Assert(err, check.Equals, errNoCanaryDeploy)
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/docker/container"
 )
 
@@ -23,11 +24,20 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	path := yamlData.Healthcheck.Path
-	method := yamlData.Healthcheck.Method
-	match := yamlData.Healthcheck.Match
-	status := yamlData.Healthcheck.Status
-	allowedFailures := yamlData.Healthcheck.AllowedFailures
+	baseURL := fmt.Sprintf("http://%s:%s", cont.HostAddr, cont.HostPort)
+	return checkHealthcheck(net.Dial5Full60ClientNoKeepAlive, baseURL, cont.ShortID(), yamlData.Healthcheck, w)
+}
+
+// checkHealthcheck sends the healthcheck request to the given base URL until
+// it succeeds or docker:healthcheck:max-time is reached. The name identifies
+// the checked target in messages.
+func checkHealthcheck(client *http.Client, baseURL, name string, hc provision.TsuruYamlHealthcheck, w io.Writer) error {
+	path := hc.Path
+	method := hc.Method
+	match := hc.Match
+	status := hc.Status
+	allowedFailures := hc.AllowedFailures
+	var err error
 	if path == "" {
 		return nil
 	}
@@ -54,20 +64,20 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 	maxWaitTime = maxWaitTime * int(time.Second)
 	sleepTime := 3 * time.Second
 	startedTime := time.Now()
-	url := fmt.Sprintf("http://%s:%s/%s", cont.HostAddr, cont.HostPort, path)
+	url := fmt.Sprintf("%s/%s", baseURL, path)
 	for {
 		var lastError error = nil
 		req, err := http.NewRequest(method, url, nil)
 		if err != nil {
 			return err
 		}
-		rsp, err := net.Dial5Full60ClientNoKeepAlive.Do(req)
+		rsp, err := client.Do(req)
 		if err != nil {
-			lastError = fmt.Errorf("healthcheck fail(%s): %s", cont.ShortID(), err.Error())
+			lastError = fmt.Errorf("healthcheck fail(%s): %s", name, err.Error())
 		} else {
 			defer rsp.Body.Close()
 			if status != 0 && rsp.StatusCode != status {
-				lastError = fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", cont.ShortID(), status, rsp.StatusCode)
+				lastError = fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", name, status, rsp.StatusCode)
 			} else if matchRE != nil {
 				result, err := ioutil.ReadAll(rsp.Body)
 
@@ -75,7 +85,7 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 					lastError = err
 				}
 				if !matchRE.Match(result) {
-					lastError = fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", cont.ShortID(), match, string(result))
+					lastError = fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", name, match, string(result))
 				}
 			}
 			if lastError != nil {
@@ -86,7 +96,7 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 			}
 		}
 		if lastError == nil {
-			fmt.Fprintf(w, " ---> healthcheck successful(%s)\n", cont.ShortID())
+			fmt.Fprintf(w, " ---> healthcheck successful(%s)\n", name)
 			return nil
 		}
 		if time.Since(startedTime) > time.Duration(maxWaitTime) {
@@ -0,0 +1,155 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+)
+
+var isolatedCancelCheckInterval = 2 * time.Second
+
+// checkValidAppImage ensures the image is in the image history of the app, so
+// that isolated commands, which run with the app environment, can't use
+// arbitrary images.
+func checkValidAppImage(appName, image string) error {
+	validImgs, err := listValidAppImages(appName)
+	if err != nil {
+		return err
+	}
+	for _, img := range validImgs {
+		if img == image {
+			return nil
+		}
+	}
+	return fmt.Errorf("Image %q not found in app", image)
+}
+
+func (p *dockerProvisioner) ExecuteCommandIsolated(opts provision.IsolatedRunOptions) error {
+	if err := checkCanceled(opts.Event); err != nil {
+		return ErrRunCanceled
+	}
+	a := opts.App
+	image := opts.Image
+	if image == "" {
+		var err error
+		image, err = appCurrentImageName(a.GetName())
+		if err != nil {
+			return err
+		}
+	} else if err := checkValidAppImage(a.GetName(), image); err != nil {
+		return err
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = ioutil.Discard
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = ioutil.Discard
+	}
+	host, _ := config.GetString("host")
+	var env []string
+	for _, envData := range a.Envs() {
+		env = append(env, fmt.Sprintf("%s=%s", envData.Name, envData.Value))
+	}
+	env = append(env,
+		fmt.Sprintf("%s=%s", "TSURU_PROCESSNAME", opts.Process),
+		fmt.Sprintf("%s=%s", "TSURU_HOST", host),
+	)
+	createOptions := docker.CreateContainerOptions{
+		Config: &docker.Config{
+			AttachStdout: true,
+			AttachStderr: true,
+			Image:        image,
+			Entrypoint:   []string{"/bin/bash", "-lc"},
+			Cmd:          append([]string{opts.Cmd}, opts.Args...),
+			Env:          env,
+		},
+		HostConfig: &docker.HostConfig{
+			CPUShares:  int64(a.GetCpuShare()),
+			Memory:     a.GetMemory(),
+			MemorySwap: a.GetMemory() + a.GetSwap(),
+		},
+	}
+	cluster := p.Cluster()
+	schedOpts := &container.SchedulerOpts{
+		AppName:       a.GetName(),
+		ActionLimiter: p.ActionLimiter(),
+	}
+	addr, cont, err := cluster.CreateContainerSchedulerOpts(createOptions, schedOpts, net.StreamInactivityTimeout)
+	hostAddr := net.URLToHost(addr)
+	if schedOpts.LimiterDone != nil {
+		schedOpts.LimiterDone()
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		done := p.ActionLimiter().Start(hostAddr)
+		cluster.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID, Force: true})
+		done()
+	}()
+	attachOptions := docker.AttachToContainerOptions{
+		Container:    cont.ID,
+		OutputStream: opts.Stdout,
+		ErrorStream:  opts.Stderr,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+		Success:      make(chan struct{}),
+	}
+	waiter, err := cluster.AttachToContainerNonBlocking(attachOptions)
+	if err != nil {
+		return err
+	}
+	<-attachOptions.Success
+	close(attachOptions.Success)
+	done := p.ActionLimiter().Start(hostAddr)
+	err = cluster.StartContainer(cont.ID, nil)
+	done()
+	if err != nil {
+		return err
+	}
+	result := make(chan error, 1)
+	go func() {
+		waiter.Wait()
+		code, waitErr := cluster.WaitContainer(cont.ID)
+		if waitErr == nil && code != 0 {
+			waitErr = fmt.Errorf("unexpected exit code: %d", code)
+		}
+		result <- waitErr
+	}()
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var cancelCheck <-chan time.Time
+	if opts.Event != nil {
+		ticker := time.NewTicker(isolatedCancelCheckInterval)
+		defer ticker.Stop()
+		cancelCheck = ticker.C
+	}
+	for {
+		select {
+		case err = <-result:
+			return err
+		case <-timeout:
+			return fmt.Errorf("command timed out after %s", opts.Timeout)
+		case <-cancelCheck:
+			if checkCanceled(opts.Event) != nil {
+				fmt.Fprintln(opts.Stderr, "---- Run canceled, removing temporary unit ----")
+				return ErrRunCanceled
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteCommandIsolated(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-myapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	a.SetEnv(bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost"})
+	var createdConfig docker.Config
+	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
+		json.Unmarshal(data, &createdConfig)
+		s.server.DefaultHandler().ServeHTTP(w, r)
+	}))
+	var stdout, stderr bytes.Buffer
+	err = s.p.ExecuteCommandIsolated(provision.IsolatedRunOptions{
+		App:     a,
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+		Cmd:     "python manage.py migrate",
+		Process: "worker",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(createdConfig.Image, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(createdConfig.Entrypoint, check.DeepEquals, []string{"/bin/bash", "-lc"})
+	c.Assert(createdConfig.Cmd, check.DeepEquals, []string{"python manage.py migrate"})
+	c.Assert(createdConfig.Env, check.DeepEquals, []string{
+		"DATABASE_HOST=localhost",
+		"TSURU_PROCESSNAME=worker",
+		"TSURU_HOST=",
+	})
+	containers, err := s.p.Cluster().ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+}
+
+func (s *S) TestExecuteCommandIsolatedWithImage(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-myapp:v2", nil)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("myapp", "tsuru/app-myapp:v2")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	var createdConfig docker.Config
+	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
+		json.Unmarshal(data, &createdConfig)
+		s.server.DefaultHandler().ServeHTTP(w, r)
+	}))
+	err = s.p.ExecuteCommandIsolated(provision.IsolatedRunOptions{
+		App:   a,
+		Cmd:   "ls",
+		Image: "tsuru/app-myapp:v2",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(createdConfig.Image, check.Equals, "tsuru/app-myapp:v2")
+}
+
+func (s *S) TestExecuteCommandIsolatedImageNotInApp(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-other:v1", nil)
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	err = s.p.ExecuteCommandIsolated(provision.IsolatedRunOptions{
+		App:   a,
+		Cmd:   "ls",
+		Image: "tsuru/app-other:v1",
+	})
+	c.Assert(err, check.ErrorMatches, `Image "tsuru/app-other:v1" not found in app`)
+	containers, err := s.p.Cluster().ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+}
+
+func (s *S) TestExecuteCommandIsolatedNoImage(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	err := s.p.ExecuteCommandIsolated(provision.IsolatedRunOptions{App: a, Cmd: "ls"})
+	c.Assert(err, check.Equals, errNoImagesAvailable)
+}
+
+func (s *S) TestExecuteCommandIsolatedCanceled(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-myapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: "app", Value: "myapp"},
+		Kind:       permission.PermAppRun,
+		Owner:      s.token,
+		Cancelable: true,
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.TryCancel("because yes", "majortom@ground.control")
+	c.Assert(err, check.IsNil)
+	err = s.p.ExecuteCommandIsolated(provision.IsolatedRunOptions{App: a, Cmd: "ls", Event: evt})
+	c.Assert(err, check.Equals, ErrRunCanceled)
+	containers, err := s.p.Cluster().ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+}
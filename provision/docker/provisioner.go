@@ -12,6 +12,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/url"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +37,7 @@ import (
 	"github.com/tsuru/tsuru/provision/docker/healer"
 	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
 	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/builtin"
 	_ "github.com/tsuru/tsuru/router/fusis"
 	_ "github.com/tsuru/tsuru/router/galeb"
 	_ "github.com/tsuru/tsuru/router/hipache"
@@ -48,6 +51,7 @@ var (
 
 	ErrEntrypointOrProcfileNotFound = stderr.New("You should provide a entrypoint in image or a Procfile in the following locations: /home/application/current or /app/user or /.")
 	ErrDeployCanceled               = stderr.New("deploy canceled by user action")
+	ErrRunCanceled                  = stderr.New("run canceled by user action")
 )
 
 func init() {
@@ -63,6 +67,86 @@ func getRouterForApp(app provision.App) (router.Router, error) {
 	return router.Get(routerName)
 }
 
+// getCNameRoutersForApp returns the routers of the app supporting cnames. The
+// router of the app plan must support them, additional routers without cname
+// support are ignored.
+func getCNameRoutersForApp(app provision.App) ([]router.CNameRouter, error) {
+	routers, err := getRoutersForApp(app)
+	if err != nil {
+		return nil, err
+	}
+	var cnameRouters []router.CNameRouter
+	for i, r := range routers {
+		cnameRouter, ok := r.(router.CNameRouter)
+		if !ok {
+			if i == 0 {
+				return nil, fmt.Errorf("router %T does not allow cnames", r)
+			}
+			continue
+		}
+		cnameRouters = append(cnameRouters, cnameRouter)
+	}
+	return cnameRouters, nil
+}
+
+// getHealthcheckRoutersForApp returns the routers of the app supporting custom
+// healthchecks.
+func getHealthcheckRoutersForApp(app provision.App) ([]router.CustomHealthcheckRouter, error) {
+	routers, err := getRoutersForApp(app)
+	if err != nil {
+		return nil, err
+	}
+	var hcRouters []router.CustomHealthcheckRouter
+	for _, r := range routers {
+		if hcRouter, ok := r.(router.CustomHealthcheckRouter); ok {
+			hcRouters = append(hcRouters, hcRouter)
+		}
+	}
+	return hcRouters, nil
+}
+
+// checkSameRouters returns an error unless both apps are attached to the same
+// routers, which is required to swap apps with more than one router.
+func checkSameRouters(app1, app2 provision.App) error {
+	routers1, err := app1.GetRouters()
+	if err != nil {
+		return err
+	}
+	routers2, err := app2.GetRouters()
+	if err != nil {
+		return err
+	}
+	if len(routers1) == 1 && len(routers2) == 1 {
+		return nil
+	}
+	sorted1 := append([]string(nil), routers1...)
+	sort.Strings(sorted1)
+	sorted2 := append([]string(nil), routers2...)
+	sort.Strings(sorted2)
+	if !reflect.DeepEqual(sorted1, sorted2) {
+		return fmt.Errorf("swap is only allowed between apps with the same routers. %q uses %v, %q uses %v",
+			app1.GetName(), routers1, app2.GetName(), routers2)
+	}
+	return nil
+}
+
+// getRoutersForApp returns all routers of the app, starting with the router
+// of its plan.
+func getRoutersForApp(app provision.App) ([]router.Router, error) {
+	routerNames, err := app.GetRouters()
+	if err != nil {
+		return nil, err
+	}
+	routers := make([]router.Router, len(routerNames))
+	for i, routerName := range routerNames {
+		routers[i], err = router.Get(routerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return routers, nil
+}
+
 type dockerProvisioner struct {
 	cluster        *cluster.Cluster
 	collectionName string
@@ -283,20 +367,31 @@ func (p *dockerProvisioner) Initialize() error {
 	if err != nil {
 		return err
 	}
+	err = registerBlueGreenCleanupTask(p)
+	if err != nil {
+		return err
+	}
 	return p.initDockerCluster()
 }
 
 // Provision creates a route for the container
 func (p *dockerProvisioner) Provision(app provision.App) error {
-	r, err := getRouterForApp(app)
+	routers, err := getRoutersForApp(app)
 	if err != nil {
 		log.Fatalf("Failed to get router: %s", err)
 		return err
 	}
-	if optsRouter, ok := r.(router.OptsRouter); ok {
-		return optsRouter.AddBackendOpts(app.GetName(), app.GetRouterOpts())
+	for _, r := range routers {
+		if optsRouter, ok := r.(router.OptsRouter); ok {
+			err = optsRouter.AddBackendOpts(app.GetName(), app.GetRouterOpts())
+		} else {
+			err = r.AddBackend(app.GetName())
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return r.AddBackend(app.GetName())
+	return nil
 }
 
 func (p *dockerProvisioner) Restart(a provision.App, process string, w io.Writer) error {
@@ -379,11 +474,15 @@ func (p *dockerProvisioner) Sleep(app provision.App, process string) error {
 }
 
 func (p *dockerProvisioner) Swap(app1, app2 provision.App, cnameOnly bool) error {
-	r, err := getRouterForApp(app1)
+	routers, err := getRoutersForApp(app1)
 	if err != nil {
 		return err
 	}
-	err = r.Swap(app1.GetName(), app2.GetName(), cnameOnly)
+	err = checkSameRouters(app1, app2)
+	if err != nil {
+		return err
+	}
+	err = router.SwapAll(routers, app1.GetName(), app2.GetName(), cnameOnly)
 	if err != nil {
 		routesRebuildOrEnqueue(app1.GetName())
 		routesRebuildOrEnqueue(app2.GetName())
@@ -619,6 +718,12 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, evt *event.E
 	if err := checkCanceled(evt); err != nil {
 		return err
 	}
+	if err := p.finishBlueGreenDeploy(a); err != nil {
+		return err
+	}
+	if fraction := canaryFraction(evt); fraction > 0 {
+		return p.canaryDeploy(a, imageId, fraction, evt)
+	}
 	containers, err := p.listContainersByApp(a.GetName())
 	if err != nil {
 		return err
@@ -641,6 +746,8 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, evt *event.E
 			return err
 		}
 		_, err = p.runCreateUnitsPipeline(evt, a, toAdd, imageId, imageData.ExposedPort)
+	} else if startDeployOptions(evt).BlueGreen {
+		err = p.blueGreenDeploy(a, imageId, containers, evt)
 	} else {
 		toAdd := getContainersToAdd(imageData, containers)
 		if err = setQuota(a, toAdd); err != nil {
@@ -648,6 +755,9 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, evt *event.E
 		}
 		_, err = p.runReplaceUnitsPipeline(evt, a, toAdd, containers, imageId)
 	}
+	if err == nil {
+		p.discardCanaryDeploy(a.GetName(), imageId)
+	}
 	routesRebuildOrEnqueue(a.GetName())
 	return err
 }
@@ -733,16 +843,22 @@ func (p *dockerProvisioner) Destroy(app provision.App) error {
 	if err != nil {
 		log.Errorf("Failed to remove image names from storage for app %s: %s", app.GetName(), err.Error())
 	}
-	r, err := getRouterForApp(app)
+	err = removeBlueGreenDeploy(app.GetName())
 	if err != nil {
-		log.Errorf("Failed to get router: %s", err.Error())
-		return err
+		log.Errorf("Failed to remove blue/green deploy data for app %s: %s", app.GetName(), err.Error())
 	}
-	err = r.RemoveBackend(app.GetName())
+	routers, err := getRoutersForApp(app)
 	if err != nil {
-		log.Errorf("Failed to remove route backend: %s", err.Error())
+		log.Errorf("Failed to get router: %s", err.Error())
 		return err
 	}
+	for _, r := range routers {
+		err = r.RemoveBackend(app.GetName())
+		if err != nil {
+			log.Errorf("Failed to remove route backend: %s", err.Error())
+			return err
+		}
+	}
 	return nil
 }
 
@@ -991,35 +1107,33 @@ func (p *dockerProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provisi
 }
 
 func (p *dockerProvisioner) SetCName(app provision.App, cname string) error {
-	r, err := getRouterForApp(app)
+	routers, err := getCNameRoutersForApp(app)
 	if err != nil {
 		return err
 	}
-	cnameRouter, ok := r.(router.CNameRouter)
-	if !ok {
-		return fmt.Errorf("router %T does not allow cnames", r)
-	}
-	err = cnameRouter.SetCName(cname, app.GetName())
-	if err != nil {
-		routesRebuildOrEnqueue(app.GetName())
+	for _, cnameRouter := range routers {
+		err = cnameRouter.SetCName(cname, app.GetName())
+		if err != nil {
+			routesRebuildOrEnqueue(app.GetName())
+			return err
+		}
 	}
-	return err
+	return nil
 }
 
 func (p *dockerProvisioner) UnsetCName(app provision.App, cname string) error {
-	r, err := getRouterForApp(app)
+	routers, err := getCNameRoutersForApp(app)
 	if err != nil {
 		return err
 	}
-	cnameRouter, ok := r.(router.CNameRouter)
-	if !ok {
-		return fmt.Errorf("router %T does not allow cnames", r)
-	}
-	err = cnameRouter.UnsetCName(cname, app.GetName())
-	if err != nil {
-		routesRebuildOrEnqueue(app.GetName())
+	for _, cnameRouter := range routers {
+		err = cnameRouter.UnsetCName(cname, app.GetName())
+		if err != nil {
+			routesRebuildOrEnqueue(app.GetName())
+			return err
+		}
 	}
-	return err
+	return nil
 }
 
 func (p *dockerProvisioner) AdminCommands() []cmd.Command {
@@ -1176,9 +1290,13 @@ func (p *dockerProvisioner) RoutableUnits(app provision.App) ([]provision.Unit,
 	if err != nil {
 		return nil, err
 	}
+	standby, err := standbyUnits(app.GetName())
+	if err != nil {
+		return nil, err
+	}
 	units := make([]provision.Unit, 0, len(containers))
 	for _, container := range containers {
-		if container.ProcessName == webProcessName && container.ValidAddr() {
+		if container.ProcessName == webProcessName && container.ValidAddr() && !standby[container.ID] {
 			units = append(units, container.AsUnit(app))
 		}
 	}
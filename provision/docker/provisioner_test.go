@@ -38,6 +38,7 @@ import (
 	"github.com/tsuru/tsuru/queue"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/routertest"
 	"github.com/tsuru/tsuru/safe"
 	"gopkg.in/check.v1"
@@ -2074,6 +2075,22 @@ func (s *S) TestProvisionUnsetCName(c *check.C) {
 	c.Assert(routertest.FakeRouter.HasRoute(cname, addr.String()), check.Equals, false)
 }
 
+func (s *S) TestProvisionSetCNameMultipleRouters(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	a.Routers = []string{"fake-hc"}
+	routertest.FakeRouter.AddBackend("myapp")
+	routertest.HCRouter.AddBackend("myapp")
+	cname := "mycname.com"
+	err := s.p.SetCName(a, cname)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasCName(cname), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasCName(cname), check.Equals, true)
+	err = s.p.UnsetCName(a, cname)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasCName(cname), check.Equals, false)
+	c.Assert(routertest.HCRouter.HasCName(cname), check.Equals, false)
+}
+
 func (s *S) TestProvisionerIsCNameManager(c *check.C) {
 	var _ provision.CNameManager = &dockerProvisioner{}
 }
@@ -2132,6 +2149,40 @@ func (s *S) TestSwap(c *check.C) {
 	c.Assert(routertest.FakeRouter.HasRoute(app1.GetName(), addr2.String()), check.Equals, true)
 }
 
+func (s *S) TestSwapMultipleRouters(c *check.C) {
+	app1 := provisiontest.NewFakeApp("app1", "python", 1)
+	app1.Routers = []string{"fake-hc"}
+	app2 := provisiontest.NewFakeApp("app2", "python", 1)
+	app2.Routers = []string{"fake-hc"}
+	addr1, _ := url.Parse("http://127.0.0.1")
+	addr2, _ := url.Parse("http://127.0.0.2")
+	for _, r := range []router.Router{&routertest.FakeRouter, &routertest.HCRouter} {
+		r.AddBackend(app1.GetName())
+		r.AddRoute(app1.GetName(), addr1)
+		r.AddBackend(app2.GetName())
+		r.AddRoute(app2.GetName(), addr2)
+	}
+	err := s.p.Swap(app1, app2, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(app2.GetName(), addr1.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(app1.GetName(), addr2.String()), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasRoute(app2.GetName(), addr1.String()), check.Equals, true)
+	c.Assert(routertest.HCRouter.HasRoute(app1.GetName(), addr2.String()), check.Equals, true)
+}
+
+func (s *S) TestSwapDifferentRouters(c *check.C) {
+	app1 := provisiontest.NewFakeApp("app1", "python", 1)
+	app1.Routers = []string{"fake-hc"}
+	app2 := provisiontest.NewFakeApp("app2", "python", 1)
+	routertest.FakeRouter.AddBackend(app1.GetName())
+	routertest.FakeRouter.AddBackend(app2.GetName())
+	err := s.p.Swap(app1, app2, false)
+	c.Assert(err, check.ErrorMatches, `swap is only allowed between apps with the same routers. "app1" uses \[fake fake-hc\], "app2" uses \[fake\]`)
+	swapped, _, err := router.IsSwapped(app1.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(swapped, check.Equals, false)
+}
+
 func (s *S) TestProvisionerRollbackNoDeployImage(c *check.C) {
 	a := provisiontest.NewFakeApp("otherapp", "python", 1)
 	_, err := s.p.Rollback(a, "inexist", nil)
@@ -17,6 +17,9 @@ type Pool struct {
 	Teams   []string
 	Public  bool
 	Default bool
+	// RequireDeployApproval makes deploys of apps in the pool wait for the
+	// approval of a user other than the one who started them.
+	RequireDeployApproval bool
 }
 
 var (
@@ -27,10 +30,11 @@ var (
 )
 
 type AddPoolOptions struct {
-	Name    string
-	Public  bool
-	Default bool
-	Force   bool
+	Name                  string
+	Public                bool
+	Default               bool
+	Force                 bool
+	RequireDeployApproval bool
 }
 
 func AddPool(opts AddPoolOptions) error {
@@ -48,7 +52,12 @@ func AddPool(opts AddPoolOptions) error {
 			return err
 		}
 	}
-	pool := Pool{Name: opts.Name, Public: opts.Public, Default: opts.Default}
+	pool := Pool{
+		Name:                  opts.Name,
+		Public:                opts.Public,
+		Default:               opts.Default,
+		RequireDeployApproval: opts.RequireDeployApproval,
+	}
 	return conn.Pools().Insert(pool)
 }
 
@@ -64,6 +64,20 @@ func (s *S) TestAddNonPublicPool(c *check.C) {
 	c.Assert(p.Public, check.Equals, false)
 }
 
+func (s *S) TestAddPoolRequiringDeployApproval(c *check.C) {
+	coll := s.storage.Pools()
+	defer coll.RemoveId("pool1")
+	opts := AddPoolOptions{
+		Name:                  "pool1",
+		RequireDeployApproval: true,
+	}
+	err := AddPool(opts)
+	c.Assert(err, check.IsNil)
+	p, err := GetPoolByName("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.RequireDeployApproval, check.Equals, true)
+}
+
 func (s *S) TestAddPublicPool(c *check.C) {
 	coll := s.storage.Pools()
 	defer coll.RemoveId("pool1")
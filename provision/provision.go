@@ -192,6 +192,10 @@ type App interface {
 
 	GetRouter() (string, error)
 
+	// GetRouters returns the names of all routers the app is attached to,
+	// starting with the router returned by GetRouter.
+	GetRouters() ([]string, error)
+
 	GetPool() string
 
 	GetTeamOwner() string
@@ -256,6 +260,51 @@ type ImageDeployer interface {
 	ImageDeploy(app App, image string, evt *event.Event) (string, error)
 }
 
+// CanaryDeployer is a provisioner that is able to deploy a new image to only a
+// fraction of the units of the application, keeping the remaining units in
+// the previous image until the deploy is either promoted or aborted.
+type CanaryDeployer interface {
+	CanaryPromote(app App, evt *event.Event) error
+	CanaryAbort(app App, evt *event.Event) error
+}
+
+// BlueGreenDeployer is a provisioner that is able to deploy a new image by
+// starting a full set of units alongside the current ones, switching the
+// traffic to them only after they pass the healthcheck. Units running the
+// previous image are kept for a while, so the deploy can be reverted.
+type BlueGreenDeployer interface {
+	BlueGreenRevert(app App, evt *event.Event) error
+}
+
+// IsolatedRunOptions is the set of options that can be used when calling the
+// method ExecuteCommandIsolated in the provisioner.
+type IsolatedRunOptions struct {
+	App     App
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Cmd     string
+	Args    []string
+	Process string
+	// Image is the image used to run the command, when empty the current
+	// image of the app is used.
+	Image string
+	// Timeout is the maximum duration of the command, zero means no
+	// timeout.
+	Timeout time.Duration
+	// Event, when set, is checked for cancel requests while the command
+	// runs, the temporary unit is removed as soon as the cancel is
+	// acknowledged.
+	Event *event.Event
+}
+
+// IsolatedRunner is a provisioner that is able to run a command in a new
+// temporary unit, created from the app image and with the app environment,
+// instead of running it in the units serving the app. The temporary unit is
+// removed when the command finishes.
+type IsolatedRunner interface {
+	ExecuteCommandIsolated(opts IsolatedRunOptions) error
+}
+
 // Provisioner is the basic interface of this package.
 //
 // Any tsuru provisioner must implement this interface in order to provision
@@ -385,6 +434,9 @@ type UnitStatusData struct {
 	ID     string
 	Name   string
 	Status Status
+	// Metrics holds resource usage reported by the node agent for the
+	// unit, like "cpu", "memory" and "requests", used by app autoscaling.
+	Metrics map[string]float64
 }
 
 type NodeCheckResult struct {
@@ -56,6 +56,9 @@ type FakeApp struct {
 	UpdatePlatform bool
 	TeamOwner      string
 	Teams          []string
+	// Routers holds the routers attached to the app besides the "fake"
+	// router.
+	Routers []string
 	quota.Quota
 }
 
@@ -329,6 +332,10 @@ func (app *FakeApp) GetRouter() (string, error) {
 	return "fake", nil
 }
 
+func (app *FakeApp) GetRouters() ([]string, error) {
+	return append([]string{"fake"}, app.Routers...), nil
+}
+
 func (app *FakeApp) GetTeamsName() []string {
 	return app.Teams
 }
@@ -338,9 +345,10 @@ func (app *FakeApp) GetTeamOwner() string {
 }
 
 type Cmd struct {
-	Cmd  string
-	Args []string
-	App  provision.App
+	Cmd      string
+	Args     []string
+	App      provision.App
+	Isolated bool
 }
 
 type failure struct {
@@ -925,6 +933,43 @@ func (p *FakeProvisioner) ExecuteCommandOnce(stdout, stderr io.Writer, app provi
 	return nil
 }
 
+// ExecuteCommandIsolated pretends to run the given command in a new unit,
+// recording data about it. Output and failures must be prepared just like in
+// ExecuteCommandOnce.
+func (p *FakeProvisioner) ExecuteCommandIsolated(opts provision.IsolatedRunOptions) error {
+	var output []byte
+	command := Cmd{
+		Cmd:      opts.Cmd,
+		Args:     opts.Args,
+		App:      opts.App,
+		Isolated: true,
+	}
+	p.cmdMut.Lock()
+	p.cmds = append(p.cmds, command)
+	p.cmdMut.Unlock()
+	select {
+	case output = <-p.outputs:
+		if opts.Stdout != nil {
+			opts.Stdout.Write(output)
+		}
+	case fail := <-p.failures:
+		if fail.method == "ExecuteCommandIsolated" {
+			select {
+			case output = <-p.outputs:
+				if opts.Stderr != nil {
+					opts.Stderr.Write(output)
+				}
+			default:
+			}
+			return fail.err
+		}
+		p.failures <- fail
+	case <-time.After(2e9):
+		return errors.New("FakeProvisioner timed out waiting for output.")
+	}
+	return nil
+}
+
 func (p *FakeProvisioner) AddUnit(app provision.App, unit provision.Unit) {
 	p.mut.Lock()
 	defer p.mut.Unlock()
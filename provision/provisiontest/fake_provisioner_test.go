@@ -971,6 +971,29 @@ func (s *S) TestExecuteCommandOnce(c *check.C) {
 	c.Assert(buf.String(), check.Equals, string(output))
 }
 
+func (s *S) TestExecuteCommandIsolated(c *check.C) {
+	var buf bytes.Buffer
+	output := []byte("myoutput!")
+	app := NewFakeApp("grand-designs", "rush", 1)
+	p := NewFakeProvisioner()
+	p.PrepareOutput(output)
+	err := p.ExecuteCommandIsolated(provision.IsolatedRunOptions{App: app, Stdout: &buf, Cmd: "ls", Args: []string{"-l"}})
+	c.Assert(err, check.IsNil)
+	cmds := p.GetCmds("ls", app)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Isolated, check.Equals, true)
+	c.Assert(cmds[0].Args, check.DeepEquals, []string{"-l"})
+	c.Assert(buf.String(), check.Equals, string(output))
+}
+
+func (s *S) TestExecuteCommandIsolatedFailure(c *check.C) {
+	app := NewFakeApp("grand-designs", "rush", 1)
+	p := NewFakeProvisioner()
+	p.PrepareFailure("ExecuteCommandIsolated", errors.New("failed to run"))
+	err := p.ExecuteCommandIsolated(provision.IsolatedRunOptions{App: app, Cmd: "ls"})
+	c.Assert(err, check.ErrorMatches, "failed to run")
+}
+
 func (s *S) TestExtensiblePlatformAdd(c *check.C) {
 	p := ExtensibleFakeProvisioner{FakeProvisioner: NewFakeProvisioner()}
 	args := map[string]string{"dockerfile": "mydockerfile.txt"}
@@ -33,6 +33,7 @@ type baseClient interface {
 	Keys(pattern string) *redis.StringSliceCmd
 	LLen(key string) *redis.IntCmd
 	HMSetMap(key string, fields map[string]string) *redis.StatusCmd
+	HGet(key, field string) *redis.StringCmd
 	Close() error
 }
 
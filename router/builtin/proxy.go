@@ -0,0 +1,302 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultReloadInterval      = 5 * time.Second
+	defaultHealthcheckInterval = 10 * time.Second
+	healthcheckTimeout         = 5 * time.Second
+)
+
+// Proxy is an http.Handler that forwards each request to one of the routes of
+// the backend matching its Host header, either by the backend address or by
+// one of its cnames. Routes are picked in a round-robin fashion, skipping the
+// ones failing the healthcheck of the backend.
+type Proxy struct {
+	routerName          string
+	domain              string
+	reloadInterval      time.Duration
+	healthcheckInterval time.Duration
+	client              *http.Client
+	mut                 sync.RWMutex
+	backends            map[string]*proxyBackend
+	hosts               map[string]*proxyBackend
+	quit                chan struct{}
+}
+
+type proxyBackend struct {
+	healthcheck router.HealthcheckData
+	mut         sync.Mutex
+	routes      []string
+	unhealthy   map[string]bool
+	next        int
+}
+
+// NewProxy returns the proxy for the builtin router with the given name.
+//
+// The routing table is reloaded from the database every
+// "routers:<name>:reload-interval" seconds and routes are checked every
+// "routers:<name>:healthcheck-interval" seconds.
+func NewProxy(routerName string) (*Proxy, error) {
+	kind, prefix, err := router.Type(routerName)
+	if err != nil {
+		return nil, err
+	}
+	if kind != routerType {
+		return nil, fmt.Errorf("router %q is not a builtin router", routerName)
+	}
+	domain, err := config.GetString(prefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		routerName:          routerName,
+		domain:              domain,
+		reloadInterval:      defaultReloadInterval,
+		healthcheckInterval: defaultHealthcheckInterval,
+		client:              &http.Client{Timeout: healthcheckTimeout},
+		backends:            map[string]*proxyBackend{},
+		hosts:               map[string]*proxyBackend{},
+	}
+	if interval, err := config.GetInt(prefix + ":reload-interval"); err == nil {
+		p.reloadInterval = time.Duration(interval) * time.Second
+	}
+	if interval, err := config.GetInt(prefix + ":healthcheck-interval"); err == nil {
+		p.healthcheckInterval = time.Duration(interval) * time.Second
+	}
+	return p, nil
+}
+
+// Start loads the routing table and keeps it updated, along with the health
+// of the routes, until Stop is called.
+func (p *Proxy) Start() error {
+	err := p.Reload()
+	if err != nil {
+		return err
+	}
+	p.CheckHealth()
+	quit := make(chan struct{})
+	p.quit = quit
+	go func() {
+		reload := time.NewTicker(p.reloadInterval)
+		defer reload.Stop()
+		healthcheck := time.NewTicker(p.healthcheckInterval)
+		defer healthcheck.Stop()
+		for {
+			select {
+			case <-reload.C:
+				if err := p.Reload(); err != nil {
+					log.Errorf("[router-proxy] unable to reload routes: %s", err)
+				}
+			case <-healthcheck.C:
+				p.CheckHealth()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops updating the routing table.
+func (p *Proxy) Stop() {
+	if p.quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+}
+
+// Reload reads the backends of the router from the database, replacing the
+// routing table.
+func (p *Proxy) Reload() error {
+	coll, err := collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	var backends []backend
+	err = coll.Find(bson.M{"router": p.routerName}).All(&backends)
+	if err != nil {
+		return err
+	}
+	p.setBackends(backends)
+	return nil
+}
+
+func (p *Proxy) setBackends(backends []backend) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	newBackends := make(map[string]*proxyBackend, len(backends))
+	hosts := map[string]*proxyBackend{}
+	for _, b := range backends {
+		pb := p.backends[b.Name]
+		if pb == nil {
+			pb = &proxyBackend{unhealthy: map[string]bool{}}
+		}
+		pb.update(b)
+		newBackends[b.Name] = pb
+		hosts[b.Name+"."+p.domain] = pb
+		for _, cname := range b.CNames {
+			hosts[cname] = pb
+		}
+	}
+	p.backends = newBackends
+	p.hosts = hosts
+}
+
+// CheckHealth runs the healthcheck of each backend against all its routes.
+// Backends without a healthcheck path consider all their routes healthy.
+func (p *Proxy) CheckHealth() {
+	p.mut.RLock()
+	backends := make([]*proxyBackend, 0, len(p.backends))
+	for _, b := range p.backends {
+		backends = append(backends, b)
+	}
+	p.mut.RUnlock()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		hc, routes := b.snapshot()
+		if hc.Path == "" {
+			b.setHealthy(routes...)
+			continue
+		}
+		for _, route := range routes {
+			wg.Add(1)
+			go func(b *proxyBackend, hc router.HealthcheckData, route string) {
+				defer wg.Done()
+				err := p.checkRoute(hc, route)
+				if err != nil {
+					log.Errorf("[router-proxy] healthcheck failed for route %s: %s", route, err)
+					b.setUnhealthy(route)
+					return
+				}
+				b.setHealthy(route)
+			}(b, hc, route)
+		}
+	}
+	wg.Wait()
+}
+
+func (p *Proxy) checkRoute(hc router.HealthcheckData, route string) error {
+	path := hc.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	resp, err := p.client.Get(fmt.Sprintf("%s://%s%s", router.HttpScheme, route, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	status := hc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("unexpected status code %d, expected %d", resp.StatusCode, status)
+	}
+	if hc.Body != "" {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), hc.Body) {
+			return fmt.Errorf("response body doesn't contain %q", hc.Body)
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	p.mut.RLock()
+	b := p.hosts[host]
+	p.mut.RUnlock()
+	if b == nil {
+		http.Error(w, fmt.Sprintf("no backend found for host %q", host), http.StatusNotFound)
+		return
+	}
+	route := b.nextRoute()
+	if route == "" {
+		http.Error(w, fmt.Sprintf("no healthy routes for host %q", host), http.StatusServiceUnavailable)
+		return
+	}
+	proxy := httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = router.HttpScheme
+			req.URL.Host = route
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (b *proxyBackend) update(data backend) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.healthcheck = data.Healthcheck
+	b.routes = data.Routes
+	unhealthy := map[string]bool{}
+	for _, route := range b.routes {
+		if b.unhealthy[route] {
+			unhealthy[route] = true
+		}
+	}
+	b.unhealthy = unhealthy
+}
+
+func (b *proxyBackend) snapshot() (router.HealthcheckData, []string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	routes := make([]string, len(b.routes))
+	copy(routes, b.routes)
+	return b.healthcheck, routes
+}
+
+func (b *proxyBackend) setHealthy(routes ...string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for _, route := range routes {
+		delete(b.unhealthy, route)
+	}
+}
+
+func (b *proxyBackend) setUnhealthy(route string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.unhealthy[route] = true
+}
+
+// nextRoute returns the next healthy route of the backend, or an empty string
+// if there's none.
+func (b *proxyBackend) nextRoute() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for range b.routes {
+		route := b.routes[b.next%len(b.routes)]
+		b.next = (b.next + 1) % len(b.routes)
+		if !b.unhealthy[route] {
+			return route
+		}
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/check.v1"
+)
+
+func newTestServer(c *check.C, name string) (*httptest.Server, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthcheck" {
+			w.Write([]byte("WORKING"))
+			return
+		}
+		fmt.Fprintf(w, "%s %s", name, r.Host)
+	}))
+	u, err := url.Parse(srv.URL)
+	c.Assert(err, check.IsNil)
+	return srv, u.Host
+}
+
+func doRequest(c *check.C, p *Proxy, host string) *httptest.ResponseRecorder {
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	request.Host = host
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *S) newProxy(c *check.C) *Proxy {
+	p, err := NewProxy("builtin")
+	c.Assert(err, check.IsNil)
+	return p
+}
+
+func (s *S) TestNewProxy(c *check.C) {
+	config.Set("routers:builtin:reload-interval", 2)
+	defer config.Unset("routers:builtin:reload-interval")
+	p := s.newProxy(c)
+	c.Assert(p.domain, check.Equals, "builtin.example.com")
+	c.Assert(p.reloadInterval, check.Equals, 2*time.Second)
+	c.Assert(p.healthcheckInterval, check.Equals, defaultHealthcheckInterval)
+}
+
+func (s *S) TestNewProxyNotBuiltin(c *check.C) {
+	config.Set("routers:other:type", "hipache")
+	defer config.Unset("routers:other")
+	_, err := NewProxy("other")
+	c.Assert(err, check.ErrorMatches, `router "other" is not a builtin router`)
+}
+
+func (s *S) TestProxyRoundRobin(c *check.C) {
+	srv1, host1 := newTestServer(c, "srv1")
+	defer srv1.Close()
+	srv2, host2 := newTestServer(c, "srv2")
+	defer srv2.Close()
+	p := s.newProxy(c)
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{host1, host2}, CNames: []string{"my.host.com"}}})
+	var bodies []string
+	for _, host := range []string{"myapp.builtin.example.com", "my.host.com:8080", "myapp.builtin.example.com"} {
+		recorder := doRequest(c, p, host)
+		c.Assert(recorder.Code, check.Equals, http.StatusOK)
+		bodies = append(bodies, recorder.Body.String())
+	}
+	c.Assert(bodies, check.DeepEquals, []string{
+		"srv1 myapp.builtin.example.com",
+		"srv2 my.host.com:8080",
+		"srv1 myapp.builtin.example.com",
+	})
+}
+
+func (s *S) TestProxyUnknownHost(c *check.C) {
+	p := s.newProxy(c)
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{"127.0.0.1:1"}}})
+	recorder := doRequest(c, p, "otherapp.builtin.example.com")
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestProxyNoRoutes(c *check.C) {
+	p := s.newProxy(c)
+	p.setBackends([]backend{{Name: "myapp"}})
+	recorder := doRequest(c, p, "myapp.builtin.example.com")
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+}
+
+func (s *S) TestProxyCheckHealth(c *check.C) {
+	srv1, host1 := newTestServer(c, "srv1")
+	defer srv1.Close()
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("BROKEN"))
+	}))
+	defer srv2.Close()
+	u, err := url.Parse(srv2.URL)
+	c.Assert(err, check.IsNil)
+	host2 := u.Host
+	p := s.newProxy(c)
+	hc := router.HealthcheckData{Path: "/healthcheck", Status: 200, Body: "WORKING"}
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{host1, host2}, Healthcheck: hc}})
+	p.CheckHealth()
+	for i := 0; i < 3; i++ {
+		recorder := doRequest(c, p, "myapp.builtin.example.com")
+		c.Assert(recorder.Code, check.Equals, http.StatusOK)
+		c.Assert(recorder.Body.String(), check.Equals, "srv1 myapp.builtin.example.com")
+	}
+	srv1.Close()
+	p.CheckHealth()
+	recorder := doRequest(c, p, "myapp.builtin.example.com")
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{host1, host2}}})
+	p.CheckHealth()
+	c.Assert(p.backends["myapp"].unhealthy, check.HasLen, 0)
+}
+
+func (s *S) TestProxySetBackendsKeepsHealth(c *check.C) {
+	p := s.newProxy(c)
+	hc := router.HealthcheckData{Path: "/healthcheck"}
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{"10.0.0.1:80", "10.0.0.2:80"}, Healthcheck: hc}})
+	p.backends["myapp"].setUnhealthy("10.0.0.1:80")
+	p.setBackends([]backend{{Name: "myapp", Routes: []string{"10.0.0.1:80", "10.0.0.3:80"}, Healthcheck: hc}})
+	c.Assert(p.backends["myapp"].unhealthy, check.DeepEquals, map[string]bool{"10.0.0.1:80": true})
+	c.Assert(p.backends["myapp"].nextRoute(), check.Equals, "10.0.0.3:80")
+}
@@ -0,0 +1,297 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package builtin provides a router implementation that stores backends,
+// routes and cnames in the tsuru database, along with the reverse proxy that
+// serves them. It doesn't depend on any external service, which makes it
+// suitable for small installations and development environments.
+//
+// In order to use this router, you need to define the "routers:<name>:type =
+// builtin" in your config and run the proxy with "tsurud router-proxy".
+package builtin
+
+import (
+	"net/url"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const routerType = "builtin"
+
+func init() {
+	router.Register(routerType, createRouter)
+}
+
+type builtinRouter struct {
+	routerName string
+	prefix     string
+}
+
+// backend is the document stored for each backend. Routes are stored as
+// host:port pairs, as the proxy always talks HTTP to the units.
+type backend struct {
+	Router      string
+	Name        string
+	Routes      []string
+	CNames      []string
+	Healthcheck router.HealthcheckData
+}
+
+func createRouter(routerName, configPrefix string) (router.Router, error) {
+	return &builtinRouter{routerName: routerName, prefix: configPrefix}, nil
+}
+
+func collection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	coll := conn.Collection("builtin_router")
+	coll.EnsureIndex(mgo.Index{Key: []string{"router", "name"}, Unique: true})
+	coll.EnsureIndex(mgo.Index{Key: []string{"router", "cnames"}})
+	return coll, nil
+}
+
+func (r *builtinRouter) domain() (string, error) {
+	return config.GetString(r.prefix + ":domain")
+}
+
+func (r *builtinRouter) getBackend(name string) (*backend, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := collection()
+	if err != nil {
+		return nil, &router.RouterError{Op: "get", Err: err}
+	}
+	defer coll.Close()
+	var b backend
+	err = coll.Find(bson.M{"router": r.routerName, "name": backendName}).One(&b)
+	if err == mgo.ErrNotFound {
+		return nil, router.ErrBackendNotFound
+	}
+	if err != nil {
+		return nil, &router.RouterError{Op: "get", Err: err}
+	}
+	return &b, nil
+}
+
+func (r *builtinRouter) updateBackend(op, name string, update bson.M) error {
+	coll, err := collection()
+	if err != nil {
+		return &router.RouterError{Op: op, Err: err}
+	}
+	defer coll.Close()
+	err = coll.Update(bson.M{"router": r.routerName, "name": name}, update)
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: op, Err: err}
+	}
+	return nil
+}
+
+func (r *builtinRouter) AddBackend(name string) error {
+	coll, err := collection()
+	if err != nil {
+		return &router.RouterError{Op: "add", Err: err}
+	}
+	defer coll.Close()
+	err = coll.Insert(backend{
+		Router: r.routerName,
+		Name:   name,
+		Routes: []string{},
+		CNames: []string{},
+	})
+	if mgo.IsDup(err) {
+		return router.ErrBackendExists
+	}
+	if err != nil {
+		return &router.RouterError{Op: "add", Err: err}
+	}
+	return router.Store(name, name, routerType)
+}
+
+func (r *builtinRouter) RemoveBackend(name string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if backendName != name {
+		return router.ErrBackendSwapped
+	}
+	coll, err := collection()
+	if err != nil {
+		return &router.RouterError{Op: "remove", Err: err}
+	}
+	defer coll.Close()
+	err = coll.Remove(bson.M{"router": r.routerName, "name": backendName})
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "remove", Err: err}
+	}
+	return router.Remove(name)
+}
+
+func (r *builtinRouter) AddRoute(name string, address *url.URL) error {
+	b, err := r.getBackend(name)
+	if err != nil {
+		return err
+	}
+	for _, route := range b.Routes {
+		if route == address.Host {
+			return router.ErrRouteExists
+		}
+	}
+	return r.updateBackend("add", b.Name, bson.M{"$addToSet": bson.M{"routes": address.Host}})
+}
+
+func (r *builtinRouter) AddRoutes(name string, addresses []*url.URL) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	hosts := make([]string, len(addresses))
+	for i, address := range addresses {
+		hosts[i] = address.Host
+	}
+	return r.updateBackend("add", backendName, bson.M{"$addToSet": bson.M{"routes": bson.M{"$each": hosts}}})
+}
+
+func (r *builtinRouter) RemoveRoute(name string, address *url.URL) error {
+	b, err := r.getBackend(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, route := range b.Routes {
+		if route == address.Host {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrRouteNotFound
+	}
+	return r.updateBackend("remove", b.Name, bson.M{"$pull": bson.M{"routes": address.Host}})
+}
+
+func (r *builtinRouter) RemoveRoutes(name string, addresses []*url.URL) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	hosts := make([]string, len(addresses))
+	for i, address := range addresses {
+		hosts[i] = address.Host
+	}
+	return r.updateBackend("remove", backendName, bson.M{"$pullAll": bson.M{"routes": hosts}})
+}
+
+func (r *builtinRouter) Addr(name string) (string, error) {
+	b, err := r.getBackend(name)
+	if err != nil {
+		return "", err
+	}
+	domain, err := r.domain()
+	if err != nil {
+		return "", &router.RouterError{Op: "get", Err: err}
+	}
+	return b.Name + "." + domain, nil
+}
+
+func (r *builtinRouter) Routes(name string) ([]*url.URL, error) {
+	b, err := r.getBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*url.URL, len(b.Routes))
+	for i, route := range b.Routes {
+		result[i] = &url.URL{Scheme: router.HttpScheme, Host: route}
+	}
+	return result, nil
+}
+
+func (r *builtinRouter) Swap(backend1, backend2 string, cnameOnly bool) error {
+	return router.Swap(r, backend1, backend2, cnameOnly)
+}
+
+func (r *builtinRouter) SetCName(cname, name string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	domain, err := r.domain()
+	if err != nil {
+		return &router.RouterError{Op: "setCName", Err: err}
+	}
+	if !router.ValidCName(cname, domain) {
+		return router.ErrCNameNotAllowed
+	}
+	coll, err := collection()
+	if err != nil {
+		return &router.RouterError{Op: "setCName", Err: err}
+	}
+	defer coll.Close()
+	n, err := coll.Find(bson.M{"router": r.routerName, "cnames": cname}).Count()
+	if err != nil {
+		return &router.RouterError{Op: "setCName", Err: err}
+	}
+	if n > 0 {
+		return router.ErrCNameExists
+	}
+	return r.updateBackend("setCName", backendName, bson.M{"$addToSet": bson.M{"cnames": cname}})
+}
+
+func (r *builtinRouter) UnsetCName(cname, name string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	coll, err := collection()
+	if err != nil {
+		return &router.RouterError{Op: "unsetCName", Err: err}
+	}
+	defer coll.Close()
+	err = coll.Update(
+		bson.M{"router": r.routerName, "name": backendName, "cnames": cname},
+		bson.M{"$pull": bson.M{"cnames": cname}},
+	)
+	if err == mgo.ErrNotFound {
+		return router.ErrCNameNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "unsetCName", Err: err}
+	}
+	return nil
+}
+
+func (r *builtinRouter) CNames(name string) ([]*url.URL, error) {
+	b, err := r.getBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*url.URL, len(b.CNames))
+	for i, cname := range b.CNames {
+		result[i] = &url.URL{Host: cname}
+	}
+	return result, nil
+}
+
+func (r *builtinRouter) SetHealthcheck(name string, data router.HealthcheckData) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	return r.updateBackend("setHealthcheck", backendName, bson.M{"$set": bson.M{"healthcheck": data}})
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builtin
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	conn *db.Storage
+}
+
+var _ = check.Suite(&S{})
+
+func init() {
+	base := &S{}
+	suite := &routertest.RouterSuite{
+		SetUpSuiteFunc:   base.SetUpSuite,
+		TearDownTestFunc: base.TearDownTest,
+	}
+	suite.SetUpTestFunc = func(c *check.C) {
+		config.Set("database:name", "router_generic_builtin_tests")
+		base.SetUpTest(c)
+		r, err := router.Get("builtin")
+		c.Assert(err, check.IsNil)
+		suite.Router = r
+	}
+	check.Suite(suite)
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("routers:builtin:type", "builtin")
+	config.Set("routers:builtin:domain", "builtin.example.com")
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_builtin_tests")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+	dbtest.ClearAllCollections(s.conn.Collection("builtin_router").Database)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.conn.Close()
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Collection("builtin_router").Database.DropDatabase()
+}
+
+func (s *S) TestAddBackendStoresDocument(c *check.C) {
+	r, err := router.Get("builtin")
+	c.Assert(err, check.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("myapp")
+	var b backend
+	err = s.conn.Collection("builtin_router").Find(bson.M{"router": "builtin", "name": "myapp"}).One(&b)
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Routes, check.DeepEquals, []string{})
+	c.Assert(b.CNames, check.DeepEquals, []string{})
+}
+
+func (s *S) TestRoutesAreStoredByHost(c *check.C) {
+	r, err := router.Get("builtin")
+	c.Assert(err, check.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("myapp")
+	addr, _ := url.Parse("http://10.10.10.10:8080")
+	err = r.AddRoute("myapp", addr)
+	c.Assert(err, check.IsNil)
+	tcpAddr, _ := url.Parse("tcp://10.10.10.10:8080")
+	err = r.AddRoute("myapp", tcpAddr)
+	c.Assert(err, check.Equals, router.ErrRouteExists)
+	routes, err := r.Routes("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []*url.URL{{Scheme: "http", Host: "10.10.10.10:8080"}})
+}
+
+func (s *S) TestSetCNameUsedByOtherBackend(c *check.C) {
+	r, err := router.Get("builtin")
+	c.Assert(err, check.IsNil)
+	cnameRouter := r.(router.CNameRouter)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("myapp")
+	err = r.AddBackend("otherapp")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("otherapp")
+	err = cnameRouter.SetCName("my.host.com", "myapp")
+	c.Assert(err, check.IsNil)
+	err = cnameRouter.SetCName("my.host.com", "otherapp")
+	c.Assert(err, check.Equals, router.ErrCNameExists)
+}
+
+func (s *S) TestSetHealthcheckStoresData(c *check.C) {
+	r, err := router.Get("builtin")
+	c.Assert(err, check.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("myapp")
+	data := router.HealthcheckData{Path: "/healthcheck", Status: 200, Body: "WORKING"}
+	err = r.(router.CustomHealthcheckRouter).SetHealthcheck("myapp", data)
+	c.Assert(err, check.IsNil)
+	var b backend
+	err = s.conn.Collection("builtin_router").Find(bson.M{"router": "builtin", "name": "myapp"}).One(&b)
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Healthcheck, check.DeepEquals, data)
+}
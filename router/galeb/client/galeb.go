@@ -187,6 +187,24 @@ func (c *GalebClient) UpdatePoolProperties(poolName string, properties BackendPo
 	return c.waitStatusOK(poolID)
 }
 
+// UpdateTargetProperties changes the properties of a target, such as its
+// weight in the balancing of the backend pool.
+func (c *GalebClient) UpdateTargetProperties(targetID string, properties TargetProperties) error {
+	path := strings.TrimPrefix(targetID, c.ApiUrl)
+	params := struct {
+		Properties TargetProperties `json:"properties"`
+	}{Properties: properties}
+	rsp, err := c.doRequest("PATCH", path, params)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusNoContent {
+		responseData, _ := ioutil.ReadAll(rsp.Body)
+		return fmt.Errorf("PATCH %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	return c.waitStatusOK(targetID)
+}
+
 func (c *GalebClient) AddBackend(backend *url.URL, poolName string) (string, error) {
 	var params Target
 	c.fillDefaultTargetValues(&params)
@@ -7,6 +7,7 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -161,6 +162,31 @@ func (s *S) TestGalebAddBackend(c *check.C) {
 	c.Assert(fullId, check.Equals, fmt.Sprintf("%s/target/10", s.client.ApiUrl))
 }
 
+func (s *S) TestGalebUpdateTargetProperties(c *check.C) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/api/target/10")
+		if r.Method == "PATCH" {
+			body, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"_status": "OK"}`))
+	}))
+	defer server.Close()
+	s.client.ApiUrl = server.URL + "/api"
+	err := s.client.UpdateTargetProperties(s.client.ApiUrl+"/target/10", TargetProperties{Weight: 3})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(body), check.Equals, `{"properties":{"weight":3}}`+"\n")
+}
+
+func (s *S) TestGalebUpdateTargetPropertiesInvalidStatusCode(c *check.C) {
+	s.handler.RspCode = http.StatusOK
+	s.handler.Content = "invalid content"
+	err := s.client.UpdateTargetProperties(s.client.ApiUrl+"/target/10", TargetProperties{Weight: 3})
+	c.Assert(err, check.ErrorMatches, "PATCH /target/10: invalid response code: 200: invalid content")
+}
+
 func (s *S) TestGalebAddVirtualHost(c *check.C) {
 	s.handler.ConditionalContent["/api/virtualhost/999"] = []string{
 		"200", `{"_status": "OK"}`,
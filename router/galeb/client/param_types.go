@@ -39,11 +39,16 @@ type BackendPoolProperties struct {
 	HcStatusCode string `json:"hcStatusCode"`
 }
 
+type TargetProperties struct {
+	Weight int `json:"weight,omitempty"`
+}
+
 type Target struct {
 	commonPostResponse
-	Project     string `json:"project"`
-	Environment string `json:"environment"`
-	BackendPool string `json:"parent,omitempty"`
+	Project     string           `json:"project"`
+	Environment string           `json:"environment"`
+	BackendPool string           `json:"parent,omitempty"`
+	Properties  TargetProperties `json:"properties,omitempty"`
 }
 
 type Pool struct {
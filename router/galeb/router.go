@@ -186,6 +186,55 @@ func (r *galebRouter) RemoveRoutes(name string, addresses []*url.URL) error {
 	return r.client.RemoveBackendsByIDs(ids)
 }
 
+func (r *galebRouter) findTarget(backendName string, address *url.URL) (*galebClient.Target, error) {
+	targets, err := r.client.FindTargetsByParent(r.poolName(backendName))
+	if err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		parsedAddr, err := url.Parse(targets[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		if parsedAddr.Host == address.Host {
+			return &targets[i], nil
+		}
+	}
+	return nil, router.ErrRouteNotFound
+}
+
+// SetRouteWeight sets the weight property of the target of the route, which is
+// honored by weighted balance policies of the backend pool.
+func (r *galebRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	if weight < 1 {
+		return router.ErrInvalidRouteWeight
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	target, err := r.findTarget(backendName, address)
+	if err != nil {
+		return err
+	}
+	return r.client.UpdateTargetProperties(target.FullId(), galebClient.TargetProperties{Weight: weight})
+}
+
+func (r *galebRouter) RouteWeight(name string, address *url.URL) (int, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return 0, err
+	}
+	target, err := r.findTarget(backendName, address)
+	if err != nil {
+		return 0, err
+	}
+	if target.Properties.Weight == 0 {
+		return 1, nil
+	}
+	return target.Properties.Weight, nil
+}
+
 func (r *galebRouter) CNames(name string) ([]*url.URL, error) {
 	backendName, err := router.Retrieve(name)
 	if err != nil {
@@ -518,16 +518,134 @@ func (r *hipacheRouter) Routes(name string) ([]*url.URL, error) {
 		return nil, router.ErrBackendNotFound
 	}
 	routes = routes[1:]
-	result := make([]*url.URL, len(routes))
-	for i, route := range routes {
-		result[i], err = url.Parse(route)
+	result := make([]*url.URL, 0, len(routes))
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		// Weighted routes are repeated in the frontend, but only listed
+		// once.
+		if seen[route] {
+			continue
+		}
+		seen[route] = true
+		u, err := url.Parse(route)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, u)
 	}
 	return result, nil
 }
 
+// SetRouteWeight sets the weight of a route by repeating it in the frontend
+// of the backend and in the frontends of its cnames, as Hipache picks the
+// route of each request randomly.
+func (r *hipacheRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	if weight < 1 {
+		return router.ErrInvalidRouteWeight
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return &router.RouterError{Op: "setRouteWeight", Err: err}
+	}
+	u := *address
+	u.Scheme = router.HttpScheme
+	route := u.String()
+	frontend := "frontend:" + backendName + "." + domain
+	current, err := r.countRoute(frontend, route)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return router.ErrRouteNotFound
+	}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return err
+	}
+	frontends := []string{frontend}
+	for _, cname := range cnames {
+		frontends = append(frontends, "frontend:"+cname)
+	}
+	for _, f := range frontends {
+		err = r.setRouteCount(f, route, weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *hipacheRouter) RouteWeight(name string, address *url.URL) (int, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return 0, err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return 0, &router.RouterError{Op: "routeWeight", Err: err}
+	}
+	u := *address
+	u.Scheme = router.HttpScheme
+	frontend := "frontend:" + backendName + "." + domain
+	count, err := r.countRoute(frontend, u.String())
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, router.ErrRouteNotFound
+	}
+	return count, nil
+}
+
+func (r *hipacheRouter) countRoute(frontend, address string) (int, error) {
+	conn, err := r.connect()
+	if err != nil {
+		return 0, &router.RouterError{Op: "routeWeight", Err: err}
+	}
+	routes, err := conn.LRange(frontend, 1, -1).Result()
+	if err != nil {
+		return 0, &router.RouterError{Op: "routeWeight", Err: err}
+	}
+	count := 0
+	for _, route := range routes {
+		if route == address {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *hipacheRouter) setRouteCount(frontend, address string, count int) error {
+	current, err := r.countRoute(frontend, address)
+	if err != nil {
+		return err
+	}
+	if current == 0 || current == count {
+		return nil
+	}
+	conn, err := r.connect()
+	if err != nil {
+		return &router.RouterError{Op: "setRouteWeight", Err: err}
+	}
+	if current > count {
+		err = conn.LRem(frontend, int64(current-count), address).Err()
+	} else {
+		toAdd := make([]string, count-current)
+		for i := range toAdd {
+			toAdd[i] = address
+		}
+		err = conn.RPush(frontend, toAdd...).Err()
+	}
+	if err != nil {
+		return &router.RouterError{Op: "setRouteWeight", Err: err}
+	}
+	return nil
+}
+
 func (r *hipacheRouter) removeElement(name, address string) (int, error) {
 	conn, err := r.connect()
 	if err != nil {
@@ -593,3 +711,48 @@ func (r *hipacheRouter) SetHealthcheck(name string, data router.HealthcheckData)
 	}
 	return nil
 }
+
+func (r *hipacheRouter) AddCertificate(cname, certificate, key string) error {
+	conn, err := r.connect()
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	err = conn.HMSetMap("tls:"+cname, map[string]string{
+		"certificate": certificate,
+		"key":         key,
+	}).Err()
+	if err != nil {
+		return &router.RouterError{Op: "addCertificate", Err: err}
+	}
+	return nil
+}
+
+func (r *hipacheRouter) RemoveCertificate(cname string) error {
+	conn, err := r.connect()
+	if err != nil {
+		return &router.RouterError{Op: "removeCertificate", Err: err}
+	}
+	removed, err := conn.Del("tls:" + cname).Result()
+	if err != nil {
+		return &router.RouterError{Op: "removeCertificate", Err: err}
+	}
+	if removed == 0 {
+		return router.ErrCertificateNotFound
+	}
+	return nil
+}
+
+func (r *hipacheRouter) GetCertificate(cname string) (string, error) {
+	conn, err := r.connect()
+	if err != nil {
+		return "", &router.RouterError{Op: "getCertificate", Err: err}
+	}
+	certificate, err := conn.HGet("tls:"+cname, "certificate").Result()
+	if err == redis.Nil {
+		return "", router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return "", &router.RouterError{Op: "getCertificate", Err: err}
+	}
+	return certificate, nil
+}
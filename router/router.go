@@ -32,6 +32,9 @@ var (
 	ErrCNameExists     = errors.New("CName already exists")
 	ErrCNameNotFound   = errors.New("CName not found")
 	ErrCNameNotAllowed = errors.New("CName as router subdomain not allowed")
+
+	ErrCertificateNotFound = errors.New("Certificate not found")
+	ErrInvalidRouteWeight  = errors.New("Route weight must be greater than zero")
 )
 
 const HttpScheme = "http"
@@ -99,6 +102,23 @@ type CNameRouter interface {
 	CNames(name string) ([]*url.URL, error)
 }
 
+// TLSRouter is implemented by routers able to serve CNames over TLS. The
+// certificate and key are PEM encoded.
+type TLSRouter interface {
+	AddCertificate(cname, certificate, key string) error
+	RemoveCertificate(cname string) error
+	GetCertificate(cname string) (string, error)
+}
+
+// WeightedRouter is implemented by routers able to balance the requests to a
+// backend according to the weight of each route. Routes are added with weight
+// 1, so a route with weight 3 receives three times the requests of a route
+// with the default weight.
+type WeightedRouter interface {
+	SetRouteWeight(name string, address *url.URL, weight int) error
+	RouteWeight(name string, address *url.URL) (int, error)
+}
+
 type MessageRouter interface {
 	StartupMessage() (string, error)
 }
@@ -204,13 +224,16 @@ func swapBackendName(backend1, backend2 string) error {
 	if err != nil {
 		return err
 	}
+	// An app attached to multiple routers has one entry for each router, all
+	// of them pointing to the same backend name.
 	update := bson.M{"$set": bson.M{"router": router2}}
-	err = coll.Update(bson.M{"app": backend1}, update)
+	_, err = coll.UpdateAll(bson.M{"app": backend1}, update)
 	if err != nil {
 		return err
 	}
 	update = bson.M{"$set": bson.M{"router": router1}}
-	return coll.Update(bson.M{"app": backend2}, update)
+	_, err = coll.UpdateAll(bson.M{"app": backend2}, update)
+	return err
 }
 
 func swapCnames(r Router, backend1, backend2 string) error {
@@ -249,7 +272,54 @@ func swapCnames(r Router, backend1, backend2 string) error {
 	return nil
 }
 
+func routeWeights(r Router, name string, routes []*url.URL) (map[string]int, error) {
+	weightedRouter, ok := r.(WeightedRouter)
+	if !ok {
+		return nil, nil
+	}
+	weights := make(map[string]int)
+	for _, route := range routes {
+		weight, err := weightedRouter.RouteWeight(name, route)
+		if err != nil {
+			return nil, err
+		}
+		if weight != 1 {
+			weights[route.Host] = weight
+		}
+	}
+	return weights, nil
+}
+
+func setRouteWeights(r Router, name string, routes []*url.URL, weights map[string]int) error {
+	weightedRouter, ok := r.(WeightedRouter)
+	if !ok {
+		return nil
+	}
+	for _, route := range routes {
+		if weight, ok := weights[route.Host]; ok {
+			err := weightedRouter.SetRouteWeight(name, route, weight)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func swapBackends(r Router, backend1, backend2 string) error {
+	err := swapRoutes(r, backend1, backend2)
+	if err != nil {
+		return err
+	}
+	return swapBackendName(backend1, backend2)
+}
+
+// swapRoutes swaps the routes of the backends in the router. Routers can't
+// replace the routes of a backend at once, so the new routes are added before
+// the old ones are removed, and for a moment each backend sends requests to
+// both sets of routes. When any step fails, the previous routes of both
+// backends are restored.
+func swapRoutes(r Router, backend1, backend2 string) (err error) {
 	routes1, err := r.Routes(backend1)
 	if err != nil {
 		return err
@@ -258,6 +328,20 @@ func swapBackends(r Router, backend1, backend2 string) error {
 	if err != nil {
 		return err
 	}
+	weights1, err := routeWeights(r, backend1, routes1)
+	if err != nil {
+		return err
+	}
+	weights2, err := routeWeights(r, backend2, routes2)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			restoreRoutes(r, backend1, routes1, routes2, weights1)
+			restoreRoutes(r, backend2, routes2, routes1, weights2)
+		}
+	}()
 	err = r.AddRoutes(backend1, routes2)
 	if err != nil {
 		return err
@@ -266,19 +350,52 @@ func swapBackends(r Router, backend1, backend2 string) error {
 	if err != nil {
 		return err
 	}
-	err = r.RemoveRoutes(backend1, routes1)
+	err = setRouteWeights(r, backend1, routes2, weights2)
 	if err != nil {
 		return err
 	}
-	err = r.RemoveRoutes(backend2, routes2)
+	err = setRouteWeights(r, backend2, routes1, weights1)
 	if err != nil {
 		return err
 	}
-	return swapBackendName(backend1, backend2)
+	err = r.RemoveRoutes(backend1, diffRoutes(routes1, routes2))
+	if err != nil {
+		return err
+	}
+	return r.RemoveRoutes(backend2, diffRoutes(routes2, routes1))
+}
 
+// restoreRoutes removes the routes added to the backend during a failed swap
+// and adds back its previous routes.
+func restoreRoutes(r Router, name string, previous, added []*url.URL, weights map[string]int) {
+	err := r.RemoveRoutes(name, diffRoutes(added, previous))
+	if err == nil {
+		err = r.AddRoutes(name, previous)
+	}
+	if err == nil {
+		err = setRouteWeights(r, name, previous, weights)
+	}
+	if err != nil {
+		log.Errorf("unable to restore routes of %s in router %T: %s", name, r, err)
+	}
 }
 
-func Swap(r Router, backend1, backend2 string, cnameOnly bool) error {
+// diffRoutes returns the routes in a whose host isn't in b.
+func diffRoutes(a, b []*url.URL) []*url.URL {
+	hosts := make(map[string]bool, len(b))
+	for _, route := range b {
+		hosts[route.Host] = true
+	}
+	var diff []*url.URL
+	for _, route := range a {
+		if !hosts[route.Host] {
+			diff = append(diff, route)
+		}
+	}
+	return diff
+}
+
+func checkSwapKind(backend1, backend2 string) error {
 	data1, err := retrieveRouterData(backend1)
 	if err != nil {
 		return err
@@ -291,12 +408,75 @@ func Swap(r Router, backend1, backend2 string, cnameOnly bool) error {
 		return fmt.Errorf("swap is only allowed between routers of the same kind. %q uses %q, %q uses %q",
 			backend1, data1["kind"], backend2, data2["kind"])
 	}
+	return nil
+}
+
+func Swap(r Router, backend1, backend2 string, cnameOnly bool) error {
+	err := checkSwapKind(backend1, backend2)
+	if err != nil {
+		return err
+	}
 	if cnameOnly {
 		return swapCnames(r, backend1, backend2)
 	}
 	return swapBackends(r, backend1, backend2)
 }
 
+// SwapAll swaps the backends, or only the cnames, in all the given routers,
+// which must be the routers of both backends. The backend names are shared by
+// every router of an app, so they're swapped only once, after the routes are
+// swapped in all routers. When swapping fails in a router, the swap is undone
+// in the routers already swapped.
+func SwapAll(routers []Router, backend1, backend2 string, cnameOnly bool) error {
+	if len(routers) == 1 {
+		return routers[0].Swap(backend1, backend2, cnameOnly)
+	}
+	if cnameOnly {
+		return swapAllWith(routers, swapCnames, backend1, backend2)
+	}
+	err := SwapRoutes(routers, backend1, backend2)
+	if err != nil {
+		return err
+	}
+	err = swapBackendName(backend1, backend2)
+	if err != nil {
+		undoSwap(routers, swapRoutes, backend1, backend2)
+	}
+	return err
+}
+
+// SwapRoutes swaps only the routes of the backends in all the given routers,
+// keeping their names and cnames. The swap isn't atomic: while routes are
+// swapped in a router, each backend briefly sends requests to the routes of
+// both backends. When swapping fails in a router, the previous routes are
+// restored in it and the swap is undone in the routers already swapped.
+// Swapping again restores the previous routes.
+func SwapRoutes(routers []Router, backend1, backend2 string) error {
+	return swapAllWith(routers, swapRoutes, backend1, backend2)
+}
+
+func swapAllWith(routers []Router, swap func(Router, string, string) error, backend1, backend2 string) error {
+	for i, r := range routers {
+		err := swap(r, backend1, backend2)
+		if err != nil {
+			undoSwap(routers[:i], swap, backend1, backend2)
+			return err
+		}
+	}
+	return nil
+}
+
+// undoSwap swaps the backends again in the given routers, in reverse order,
+// which restores them as both swap functions are symmetric.
+func undoSwap(routers []Router, swap func(Router, string, string) error, backend1, backend2 string) {
+	for i := len(routers) - 1; i >= 0; i-- {
+		err := swap(routers[i], backend1, backend2)
+		if err != nil {
+			log.Errorf("unable to undo swap of %s and %s in router %T: %s", backend1, backend2, routers[i], err)
+		}
+	}
+}
+
 type PlanRouter struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
@@ -5,6 +5,7 @@
 package router_test
 
 import (
+	"errors"
 	"net/url"
 
 	"github.com/tsuru/config"
@@ -12,7 +13,7 @@ import (
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/router"
 	_ "github.com/tsuru/tsuru/router/hipache"
-	_ "github.com/tsuru/tsuru/router/routertest"
+	"github.com/tsuru/tsuru/router/routertest"
 	"gopkg.in/check.v1"
 )
 
@@ -27,6 +28,7 @@ func (s *ExternalSuite) SetUpSuite(c *check.C) {
 	config.Set("database:url", "127.0.0.1:27017")
 	config.Set("database:name", "router_swap_tests")
 	config.Set("routers:fake:type", "fake")
+	config.Set("routers:fake-hc:type", "fake-hc")
 }
 
 func (s *ExternalSuite) SetUpTest(c *check.C) {
@@ -124,6 +126,161 @@ func (s *ExternalSuite) TestSwap(c *check.C) {
 	c.Assert(name2, check.Equals, backend1)
 }
 
+func (s *ExternalSuite) TestSwapAll(c *check.C) {
+	backend1 := "ba1"
+	backend2 := "ba2"
+	r1, err := router.Get("fake")
+	c.Assert(err, check.IsNil)
+	r2, err := router.Get("fake-hc")
+	c.Assert(err, check.IsNil)
+	addr1, _ := url.Parse("http://127.0.0.1")
+	addr2, _ := url.Parse("http://10.10.10.10")
+	for _, r := range []router.Router{r1, r2} {
+		err = r.AddBackend(backend1)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend1)
+		err = r.AddRoute(backend1, addr1)
+		c.Assert(err, check.IsNil)
+		err = r.AddBackend(backend2)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend2)
+		err = r.AddRoute(backend2, addr2)
+		c.Assert(err, check.IsNil)
+	}
+	err = router.SwapAll([]router.Router{r1, r2}, backend1, backend2, false)
+	c.Assert(err, check.IsNil)
+	name1, err := router.Retrieve(backend1)
+	c.Assert(err, check.IsNil)
+	c.Assert(name1, check.Equals, backend2)
+	name2, err := router.Retrieve(backend2)
+	c.Assert(err, check.IsNil)
+	c.Assert(name2, check.Equals, backend1)
+	for _, r := range []router.Router{r1, r2} {
+		routes1, err := r.Routes(backend1)
+		c.Assert(err, check.IsNil)
+		c.Assert(routes1, check.DeepEquals, []*url.URL{addr1})
+		routes2, err := r.Routes(backend2)
+		c.Assert(err, check.IsNil)
+		c.Assert(routes2, check.DeepEquals, []*url.URL{addr2})
+	}
+	err = router.SwapAll([]router.Router{r1, r2}, backend1, backend2, false)
+	c.Assert(err, check.IsNil)
+	name1, err = router.Retrieve(backend1)
+	c.Assert(err, check.IsNil)
+	c.Assert(name1, check.Equals, backend1)
+}
+
+func (s *ExternalSuite) TestSwapAllFailureInSecondRouter(c *check.C) {
+	backend1 := "bd1"
+	backend2 := "bd2"
+	r1, err := router.Get("fake")
+	c.Assert(err, check.IsNil)
+	r2, err := router.Get("fake-hc")
+	c.Assert(err, check.IsNil)
+	addr1, _ := url.Parse("http://127.0.0.1")
+	addr2, _ := url.Parse("http://10.10.10.10")
+	for _, r := range []router.Router{r1, r2} {
+		err = r.AddBackend(backend1)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend1)
+		err = r.AddRoute(backend1, addr1)
+		c.Assert(err, check.IsNil)
+		err = r.AddBackend(backend2)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend2)
+		err = r.AddRoute(backend2, addr2)
+		c.Assert(err, check.IsNil)
+	}
+	routertest.HCRouter.FailForIp(addr2.String())
+	defer routertest.HCRouter.RemoveFailForIp(addr2.String())
+	err = router.SwapAll([]router.Router{r1, r2}, backend1, backend2, false)
+	c.Assert(err, check.Equals, routertest.ErrForcedFailure)
+	name1, err := router.Retrieve(backend1)
+	c.Assert(err, check.IsNil)
+	c.Assert(name1, check.Equals, backend1)
+	for _, r := range []router.Router{r1, r2} {
+		routes1, err := r.Routes(backend1)
+		c.Assert(err, check.IsNil)
+		c.Assert(routes1, check.DeepEquals, []*url.URL{addr1})
+		routes2, err := r.Routes(backend2)
+		c.Assert(err, check.IsNil)
+		c.Assert(routes2, check.DeepEquals, []*url.URL{addr2})
+	}
+}
+
+var errRemoveRoutes = errors.New("unable to remove routes")
+
+// failingRemoveRouter fails the first call to RemoveRoutes.
+type failingRemoveRouter struct {
+	router.Router
+	failed bool
+}
+
+func (r *failingRemoveRouter) RemoveRoutes(name string, addresses []*url.URL) error {
+	if !r.failed {
+		r.failed = true
+		return errRemoveRoutes
+	}
+	return r.Router.RemoveRoutes(name, addresses)
+}
+
+func (s *ExternalSuite) TestSwapRoutesRemoveFailure(c *check.C) {
+	backend1 := "be1"
+	backend2 := "be2"
+	fake, err := router.Get("fake")
+	c.Assert(err, check.IsNil)
+	addr1, _ := url.Parse("http://127.0.0.1")
+	addr2, _ := url.Parse("http://10.10.10.10")
+	err = fake.AddBackend(backend1)
+	c.Assert(err, check.IsNil)
+	defer fake.RemoveBackend(backend1)
+	err = fake.AddRoute(backend1, addr1)
+	c.Assert(err, check.IsNil)
+	err = fake.AddBackend(backend2)
+	c.Assert(err, check.IsNil)
+	defer fake.RemoveBackend(backend2)
+	err = fake.AddRoute(backend2, addr2)
+	c.Assert(err, check.IsNil)
+	r := &failingRemoveRouter{Router: fake}
+	err = router.SwapRoutes([]router.Router{r}, backend1, backend2)
+	c.Assert(err, check.Equals, errRemoveRoutes)
+	routes1, err := fake.Routes(backend1)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes1, check.DeepEquals, []*url.URL{addr1})
+	routes2, err := fake.Routes(backend2)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes2, check.DeepEquals, []*url.URL{addr2})
+}
+
+func (s *ExternalSuite) TestSwapAllCnameOnly(c *check.C) {
+	backend1 := "bc1"
+	backend2 := "bc2"
+	r1, err := router.Get("fake")
+	c.Assert(err, check.IsNil)
+	r2, err := router.Get("fake-hc")
+	c.Assert(err, check.IsNil)
+	for _, r := range []router.Router{r1, r2} {
+		err = r.AddBackend(backend1)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend1)
+		err = r.AddBackend(backend2)
+		c.Assert(err, check.IsNil)
+		defer r.RemoveBackend(backend2)
+		err = r.(router.CNameRouter).SetCName("cname.com", backend1)
+		c.Assert(err, check.IsNil)
+	}
+	err = router.SwapAll([]router.Router{r1, r2}, backend1, backend2, true)
+	c.Assert(err, check.IsNil)
+	name1, err := router.Retrieve(backend1)
+	c.Assert(err, check.IsNil)
+	c.Assert(name1, check.Equals, backend1)
+	for _, r := range []router.Router{r1, r2} {
+		cnames, err := r.(router.CNameRouter).CNames(backend2)
+		c.Assert(err, check.IsNil)
+		c.Assert(cnames, check.DeepEquals, []*url.URL{{Host: "cname.com"}})
+	}
+}
+
 func (s *ExternalSuite) TestSwapWithDifferentRouterKinds(c *check.C) {
 	config.Set("hipache:redis-server", "127.0.0.1:6379")
 	config.Set("hipache:redis-db", 5)
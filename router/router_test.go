@@ -129,6 +129,27 @@ func (s *S) TestSwapBackendName(c *check.C) {
 	c.Assert(name, check.Equals, "routername")
 }
 
+func (s *S) TestSwapBackendNameMultipleEntries(c *check.C) {
+	err := Store("appname", "appname", "fake")
+	c.Assert(err, check.IsNil)
+	err = Store("appname", "appname", "hipache")
+	c.Assert(err, check.IsNil)
+	err = Store("appname2", "appname2", "fake")
+	c.Assert(err, check.IsNil)
+	defer Remove("appname2")
+	err = swapBackendName("appname", "appname2")
+	c.Assert(err, check.IsNil)
+	err = Remove("appname")
+	c.Assert(err, check.IsNil)
+	name, err := Retrieve("appname")
+	c.Assert(err, check.IsNil)
+	c.Assert(name, check.Equals, "appname2")
+	err = Remove("appname")
+	c.Assert(err, check.IsNil)
+	_, err = Retrieve("appname")
+	c.Assert(err, check.Equals, ErrBackendNotFound)
+}
+
 func (s *S) TestList(c *check.C) {
 	config.Set("routers:router1:type", "foo")
 	config.Set("routers:router2:type", "bar")
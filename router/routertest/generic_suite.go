@@ -550,6 +550,26 @@ func (s *RouterSuite) TestRemoveBackendWithCName(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *RouterSuite) TestAddRemoveCertificate(c *check.C) {
+	tlsRouter, ok := s.Router.(router.TLSRouter)
+	if !ok {
+		c.Skip(fmt.Sprintf("%T does not implement TLSRouter", s.Router))
+	}
+	_, err := tlsRouter.GetCertificate("my.host.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	err = tlsRouter.AddCertificate("my.host.com", "mycert", "mykey")
+	c.Assert(err, check.IsNil)
+	certificate, err := tlsRouter.GetCertificate("my.host.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(certificate, check.Equals, "mycert")
+	err = tlsRouter.RemoveCertificate("my.host.com")
+	c.Assert(err, check.IsNil)
+	err = tlsRouter.RemoveCertificate("my.host.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	_, err = tlsRouter.GetCertificate("my.host.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
 func (s *RouterSuite) TestRemoveBackendAfterSwap(c *check.C) {
 	addr1, _ := url.Parse("http://127.0.0.1")
 	addr2, _ := url.Parse("http://10.10.10.10")
@@ -610,3 +630,104 @@ func (s *RouterSuite) TestSetHealthcheck(c *check.C) {
 	err = s.Router.RemoveBackend(testBackend1)
 	c.Assert(err, check.IsNil)
 }
+
+func (s *RouterSuite) TestRouteWeight(c *check.C) {
+	weightedRouter, ok := s.Router.(router.WeightedRouter)
+	if !ok {
+		c.Skip(fmt.Sprintf("%T does not implement WeightedRouter", s.Router))
+	}
+	err := s.Router.AddBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	err = s.Router.AddRoutes(testBackend1, []*url.URL{addr1, addr2})
+	c.Assert(err, check.IsNil)
+	weight, err := weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 1)
+	err = weightedRouter.SetRouteWeight(testBackend1, addr1, 3)
+	c.Assert(err, check.IsNil)
+	weight, err = weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 3)
+	weight, err = weightedRouter.RouteWeight(testBackend1, addr2)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 1)
+	routes, err := s.Router.Routes(testBackend1)
+	c.Assert(err, check.IsNil)
+	sort.Sort(URLList(routes))
+	c.Assert(routes, HostEquals, []*url.URL{addr1, addr2})
+	err = weightedRouter.SetRouteWeight(testBackend1, addr1, 2)
+	c.Assert(err, check.IsNil)
+	weight, err = weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 2)
+	err = s.Router.RemoveRoute(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	err = s.Router.AddRoute(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	weight, err = weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 1)
+	err = s.Router.RemoveBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *RouterSuite) TestRouteWeightInvalid(c *check.C) {
+	weightedRouter, ok := s.Router.(router.WeightedRouter)
+	if !ok {
+		c.Skip(fmt.Sprintf("%T does not implement WeightedRouter", s.Router))
+	}
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	err := weightedRouter.SetRouteWeight(testBackend1, addr1, 2)
+	c.Assert(err, check.Equals, router.ErrBackendNotFound)
+	err = s.Router.AddBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+	err = s.Router.AddRoute(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	err = weightedRouter.SetRouteWeight(testBackend1, addr1, 0)
+	c.Assert(err, check.Equals, router.ErrInvalidRouteWeight)
+	err = weightedRouter.SetRouteWeight(testBackend1, addr2, 2)
+	c.Assert(err, check.Equals, router.ErrRouteNotFound)
+	_, err = weightedRouter.RouteWeight(testBackend1, addr2)
+	c.Assert(err, check.Equals, router.ErrRouteNotFound)
+	err = s.Router.RemoveBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *RouterSuite) TestRouteWeightSwap(c *check.C) {
+	weightedRouter, ok := s.Router.(router.WeightedRouter)
+	if !ok {
+		c.Skip(fmt.Sprintf("%T does not implement WeightedRouter", s.Router))
+	}
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	err := s.Router.AddBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+	err = s.Router.AddRoute(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	err = s.Router.AddBackend(testBackend2)
+	c.Assert(err, check.IsNil)
+	err = s.Router.AddRoute(testBackend2, addr2)
+	c.Assert(err, check.IsNil)
+	err = weightedRouter.SetRouteWeight(testBackend1, addr1, 4)
+	c.Assert(err, check.IsNil)
+	err = s.Router.Swap(testBackend1, testBackend2, false)
+	c.Assert(err, check.IsNil)
+	weight, err := weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 4)
+	weight, err = weightedRouter.RouteWeight(testBackend2, addr2)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 1)
+	err = s.Router.Swap(testBackend1, testBackend2, false)
+	c.Assert(err, check.IsNil)
+	weight, err = weightedRouter.RouteWeight(testBackend1, addr1)
+	c.Assert(err, check.IsNil)
+	c.Assert(weight, check.Equals, 4)
+	err = s.Router.RemoveBackend(testBackend1)
+	c.Assert(err, check.IsNil)
+	err = s.Router.RemoveBackend(testBackend2)
+	c.Assert(err, check.IsNil)
+}
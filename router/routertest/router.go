@@ -33,14 +33,16 @@ func createHCRouter(name, prefix string) (router.Router, error) {
 }
 
 func newFakeRouter() fakeRouter {
-	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), mutex: &sync.Mutex{}}
+	return fakeRouter{cnames: make(map[string]string), certificates: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), weights: make(map[string]map[string]int), mutex: &sync.Mutex{}}
 }
 
 type fakeRouter struct {
 	backends     map[string][]string
 	cnames       map[string]string
+	certificates map[string]string
 	failuresByIp map[string]bool
 	healthcheck  map[string]router.HealthcheckData
+	weights      map[string]map[string]int
 	mutex        *sync.Mutex
 }
 
@@ -147,6 +149,7 @@ func (r *fakeRouter) RemoveBackend(name string) error {
 		}
 	}
 	delete(r.backends, backendName)
+	delete(r.weights, backendName)
 	return router.Remove(backendName)
 }
 
@@ -202,6 +205,7 @@ func (r *fakeRouter) RemoveRoutes(name string, addresses []*url.URL) error {
 				break
 			}
 		}
+		delete(r.weights[backendName], addr.Host)
 	}
 	r.backends[backendName] = routes
 	return nil
@@ -255,9 +259,52 @@ func (r *fakeRouter) RemoveRoute(name string, address *url.URL) error {
 	}
 	routes[index] = routes[len(routes)-1]
 	r.backends[backendName] = routes[:len(routes)-1]
+	delete(r.weights[backendName], address.Host)
 	return nil
 }
 
+func (r *fakeRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	if weight < 1 {
+		return router.ErrInvalidRouteWeight
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasBackend(backendName) {
+		return router.ErrBackendNotFound
+	}
+	if !r.HasRoute(backendName, address.Host) {
+		return router.ErrRouteNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.weights[backendName] == nil {
+		r.weights[backendName] = make(map[string]int)
+	}
+	r.weights[backendName][address.Host] = weight
+	return nil
+}
+
+func (r *fakeRouter) RouteWeight(name string, address *url.URL) (int, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return 0, err
+	}
+	if !r.HasBackend(backendName) {
+		return 0, router.ErrBackendNotFound
+	}
+	if !r.HasRoute(backendName, address.Host) {
+		return 0, router.ErrRouteNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if weight, ok := r.weights[backendName][address.Host]; ok {
+		return weight, nil
+	}
+	return 1, nil
+}
+
 func (r *fakeRouter) SetCName(cname, name string) error {
 	backendName, err := router.Retrieve(name)
 	if err != nil {
@@ -295,6 +342,33 @@ func (r *fakeRouter) UnsetCName(cname, name string) error {
 	return nil
 }
 
+func (r *fakeRouter) AddCertificate(cname, certificate, key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificates[cname] = certificate
+	return nil
+}
+
+func (r *fakeRouter) RemoveCertificate(cname string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.certificates[cname]; !ok {
+		return router.ErrCertificateNotFound
+	}
+	delete(r.certificates, cname)
+	return nil
+}
+
+func (r *fakeRouter) GetCertificate(cname string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	certificate, ok := r.certificates[cname]
+	if !ok {
+		return "", router.ErrCertificateNotFound
+	}
+	return certificate, nil
+}
+
 func (r *fakeRouter) Addr(name string) (string, error) {
 	backendName, err := router.Retrieve(name)
 	if err != nil {
@@ -314,7 +388,9 @@ func (r *fakeRouter) Reset() {
 	r.backends = make(map[string][]string)
 	r.failuresByIp = make(map[string]bool)
 	r.cnames = make(map[string]string)
+	r.certificates = make(map[string]string)
 	r.healthcheck = make(map[string]router.HealthcheckData)
+	r.weights = make(map[string]map[string]int)
 }
 
 func (r *fakeRouter) Routes(name string) ([]*url.URL, error) {